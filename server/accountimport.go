@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// A bundle from GET /profile/export. Every field is optional, so
+// bundles from older (or newer) versions can still be imported.
+type AccountImportBundle struct {
+	Version     int             `json:"version"`
+	Profile     *UserProfile    `json:"profile"`
+	Settings    *UserSettings   `json:"settings"`
+	SubProfiles []SubProfile    `json:"subProfiles"`
+	Watched     []ExportWatched `json:"watched"`
+}
+
+type AccountImportWatchedResult struct {
+	TmdbID int         `json:"tmdbId"`
+	Type   ContentType `json:"type"`
+	Title  string      `json:"title"`
+	// Where the content is from, and its id there.
+	Provider   ContentProvider `json:"provider"`
+	ProviderID int             `json:"providerId"`
+	// Name of the sub profile the item belongs to, empty for the main profile.
+	SubProfile string       `json:"subProfile"`
+	Action     ImportAction `json:"action"`
+	Reason     string       `json:"reason,omitempty"`
+}
+
+type AccountImportReport struct {
+	DryRun  bool `json:"dryRun"`
+	Version int  `json:"version"`
+	// Anything in the bundle we couldn't import, or had to guess at.
+	Warnings         []string                     `json:"warnings"`
+	SubProfilesAdded []string                     `json:"subProfilesAdded"`
+	ProfileRestored  bool                         `json:"profileRestored"`
+	SettingsRestored bool                         `json:"settingsRestored"`
+	Watched          []AccountImportWatchedResult `json:"watched"`
+}
+
+// Restore an account export into a users account. Items already on the
+// users watched list are left alone, so importing the same bundle
+// again changes nothing. In a dry run, nothing is written.
+func importAccount(db *gorm.DB, userId uint, b AccountImportBundle, dryRun bool) (AccountImportReport, error) {
+	report := AccountImportReport{DryRun: dryRun, Version: b.Version, Warnings: []string{}, SubProfilesAdded: []string{}, Watched: []AccountImportWatchedResult{}}
+	if b.Version == 0 {
+		report.Warnings = append(report.Warnings, "bundle has no version, assuming it is version 1")
+	} else if b.Version > accountExportVersion {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("bundle is from a newer version (%d), anything this server doesn't know about is ignored", b.Version))
+	}
+
+	// Match sub profiles by name, creating any missing ones.
+	existing, err := getSubProfiles(db, userId)
+	if err != nil {
+		return AccountImportReport{}, err
+	}
+	profileIds := map[uint]uint{0: 0}
+	profileNames := map[uint]string{0: ""}
+	newProfiles := map[uint]bool{}
+	for _, sp := range b.SubProfiles {
+		name := sanitizeString(sp.Name)
+		profileNames[sp.ID] = name
+		if i := slices.IndexFunc(existing, func(e SubProfile) bool { return e.Name == name }); i != -1 {
+			profileIds[sp.ID] = existing[i].ID
+			continue
+		}
+		report.SubProfilesAdded = append(report.SubProfilesAdded, name)
+		newProfiles[sp.ID] = true
+		if dryRun {
+			continue
+		}
+		added, err := addSubProfile(db, userId, SubProfileAddRequest{Name: name})
+		if err != nil {
+			return AccountImportReport{}, err
+		}
+		existing = append(existing, added)
+		profileIds[sp.ID] = added.ID
+	}
+
+	if b.Profile != nil {
+		report.ProfileRestored = true
+		if !dryRun {
+			_, err := updateUserProfile(db, userId, UserProfileUpdateRequest{Bio: &b.Profile.Bio, Location: &b.Profile.Location, Website: &b.Profile.Website})
+			if err != nil {
+				report.ProfileRestored = false
+				report.Warnings = append(report.Warnings, "profile not restored: "+err.Error())
+			}
+		}
+	}
+	if b.Settings != nil {
+		report.SettingsRestored = true
+		if !dryRun {
+			s := b.Settings
+			ur := UserSettingsUpdateRequest{
+				MaxRating:           &s.MaxRating,
+				ShowUnrated:         &s.ShowUnrated,
+				ShareWithInstance:   &s.ShareWithInstance,
+				SharePrivateRatings: &s.SharePrivateRatings,
+				ShareReviews:        &s.ShareReviews,
+				Timezone:            &s.Timezone,
+				DefaultStatusOnAdd:  &s.DefaultStatusOnAdd,
+				IncludeRatingPrompt: &s.IncludeRatingPrompt,
+				Region:              &s.Region,
+				Language:            &s.Language,
+			}
+			// Older bundles don't have a rating scale, keep the users current one.
+			if s.RatingScale != 0 {
+				ur.RatingScale = &s.RatingScale
+			}
+			_, err := updateUserSettings(db, userId, ur)
+			if err != nil {
+				report.SettingsRestored = false
+				report.Warnings = append(report.Warnings, "settings not restored: "+err.Error())
+			}
+		}
+	}
+
+	skippedActivity := 0
+	for _, w := range b.Watched {
+		// Bundles from before content had a provider are all from TMDB.
+		if w.Content.Provider == "" {
+			w.Content.Provider = PROVIDER_TMDB
+			w.Content.ProviderID = w.Content.TmdbID
+		}
+		result := AccountImportWatchedResult{TmdbID: w.Content.TmdbID, Type: w.Content.Type, Title: w.Content.Title, Provider: w.Content.Provider, ProviderID: w.Content.ProviderID}
+		profileId, ok := profileIds[w.SubProfileID]
+		if !ok && !newProfiles[w.SubProfileID] {
+			result.Action = IMPORT_ACTION_ERROR
+			result.Reason = "belongs to a sub profile that isn't in the bundle"
+			report.Watched = append(report.Watched, result)
+			continue
+		}
+		result.SubProfile = profileNames[w.SubProfileID]
+		result.Action, result.Reason = importAccountWatched(db, userId, profileId, newProfiles[w.SubProfileID], w, dryRun, &skippedActivity)
+		report.Watched = append(report.Watched, result)
+	}
+	if skippedActivity > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d activity entries of an unknown type were skipped", skippedActivity))
+	}
+	slog.Info("Imported account bundle", "userId", userId, "version", b.Version, "watched", len(b.Watched), "dryRun", dryRun)
+	return report, nil
+}
+
+// Import one watched item with its activity and episodes, returning what was done.
+// Activity of a type we don't know is skipped and counted in skippedActivity.
+func importAccountWatched(db *gorm.DB, userId uint, profileId uint, newProfile bool, w ExportWatched, dryRun bool, skippedActivity *int) (ImportAction, string) {
+	if w.Content.ProviderID == 0 || (w.Content.Type != MOVIE && w.Content.Type != SHOW) {
+		return IMPORT_ACTION_ERROR, "missing content providerId (or tmdbId) or type"
+	}
+	if _, err := getContentSource(w.Content.Provider); err != nil {
+		return IMPORT_ACTION_ERROR, err.Error()
+	}
+	// Checked and cleaned up the same as entries added or updated through the api.
+	w.Thoughts = sanitizeString(w.Thoughts)
+	w.PlannedNote = sanitizeString(w.PlannedNote)
+	w.WatchedOn = sanitizeString(w.WatchedOn)
+	if err := validateWatched(w.Watched); err != nil {
+		return IMPORT_ACTION_ERROR, err.Error()
+	}
+	if !newProfile {
+		var existing Watched
+		res := db.Unscoped().
+			Where("user_id = ? AND sub_profile_id = ?", userId, profileId).
+			Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("provider = ? AND provider_id = ? AND type = ?", w.Content.Provider, w.Content.ProviderID, w.Content.Type)).
+			Limit(1).Find(&existing)
+		if res.Error != nil {
+			return IMPORT_ACTION_ERROR, "failed to look up existing watched entry"
+		}
+		if existing.ID != 0 && !existing.DeletedAt.Valid {
+			return IMPORT_ACTION_SKIP, "already on watched list"
+		}
+		if existing.ID != 0 {
+			if dryRun {
+				return IMPORT_ACTION_RESTORE, "was removed from watched list"
+			}
+			res := db.Model(&Watched{}).Unscoped().Where("id = ?", existing.ID).Updates(map[string]interface{}{
+				"status":     w.Status,
+				"rating":     w.Rating,
+				"thoughts":   w.Thoughts,
+				"watched_on": w.WatchedOn,
+				"deleted_at": nil,
+			})
+			if res.Error != nil {
+				slog.Error("importAccountWatched: Failed to restore watched entry", "id", existing.ID, "error", res.Error)
+				return IMPORT_ACTION_ERROR, "failed to restore watched entry"
+			}
+			return IMPORT_ACTION_RESTORE, "was removed from watched list"
+		}
+	}
+	if dryRun {
+		return IMPORT_ACTION_ADD, ""
+	}
+
+	content, err := getOrCacheProviderContent(db, w.Content.Provider, w.Content.Type, w.Content.ProviderID)
+	if err != nil {
+		return IMPORT_ACTION_ERROR, err.Error()
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		watched := Watched{
+			GormModel:           GormModel{CreatedAt: w.CreatedAt},
+			Status:              w.Status,
+			Rating:              w.Rating,
+			Thoughts:            w.Thoughts,
+			PlannedNote:         w.PlannedNote,
+			PlannedNoteFinished: w.PlannedNoteFinished,
+			WatchedOn:           w.WatchedOn,
+			Source:              SOURCE_ACCOUNT_IMPORT,
+			UserID:              userId,
+			SubProfileID:        profileId,
+			ContentID:           content.ID,
+		}
+		tx.Model(&Watched{}).Select("COALESCE(MAX(display_order), 0) + 1").Where("user_id = ? AND sub_profile_id = ?", userId, profileId).Scan(&watched.DisplayOrder)
+		if res := tx.Create(&watched); res.Error != nil {
+			return res.Error
+		}
+		activity := []Activity{}
+		for _, a := range w.Activity {
+			if !slices.Contains(activityTypes, a.Type) {
+				*skippedActivity++
+				continue
+			}
+			activity = append(activity, Activity{GormModel: GormModel{CreatedAt: a.CreatedAt}, UserID: userId, WatchedID: watched.ID, Type: a.Type, Data: sanitizeString(a.Data)})
+		}
+		if len(activity) > 0 {
+			if res := tx.Create(&activity); res.Error != nil {
+				return res.Error
+			}
+		}
+		episodes := []WatchedEpisode{}
+		for _, ep := range w.Episodes {
+			episodes = append(episodes, WatchedEpisode{GormModel: GormModel{CreatedAt: ep.CreatedAt}, WatchedID: watched.ID, SeasonNumber: ep.SeasonNumber, EpisodeNumber: ep.EpisodeNumber, WatchedDate: ep.WatchedDate, Runtime: ep.Runtime})
+		}
+		if len(episodes) > 0 {
+			if res := tx.Create(&episodes); res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return IMPORT_ACTION_SKIP, "already on watched list"
+		}
+		slog.Error("importAccountWatched: Failed to add watched entry", "provider", w.Content.Provider, "id", w.Content.ProviderID, "error", err)
+		return IMPORT_ACTION_ERROR, "failed to add watched entry"
+	}
+	return IMPORT_ACTION_ADD, ""
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"errors"
 	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -16,8 +19,15 @@ var (
 	STATUS_CHANGED   ActivityType = "STATUS_CHANGED"
 	THOUGHTS_CHANGED ActivityType = "THOUGHTS_CHANGED"
 	THOUGHTS_REMOVED ActivityType = "THOUGHTS_REMOVED"
+	REWATCHED        ActivityType = "REWATCHED"
+	// Planned note ("why I added this") was set or removed.
+	PLANNED_NOTE_CHANGED ActivityType = "PLANNED_NOTE_CHANGED"
+	PLANNED_NOTE_REMOVED ActivityType = "PLANNED_NOTE_REMOVED"
 )
 
+// All activity types, activity can only be added with one of these.
+var activityTypes = []ActivityType{ADDED_WATCHED, REMOVED_WATCHED, RATING_CHANGED, STATUS_CHANGED, THOUGHTS_CHANGED, THOUGHTS_REMOVED, REWATCHED, PLANNED_NOTE_CHANGED, PLANNED_NOTE_REMOVED}
+
 type Activity struct {
 	GormModel
 	// ID of user this activity is linked to, so it can be easily
@@ -36,11 +46,30 @@ type ActivityAddRequest struct {
 	WatchedID uint         `json:"watchedId" binding:"required"`
 	Type      ActivityType `json:"type" binding:"required"`
 	Data      string       `json:"data" binding:"required"`
+	// Date the activity happened, when backfilling history. Only set
+	// by us (eg. when adding a watched item), so not accepted from clients.
+	CustomDate *time.Time `json:"-"`
+}
+
+type ActivityFilters struct {
+	// Only get activity of this type (case insensitive, eg. rating_changed).
+	Type string `form:"type"`
 }
 
-func getActivity(db *gorm.DB, userId uint, watchedId uint) ([]Activity, error) {
+// Get activity of a watched item, only if it is on the users profileId.
+func getActivity(db *gorm.DB, userId uint, profileId uint, watchedId uint, f ActivityFilters) ([]Activity, error) {
 	activity := new([]Activity)
-	res := db.Model(&Activity{}).Where("user_id = ? AND watched_id = ?", userId, watchedId).Find(&activity)
+	q := db.Model(&Activity{}).
+		Joins("JOIN watcheds ON watcheds.id = activities.watched_id AND watcheds.sub_profile_id = ?", profileId).
+		Where("activities.user_id = ? AND activities.watched_id = ?", userId, watchedId)
+	if f.Type != "" {
+		t := ActivityType(strings.ToUpper(f.Type))
+		if !slices.Contains(activityTypes, t) {
+			return []Activity{}, errors.New("unknown activity type")
+		}
+		q = q.Where("activities.type = ?", t)
+	}
+	res := q.Find(&activity)
 	if res.Error != nil {
 		slog.Error("Failed getting activity from database", "error", res.Error.Error())
 		return []Activity{}, errors.New("failed getting activity")
@@ -48,11 +77,33 @@ func getActivity(db *gorm.DB, userId uint, watchedId uint) ([]Activity, error) {
 	return *activity, nil
 }
 
+var ErrActivityWatchedNotFound = errors.New("watched item not found")
+
+// Add activity sent by a client, its watched item must be on the users profileId.
+func addProfileActivity(db *gorm.DB, userId uint, profileId uint, ar ActivityAddRequest) (Activity, error) {
+	var n int64
+	res := db.Model(&Watched{}).Where("id = ? AND user_id = ? AND sub_profile_id = ?", ar.WatchedID, userId, profileId).Count(&n)
+	if res.Error != nil {
+		slog.Error("addProfileActivity: Failed to check watched item", "watched_id", ar.WatchedID, "error", res.Error)
+		return Activity{}, errors.New("failed adding new activity to database")
+	}
+	if n == 0 {
+		return Activity{}, ErrActivityWatchedNotFound
+	}
+	return addActivity(db, userId, ar)
+}
+
 func addActivity(db *gorm.DB, userId uint, ar ActivityAddRequest) (Activity, error) {
 	if ar.WatchedID == 0 {
 		return Activity{}, errors.New("watchedId must be set to add an activity")
 	}
+	if !slices.Contains(activityTypes, ar.Type) {
+		return Activity{}, errors.New("unknown activity type")
+	}
 	activity := Activity{UserID: userId, WatchedID: ar.WatchedID, Type: ar.Type, Data: ar.Data}
+	if ar.CustomDate != nil {
+		activity.CreatedAt = ar.CustomDate.UTC()
+	}
 	res := db.Create(&activity)
 	if res.Error != nil {
 		slog.Error("Error adding activity to database", "error", res.Error.Error())
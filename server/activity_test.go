@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// Activity is only viewable and addable on the profile its watched item is on.
+func TestActivityProfiles(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	var kid SubProfile
+	s.expect("POST", "/profiles", token, `{"name":"kid"}`, http.StatusOK, &kid)
+	kidQuery := "profile=" + strconv.Itoa(int(kid.ID))
+
+	var mainWatched, kidWatched Watched
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie","status":"FINISHED"}`, http.StatusOK, &mainWatched)
+	s.expect("POST", "/watched?"+kidQuery, token, `{"contentId":603,"contentType":"movie","status":"WATCHING"}`, http.StatusOK, &kidWatched)
+	mainId, kidId := strconv.Itoa(int(mainWatched.ID)), strconv.Itoa(int(kidWatched.ID))
+
+	for _, tc := range []struct {
+		name      string
+		watchedId string
+		query     string
+		wantGet   int
+		wantAdd   int
+	}{
+		{"main profile own item", mainId, "", 1, http.StatusOK},
+		{"main profile kids item", kidId, "", 0, http.StatusNotFound},
+		{"kid profile own item", kidId, kidQuery, 1, http.StatusOK},
+		{"kid profile mains item", mainId, kidQuery, 0, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var activity []Activity
+			s.expect("GET", "/activity/"+tc.watchedId+"?"+tc.query, token, "", http.StatusOK, &activity)
+			if len(activity) != tc.wantGet {
+				t.Errorf("got %d activities, want %d: %+v", len(activity), tc.wantGet, activity)
+			}
+			s.expect("GET", "/activity/"+tc.watchedId+"?type=added_watched&"+tc.query, token, "", http.StatusOK, &activity)
+			if len(activity) != tc.wantGet {
+				t.Errorf("got %d activities filtered by type, want %d", len(activity), tc.wantGet)
+			}
+
+			var before int64
+			s.db.Model(&Activity{}).Where("watched_id = ?", tc.watchedId).Count(&before)
+			s.expect("POST", "/activity?"+tc.query, token, `{"watchedId":`+tc.watchedId+`,"type":"REWATCHED","data":"test"}`, tc.wantAdd, nil)
+			var after int64
+			s.db.Model(&Activity{}).Where("watched_id = ?", tc.watchedId).Count(&after)
+			if added := after != before; added != (tc.wantAdd == http.StatusOK) {
+				t.Errorf("activity added: %v, want %v", added, tc.wantAdd == http.StatusOK)
+			}
+			// Remove it again, so later cases only see the ADDED_WATCHED activity.
+			s.db.Unscoped().Where("watched_id = ? AND type = ?", tc.watchedId, REWATCHED).Delete(&Activity{})
+		})
+	}
+}
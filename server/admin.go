@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AdminPasswordResetRequest struct {
+	// Temporary password to set, one is generated if not provided.
+	NewPassword string `json:"newPassword"`
+}
+
+type AdminPasswordResetResponse struct {
+	// Password the user can login with once, they must change it after.
+	TemporaryPassword string `json:"temporaryPassword"`
+}
+
+type ImpersonationResponse struct {
+	// Token to make requests as the user with, it can't change their password or 2fa.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// User being impersonated.
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+}
+
+type UserMergeRequest struct {
+	// User that will be merged and then deleted.
+	SourceUserID uint `json:"sourceUserId" binding:"required"`
+	// User that will receive the source users data.
+	TargetUserID uint `json:"targetUserId" binding:"required"`
+}
+
+type UserMergeResponse struct {
+	// Watched items moved to target, which they didn't have.
+	Moved int `json:"moved"`
+	// Watched items both users had, that were merged into targets entry.
+	Merged int `json:"merged"`
+	// Merged items where source had a different status/rating/thoughts,
+	// the targets values are always kept in this case.
+	Conflicted int `json:"conflicted"`
+}
+
+// Merge all data from source user into target user, then delete source user.
+// When both users have the same content in their watched list, the targets
+// entry is kept and the sources activity is moved over to it.
+func mergeUsers(db *gorm.DB, mr UserMergeRequest) (UserMergeResponse, error) {
+	if mr.SourceUserID == mr.TargetUserID {
+		return UserMergeResponse{}, errors.New("source and target user must be different")
+	}
+	slog.Info("Merging users", "source_user_id", mr.SourceUserID, "target_user_id", mr.TargetUserID)
+	resp := UserMergeResponse{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var source, target User
+		if res := tx.Where("id = ?", mr.SourceUserID).Take(&source); res.Error != nil {
+			return errors.New("source user not found")
+		}
+		if res := tx.Where("id = ?", mr.TargetUserID).Take(&target); res.Error != nil {
+			return errors.New("target user not found")
+		}
+
+		// Unscoped, so soft deleted items (and their activity) come across too,
+		// they also still take part in the user+content unique constraint.
+		var sourceWatched []Watched
+		if res := tx.Unscoped().Where("user_id = ?", source.ID).Find(&sourceWatched); res.Error != nil {
+			return res.Error
+		}
+		for _, sw := range sourceWatched {
+			var tw Watched
+			res := tx.Unscoped().Where("user_id = ? AND sub_profile_id = ? AND content_id = ?", target.ID, sw.SubProfileID, sw.ContentID).Limit(1).Find(&tw)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				// Target doesn't have this content, move it straight over
+				if res := tx.Unscoped().Model(&Watched{}).Where("id = ?", sw.ID).Update("user_id", target.ID); res.Error != nil {
+					return res.Error
+				}
+				if res := tx.Unscoped().Model(&Activity{}).Where("watched_id = ?", sw.ID).Update("user_id", target.ID); res.Error != nil {
+					return res.Error
+				}
+				resp.Moved++
+				continue
+			}
+			// Both have this content, keep targets entry
+			updates := map[string]interface{}{}
+			if sw.CreatedAt.Before(tw.CreatedAt) {
+				updates["created_at"] = sw.CreatedAt
+			}
+			if tw.DeletedAt.Valid && !sw.DeletedAt.Valid {
+				// Target removed this from their list, but source still has it,
+				// restore it with the sources data so nothing is lost.
+				updates["deleted_at"] = nil
+				updates["status"] = sw.Status
+				updates["rating"] = sw.Rating
+				updates["thoughts"] = sw.Thoughts
+			} else if tw.Status != sw.Status || tw.Rating != sw.Rating || tw.Thoughts != sw.Thoughts {
+				resp.Conflicted++
+			}
+			if len(updates) > 0 {
+				if res := tx.Unscoped().Model(&Watched{}).Where("id = ?", tw.ID).Updates(updates); res.Error != nil {
+					return res.Error
+				}
+			}
+			// Episodes target has also watched keep targets watch, the rest move over.
+			// Moved activity and rewatches are given to target with the rest of sources below.
+			if err := moveWatchedChildren(tx, sw.ID, tw.ID); err != nil {
+				return err
+			}
+			if res := tx.Unscoped().Delete(&Watched{}, sw.ID); res.Error != nil {
+				return res.Error
+			}
+			resp.Merged++
+		}
+
+		// Sub profiles come across as they are, their watched items were moved above.
+		if res := tx.Unscoped().Model(&SubProfile{}).Where("user_id = ?", source.ID).Update("user_id", target.ID); res.Error != nil {
+			return res.Error
+		}
+		// Any activity left over (shouldn't be any) would be orphaned, move it too.
+		if res := tx.Unscoped().Model(&Activity{}).Where("user_id = ?", source.ID).Update("user_id", target.ID); res.Error != nil {
+			return res.Error
+		}
+		// Rewatches are looked up by user, so they all need to be targets now (their entries were moved above).
+		if res := tx.Unscoped().Model(&ReWatchEntry{}).Where("user_id = ?", source.ID).Update("user_id", target.ID); res.Error != nil {
+			return res.Error
+		}
+		// Sources tags are merged into targets tags with the same name (on the same profile).
+		var sourceTags []Tag
+		if res := tx.Unscoped().Where("user_id = ?", source.ID).Find(&sourceTags); res.Error != nil {
+			return res.Error
+		}
+		for _, st := range sourceTags {
+			var tt Tag
+			res := tx.Unscoped().Where("user_id = ? AND sub_profile_id = ? AND name = ?", target.ID, st.SubProfileID, st.Name).Limit(1).Find(&tt)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				if res := tx.Unscoped().Model(&Tag{}).Where("id = ?", st.ID).Update("user_id", target.ID); res.Error != nil {
+					return res.Error
+				}
+				continue
+			}
+			if err := moveWatchedTags(tx, "tag_id", st.ID, tt.ID); err != nil {
+				return err
+			}
+			if res := tx.Unscoped().Delete(&Tag{}, st.ID); res.Error != nil {
+				return res.Error
+			}
+		}
+		for _, m := range []any{&Notification{}, &Job{}, &AuthLog{}} {
+			if res := tx.Unscoped().Model(m).Where("user_id = ?", source.ID).Update("user_id", target.ID); res.Error != nil {
+				return res.Error
+			}
+		}
+		// Targets own profile and goals win, sources are only kept when target doesn't have them.
+		res := tx.Unscoped().Model(&UserProfile{}).
+			Where("user_id = ? AND NOT EXISTS (SELECT 1 FROM user_profiles t WHERE t.user_id = ?)", source.ID, target.ID).
+			Update("user_id", target.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		res = tx.Unscoped().Model(&WatchGoal{}).
+			Where("user_id = ? AND NOT EXISTS (SELECT 1 FROM watch_goals t WHERE t.user_id = ? AND t.sub_profile_id = watch_goals.sub_profile_id AND t.year = watch_goals.year AND t.type = watch_goals.type)", source.ID, target.ID).
+			Update("user_id", target.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		for _, m := range []any{&UserProfile{}, &WatchGoal{}} {
+			if res := tx.Unscoped().Where("user_id = ?", source.ID).Delete(m); res.Error != nil {
+				return res.Error
+			}
+		}
+		if res := tx.Unscoped().Delete(&source); res.Error != nil {
+			return res.Error
+		}
+		// Invalidate targets tokens, source users are invalid now they don't exist.
+		if res := tx.Model(&target).Update("token_version", gorm.Expr("token_version + 1")); res.Error != nil {
+			return res.Error
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to merge users", "source_user_id", mr.SourceUserID, "target_user_id", mr.TargetUserID, "error", err)
+		return UserMergeResponse{}, errors.New("failed to merge users: " + err.Error())
+	}
+	slog.Info("Merged users", "source_user_id", mr.SourceUserID, "target_user_id", mr.TargetUserID, "summary", resp)
+	return resp, nil
+}
+
+// Move tags applied to a watched entry (col watched_id) or entries tagged with a tag (col tag_id)
+// from one to another. Ones already there are left as they are.
+func moveWatchedTags(tx *gorm.DB, col string, from uint, to uint) error {
+	other := "tag_id"
+	if col == "tag_id" {
+		other = "watched_id"
+	}
+	res := tx.Exec("INSERT INTO watched_tags ("+col+", "+other+", created_at) SELECT ?, "+other+", created_at FROM watched_tags WHERE "+col+" = ? ON CONFLICT DO NOTHING", to, from)
+	if res.Error != nil {
+		return res.Error
+	}
+	return tx.Where(col+" = ?", from).Delete(&WatchedTag{}).Error
+}
+
+// Reset a users password to a temporary one, which they must change on next login.
+// All of the users existing tokens are invalidated.
+func resetUserPassword(db *gorm.DB, adminId uint, userId uint, rr AdminPasswordResetRequest) (AdminPasswordResetResponse, error) {
+	var user User
+	if res := db.Select("id", "type", "token_version").Where("id = ?", userId).Take(&user); res.Error != nil {
+		return AdminPasswordResetResponse{}, errors.New("user not found")
+	}
+	// Third party users don't have a password with us.
+	if user.Type != 0 {
+		return AdminPasswordResetResponse{}, errors.New("password can only be reset for watcharr users")
+	}
+	password := rr.NewPassword
+	if password == "" {
+		b, err := generateRandomBytes(12)
+		if err != nil {
+			slog.Error("resetUserPassword: Failed to generate temporary password", "error", err)
+			return AdminPasswordResetResponse{}, errors.New("failed to generate temporary password")
+		}
+		password = base64.RawURLEncoding.EncodeToString(b)
+	} else if hasControlChars(password) {
+		return AdminPasswordResetResponse{}, errors.New("password must not contain control characters")
+	}
+	hash, err := hashPassword(password, newArgonParams())
+	if err != nil {
+		slog.Error("resetUserPassword: Failed to hash password", "error", err)
+		return AdminPasswordResetResponse{}, ErrPasswordHash
+	}
+	res := db.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": true,
+		"token_version":        user.TokenVersion + 1,
+	})
+	if res.Error != nil {
+		slog.Error("resetUserPassword: Failed to update user", "userId", userId, "error", res.Error)
+		return AdminPasswordResetResponse{}, errors.New("failed to reset password")
+	}
+	slog.Info("Admin reset a users password", "admin_user_id", adminId, "user_id", userId)
+	return AdminPasswordResetResponse{TemporaryPassword: password}, nil
+}
+
+// How long impersonation tokens last, they can't be renewed.
+const impersonationTokenTTL = 15 * time.Minute
+
+// Get a short lived token to act as a user, for debugging
+// problems they report. Everything done with it is logged with the admins id.
+func impersonateUser(db *gorm.DB, adminId uint, userId uint) (ImpersonationResponse, error) {
+	if adminId == userId {
+		return ImpersonationResponse{}, errors.New("you can't impersonate yourself")
+	}
+	var user User
+	if res := db.Select("id", "username", "permissions", "token_version").Where("id = ?", userId).Take(&user); res.Error != nil {
+		return ImpersonationResponse{}, ErrUserNotFound
+	}
+	// Acting as another admin would let one admin do things as another.
+	if user.Permissions&PERM_ADMIN != 0 {
+		return ImpersonationResponse{}, ErrImpersonateAdmin
+	}
+	expiresAt := timeNow().Add(impersonationTokenTTL)
+	token, err := signImpersonationJWT(&user, adminId, expiresAt)
+	if err != nil {
+		slog.Error("impersonateUser: Failed to sign token", "error", err)
+		return ImpersonationResponse{}, errors.New("failed to get impersonation token")
+	}
+	slog.Warn("Admin started impersonating a user", "admin_user_id", adminId, "user_id", userId, "expires_at", expiresAt.UTC())
+	return ImpersonationResponse{Token: token, ExpiresAt: expiresAt.UTC(), UserID: user.ID, Username: user.Username}, nil
+}
+
+// If an impersonation token is still usable, it must expire and the admin
+// who got it must still be an admin. Their token being valid isn't checked,
+// since it isn't available, but the impersonation token is short lived.
+func validImpersonation(db *gorm.DB, claims *TokenClaims) bool {
+	if claims.ExpiresAt == nil {
+		slog.Warn("Impersonation token has no expiry", "userId", claims.UserID, "impersonatorId", claims.ImpersonatorID)
+		return false
+	}
+	var admin User
+	res := db.Model(&User{}).Select("id", "permissions").Where("id = ?", claims.ImpersonatorID).Take(&admin)
+	if res.Error != nil || admin.Permissions&PERM_ADMIN == 0 {
+		slog.Warn("Impersonation token from a user who is no longer an admin", "userId", claims.UserID, "impersonatorId", claims.ImpersonatorID)
+		return false
+	}
+	return true
+}
+
+var (
+	ErrContentNotFound = errors.New("content not found")
+	// Content can't be deleted while a watched entry references it.
+	ErrContentReferenced = errors.New("content is on a watched list, it can't be deleted")
+)
+
+type AdminContentQuery struct {
+	PageQuery
+	// Only content with a title containing this.
+	Search string `form:"search" binding:"max=200"`
+	// Shorter alias of Search.
+	Q string `form:"q" binding:"max=200"`
+	// Only content no watched entry references.
+	Orphaned bool `form:"orphaned"`
+}
+
+type AdminContentItem struct {
+	Content
+	// Number of users with the content on a watched list
+	// (including removed entries, they still reference it).
+	Users int64 `json:"users"`
+	// Number of watched entries referencing the content.
+	WatchedCount int64 `json:"watchedCount"`
+	// If the poster has been downloaded to disk.
+	PosterOnDisk bool `json:"posterOnDisk"`
+	// When the contents data was last fetched from TMDB,
+	// nil if it was cached before this was tracked.
+	CachedAt *time.Time `json:"cachedAt"`
+}
+
+// Returned when content can't be deleted, because it is still watched.
+type AdminContentReferencedResponse struct {
+	Error        string `json:"error"`
+	WatchedCount int64  `json:"watchedCount"`
+}
+
+type AdminContentResponse struct {
+	Content []AdminContentItem `json:"content"`
+	Page    int                `json:"page"`
+	Total   int64              `json:"total"`
+}
+
+// Get a page of our cached content, with how much each row is used.
+func getAdminContent(db *gorm.DB, q AdminContentQuery) (AdminContentResponse, error) {
+	q.setDefaults()
+	if q.Search == "" {
+		q.Search = q.Q
+	}
+	resp := AdminContentResponse{Content: []AdminContentItem{}, Page: q.Page}
+	base := db.Model(&Content{})
+	if q.Search != "" {
+		base = base.Where(`title LIKE ? ESCAPE '\'`, "%"+escapeLike(q.Search)+"%")
+	}
+	if q.Orphaned {
+		base = base.Where("id NOT IN (?)", db.Unscoped().Model(&Watched{}).Select("content_id"))
+	}
+	if res := base.Session(&gorm.Session{}).Count(&resp.Total); res.Error != nil {
+		slog.Error("getAdminContent: Failed to count content", "error", res.Error)
+		return AdminContentResponse{}, errors.New("failed to get content")
+	}
+	var content []Content
+	res := base.Session(&gorm.Session{}).
+		Omit("cached_detail").
+		Order("title, id").
+		Offset(q.offset()).
+		Limit(q.Limit).
+		Find(&content)
+	if res.Error != nil {
+		slog.Error("getAdminContent: Failed to get content", "error", res.Error)
+		return AdminContentResponse{}, errors.New("failed to get content")
+	}
+	if len(content) == 0 {
+		return resp, nil
+	}
+	ids := []int{}
+	for _, c := range content {
+		ids = append(ids, c.ID)
+	}
+	var counts []struct {
+		ContentID int
+		Users     int64
+		Watched   int64
+	}
+	res = db.Unscoped().Model(&Watched{}).
+		Select("content_id, COUNT(DISTINCT user_id) AS users, COUNT(*) AS watched").
+		Where("content_id IN ?", ids).
+		Group("content_id").
+		Scan(&counts)
+	if res.Error != nil {
+		slog.Error("getAdminContent: Failed to count content users", "error", res.Error)
+		return AdminContentResponse{}, errors.New("failed to get content")
+	}
+	users := map[int]int64{}
+	watched := map[int]int64{}
+	for _, c := range counts {
+		users[c.ContentID] = c.Users
+		watched[c.ContentID] = c.Watched
+	}
+	for _, c := range content {
+		cachedAt := c.LastRefreshedAt
+		if cachedAt == nil {
+			cachedAt = c.CreatedAt
+		}
+		resp.Content = append(resp.Content, AdminContentItem{
+			Content:      c,
+			Users:        users[c.ID],
+			WatchedCount: watched[c.ID],
+			PosterOnDisk: c.PosterPath != "" && posterOnDisk(c.PosterPath),
+			CachedAt:     cachedAt,
+		})
+	}
+	return resp, nil
+}
+
+// Delete content that isn't on anyones watched list, with its cached images.
+// When it is still watched, ErrContentReferenced is returned with the watched count.
+func deleteAdminContent(db *gorm.DB, id int) (int64, error) {
+	var content Content
+	if res := db.Model(&Content{}).Omit("cached_detail").Where("id = ?", id).Take(&content); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return 0, ErrContentNotFound
+		}
+		slog.Error("deleteAdminContent: Failed to get content", "id", id, "error", res.Error)
+		return 0, errors.New("failed to get content")
+	}
+	var count int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Unscoped().Model(&Watched{}).Where("content_id = ?", id).Count(&count); res.Error != nil {
+			return res.Error
+		}
+		if count > 0 {
+			return ErrContentReferenced
+		}
+		if res := tx.Model(&Notification{}).Where("content_id = ?", id).Update("content_id", nil); res.Error != nil {
+			return res.Error
+		}
+		return tx.Delete(&Content{}, id).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrContentReferenced) {
+			return count, err
+		}
+		slog.Error("deleteAdminContent: Failed to delete content", "id", id, "error", err)
+		return 0, errors.New("failed to delete content")
+	}
+	if content.PosterPath != "" {
+		if err := os.Remove(posterFilePath(content.PosterPath)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("deleteAdminContent: Failed to remove poster", "path", content.PosterPath, "error", err)
+		}
+	}
+	if content.Type == SHOW {
+		if err := os.RemoveAll(dataPath(imgDir, "stills", strconv.Itoa(content.TmdbID))); err != nil {
+			slog.Warn("deleteAdminContent: Failed to remove stills", "tmdb_id", content.TmdbID, "error", err)
+		}
+	}
+	slog.Info("Deleted content", "id", id, "tmdbId", content.TmdbID, "type", content.Type, "title", content.Title)
+	return 0, nil
+}
+
+// Refresh content from its provider now, instead of waiting for the refresh job.
+func refreshAdminContent(db *gorm.DB, id int) (Content, error) {
+	var content Content
+	if res := db.Where("id = ?", id).Take(&content); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return Content{}, ErrContentNotFound
+		}
+		slog.Error("refreshAdminContent: Failed to get content", "id", id, "error", res.Error)
+		return Content{}, errors.New("failed to get content")
+	}
+	if err := refreshContent(db, &content); err != nil {
+		slog.Error("refreshAdminContent: Failed to refresh content", "id", id, "error", err)
+		return Content{}, errors.New("failed to refresh content")
+	}
+	return content, nil
+}
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrImpersonateAdmin = errors.New("admins can't be impersonated")
+
+// How long deleted users are kept (soft deleted) before their data is removed for good.
+const deletedUserRetention = 24 * time.Hour
+
+// How often we check for deleted users that are ready to be purged.
+const deletedUserPurgeInterval = time.Hour
+
+// Delete a user, by an admin. The user and their watched list are soft deleted
+// straight away (so they can't login and don't show up anywhere), then
+// everything is removed for good by the purge job after deletedUserRetention.
+func deleteUserWithCascade(db *gorm.DB, adminId uint, userId uint) error {
+	if adminId == userId {
+		return errors.New("you can't delete yourself")
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if res := tx.Select("id").Where("id = ?", userId).Take(&user); res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return res.Error
+		}
+		// Invalidate their tokens too, incase anything doesn't check deleted_at.
+		if res := tx.Model(&user).Update("token_version", gorm.Expr("token_version + 1")); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Where("user_id = ?", userId).Delete(&Watched{}); res.Error != nil {
+			return res.Error
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		slog.Error("deleteUserWithCascade: Failed to delete user", "user_id", userId, "error", err)
+		return errors.New("failed to delete user")
+	}
+	slog.Info("Deleted user, their data will be purged later", "user_id", userId, "admin_user_id", adminId, "purge_after", deletedUserRetention)
+	return nil
+}
+
+// Periodically purge users that were deleted over deletedUserRetention ago.
+func startDeletedUserPurgeJob(db *gorm.DB) {
+	for {
+		purgeDeletedUsers(db)
+		time.Sleep(deletedUserPurgeInterval)
+	}
+}
+
+func purgeDeletedUsers(db *gorm.DB) {
+	var ids []uint
+	res := db.Unscoped().Model(&User{}).Where("deleted_at IS NOT NULL AND deleted_at < ?", timeNow().Add(-deletedUserRetention)).Pluck("id", &ids)
+	if res.Error != nil {
+		slog.Error("purgeDeletedUsers: Failed to get deleted users", "error", res.Error)
+		return
+	}
+	for _, id := range ids {
+		if err := purgeUser(db, id); err != nil {
+			slog.Error("purgeDeletedUsers: Failed to purge user", "user_id", id, "error", err)
+			continue
+		}
+		slog.Info("Purged deleted user", "user_id", id)
+	}
+}
+
+// Remove a user and all of their data for good, in one transaction.
+func purgeUser(db *gorm.DB, userId uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Unscoped().Session(&gorm.Session{})
+		watchedIds := tx.Model(&Watched{}).Select("id").Where("user_id = ?", userId)
+		if res := tx.Where("watched_id IN (?)", watchedIds).Delete(&WatchedEpisode{}); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Where("watched_id IN (?)", watchedIds).Delete(&WatchedTag{}); res.Error != nil {
+			return res.Error
+		}
+		for _, m := range []any{&Activity{}, &Watched{}, &SubProfile{}, &Notification{}, &UserProfile{}, &WatchGoal{}, &Job{}, &ReWatchEntry{}, &AuthLog{}, &Tag{}} {
+			if res := tx.Where("user_id = ?", userId).Delete(m); res.Error != nil {
+				return res.Error
+			}
+		}
+		return tx.Delete(&User{}, userId).Error
+	})
+}
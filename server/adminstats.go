@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Number of items in each top list of admin stats.
+const adminStatsTopLimit = 10
+
+type AdminStatsQuery struct {
+	// Start of time bounded stats (YYYY-MM-DD), defaults to 30 days ago.
+	Since *time.Time `form:"since" time_format:"2006-01-02"`
+}
+
+type AdminStats struct {
+	// Start of the time bounded stats below (new*, most*).
+	Since         time.Time `json:"since"`
+	TotalUsers    int64     `json:"totalUsers"`
+	NewUsers      int64     `json:"newUsers"`
+	TotalWatched  int64     `json:"totalWatched"`
+	NewWatched    int64     `json:"newWatched"`
+	TotalContent  int64     `json:"totalContent"`
+	DatabaseSize  int64     `json:"databaseSize"`
+	ImageDiskSize int64     `json:"imageDiskSize"`
+	// Content added to the most watched lists since Since.
+	MostWatched []AdminStatsContent `json:"mostWatched"`
+	// Users that added the most to their watched lists since Since.
+	MostActiveUsers []AdminStatsUser `json:"mostActiveUsers"`
+}
+
+type AdminStatsContent struct {
+	ID     int         `json:"id"`
+	TmdbID int         `json:"tmdbId"`
+	Title  string      `json:"title"`
+	Type   ContentType `json:"type"`
+	Count  int64       `json:"count"`
+}
+
+type AdminStatsUser struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Count    int64  `json:"count"`
+}
+
+// Get server wide usage stats.
+func getAdminStats(db *gorm.DB, q AdminStatsQuery) (AdminStats, error) {
+	stats := AdminStats{Since: timeNow().AddDate(0, 0, -30).Truncate(24 * time.Hour), MostWatched: []AdminStatsContent{}, MostActiveUsers: []AdminStatsUser{}}
+	if q.Since != nil {
+		stats.Since = *q.Since
+	}
+	counts := []struct {
+		q     *gorm.DB
+		count *int64
+	}{
+		{db.Model(&User{}), &stats.TotalUsers},
+		{db.Model(&User{}).Where("created_at >= ?", stats.Since), &stats.NewUsers},
+		{db.Model(&Watched{}), &stats.TotalWatched},
+		{db.Model(&Watched{}).Where("created_at >= ?", stats.Since), &stats.NewWatched},
+		{db.Model(&Content{}), &stats.TotalContent},
+	}
+	for _, c := range counts {
+		if res := c.q.Count(c.count); res.Error != nil {
+			slog.Error("getAdminStats: Failed to count", "error", res.Error)
+			return AdminStats{}, errors.New("failed to get stats")
+		}
+	}
+	res := db.Raw("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&stats.DatabaseSize)
+	if res.Error != nil {
+		slog.Error("getAdminStats: Failed to get database size", "error", res.Error)
+		return AdminStats{}, errors.New("failed to get stats")
+	}
+	res = db.Model(&Watched{}).
+		Select("contents.id, contents.tmdb_id, contents.title, contents.type, COUNT(*) AS count").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.created_at >= ?", stats.Since).
+		Group("contents.id").
+		Order("count DESC, contents.title").
+		Limit(adminStatsTopLimit).
+		Scan(&stats.MostWatched)
+	if res.Error != nil {
+		slog.Error("getAdminStats: Failed to get most watched content", "error", res.Error)
+		return AdminStats{}, errors.New("failed to get stats")
+	}
+	res = db.Model(&Watched{}).
+		Select("users.id, users.username, COUNT(*) AS count").
+		Joins("JOIN users ON users.id = watcheds.user_id AND users.deleted_at IS NULL").
+		Where("watcheds.created_at >= ?", stats.Since).
+		Group("users.id").
+		Order("count DESC, users.username").
+		Limit(adminStatsTopLimit).
+		Scan(&stats.MostActiveUsers)
+	if res.Error != nil {
+		slog.Error("getAdminStats: Failed to get most active users", "error", res.Error)
+		return AdminStats{}, errors.New("failed to get stats")
+	}
+	size, err := dirSize(dataPath(imgDir))
+	if err != nil {
+		// Not worth failing over, the rest of the stats are still useful.
+		slog.Warn("getAdminStats: Failed to get image disk usage", "error", err)
+	}
+	stats.ImageDiskSize = size
+	return stats, nil
+}
+
+// Total size of all files under dir, 0 if it doesn't exist.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Users seen within this long are considered active.
+const activeUserWindow = 30 * 24 * time.Hour
+
+// How often a users last seen time is updated, so every request isn't a write.
+const lastSeenUpdateInterval = 5 * time.Minute
+
+var ErrLastAdmin = errors.New("the last admin can't be demoted")
+
+type AdminUsersQuery struct {
+	PageQuery
+	// Only users with a username containing this.
+	Q string `form:"q" binding:"max=200"`
+	// What to sort by (most recent/most first), defaults to createdAt.
+	Sort string `form:"sort" binding:"omitempty,oneof=createdAt lastSeen watchedCount"`
+}
+
+type AdminUser struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Type      UserType  `json:"type"`
+	IsAdmin   bool      `json:"isAdmin"`
+	CreatedAt time.Time `json:"createdAt"`
+	// nil if the user hasn't been seen since this was tracked.
+	LastSeenAt *time.Time `json:"lastSeenAt"`
+	// Number of items on the users (and their profiles) watched lists.
+	WatchedCount int64 `json:"watchedCount"`
+	// If the user has been seen within activeUserWindow.
+	IsActive bool `json:"isActive"`
+}
+
+type AdminUsersResponse struct {
+	Users []AdminUser `json:"users"`
+	Page  int         `json:"page"`
+	Total int64       `json:"total"`
+}
+
+// Get a page of users, for managing them.
+func getAdminUsers(db *gorm.DB, q AdminUsersQuery) (AdminUsersResponse, error) {
+	q.setDefaults()
+	resp := AdminUsersResponse{Users: []AdminUser{}, Page: q.Page}
+	base := db.Model(&User{})
+	if q.Q != "" {
+		base = base.Where(`username LIKE ? ESCAPE '\'`, "%"+escapeLike(q.Q)+"%")
+	}
+	if res := base.Session(&gorm.Session{}).Count(&resp.Total); res.Error != nil {
+		slog.Error("getAdminUsers: Failed to count users", "error", res.Error)
+		return AdminUsersResponse{}, errors.New("failed to get users")
+	}
+	order := "users.created_at DESC"
+	switch q.Sort {
+	case "lastSeen":
+		order = "users.last_seen_at IS NULL, users.last_seen_at DESC"
+	case "watchedCount":
+		order = "watched_count DESC"
+	}
+	var rows []struct {
+		User
+		WatchedCount int64
+	}
+	res := base.Session(&gorm.Session{}).
+		Select("users.id, users.username, users.type, users.permissions, users.created_at, users.last_seen_at, (?) AS watched_count",
+			db.Model(&Watched{}).Select("COUNT(*)").Where("watcheds.user_id = users.id")).
+		Order(order + ", users.id").
+		Offset(q.offset()).
+		Limit(q.Limit).
+		Find(&rows)
+	if res.Error != nil {
+		slog.Error("getAdminUsers: Failed to get users", "error", res.Error)
+		return AdminUsersResponse{}, errors.New("failed to get users")
+	}
+	for _, r := range rows {
+		resp.Users = append(resp.Users, AdminUser{
+			ID:           r.ID,
+			Username:     r.Username,
+			Type:         r.Type,
+			IsAdmin:      r.Permissions&PERM_ADMIN != 0,
+			CreatedAt:    r.CreatedAt,
+			LastSeenAt:   r.LastSeenAt,
+			WatchedCount: r.WatchedCount,
+			IsActive:     r.LastSeenAt != nil && timeNow().Sub(*r.LastSeenAt) < activeUserWindow,
+		})
+	}
+	return resp, nil
+}
+
+// Give or take away a users admin permission.
+// The last admin can't be demoted, so the server can always be managed.
+func setUserAdmin(db *gorm.DB, userId uint, admin bool) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if res := tx.Select("id", "permissions").Where("id = ?", userId).Take(&user); res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return res.Error
+		}
+		perms := user.Permissions | PERM_ADMIN
+		if !admin {
+			var others int64
+			if res := tx.Model(&User{}).Where("id != ? AND permissions & ? != 0", userId, PERM_ADMIN).Count(&others); res.Error != nil {
+				return res.Error
+			}
+			if others == 0 {
+				return ErrLastAdmin
+			}
+			perms = user.Permissions &^ PERM_ADMIN
+		}
+		return tx.Model(&user).Update("permissions", perms).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrLastAdmin) {
+			return err
+		}
+		slog.Error("setUserAdmin: Failed to update user permissions", "user_id", userId, "admin", admin, "error", err)
+		return errors.New("failed to update user")
+	}
+	slog.Info("Changed users admin permission", "user_id", userId, "admin", admin)
+	return nil
+}
+
+// Record that the user was just seen, if it hasn't been for lastSeenUpdateInterval.
+func updateLastSeen(db *gorm.DB, user User) {
+	if user.LastSeenAt != nil && timeNow().Sub(*user.LastSeenAt) < lastSeenUpdateInterval {
+		return
+	}
+	// UpdateColumn, so updated_at isn't bumped by just using the app.
+	res := db.Model(&User{}).Where("id = ?", user.ID).UpdateColumn("last_seen_at", timeNow().UTC())
+	if res.Error != nil {
+		slog.Error("updateLastSeen: Failed to update users last seen time", "user_id", user.ID, "error", res.Error)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How long alternative titles we have cached are used for, they rarely change.
+const alternativeTitlesCacheTTL = 30 * 24 * time.Hour
+
+type ContentAlternativeTitlesResponse struct {
+	ID int `json:"id"`
+	// Title content was originally released under, in its original language.
+	// Empty when we don't have the content yet.
+	OriginalTitle string   `json:"originalTitle"`
+	Titles        []string `json:"titles"`
+}
+
+// Get alternative titles of content, from our cache when we have them, otherwise from TMDB
+// (caching them if we have the content, so watched search can match them).
+func contentAlternativeTitles(db *gorm.DB, contentType ContentType, id string) (ContentAlternativeTitlesResponse, error) {
+	var content Content
+	res := db.Model(&Content{}).Select("id", "title", "original_title", "alternative_titles", "alternative_titles_cached_at").Where("tmdb_id = ? AND type = ?", id, contentType).Limit(1).Find(&content)
+	if res.Error != nil {
+		slog.Error("contentAlternativeTitles: Failed to get cached alternative titles", "tmdb_id", id, "type", contentType, "error", res.Error)
+	}
+	tmdbId, _ := strconv.Atoi(id)
+	if res.RowsAffected > 0 && content.AlternativeTitlesCachedAt != nil && timeNow().Sub(*content.AlternativeTitlesCachedAt) < alternativeTitlesCacheTTL {
+		return ContentAlternativeTitlesResponse{ID: tmdbId, OriginalTitle: content.OriginalTitle, Titles: content.AlternativeTitles}, nil
+	}
+	var found []string
+	if contentType == MOVIE {
+		resp := new(TMDBMovieAlternativeTitles)
+		if err := tmdbRequest("/movie/"+id+"/alternative_titles", map[string]string{}, &resp); err != nil {
+			slog.Error("Failed to complete movie alternative titles request!", "error", err.Error())
+			return ContentAlternativeTitlesResponse{}, errors.New("failed to complete movie alternative titles request")
+		}
+		for _, t := range resp.Titles {
+			found = append(found, t.Title)
+		}
+	} else {
+		resp := new(TMDBShowAlternativeTitles)
+		if err := tmdbRequest("/tv/"+id+"/alternative_titles", map[string]string{}, &resp); err != nil {
+			slog.Error("Failed to complete tv alternative titles request!", "error", err.Error())
+			return ContentAlternativeTitlesResponse{}, errors.New("failed to complete tv alternative titles request")
+		}
+		for _, t := range resp.Results {
+			found = append(found, t.Title)
+		}
+	}
+	titles := alternativeTitleList(found, content.Title)
+	if res.RowsAffected > 0 {
+		res = db.Model(&Content{}).Where("id = ?", content.ID).Updates(map[string]interface{}{
+			"alternative_titles":           titles,
+			"alternative_titles_cached_at": timeNow(),
+		})
+		if res.Error != nil {
+			slog.Error("contentAlternativeTitles: Failed to cache alternative titles", "tmdb_id", id, "type", contentType, "error", res.Error)
+		}
+	}
+	return ContentAlternativeTitlesResponse{ID: tmdbId, OriginalTitle: content.OriginalTitle, Titles: titles}, nil
+}
+
+// Alternative titles without blanks, duplicates (the same title is often
+// listed for many countries) or the title content already has.
+func alternativeTitleList(found []string, title string) JSONList[string] {
+	titles := JSONList[string]{}
+	for _, t := range found {
+		t = strings.TrimSpace(t)
+		if t == "" || t == title || slices.Contains(titles, t) {
+			continue
+		}
+		titles = append(titles, t)
+	}
+	return titles
+}
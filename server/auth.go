@@ -9,11 +9,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -30,20 +30,73 @@ var (
 	JELLYFIN_USER UserType = 1
 )
 
-// uniqueIndex applied between Username and UserType, so same usernames can exist, but only with different types.
+// Bitflags of what a user is permitted to do.
+type Permission int
+
+const (
+	PERM_NONE  Permission = 0
+	PERM_ADMIN Permission = 1 << 0
+)
+
+// uniqueIndex applied between Username, UserType and JellyfinServerID, so same usernames can exist, but only with different types (or jellyfin servers).
 // This is incase different users with same name from different services try to signup.
 type User struct {
 	GormModel
-	Username string `gorm:"uniqueIndex:usr_name_to_type;not null" json:"username" binding:"required"`
+	Username string `gorm:"uniqueIndex:usr_name_type_server;not null" json:"username" binding:"required"`
 	Password string `gorm:"not null" json:"password" binding:"required"`
 	// The type of user/which auth service they originate from.
 	// Empty if from Watcharr, or the name of the service (eg. jellyfin)
-	Type UserType `gorm:"uniqueIndex:usr_name_to_type;not null;default:0" json:"type"`
+	Type UserType `gorm:"uniqueIndex:usr_name_type_server;not null;default:0" json:"type"`
 	// ID of user from the third party service, this will be used purely for lookup of user at signin.
 	ThirdPartyID string `json:"-"`
-	Watched      []Watched
+	// Jellyfin server a jellyfin user is from, 0 for other users.
+	// Part of the unique index, so the same username can exist on multiple servers.
+	JellyfinServerID uint `gorm:"uniqueIndex:usr_name_type_server;not null;default:0" json:"-"`
+	// What this user is allowed to do.
+	Permissions Permission `json:"-" gorm:"not null;default:0"`
+	// Must match the version in a token for it to be accepted.
+	// Incrementing this invalidates all of the users existing tokens.
+	TokenVersion uint `json:"-" gorm:"not null;default:0"`
+	// Set when an admin resets the users password, the user
+	// can't do anything but change it until they have.
+	MustChangePassword bool `json:"-" gorm:"not null;default:false"`
+	// When the user last made an authenticated request,
+	// only updated every lastSeenUpdateInterval.
+	LastSeenAt *time.Time `json:"-"`
+	// Base32 TOTP secret, set by 2fa setup (before it is enabled).
+	TOTPSecret string `json:"-"`
+	// When enabled, a valid TOTP code is required after logging in.
+	TOTPEnabled bool `json:"-" gorm:"not null;default:false"`
+	// Time step of the last accepted code, so codes can't be reused.
+	TOTPLastCounter uint64 `json:"-" gorm:"not null;default:0"`
+	// Users preferences.
+	Settings UserSettings `json:"-" gorm:"embedded;embeddedPrefix:setting_"`
+	Watched  []Watched
+}
+
+// Basic info about the authenticated user.
+type AuthMeResponse struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Type      UserType  `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	IsAdmin   bool      `json:"isAdmin"`
 }
 
+var ErrUserExists = errors.New("User already exists")
+var ErrSignupDisabled = errors.New("signup is disabled on this server")
+var ErrPasswordHash = errors.New("failed to process password")
+var ErrMustChangePassword = errors.New("password must be changed")
+
+// Routes (suffix of full path) a user that must change their password can still use.
+var mustChangePasswordAllowedRoutes = []string{"/auth/me", "/auth/password"}
+
+// Routes impersonation tokens can't use, so whoever is impersonating
+// can't take over the account or get a token that outlives theirs.
+var impersonationBlockedRoutes = []string{"/auth/password", "/auth/2fa/setup", "/auth/2fa/enable", "/auth/2fa/disable", "/profiles/:id/token", "/admin/users/:id/impersonate"}
+
+var ErrImpersonationNotAllowed = errors.New("not allowed while impersonating")
+
 type JellyfinAuth struct {
 	Username string `json:"Username"`
 	Pw       string `json:"Pw"`
@@ -58,9 +111,48 @@ type JellyfinAuthResponse struct {
 
 type AuthResponse struct {
 	Token string `json:"token"`
+	// User must change their password (PUT /auth/password) before they can do anything else.
+	MustChangePassword bool `json:"mustChangePassword,omitempty"`
+	// User the token is for, so clients don't need to request it separately.
+	User *AuthUser `json:"user,omitempty"`
+	// User has 2fa enabled, Token is empty and MFAToken must be exchanged
+	// along with a code (POST /auth/verify-2fa) for one.
+	MFARequired bool   `json:"mfaRequired,omitempty"`
+	MFAToken    string `json:"mfaToken,omitempty"`
+}
+
+// Info about a user clients need when starting a session.
+type AuthUser struct {
+	ID       uint         `json:"id"`
+	Username string       `json:"username"`
+	Type     UserType     `json:"type"`
+	IsAdmin  bool         `json:"isAdmin"`
+	Settings UserSettings `json:"settings"`
+}
+
+// Response for a user that has just been given token.
+func newAuthResponse(user *User, token string) AuthResponse {
+	return AuthResponse{
+		Token:              token,
+		MustChangePassword: user.MustChangePassword,
+		User: &AuthUser{
+			ID:       user.ID,
+			Username: user.Username,
+			Type:     user.Type,
+			IsAdmin:  user.Permissions&PERM_ADMIN != 0,
+			Settings: user.Settings,
+		},
+	}
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
 }
 
 type ArgonParams struct {
+	// argon2id or argon2i, recorded in the encoded hash.
+	variant     string
 	memory      uint32
 	iterations  uint32
 	parallelism uint8
@@ -68,27 +160,43 @@ type ArgonParams struct {
 	keyLength   uint32
 }
 
+// Issuer (iss) of tokens we sign, tokens from any other issuer are rejected.
+const jwtIssuer = "watcharr"
+
 type TokenClaims struct {
-	UserID   uint   `json:"userId"`
-	Username string `json:"username"`
+	UserID       uint   `json:"userId"`
+	Username     string `json:"username"`
+	TokenVersion uint   `json:"tokenVersion"`
+	// When set, token is scoped to this sub profile and can't switch to another.
+	ProfileID uint `json:"profileId,omitempty"`
+	// When set, token was given to this admin to act as the user (for support).
+	ImpersonatorID uint `json:"impersonatorId,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Parse an auth token, only accepting tokens we could have signed. exp is
+// checked when present, but not required, since tokens we sign don't expire yet.
+func parseAuthToken(atoken string) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(atoken, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(jwtIssuer))
+}
+
 // Auth middleware
-func AuthRequired() gin.HandlerFunc {
+func AuthRequired(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		slog.Debug("AuthRequired middleware hit")
-		atoken := c.GetHeader("Authorization")
-		// Make sure auth header isn't empty
+		atoken := getRequestToken(c)
+		// Make sure we have a token
 		if atoken == "" {
-			slog.Warn("Returning 401, Authorization header not provided")
+			slog.Warn("Returning 401, Authorization header (or auth cookie) not provided")
 			c.AbortWithStatus(401)
 			return
 		}
-		// Parse token
-		token, err := jwt.ParseWithClaims(atoken, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		token, err := parseAuthToken(atoken)
 		if err != nil {
 			slog.Error("AuthRequired failed to parse token", "error", err)
 			c.AbortWithStatus(401)
@@ -97,8 +205,63 @@ func AuthRequired() gin.HandlerFunc {
 		// If token is valid, go to next handler
 		if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
 			slog.Debug("Token is valid", "userId", claims.UserID, "username", claims.Username)
+			// Ensure user still exists and token hasn't been invalidated
+			var user User
+			res := db.Model(&User{}).Select("id", "created_at", "username", "type", "permissions", "token_version", "must_change_password", "last_seen_at").Where("id = ?", claims.UserID).Take(&user)
+			if res.Error != nil {
+				slog.Error("AuthRequired failed to find user from token", "userId", claims.UserID, "error", res.Error)
+				c.AbortWithStatus(401)
+				return
+			}
+			if user.TokenVersion != claims.TokenVersion {
+				slog.Warn("AuthRequired token has been invalidated", "userId", claims.UserID)
+				c.AbortWithStatus(401)
+				return
+			}
+			if claims.ImpersonatorID != 0 {
+				if !validImpersonation(db, claims) {
+					c.AbortWithStatus(401)
+					return
+				}
+				if slices.ContainsFunc(impersonationBlockedRoutes, func(r string) bool { return strings.HasSuffix(c.FullPath(), r) }) {
+					slog.Warn("Returning 403, route not allowed while impersonating", "userId", claims.UserID, "impersonatorId", claims.ImpersonatorID)
+					c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: ErrImpersonationNotAllowed.Error()})
+					return
+				}
+				c.Set("impersonatorId", claims.ImpersonatorID)
+			}
+			if user.MustChangePassword && !slices.ContainsFunc(mustChangePasswordAllowedRoutes, func(r string) bool { return strings.HasSuffix(c.FullPath(), r) }) {
+				slog.Warn("Returning 403, user must change their password", "userId", claims.UserID)
+				c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: ErrMustChangePassword.Error()})
+				return
+			}
+			// Resolve which profile is active, a profile scoped
+			// token always wins over the requested profile.
+			profileId := claims.ProfileID
+			if profileId == 0 {
+				requested := c.GetHeader("X-Profile-Id")
+				if requested == "" {
+					requested = c.Query("profile")
+				}
+				profileId, err = resolveSubProfile(db, claims.UserID, requested)
+				if err != nil {
+					slog.Warn("AuthRequired failed to resolve requested profile", "userId", claims.UserID, "error", err)
+					c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+					return
+				}
+			}
+			updateLastSeen(db, user)
 			c.Set("userId", claims.UserID)
+			c.Set("profileId", profileId)
+			c.Set("userPermissions", user.Permissions)
+			// Basic user info, only the fields selected above are filled in.
+			c.Set("user", user)
 			c.Next()
+			// Anything changed while impersonating is audited, reads are only in the access log.
+			if claims.ImpersonatorID != 0 && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				recordAuthEvent(db, c, AUTH_IMPERSONATED_REQUEST, &user.ID, user.Username,
+					fmt.Sprintf("by admin %d: %s %s (%d)", claims.ImpersonatorID, c.Request.Method, c.Request.URL.Path, c.Writer.Status()))
+			}
 		} else {
 			slog.Error("Token is **not** valid")
 			c.AbortWithStatus(401)
@@ -107,28 +270,108 @@ func AuthRequired() gin.HandlerFunc {
 	}
 }
 
+// Admin middleware, must be used after AuthRequired.
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms := c.MustGet("userPermissions").(Permission)
+		// Impersonating an admin doesn't give admin access.
+		if _, impersonating := c.Get("impersonatorId"); impersonating {
+			slog.Warn("Returning 403, admin routes can't be used while impersonating", "userId", c.MustGet("userId"))
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: ErrImpersonationNotAllowed.Error()})
+			return
+		}
+		if perms&PERM_ADMIN == 0 {
+			slog.Warn("Returning 403, user is not an admin", "userId", c.MustGet("userId"))
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "admin only"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// First user to be created is made admin, so the instance owner
+// can manage the server without having to touch the database.
+func setAdminIfFirstUser(db *gorm.DB, user *User) {
+	var count int64
+	res := db.Model(&User{}).Unscoped().Count(&count)
+	if res.Error != nil {
+		slog.Error("Failed to count users to check if new user should be admin", "error", res.Error)
+		return
+	}
+	if count == 0 {
+		slog.Info("First user is being created, making them admin", "username", user.Username)
+		user.Permissions |= PERM_ADMIN
+	}
+}
+
+// If new users can register, signup being disabled never stops the first user (the admin) registering.
+func signupAllowed(db *gorm.DB) bool {
+	s, err := getServerSettings(db)
+	if err != nil || s.SignupEnabled {
+		return true
+	}
+	var count int64
+	if res := db.Model(&User{}).Unscoped().Count(&count); res.Error != nil {
+		slog.Error("signupAllowed: Failed to count users", "error", res.Error)
+		return false
+	}
+	return count == 0
+}
+
+// Ensure an admin exists, for instances created before admins were
+// introduced, the oldest user (the instance owner) is made admin.
+func ensureAdminExists(db *gorm.DB) {
+	var count int64
+	db.Model(&User{}).Where("permissions & ? != 0", PERM_ADMIN).Count(&count)
+	if count > 0 {
+		return
+	}
+	var user User
+	res := db.Model(&User{}).Order("id").Limit(1).Find(&user)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	res = db.Model(&User{}).Where("id = ?", user.ID).Update("permissions", user.Permissions|PERM_ADMIN)
+	if res.Error != nil {
+		slog.Error("Failed to make oldest user admin", "error", res.Error)
+		return
+	}
+	slog.Info("No admin found, made oldest user admin", "userId", user.ID, "username", user.Username)
+}
+
+// Ensure credentials don't contain anything unexpected.
+func validateCredentials(user *User) error {
+	if hasControlChars(user.Username) || hasControlChars(user.Password) {
+		return errors.New("username and password must not contain control characters")
+	}
+	return nil
+}
+
 func register(user *User, db *gorm.DB) (AuthResponse, error) {
 	slog.Info("A user is registering", "username", user.Username)
-	hash, err := hashPassword(user.Password, &ArgonParams{
-		memory:      64 * 1024,
-		iterations:  3,
-		parallelism: 2,
-		saltLength:  16,
-		keyLength:   32,
-	})
+	if err := validateCredentials(user); err != nil {
+		return AuthResponse{}, err
+	}
+	if !signupAllowed(db) {
+		return AuthResponse{}, ErrSignupDisabled
+	}
+	hash, err := hashPassword(user.Password, newArgonParams())
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Registration failed, could not hash password", "error", err)
+		return AuthResponse{}, ErrPasswordHash
 	}
 
 	// Update user obj to replace the plaintext pass with hash
 	user.Password = hash
+	user.Settings = newUserSettings(db)
+	setAdminIfFirstUser(db, user)
 
 	res := db.Create(&user)
 	if res.Error != nil {
 		// If error is because unique contraint failed.. user already exists
-		if strings.Contains(res.Error.Error(), "UNIQUE") {
+		if isDuplicateErr(res.Error) {
 			slog.Error("Registration failed", "error", res.Error.Error(), "error_pretty", "User already exists")
-			return AuthResponse{}, errors.New("User already exists")
+			return AuthResponse{}, ErrUserExists
 		}
 		slog.Error("Registration failed", "error", err, "error_pretty", "Watcharr does not know why this failed, assume database operation failed")
 		return AuthResponse{}, errors.New("unknown error")
@@ -146,11 +389,14 @@ func register(user *User, db *gorm.DB) (AuthResponse, error) {
 		slog.Error("Registration: Failed to sign new jwt", "error", err)
 		return AuthResponse{}, errors.New("failed to get auth token")
 	}
-	return AuthResponse{Token: token}, nil
+	return newAuthResponse(user, token), nil
 }
 
 func login(user *User, db *gorm.DB) (AuthResponse, error) {
 	slog.Debug("A User Is Logging In", "username", user.Username)
+	if err := validateCredentials(user); err != nil {
+		return AuthResponse{}, err
+	}
 	dbUser := new(User)
 	res := db.Where("username = ? AND (type IS NULL OR type = 0)", user.Username).Take(&dbUser)
 	if res.Error != nil {
@@ -168,22 +414,77 @@ func login(user *User, db *gorm.DB) (AuthResponse, error) {
 		return AuthResponse{}, errors.New("incorrect details")
 	}
 
+	if dbUser.TOTPEnabled {
+		return mfaRequired(dbUser)
+	}
 	token, err := signJWT(dbUser)
 	if err != nil {
 		slog.Error("Failed to sign new jwt", "error", err)
 		return AuthResponse{}, errors.New("failed to get auth token")
 	}
-	return AuthResponse{Token: token}, nil
+	return newAuthResponse(dbUser, token), nil
+}
+
+// Change a users password, invalidating all of their existing tokens.
+// Returns a new token, so the user doesn't have to login again.
+func changePassword(db *gorm.DB, userId uint, pr PasswordChangeRequest) (AuthResponse, error) {
+	if hasControlChars(pr.NewPassword) {
+		return AuthResponse{}, errors.New("password must not contain control characters")
+	}
+	var user User
+	if res := db.Where("id = ?", userId).Take(&user); res.Error != nil {
+		slog.Error("changePassword: Failed to get user", "userId", userId, "error", res.Error)
+		return AuthResponse{}, errors.New("failed to get user")
+	}
+	// Third party users don't have a password with us.
+	if user.Type != 0 {
+		return AuthResponse{}, errors.New("password can only be changed for watcharr users")
+	}
+	match, err := compareHash(pr.CurrentPassword, user.Password)
+	if err != nil {
+		slog.Error("changePassword: Failed to compare pass to hash", "userId", userId, "error", err)
+		return AuthResponse{}, errors.New("failed to change password")
+	}
+	if !match {
+		return AuthResponse{}, errors.New("incorrect current password")
+	}
+	hash, err := hashPassword(pr.NewPassword, newArgonParams())
+	if err != nil {
+		slog.Error("changePassword: Failed to hash password", "userId", userId, "error", err)
+		return AuthResponse{}, ErrPasswordHash
+	}
+	user.Password = hash
+	user.MustChangePassword = false
+	user.TokenVersion++
+	res := db.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+		"password":             user.Password,
+		"must_change_password": false,
+		"token_version":        user.TokenVersion,
+	})
+	if res.Error != nil {
+		slog.Error("changePassword: Failed to update user", "userId", userId, "error", res.Error)
+		return AuthResponse{}, errors.New("failed to change password")
+	}
+	slog.Info("User changed their password", "userId", userId)
+	token, err := signJWT(&user)
+	if err != nil {
+		slog.Error("Failed to sign new jwt", "error", err)
+		return AuthResponse{}, errors.New("failed to get auth token")
+	}
+	return newAuthResponse(&user, token), nil
 }
 
-func loginJellyfin(user *User, db *gorm.DB) (AuthResponse, error) {
-	jellyfinHost := os.Getenv("JELLYFIN_HOST")
-	if jellyfinHost == "" {
-		slog.Error("Request made to login via Jellyfin, but JELLYFIN_HOST environment variable is not set.")
-		return AuthResponse{}, errors.New("jellyfin login not enabled")
+func loginJellyfin(lr JellyfinLoginRequest, db *gorm.DB) (AuthResponse, error) {
+	server, err := getJellyfinServer(db, lr.ServerID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	user := &User{Username: lr.Username, Password: lr.Password}
+	if err := validateCredentials(user); err != nil {
+		return AuthResponse{}, err
 	}
 
-	base, err := url.Parse(jellyfinHost + "/Users/AuthenticateByName")
+	base, err := url.Parse(server.Host + "/Users/AuthenticateByName")
 	if err != nil {
 		slog.Error("Failed to parse AuthenticateByName api endpoint url", "error", err.Error())
 		return AuthResponse{}, errors.New("failed to parse api uri")
@@ -217,7 +518,7 @@ func loginJellyfin(user *User, db *gorm.DB) (AuthResponse, error) {
 		return AuthResponse{}, err
 	}
 	if res.StatusCode != 200 {
-		slog.Error("Jellyfin auth non 200 status code", "status_code", res.StatusCode, "error", string(body))
+		slog.Error("Jellyfin auth non 200 status code", "server", server.Name, "status_code", res.StatusCode, "error", string(body))
 		return AuthResponse{}, errors.New("incorrect details")
 	}
 	// Process auth response
@@ -231,14 +532,17 @@ func loginJellyfin(user *User, db *gorm.DB) (AuthResponse, error) {
 	}
 
 	dbUser := new(User)
-	dbRes := db.Where("third_party_id = ?", resp.User.ID).Take(&dbUser)
+	dbRes := db.Where("third_party_id = ? AND jellyfin_server_id = ?", resp.User.ID, server.ID).Take(&dbUser)
 	if dbRes.Error != nil {
 		if errors.Is(dbRes.Error, gorm.ErrRecordNotFound) {
 			// Record not found, so we should create the user
 			// dbUser will be empty, so we can just reuse it for this purpose.
 			dbUser.ThirdPartyID = resp.User.ID
+			dbUser.JellyfinServerID = server.ID
 			dbUser.Username = resp.User.Name
 			dbUser.Type = JELLYFIN_USER
+			dbUser.Settings = newUserSettings(db)
+			setAdminIfFirstUser(db, dbUser)
 
 			dbRes = db.Create(&dbUser)
 			if dbRes.Error != nil {
@@ -250,23 +554,51 @@ func loginJellyfin(user *User, db *gorm.DB) (AuthResponse, error) {
 		}
 	}
 
+	if dbUser.TOTPEnabled {
+		return mfaRequired(dbUser)
+	}
 	token, err := signJWT(dbUser)
 	if err != nil {
 		slog.Error("Failed to sign new (jellyfin login) jwt", "error", err)
 		return AuthResponse{}, errors.New("failed to get auth token")
 	}
+	return newAuthResponse(dbUser, token), nil
+}
+
+// Sign a token for user that is scoped to one of their sub profiles.
+func signSubProfileToken(db *gorm.DB, userId uint, profileId string) (AuthResponse, error) {
+	id, err := resolveSubProfile(db, userId, profileId)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	user := new(User)
+	res := db.Where("id = ?", userId).Take(&user)
+	if res.Error != nil {
+		return AuthResponse{}, errors.New("failed to get user")
+	}
+	token, err := signJWTForProfile(user, id)
+	if err != nil {
+		slog.Error("Failed to sign new sub profile jwt", "error", err)
+		return AuthResponse{}, errors.New("failed to get auth token")
+	}
 	return AuthResponse{Token: token}, nil
 }
 
 func signJWT(user *User) (token string, err error) {
+	return signJWTForProfile(user, 0)
+}
+
+func signJWTForProfile(user *User, profileId uint) (token string, err error) {
 	// Create new jwt with claim data
 	jwt := jwt.NewWithClaims(jwt.SigningMethodHS256, TokenClaims{
-		user.ID,
-		user.Username,
-		jwt.RegisteredClaims{
-			// ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt: jwt.NewNumericDate(time.Now()),
-			Issuer:   "watcharr",
+		UserID:       user.ID,
+		Username:     user.Username,
+		TokenVersion: user.TokenVersion,
+		ProfileID:    profileId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			// ExpiresAt: jwt.NewNumericDate(timeNow().Add(24 * time.Hour)),
+			IssuedAt: jwt.NewNumericDate(timeNow()),
+			Issuer:   jwtIssuer,
 		},
 	})
 
@@ -274,27 +606,100 @@ func signJWT(user *User) (token string, err error) {
 	return jwt.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
+// Sign a token for an admin to act as user until expiresAt.
+func signImpersonationJWT(user *User, adminId uint, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, TokenClaims{
+		UserID:         user.ID,
+		Username:       user.Username,
+		TokenVersion:   user.TokenVersion,
+		ImpersonatorID: adminId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(timeNow()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			Issuer:    jwtIssuer,
+		},
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// Params new passwords are hashed with.
+func newArgonParams() *ArgonParams {
+	return &ArgonParams{
+		variant:     getArgonVariant(),
+		memory:      64 * 1024,
+		iterations:  3,
+		parallelism: 2,
+		saltLength:  16,
+		keyLength:   32,
+	}
+}
+
 func hashPassword(password string, p *ArgonParams) (encodedHash string, err error) {
 	salt, err := generateRandomBytes(p.saltLength)
 	if err != nil {
 		return "", err
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+	variant := p.variant
+	if variant == "" {
+		variant = ARGON2ID
+	}
+	hash, err := argonKey(variant, []byte(password), salt, p)
+	if err != nil {
+		return "", err
+	}
 
 	// Base64 encode the salt and hashed password.
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
 
 	// Format hash in standard way.
-	encodedHash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.iterations, p.parallelism, b64Salt, b64Hash)
+	encodedHash = fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", variant, argon2.Version, p.memory, p.iterations, p.parallelism, b64Salt, b64Hash)
 
 	return encodedHash, nil
 }
 
+const (
+	ARGON2ID = "argon2id"
+	ARGON2I  = "argon2i"
+)
+
+// Bounds for argon2 parameters read from an encoded hash, so a malformed
+// (or malicious) hash can't make us allocate huge amounts of memory or spin
+// the cpu when comparing. Memory is in KiB, we hash with 64MiB.
+const (
+	argonMinMemory      = 8
+	argonMaxMemory      = 1024 * 1024
+	argonMaxIterations  = 16
+	argonMaxParallelism = 16
+)
+
+// Get argon2 variant new passwords are hashed with from
+// ARGON_VARIANT, defaulting to (the recommended) argon2id.
+func getArgonVariant() string {
+	if os.Getenv("ARGON_VARIANT") == ARGON2I {
+		return ARGON2I
+	}
+	return ARGON2ID
+}
+
+// Derive key with the requested argon2 variant.
+func argonKey(variant string, password []byte, salt []byte, p *ArgonParams) ([]byte, error) {
+	switch variant {
+	case ARGON2ID:
+		return argon2.IDKey(password, salt, p.iterations, p.memory, p.parallelism, p.keyLength), nil
+	case ARGON2I:
+		return argon2.Key(password, salt, p.iterations, p.memory, p.parallelism, p.keyLength), nil
+	}
+	return nil, errors.New("unsupported hash variant")
+}
+
+// Where random bytes (eg. salts) come from, tests swap it out to make hashing fail.
+var randRead = rand.Read
+
 func generateRandomBytes(n uint32) ([]byte, error) {
 	b := make([]byte, n)
-	_, err := rand.Read(b)
+	_, err := randRead(b)
 	if err != nil {
 		return nil, err
 	}
@@ -311,7 +716,10 @@ func compareHash(password, encodedHash string) (match bool, err error) {
 	}
 
 	// Derive the key from the other password using the same parameters.
-	otherHash := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+	otherHash, err := argonKey(p.variant, []byte(password), salt, p)
+	if err != nil {
+		return false, err
+	}
 
 	// Check that the contents of the hashed passwords are identical. Note
 	// that we are using the subtle.ConstantTimeCompare() function for this
@@ -324,10 +732,14 @@ func compareHash(password, encodedHash string) (match bool, err error) {
 
 func decodeHash(encodedHash string) (p *ArgonParams, salt, hash []byte, err error) {
 	vals := strings.Split(encodedHash, "$")
-	if len(vals) != 6 {
+	if len(vals) != 6 || vals[0] != "" {
 		return nil, nil, nil, errors.New("the encoded hash is not in the correct format")
 	}
 
+	if vals[1] != ARGON2ID && vals[1] != ARGON2I {
+		return nil, nil, nil, errors.New("unsupported hash variant")
+	}
+
 	var version int
 	_, err = fmt.Sscanf(vals[2], "v=%d", &version)
 	if err != nil {
@@ -337,10 +749,16 @@ func decodeHash(encodedHash string) (p *ArgonParams, salt, hash []byte, err erro
 		return nil, nil, nil, errors.New("incompatible version of argon2")
 	}
 
-	p = &ArgonParams{}
-	_, err = fmt.Sscanf(vals[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
-	if err != nil {
-		return nil, nil, nil, err
+	p = &ArgonParams{variant: vals[1]}
+	var rest string
+	n, _ := fmt.Sscanf(vals[3], "m=%d,t=%d,p=%d%s", &p.memory, &p.iterations, &p.parallelism, &rest)
+	if n != 3 {
+		return nil, nil, nil, errors.New("the encoded hash parameters are not in the correct format")
+	}
+	if p.memory < argonMinMemory || p.memory > argonMaxMemory ||
+		p.iterations < 1 || p.iterations > argonMaxIterations ||
+		p.parallelism < 1 || p.parallelism > argonMaxParallelism {
+		return nil, nil, nil, errors.New("the encoded hash parameters are out of range")
 	}
 
 	salt, err = base64.RawStdEncoding.Strict().DecodeString(vals[4])
@@ -348,12 +766,18 @@ func decodeHash(encodedHash string) (p *ArgonParams, salt, hash []byte, err erro
 		return nil, nil, nil, err
 	}
 	p.saltLength = uint32(len(salt))
+	if p.saltLength < 8 || p.saltLength > 64 {
+		return nil, nil, nil, errors.New("the encoded hash salt length is out of range")
+	}
 
 	hash, err = base64.RawStdEncoding.Strict().DecodeString(vals[5])
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	p.keyLength = uint32(len(hash))
+	if p.keyLength < 16 || p.keyLength > 128 {
+		return nil, nil, nil, errors.New("the encoded hash key length is out of range")
+	}
 
 	return p, salt, hash, nil
 }
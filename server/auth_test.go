@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestHashRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		env         string
+		wantVariant string
+	}{
+		{"default", "", ARGON2ID},
+		{"argon2id", ARGON2ID, ARGON2ID},
+		{"argon2i", ARGON2I, ARGON2I},
+		{"unknown falls back to argon2id", "argon2d", ARGON2ID},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ARGON_VARIANT", tc.env)
+			p := newArgonParams()
+			hash, err := hashPassword("correct horse battery staple", p)
+			if err != nil {
+				t.Fatalf("hashPassword failed: %v", err)
+			}
+			dp, salt, key, err := decodeHash(hash)
+			if err != nil {
+				t.Fatalf("decodeHash(%q) failed: %v", hash, err)
+			}
+			if dp.variant != tc.wantVariant {
+				t.Errorf("got variant %q, want %q", dp.variant, tc.wantVariant)
+			}
+			if dp.memory != p.memory || dp.iterations != p.iterations || dp.parallelism != p.parallelism ||
+				dp.saltLength != p.saltLength || dp.keyLength != p.keyLength || len(salt) != int(p.saltLength) || len(key) != int(p.keyLength) {
+				t.Errorf("decoded params %+v don't match the ones hashed with %+v", dp, p)
+			}
+			for _, pw := range []struct {
+				password string
+				want     bool
+			}{
+				{"correct horse battery staple", true},
+				{"correct horse battery stapl", false},
+				{"Correct horse battery staple", false},
+				{"", false},
+			} {
+				match, err := compareHash(pw.password, hash)
+				if err != nil {
+					t.Fatalf("compareHash failed: %v", err)
+				}
+				if match != pw.want {
+					t.Errorf("compareHash(%q) = %v, want %v", pw.password, match, pw.want)
+				}
+			}
+		})
+	}
+
+	// Hashes keep working after ARGON_VARIANT is changed.
+	t.Setenv("ARGON_VARIANT", ARGON2I)
+	hash, err := hashPassword("password", newArgonParams())
+	if err != nil {
+		t.Fatalf("hashPassword failed: %v", err)
+	}
+	t.Setenv("ARGON_VARIANT", ARGON2ID)
+	if match, err := compareHash("password", hash); err != nil || !match {
+		t.Errorf("argon2i hash didn't match after switching to argon2id (error: %v)", err)
+	}
+}
+
+func FuzzDecodeHash(f *testing.F) {
+	for _, seed := range []string{
+		"$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2i$v=19$m=65536,t=3,p=2$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2d$v=19$m=65536,t=3,p=2$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2id$v=16$m=65536,t=3,p=2$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2id$v=19$m=4294967295,t=3,p=2$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2id$v=19$m=65536,t=0,p=0$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2id$v=19$m=65536,t=3,p=2,x=1$c29tZXNhbHRzb21lc2FsdA$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2id$v=19$m=65536,t=3,p=2$$",
+		"$argon2id$v=19$m=65536,t=3,p=2$c29tZQ$MTIz",
+		"$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHRzb21lc2FsdA==$MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI",
+		"$argon2id$v=19",
+		"plaintext",
+		"",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, encoded string) {
+		p, salt, hash, err := decodeHash(encoded)
+		if err != nil {
+			return
+		}
+		// Anything accepted must be safe to compare against.
+		if p.variant != ARGON2ID && p.variant != ARGON2I {
+			t.Errorf("accepted unsupported variant %q", p.variant)
+		}
+		if p.memory < argonMinMemory || p.memory > argonMaxMemory ||
+			p.iterations < 1 || p.iterations > argonMaxIterations ||
+			p.parallelism < 1 || p.parallelism > argonMaxParallelism {
+			t.Errorf("accepted out of range params %+v", p)
+		}
+		if int(p.saltLength) != len(salt) || len(salt) < 8 || len(salt) > 64 {
+			t.Errorf("accepted salt of length %d (params say %d)", len(salt), p.saltLength)
+		}
+		if int(p.keyLength) != len(hash) || len(hash) < 16 || len(hash) > 128 {
+			t.Errorf("accepted key of length %d (params say %d)", len(hash), p.keyLength)
+		}
+	})
+}
+
+// A failure hashing a password must be reported, not crash the server or save anything.
+func TestPasswordHashFailure(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	var before User
+	s.db.Where("username = ?", "alice").Take(&before)
+
+	origRandRead := randRead
+	randRead = func(b []byte) (int, error) { return 0, errors.New("no randomness left") }
+	t.Cleanup(func() { randRead = origRandRead })
+
+	var resp ErrorResponse
+	s.expect("POST", "/auth/register", "", `{"username":"bob","password":"password123"}`, http.StatusInternalServerError, &resp)
+	if resp.Error != ErrPasswordHash.Error() {
+		t.Errorf("got error %q, want %q", resp.Error, ErrPasswordHash)
+	}
+	var n int64
+	s.db.Model(&User{}).Where("username = ?", "bob").Count(&n)
+	if n != 0 {
+		t.Error("user was created without a password hash")
+	}
+
+	s.expect("PUT", "/auth/password", token, `{"currentPassword":"password123","newPassword":"new-password123"}`, http.StatusInternalServerError, &resp)
+	if resp.Error != ErrPasswordHash.Error() {
+		t.Errorf("got error %q, want %q", resp.Error, ErrPasswordHash)
+	}
+	var after User
+	s.db.Where("username = ?", "alice").Take(&after)
+	if after.Password != before.Password || after.TokenVersion != before.TokenVersion {
+		t.Error("password was changed even though hashing failed")
+	}
+	// Still logged in, since nothing changed.
+	s.expect("GET", "/auth/me", token, "", http.StatusOK, nil)
+
+	// Works again once hashing does.
+	randRead = origRandRead
+	s.register("bob")
+}
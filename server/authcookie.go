@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long the auth cookie lasts. Tokens themselves don't expire,
+// so this only limits how long a browser keeps the user logged in.
+const authCookieMaxAge = 60 * 60 * 24 * 365
+
+// If login/register should also set the token in an HttpOnly
+// cookie (AUTH_COOKIE=true), which AuthRequired then accepts.
+func isAuthCookieEnabled() bool {
+	return os.Getenv("AUTH_COOKIE") == "true"
+}
+
+// Get auth cookie name from AUTH_COOKIE_NAME, defaulting to watcharr_token.
+func getAuthCookieName() string {
+	if name := os.Getenv("AUTH_COOKIE_NAME"); name != "" {
+		return name
+	}
+	return "watcharr_token"
+}
+
+// Get auth cookie path from AUTH_COOKIE_PATH, defaulting to /.
+func getAuthCookiePath() string {
+	if p := os.Getenv("AUTH_COOKIE_PATH"); p != "" {
+		return p
+	}
+	return "/"
+}
+
+// If the auth cookie should only be sent over HTTPS. On unless
+// AUTH_COOKIE_SECURE=false, which is only needed when not using HTTPS.
+func isAuthCookieSecure() bool {
+	return os.Getenv("AUTH_COOKIE_SECURE") != "false"
+}
+
+// Set token as the auth cookie, if the auth cookie is enabled.
+func setAuthCookie(c *gin.Context, token string) {
+	// No token yet when a login still needs a 2fa code.
+	if !isAuthCookieEnabled() || token == "" {
+		return
+	}
+	writeAuthCookie(c, token, authCookieMaxAge)
+}
+
+// Remove the auth cookie from the client.
+func clearAuthCookie(c *gin.Context) {
+	writeAuthCookie(c, "", -1)
+}
+
+func writeAuthCookie(c *gin.Context, value string, maxAge int) {
+	// Strict, so other sites can't make requests with the cookie (CSRF).
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(getAuthCookieName(), value, maxAge, getAuthCookiePath(), "", isAuthCookieSecure(), true)
+}
+
+// Get token from the Authorization header, falling back
+// to the auth cookie when it is enabled.
+func getRequestToken(c *gin.Context) string {
+	if t := c.GetHeader("Authorization"); t != "" {
+		return t
+	}
+	if !isAuthCookieEnabled() {
+		return ""
+	}
+	t, err := c.Cookie(getAuthCookieName())
+	if err != nil {
+		return ""
+	}
+	return t
+}
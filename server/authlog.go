@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AuthEvent string
+
+const (
+	AUTH_LOGIN_SUCCESS           AuthEvent = "login_success"
+	AUTH_LOGIN_FAILED            AuthEvent = "login_failed"
+	AUTH_REGISTER                AuthEvent = "register"
+	AUTH_LOGOUT                  AuthEvent = "logout"
+	AUTH_PASSWORD_CHANGED        AuthEvent = "password_changed"
+	AUTH_PASSWORD_CHANGE_FAILED  AuthEvent = "password_change_failed"
+	AUTH_PASSWORD_RESET_BY_ADMIN AuthEvent = "password_reset_by_admin"
+	AUTH_IMPERSONATION_STARTED   AuthEvent = "impersonation_started"
+	// A change (non GET request) made by an admin while impersonating the user.
+	AUTH_IMPERSONATED_REQUEST AuthEvent = "impersonated_request"
+)
+
+// How long auth events are kept for.
+const authLogRetention = 90 * 24 * time.Hour
+
+// How often old auth events are pruned.
+const authLogPruneInterval = 24 * time.Hour
+
+// Longest user agent we store, anything longer is cut off.
+const authLogMaxUserAgent = 255
+
+// An auth event (eg. a login), kept so suspicious activity can be spotted.
+type AuthLog struct {
+	GormModel
+	// User the event was for, nil when unknown (eg. login with a username that doesn't exist).
+	UserID *uint `json:"userId" gorm:"index"`
+	// Username given, so failed logins for unknown users can be seen too.
+	Username  string    `json:"username"`
+	Event     AuthEvent `json:"event" gorm:"not null;index"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	// Extra info, eg. why a login failed or how it was done (jellyfin, 2fa).
+	Detail string `json:"detail,omitempty"`
+}
+
+type AuthLogsQuery struct {
+	PageQuery
+	UserID uint      `form:"userId"`
+	Event  AuthEvent `form:"event" binding:"omitempty,oneof=login_success login_failed register logout password_changed password_change_failed password_reset_by_admin impersonation_started impersonated_request"`
+}
+
+type AuthLogsResponse struct {
+	Logs  []AuthLog `json:"logs"`
+	Page  int       `json:"page"`
+	Total int64     `json:"total"`
+}
+
+// Record an auth event from a request. Failing to record
+// one is only logged, it shouldn't stop anyone logging in.
+func recordAuthEvent(db *gorm.DB, c *gin.Context, event AuthEvent, userId *uint, username string, detail string) {
+	ua := c.Request.UserAgent()
+	if len(ua) > authLogMaxUserAgent {
+		ua = ua[:authLogMaxUserAgent]
+	}
+	l := AuthLog{UserID: userId, Username: username, Event: event, IP: c.ClientIP(), UserAgent: ua, Detail: detail}
+	if res := db.Create(&l); res.Error != nil {
+		slog.Error("recordAuthEvent: Failed to record auth event", "event", event, "error", res.Error)
+	}
+}
+
+// Id of the (password) user with username, nil if there isn't one.
+func authLogUserId(db *gorm.DB, username string) *uint {
+	var user User
+	if res := db.Select("id").Where("username = ? AND (type IS NULL OR type = 0)", username).Take(&user); res.Error != nil {
+		return nil
+	}
+	return &user.ID
+}
+
+// Get a page of auth events, most recent first.
+func getAuthLogs(db *gorm.DB, q AuthLogsQuery) (AuthLogsResponse, error) {
+	q.setDefaults()
+	resp := AuthLogsResponse{Logs: []AuthLog{}, Page: q.Page}
+	base := db.Model(&AuthLog{})
+	if q.UserID != 0 {
+		base = base.Where("user_id = ?", q.UserID)
+	}
+	if q.Event != "" {
+		base = base.Where("event = ?", q.Event)
+	}
+	if res := base.Session(&gorm.Session{}).Count(&resp.Total); res.Error != nil {
+		slog.Error("getAuthLogs: Failed to count auth logs", "error", res.Error)
+		return AuthLogsResponse{}, errors.New("failed to get auth logs")
+	}
+	res := base.Session(&gorm.Session{}).
+		Order("created_at DESC, id DESC").
+		Offset(q.offset()).
+		Limit(q.Limit).
+		Find(&resp.Logs)
+	if res.Error != nil {
+		slog.Error("getAuthLogs: Failed to get auth logs", "error", res.Error)
+		return AuthLogsResponse{}, errors.New("failed to get auth logs")
+	}
+	return resp, nil
+}
+
+// Get a users recent logins (and failed attempts), most recent first.
+func getRecentLogins(db *gorm.DB, userId uint, q PageQuery) (AuthLogsResponse, error) {
+	q.setDefaults()
+	resp := AuthLogsResponse{Logs: []AuthLog{}, Page: q.Page}
+	base := db.Model(&AuthLog{}).Where("user_id = ? AND event IN ?", userId, []AuthEvent{AUTH_LOGIN_SUCCESS, AUTH_LOGIN_FAILED})
+	if res := base.Session(&gorm.Session{}).Count(&resp.Total); res.Error != nil {
+		slog.Error("getRecentLogins: Failed to count logins", "userId", userId, "error", res.Error)
+		return AuthLogsResponse{}, errors.New("failed to get recent logins")
+	}
+	res := base.Session(&gorm.Session{}).
+		Order("created_at DESC, id DESC").
+		Offset(q.offset()).
+		Limit(q.Limit).
+		Find(&resp.Logs)
+	if res.Error != nil {
+		slog.Error("getRecentLogins: Failed to get logins", "userId", userId, "error", res.Error)
+		return AuthLogsResponse{}, errors.New("failed to get recent logins")
+	}
+	return resp, nil
+}
+
+// Periodically remove auth events older than authLogRetention.
+func startAuthLogPruneJob(db *gorm.DB) {
+	for {
+		pruneAuthLogs(db)
+		time.Sleep(authLogPruneInterval)
+	}
+}
+
+func pruneAuthLogs(db *gorm.DB) {
+	res := db.Unscoped().Where("created_at < ?", timeNow().Add(-authLogRetention)).Delete(&AuthLog{})
+	if res.Error != nil {
+		slog.Error("pruneAuthLogs: Failed to prune auth logs", "error", res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		slog.Info("Pruned old auth logs", "count", res.RowsAffected)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default request body limits, in megabytes.
+const (
+	defaultMaxBodySizeMB   = 1
+	defaultMaxImportSizeMB = 20
+)
+
+// Routes that take imports (by path without the api prefix),
+// they are allowed much bigger bodies than everything else.
+var importRoutePaths = []string{"/import", "/import/simple-csv", "/profile/import"}
+
+// Get max request body size in bytes from MAX_BODY_SIZE_MB.
+func getMaxBodySize() int64 {
+	return getSizeEnv("MAX_BODY_SIZE_MB", defaultMaxBodySizeMB)
+}
+
+// Get max import request body size in bytes from MAX_IMPORT_SIZE_MB.
+func getMaxImportSize() int64 {
+	return getSizeEnv("MAX_IMPORT_SIZE_MB", defaultMaxImportSizeMB)
+}
+
+func getSizeEnv(name string, defMB int64) int64 {
+	if mb, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && mb > 0 {
+		return mb << 20
+	}
+	return defMB << 20
+}
+
+// Reject request bodies bigger than the limit for their route with a 413.
+// Gin runs this before any route middleware, so per route limits are looked up
+// here by path instead of being set by middleware on the route.
+func limitRequestBody(limits map[string]int64, defaultLimit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultLimit
+		if l, ok := limits[routePath(c.FullPath())]; ok {
+			limit = l
+		}
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: fmt.Sprintf("request body too large, must be at most %dMB", limit>>20)})
+			return
+		}
+		// Bodies without a length (chunked) are cut off once they pass the limit.
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// Body size limits for routes that differ from the default.
+func routeBodyLimits() map[string]int64 {
+	limits := map[string]int64{}
+	for _, p := range importRoutePaths {
+		limits[p] = getMaxImportSize()
+	}
+	return limits
+}
+
+// Route path without the api prefix it was registered under.
+func routePath(fullPath string) string {
+	if p, ok := strings.CutPrefix(fullPath, getAPIPrefix()); ok {
+		return p
+	}
+	return strings.TrimPrefix(fullPath, legacyAPIPrefix)
+}
+
+// Status to respond with when reading a request body failed,
+// 413 if it was cut off for being too large.
+func bodyErrorStatus(err error) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Pad a json object with whitespace (so it's still valid) until it is size bytes long.
+func paddedBody(body string, size int) string {
+	return body[:len(body)-1] + strings.Repeat(" ", size-len(body)) + "}"
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	var w Watched
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie"}`, http.StatusOK, &w)
+	id := strconv.Itoa(int(w.ID))
+
+	const mb = 1 << 20
+	importRows := `{"conflictStrategy":"skip","rows":[{"contentId":550,"contentType":"movie"}]}`
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"normal route under limit", "PUT", "/watched/" + id, paddedBody(`{"status":"FINISHED"}`, mb-1), http.StatusOK},
+		{"normal route over limit", "PUT", "/watched/" + id, paddedBody(`{"status":"FINISHED"}`, mb+1), http.StatusRequestEntityTooLarge},
+		{"unauthed route over limit", "POST", "/auth/register", paddedBody(`{"username":"bob","password":"password123"}`, 2*mb), http.StatusRequestEntityTooLarge},
+		{"import over normal limit", "POST", "/import?dryRun=true", paddedBody(importRows, 5*mb), http.StatusOK},
+		{"import over import limit", "POST", "/import?dryRun=true", paddedBody(importRows, 20*mb+1), http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, b := s.do(tc.method, tc.path, token, tc.body)
+			if status != tc.want {
+				if len(b) > 200 {
+					b = b[:200]
+				}
+				t.Errorf("got status %d, want %d (body: %s)", status, tc.want, b)
+			}
+		})
+	}
+}
+
+// Bodies sent without a Content-Length are cut off once they pass the limit.
+func TestRequestBodyLimitsChunked(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	var w Watched
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie"}`, http.StatusOK, &w)
+
+	body := paddedBody(`{"review":"great"}`, 2<<20)
+	// Hide the readers length, so the request is chunked.
+	req, err := http.NewRequest("POST", s.url+"/watched/"+strconv.Itoa(int(w.ID))+"/rewatch", io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want 413", res.StatusCode)
+	}
+	var n int64
+	s.db.Model(&ReWatchEntry{}).Count(&n)
+	if n != 0 {
+		t.Errorf("rewatch was added from a cut off body")
+	}
+}
+
+func TestGetSizeEnv(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int64
+	}{
+		{"", 3 << 20},
+		{"5", 5 << 20},
+		{"0", 3 << 20},
+		{"-1", 3 << 20},
+		{"1.5", 3 << 20},
+		{"lots", 3 << 20},
+	} {
+		t.Setenv("TEST_SIZE_MB", tc.env)
+		if got := getSizeEnv("TEST_SIZE_MB", 3); got != tc.want {
+			t.Errorf("getSizeEnv with %q = %d, want %d", tc.env, got, tc.want)
+		}
+	}
+}
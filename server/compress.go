@@ -0,0 +1,205 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Responses smaller than this aren't worth compressing.
+const compressMinSize = 1024
+
+// Content types we compress, everything else (eg. images) is
+// usually already compressed, so is sent as is.
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"text/",
+}
+
+// Get gzip level from COMPRESSION_LEVEL (1-9, 0 to disable),
+// defaulting to gzip's default level.
+func getCompressionLevel() int {
+	if l, err := strconv.Atoi(os.Getenv("COMPRESSION_LEVEL")); err == nil && l >= gzip.NoCompression && l <= gzip.BestCompression {
+		return l
+	}
+	return gzip.DefaultCompression
+}
+
+// Gzip responses for clients that accept it.
+func compressResponses(level int) gin.HandlerFunc {
+	pool := sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}}
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) ||
+			c.Request.Method == http.MethodHead ||
+			strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			c.Next()
+			return
+		}
+		w := &gzipWriter{ResponseWriter: c.Writer, pool: &pool}
+		c.Writer = w
+		c.Next()
+		w.close()
+		// Gin writes its default 404/405 body after middleware has run, so that can't go through us.
+		c.Writer = w.ResponseWriter
+	}
+}
+
+// If an Accept-Encoding header allows gzip.
+func acceptsGzip(h string) bool {
+	for _, enc := range strings.Split(h, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+	return false
+}
+
+// Buffers the start of a response, so we can skip compressing
+// small responses and ones that aren't a compressible type.
+type gzipWriter struct {
+	gin.ResponseWriter
+	pool *sync.Pool
+	gz   *gzip.Writer
+	buf  []byte
+	// Set once we know if the response is being compressed.
+	decided bool
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= compressMinSize {
+		if err := w.start(w.shouldCompress()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Nothing is written until we have decided, so report that.
+func (w *gzipWriter) Written() bool {
+	return w.decided && w.ResponseWriter.Written()
+}
+
+func (w *gzipWriter) Size() int {
+	if !w.decided {
+		return len(w.buf)
+	}
+	return w.ResponseWriter.Size()
+}
+
+// Flushing means the response is being streamed, so stop buffering.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) shouldCompress() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if s := w.Status(); s < http.StatusOK || s == http.StatusNoContent || s == http.StatusNotModified || s == http.StatusPartialContent {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(w.buf)
+	}
+	if strings.HasPrefix(ct, "text/event-stream") {
+		return false
+	}
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(ct, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write out the headers and buffered body, compressed or not.
+func (w *gzipWriter) start(compress bool) error {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		// Compressed body differs from the uncompressed one, so its ETag can only be weak.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (w *gzipWriter) close() {
+	if !w.decided {
+		// Small response, send it as is.
+		if len(w.buf) > 0 {
+			w.start(false)
+		}
+		return
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// If an If-None-Match header matches an ETag, using weak comparison
+// (so a compressed responses weak ETag still matches).
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Json big enough to be compressed.
+var bigJSON = `{"items":"` + strings.Repeat("watcharr ", 500) + `"}`
+
+func newCompressTestEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(compressResponses(gzip.DefaultCompression))
+	r.GET("/big", func(c *gin.Context) {
+		c.Header("ETag", `"abc"`)
+		c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(bigJSON))
+	})
+	r.GET("/small", func(c *gin.Context) {
+		c.Header("ETag", `"abc"`)
+		c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(`{"ok":true}`))
+	})
+	r.GET("/sse", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		// Events are big enough to be compressed if they weren't a stream.
+		for i := 0; i < 3; i++ {
+			c.Writer.WriteString("data: " + strings.Repeat("x", 2000) + "\n\n")
+			c.Writer.Flush()
+		}
+	})
+	r.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", bytes.Repeat([]byte{0x89}, 4096))
+	})
+	return r
+}
+
+func TestCompressResponses(t *testing.T) {
+	r := newCompressTestEngine()
+	for _, tc := range []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		accept         string
+		wantGzip       bool
+		wantETag       string
+	}{
+		{"big json", "/big", "gzip, deflate, br", "", true, `W/"abc"`},
+		{"big json gzip with q", "/big", "br;q=1.0, gzip;q=0.5", "", true, `W/"abc"`},
+		{"big json no accept encoding", "/big", "", "", false, `"abc"`},
+		{"big json gzip refused", "/big", "gzip;q=0", "", false, `"abc"`},
+		{"big json other encodings", "/big", "br, deflate", "", false, `"abc"`},
+		{"small json", "/small", "gzip", "", false, `"abc"`},
+		{"sse by content type", "/sse", "gzip", "", false, ""},
+		{"sse by accept", "/sse", "gzip", "text/event-stream", false, ""},
+		{"image", "/image", "gzip", "", false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", w.Code)
+			}
+			if v := w.Header().Values("Vary"); len(v) != 1 || v[0] != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", v)
+			}
+			if got := w.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("got ETag %q, want %q", got, tc.wantETag)
+			}
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tc.wantGzip {
+				t.Fatalf("got Content-Encoding %q, want gzip: %v", w.Header().Get("Content-Encoding"), tc.wantGzip)
+			}
+			if !gzipped {
+				return
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("body isn't gzipped: %v", err)
+			}
+			body, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("failed to decompress body: %v", err)
+			}
+			if string(body) != bigJSON {
+				t.Errorf("decompressed body doesn't match what was sent")
+			}
+			if w.Body.Len() >= len(bigJSON) {
+				t.Errorf("compressed body (%d bytes) isn't smaller than the original (%d bytes)", w.Body.Len(), len(bigJSON))
+			}
+		})
+	}
+}
+
+// Streamed responses must reach the client as they are flushed,
+// not once the handler is done.
+func TestCompressResponsesSSEIsStreamed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(compressResponses(gzip.DefaultCompression))
+	release := make(chan struct{})
+	r.GET("/sse", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Writer.WriteString("data: first\n\n")
+		c.Writer.Flush()
+		<-release
+		c.Writer.WriteString("data: second\n\n")
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/sse", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if enc := res.Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("got Content-Encoding %q, want none", enc)
+	}
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Errorf("got first line %q (error: %v), want the first event", line, err)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	for _, tc := range []struct {
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`W/"abc"`, `"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`"xyz", W/"abc"`, `"abc"`, true},
+		{`*`, `"abc"`, true},
+		{`"xyz"`, `"abc"`, false},
+		{``, `"abc"`, false},
+		{`"ab"`, `"abc"`, false},
+	} {
+		if got := etagMatches(tc.ifNoneMatch, tc.etag); got != tc.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tc.ifNoneMatch, tc.etag, got, tc.want)
+		}
+	}
+}
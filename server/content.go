@@ -1,9 +1,20 @@
 package main
 
 import (
+	"cmp"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type ContentType string
@@ -13,44 +24,244 @@ const (
 	SHOW  ContentType = "tv"
 )
 
+// Most cast members kept for content.
+const contentCastSize = 10
+
 // For storing cached content, so we can serve the basic local data for watched list to work
 type Content struct {
-	ID               int         `json:"id" gorm:"primaryKey;autoIncrement"`
-	TmdbID           int         `json:"tmdbId" gorm:"uniqueIndex:contentidtotypeidx;not null"`
-	Title            string      `json:"title"`
-	PosterPath       string      `json:"poster_path"`
-	Overview         string      `json:"overview"`
-	Type             ContentType `json:"type" gorm:"uniqueIndex:contentidtotypeidx;not null"`
-	ReleaseDate      time.Time   `json:"release_date"`
-	Popularity       float32     `json:"popularity"`
-	VoteAverage      float32     `json:"vote_average"`
-	VoteCount        uint32      `json:"vote_count"`
-	ImdbID           string      `json:"imdb_id"`
-	Status           string      `json:"status"`
-	Budget           uint32      `json:"budget"`
-	Revenue          uint32      `json:"revenue"`
-	Runtime          uint32      `json:"runtime"`
-	NumberOfEpisodes uint32      `json:"numberOfEpisodes"`
-	NumberOfSeasons  uint32      `json:"numberOfSeasons"`
-}
-
-func searchContent(query string) (TMDBSearchMultiResponse, error) {
+	ID int `json:"id" gorm:"primaryKey;autoIncrement"`
+	// 0 for content from other providers.
+	TmdbID int `json:"tmdbId" gorm:"index;not null"`
+	// Where content is from and its id there, ProviderID is the same as TmdbID for TMDB content.
+	Provider         ContentProvider `json:"provider" gorm:"uniqueIndex:contentprovideridx;not null;default:tmdb"`
+	ProviderID       int             `json:"providerId" gorm:"uniqueIndex:contentprovideridx;not null;default:0"`
+	Title            string          `json:"title"`
+	OriginalTitle    string          `json:"originalTitle"`
+	PosterPath       string          `json:"poster_path"`
+	Overview         string          `json:"overview"`
+	Type             ContentType     `json:"type" gorm:"uniqueIndex:contentprovideridx;not null"`
+	ReleaseDate      time.Time       `json:"release_date"`
+	Popularity       float32         `json:"popularity"`
+	VoteAverage      float32         `json:"vote_average"`
+	VoteCount        uint32          `json:"vote_count"`
+	ImdbID           string          `json:"imdb_id"`
+	Status           string          `json:"status"`
+	InProduction     bool            `json:"inProduction" gorm:"not null;default:false"` // If a show is still being made, more episodes are coming.
+	Budget           uint32          `json:"budget"`
+	Revenue          uint32          `json:"revenue"`
+	Runtime          uint32          `json:"runtime"`
+	NumberOfEpisodes uint32          `json:"numberOfEpisodes"`
+	NumberOfSeasons  uint32          `json:"numberOfSeasons"`
+	// Age rating (eg. PG-13, TV-MA) for the servers DEFAULT_COUNTRY.
+	Certification string `json:"certification"`
+	// If content has a poster we can show. Not stored, filled in by our hooks
+	// so clients know to show a placeholder instead of requesting a bad image.
+	HasPoster bool `json:"hasPoster" gorm:"-"`
+	// If a show is still airing (returning), false once it has ended or been canceled.
+	// Not stored, filled in by our hooks from Status and InProduction.
+	Airing bool `json:"airing" gorm:"-"`
+	// Size (from POSTER_SIZE) our cached poster was downloaded at.
+	PosterSize string `json:"posterSize"`
+	// When content was first cached, nil for content cached before this was tracked.
+	CreatedAt *time.Time `json:"createdAt"`
+	// When we last refreshed this content from TMDB.
+	LastRefreshedAt *time.Time `json:"lastRefreshedAt"`
+	// Air date of the next episode, for shows that are still airing.
+	NextEpisodeAirDate *time.Time `json:"nextEpisodeAirDate"`
+	// ISO 639-1 code of the language content was originally made in.
+	OriginalLanguage string `json:"originalLanguage"`
+	// Languages spoken in the content.
+	SpokenLanguages JSONList[ContentLanguage] `json:"spokenLanguages"`
+	// Countries content was produced in.
+	ProductionCountries JSONList[ContentCountry] `json:"productionCountries"`
+	// Other titles content is known by (eg. in other countries), and when they were fetched.
+	// Only fetched when asked for, see contentAlternativeTitles.
+	AlternativeTitles         JSONList[string] `json:"alternativeTitles"`
+	AlternativeTitlesCachedAt *time.Time       `json:"-"`
+	// Genres (eg. Drama, Comedy) content is listed under.
+	Genres JSONList[string] `json:"genres"`
+	// Who directed a movie, or created a show.
+	Directors JSONList[string] `json:"directors"`
+	// Top billed cast, in billing order (at most contentCastSize).
+	Cast JSONList[string] `json:"cast"`
+	// Keywords (eg. heist, time travel) TMDB has tagged the content with, and when they were fetched.
+	// Ids are kept for discovering by keyword, names are what watched search matches.
+	KeywordList      JSONList[ContentKeyword] `json:"keywords"`
+	KeywordsCachedAt *time.Time               `json:"-"`
+	// Full TMDB details response (json), cached so the details page
+	// doesn't have to hit TMDB every time. Only used for movies.
+	CachedDetail   string     `json:"-"`
+	DetailCachedAt *time.Time `json:"-"`
+}
+
+type ContentLanguage struct {
+	Iso6391 string `json:"iso_639_1"`
+	Name    string `json:"name"`
+}
+
+type ContentCountry struct {
+	Iso31661 string `json:"iso_3166_1"`
+	Name     string `json:"name"`
+}
+
+// List stored as a json column.
+type JSONList[T any] []T
+
+func (l JSONList[T]) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]T(l))
+	return string(b), err
+}
+
+// Content cached before a list was stored has none, always return a list.
+func (l JSONList[T]) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]T(l))
+}
+
+func (l *JSONList[T]) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*l = JSONList[T]{}
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return errors.New("unsupported type for JSONList")
+	}
+	return json.Unmarshal(b, (*[]T)(l))
+}
+
+func (c *Content) AfterFind(tx *gorm.DB) error {
+	c.HasPoster = c.PosterPath != ""
+	c.Airing = c.isAiring()
+	return nil
+}
+
+func (c *Content) AfterCreate(tx *gorm.DB) error {
+	c.HasPoster = c.PosterPath != ""
+	c.Airing = c.isAiring()
+	return nil
+}
+
+// Show statuses (from TMDB) that mean more episodes are coming.
+var airingShowStatuses = []string{"Returning Series", "In Production", "Planned", "Pilot"}
+
+func (c *Content) isAiring() bool {
+	return c.Type == SHOW && (c.InProduction || slices.Contains(airingShowStatuses, c.Status))
+}
+
+// Limit a content query to shows that are still airing, same as isAiring.
+func whereAiring(q *gorm.DB) *gorm.DB {
+	return q.Where("contents.type = ? AND (contents.in_production = ? OR contents.status IN ?)", SHOW, true, airingShowStatuses)
+}
+
+type SearchQuery struct {
+	// Only search for one type of result (movie, tv or person), all by default.
+	Type string `form:"type" binding:"omitempty,oneof=movie tv person"`
+	Page int    `form:"page" binding:"omitempty,min=1,max=500"`
+	// Where to search, TMDB by default.
+	Provider ContentProvider `form:"provider" binding:"omitempty,oneof=tmdb anilist"`
+}
+
+// Search TMDB, for all types of results unless q.Type is set.
+func searchContent(query string, q SearchQuery) (TMDBSearchMultiResponse, error) {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	ep := "/search/multi"
+	if q.Type != "" {
+		ep = "/search/" + q.Type
+	}
 	resp := new(TMDBSearchMultiResponse)
-	err := tmdbRequest("/search/multi", map[string]string{"query": query, "page": "1"}, &resp)
+	err := tmdbRequest(ep, map[string]string{"query": query, "page": strconv.Itoa(q.Page)}, &resp)
 	if err != nil {
-		slog.Error("Failed to complete multi search request!", "error", err.Error())
-		return TMDBSearchMultiResponse{}, errors.New("failed to complete multi search request")
+		slog.Error("Failed to complete search request!", "endpoint", ep, "error", err.Error())
+		return TMDBSearchMultiResponse{}, errors.New("failed to complete search request")
+	}
+	// Type specific searches don't include media_type, set it so results look the same as multi search.
+	if q.Type != "" {
+		for i := range resp.Results {
+			resp.Results[i].MediaType = q.Type
+		}
 	}
 	return *resp, nil
 }
 
-func movieDetails(id string) (TMDBMovieDetails, error) {
+// How long cached movie details are served before being refreshed.
+const movieDetailCacheTTL = 7 * 24 * time.Hour
+
+// Movies (tmdb ids) that currently have a background refresh running.
+var movieDetailRefreshing sync.Map
+
+// Get movie details, from our cache when the movie is in our content table.
+// Stale details are still served, while fresh ones are fetched in the background.
+func movieDetails(db *gorm.DB, id string) (TMDBMovieDetails, error) {
+	var cached struct {
+		ID             int
+		CachedDetail   string
+		DetailCachedAt *time.Time
+	}
+	res := db.Model(&Content{}).Select("id", "cached_detail", "detail_cached_at").Where("tmdb_id = ? AND type = ?", id, MOVIE).Limit(1).Scan(&cached)
+	if res.Error != nil {
+		slog.Error("movieDetails: Failed to get cached details", "tmdbId", id, "error", res.Error)
+	}
+	if cached.ID == 0 {
+		return fetchMovieDetails(id)
+	}
+	if cached.CachedDetail != "" && cached.DetailCachedAt != nil {
+		var details TMDBMovieDetails
+		if err := json.Unmarshal([]byte(cached.CachedDetail), &details); err != nil {
+			slog.Error("movieDetails: Failed to parse cached details, fetching them again", "tmdbId", id, "error", err)
+		} else {
+			if timeNow().Sub(*cached.DetailCachedAt) > movieDetailCacheTTL {
+				if _, running := movieDetailRefreshing.LoadOrStore(id, true); !running {
+					go func() {
+						defer movieDetailRefreshing.Delete(id)
+						cacheMovieDetails(db, cached.ID, id)
+					}()
+				}
+			}
+			details.Certification = movieCertification(details.ReleaseDates, getDefaultCountry())
+			return details, nil
+		}
+	}
+	return cacheMovieDetails(db, cached.ID, id)
+}
+
+// Fetch movie details from TMDB and store them in the content row with contentId.
+func cacheMovieDetails(db *gorm.DB, contentId int, id string) (TMDBMovieDetails, error) {
+	details, err := fetchMovieDetails(id)
+	if err != nil {
+		return TMDBMovieDetails{}, err
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		slog.Error("cacheMovieDetails: Failed to marshal details", "tmdbId", id, "error", err)
+		return details, nil
+	}
+	res := db.Model(&Content{}).Where("id = ?", contentId).Updates(map[string]interface{}{"cached_detail": string(b), "detail_cached_at": timeNow()})
+	if res.Error != nil {
+		slog.Error("cacheMovieDetails: Failed to store details", "tmdbId", id, "error", res.Error)
+	}
+	return details, nil
+}
+
+func fetchMovieDetails(id string) (TMDBMovieDetails, error) {
 	resp := new(TMDBMovieDetails)
-	err := tmdbRequest("/movie/"+id, map[string]string{"append_to_response": "videos,watch/providers"}, &resp)
+	err := tmdbRequest("/movie/"+id, map[string]string{"append_to_response": "videos,watch/providers,release_dates"}, &resp)
 	if err != nil {
 		slog.Error("Failed to complete movie details request!", "error", err.Error())
-		return TMDBMovieDetails{}, errors.New("failed to complete movie details request")
+		return TMDBMovieDetails{}, tmdbRequestError("failed to complete movie details request", err)
 	}
+	resp.Certification = movieCertification(resp.ReleaseDates, getDefaultCountry())
 	return *resp, nil
 }
 
@@ -66,11 +277,12 @@ func movieCredits(id string) (TMDBContentCredits, error) {
 
 func tvDetails(id string) (TMDBShowDetails, error) {
 	resp := new(TMDBShowDetails)
-	err := tmdbRequest("/tv/"+id, map[string]string{"append_to_response": "videos,watch/providers"}, &resp)
+	err := tmdbRequest("/tv/"+id, map[string]string{"append_to_response": "videos,watch/providers,content_ratings"}, &resp)
 	if err != nil {
 		slog.Error("Failed to complete tv details request!", "error", err.Error())
-		return TMDBShowDetails{}, errors.New("failed to complete tv details request")
+		return TMDBShowDetails{}, tmdbRequestError("failed to complete tv details request", err)
 	}
+	resp.Certification = showCertification(resp.ContentRatings, getDefaultCountry())
 	return *resp, nil
 }
 
@@ -78,19 +290,35 @@ func tvCredits(id string) (TMDBContentCredits, error) {
 	resp := new(TMDBContentCredits)
 	err := tmdbRequest("/tv/"+id+"/credits", map[string]string{}, &resp)
 	if err != nil {
-		slog.Error("Failed to complete tv cast request!", err.Error())
+		slog.Error("Failed to complete tv cast request!", "error", err.Error())
 		return TMDBContentCredits{}, errors.New("failed to complete tv cast request")
 	}
 	return *resp, nil
 }
 
+type seasonCacheEntry struct {
+	season  TMDBSeasonDetails
+	expires time.Time
+}
+
+// Season details are requested often when tracking episodes, so cache them.
+var seasonCache sync.Map
+
+// How long to cache season details for.
+const seasonCacheTTL = 6 * time.Hour
+
 func seasonDetails(tvId string, seasonNumber string) (TMDBSeasonDetails, error) {
+	key := tvId + "/" + seasonNumber
+	if e, ok := seasonCache.Load(key); ok && timeNow().Before(e.(seasonCacheEntry).expires) {
+		return e.(seasonCacheEntry).season, nil
+	}
 	resp := new(TMDBSeasonDetails)
 	err := tmdbRequest("/tv/"+tvId+"/season/"+seasonNumber, map[string]string{}, &resp)
 	if err != nil {
 		slog.Error("Failed to complete season details request!", "error", err.Error())
 		return TMDBSeasonDetails{}, errors.New("failed to complete season details request")
 	}
+	seasonCache.Store(key, seasonCacheEntry{season: *resp, expires: timeNow().Add(seasonCacheTTL)})
 	return *resp, nil
 }
 
@@ -99,7 +327,7 @@ func personDetails(id string) (TMDBPersonDetails, error) {
 	err := tmdbRequest("/person/"+id, map[string]string{}, &resp)
 	if err != nil {
 		slog.Error("Failed to complete person details request!", "error", err.Error())
-		return TMDBPersonDetails{}, errors.New("failed to complete person details request")
+		return TMDBPersonDetails{}, tmdbRequestError("failed to complete person details request", err)
 	}
 	return *resp, nil
 }
@@ -113,3 +341,556 @@ func personCredits(id string) (TMDBPersonCombinedCredits, error) {
 	}
 	return *resp, nil
 }
+
+type PersonCombinedCreditsQuery struct {
+	Sort string `form:"sort" binding:"omitempty,oneof=popularity date"`
+}
+
+// One piece of content a person worked on, with every role they had in it.
+type PersonCredit struct {
+	ID         int         `json:"id"`
+	MediaType  ContentType `json:"mediaType"`
+	Title      string      `json:"title"`
+	PosterPath string      `json:"posterPath"`
+	// Release date for movies, first air date for shows.
+	Date         string  `json:"date"`
+	Popularity   float64 `json:"popularity"`
+	VoteAverage  float64 `json:"voteAverage"`
+	EpisodeCount int     `json:"episodeCount,omitempty"`
+	// Character(s) played, empty if they were only crew.
+	Character string `json:"character,omitempty"`
+	// Departments they were in, Acting for cast.
+	Departments []string `json:"departments"`
+	// Crew jobs they had (eg. Director).
+	Jobs []string `json:"jobs,omitempty"`
+}
+
+type PersonCombinedCreditsResponse struct {
+	ID      int            `json:"id"`
+	Credits []PersonCredit `json:"credits"`
+}
+
+// Get a persons cast and crew credits merged into one list, with one
+// entry per piece of content, sorted by popularity (default) or newest first.
+func personCombinedCredits(id string, sortBy string) (PersonCombinedCreditsResponse, error) {
+	credits, err := personCredits(id)
+	if err != nil {
+		return PersonCombinedCreditsResponse{}, err
+	}
+	byContent := map[string]*PersonCredit{}
+	merged := []*PersonCredit{}
+	get := func(id int, mediaType string, title string, name string, poster string, releaseDate string, airDate string, popularity float64, vote float64) *PersonCredit {
+		key := mediaType + strconv.Itoa(id)
+		if pc, ok := byContent[key]; ok {
+			return pc
+		}
+		pc := &PersonCredit{
+			ID:          id,
+			MediaType:   ContentType(mediaType),
+			Title:       title,
+			PosterPath:  poster,
+			Date:        releaseDate,
+			Popularity:  popularity,
+			VoteAverage: vote,
+			Departments: []string{},
+		}
+		if pc.MediaType == SHOW {
+			pc.Title = name
+			pc.Date = airDate
+		}
+		byContent[key] = pc
+		merged = append(merged, pc)
+		return pc
+	}
+	for _, c := range credits.Cast {
+		pc := get(c.ID, c.MediaType, c.Title, c.Name, c.PosterPath, c.ReleaseDate, c.FirstAirDate, c.Popularity, c.VoteAverage)
+		if c.Character != "" && !slices.Contains(strings.Split(pc.Character, " / "), c.Character) {
+			if pc.Character != "" {
+				pc.Character += " / "
+			}
+			pc.Character += c.Character
+		}
+		if !slices.Contains(pc.Departments, "Acting") {
+			pc.Departments = append(pc.Departments, "Acting")
+		}
+		pc.EpisodeCount = max(pc.EpisodeCount, c.EpisodeCount)
+	}
+	for _, c := range credits.Crew {
+		pc := get(c.ID, c.MediaType, c.Title, c.Name, c.PosterPath, c.ReleaseDate, c.FirstAirDate, c.Popularity, c.VoteAverage)
+		if c.Department != "" && !slices.Contains(pc.Departments, c.Department) {
+			pc.Departments = append(pc.Departments, c.Department)
+		}
+		if c.Job != "" && !slices.Contains(pc.Jobs, c.Job) {
+			pc.Jobs = append(pc.Jobs, c.Job)
+		}
+		pc.EpisodeCount = max(pc.EpisodeCount, c.EpisodeCount)
+	}
+	if sortBy == "date" {
+		// Newest first, undated (usually upcoming) content before everything else.
+		slices.SortStableFunc(merged, func(a, b *PersonCredit) int {
+			if a.Date == "" || b.Date == "" {
+				return strings.Compare(a.Date, b.Date)
+			}
+			return strings.Compare(b.Date, a.Date)
+		})
+	} else {
+		slices.SortStableFunc(merged, func(a, b *PersonCredit) int { return cmp.Compare(b.Popularity, a.Popularity) })
+	}
+	resp := PersonCombinedCreditsResponse{ID: credits.ID, Credits: make([]PersonCredit, len(merged))}
+	for i, pc := range merged {
+		resp.Credits[i] = *pc
+	}
+	return resp, nil
+}
+
+// Fetch content details from TMDB and convert them into our Content model.
+func fetchContent(contentType ContentType, tmdbId int) (Content, error) {
+	appendToResponse := "release_dates,keywords,credits"
+	if contentType == SHOW {
+		appendToResponse = "content_ratings,keywords,credits"
+	}
+	resp, err := tmdbAPIRequest("/"+string(contentType)+"/"+strconv.Itoa(tmdbId), map[string]string{"append_to_response": appendToResponse})
+	if err != nil {
+		slog.Error("fetchContent tmdb api request failed", "error", err)
+		return Content{}, errors.New("failed to find requested media")
+	}
+
+	var (
+		id               int
+		title            string
+		originalTitle    string
+		overview         string
+		posterPath       string
+		releaseDate      time.Time
+		popularity       float32
+		voteAverage      float32
+		voteCount        uint32
+		imdbID           string
+		status           string
+		inProduction     bool
+		budget           uint32
+		revenue          uint32
+		runtime          uint32
+		numberOfEpisodes uint32
+		numberOfSeasons  uint32
+		certification    string
+		nextEpisodeAir   *time.Time
+		originalLanguage string
+		spokenLanguages  JSONList[ContentLanguage]
+		countries        JSONList[ContentCountry]
+		keywords         JSONList[ContentKeyword]
+		directors        = JSONList[string]{}
+	)
+	var dateFormat = "2006-01-02"
+	// Get details from movie/show response and fill out needed vars
+	if contentType == "movie" {
+		content := new(TMDBMovieDetails)
+		err = json.Unmarshal([]byte(resp), &content)
+		if err != nil {
+			slog.Error("Failed to unmarshal movie details", "error", err)
+			return Content{}, errors.New("failed to process movie details response")
+		}
+		id = content.ID
+		overview = content.Overview
+		posterPath = content.PosterPath
+		title = content.Title
+		originalTitle = content.OriginalTitle
+		releaseDate, err = time.Parse(dateFormat, content.ReleaseDate)
+		if err != nil {
+			slog.Error("Failed to parse movie release date", "error", err)
+		}
+		popularity = content.Popularity
+		voteAverage = content.VoteAverage
+		voteCount = content.VoteCount
+		imdbID = content.ImdbID
+		status = content.Status
+		budget = content.Budget
+		revenue = content.Revenue
+		runtime = content.Runtime
+		certification = movieCertification(content.ReleaseDates, getDefaultCountry())
+		originalLanguage, spokenLanguages = contentLanguages(content.TMDBContentDetails)
+		countries = contentCountries(content.TMDBContentDetails)
+		// Keywords aren't part of our movie details response type, so parse them separately.
+		var k struct {
+			Keywords TMDBMovieKeywords `json:"keywords"`
+		}
+		if err = json.Unmarshal(resp, &k); err == nil {
+			keywords = movieKeywordList(k.Keywords)
+		}
+	} else {
+		content := new(TMDBShowDetails)
+		err = json.Unmarshal(resp, &content)
+		if err != nil {
+			slog.Error("Failed to unmarshal show details", "error", err)
+			return Content{}, errors.New("failed to process show details response")
+		}
+		id = content.ID
+		overview = content.Overview
+		posterPath = content.PosterPath
+		title = content.Name
+		originalTitle = content.OriginalName
+		releaseDate, err = time.Parse(dateFormat, content.FirstAirDate)
+		if err != nil {
+			slog.Error("Failed to parse tv release date", "error", err)
+		}
+		popularity = content.Popularity
+		voteAverage = content.VoteAverage
+		voteCount = content.VoteCount
+		status = content.Status
+		inProduction = content.InProduction
+		if len(content.EpisodeRunTime) > 0 {
+			runtime = uint32(content.EpisodeRunTime[0])
+		} else if content.LastEpisodeToAir.Runtime > 0 {
+			// TMDB has stopped filling in episode_run_time for most shows.
+			runtime = uint32(content.LastEpisodeToAir.Runtime)
+		}
+		numberOfEpisodes = content.NumberOfEpisodes
+		numberOfSeasons = content.NumberOfSeasons
+		certification = showCertification(content.ContentRatings, getDefaultCountry())
+		originalLanguage, spokenLanguages = contentLanguages(content.TMDBContentDetails)
+		countries = contentCountries(content.TMDBContentDetails)
+		// NextEpisodeToAir is passed through to clients untouched, so parse what we need separately.
+		var next struct {
+			NextEpisodeToAir *struct {
+				AirDate string `json:"air_date"`
+			} `json:"next_episode_to_air"`
+		}
+		if err = json.Unmarshal(resp, &next); err == nil && next.NextEpisodeToAir != nil {
+			if d, err := time.Parse(dateFormat, next.NextEpisodeToAir.AirDate); err == nil {
+				nextEpisodeAir = &d
+			}
+		}
+		var k struct {
+			Keywords TMDBShowKeywords `json:"keywords"`
+		}
+		if err = json.Unmarshal(resp, &k); err == nil {
+			keywords = showKeywordList(k.Keywords)
+		}
+		// Shows have a director per episode, so use who created them.
+		for _, c := range content.CreatedBy {
+			directors = append(directors, c.Name)
+		}
+	}
+	if id == 0 || title == "" {
+		slog.Error("fetchContent, returned content missing id or title!", "id", id, "title", title)
+		return Content{}, errors.New("content response missing id or title")
+	}
+	// Genres and credits are parsed separately, since they are the same for movies and shows.
+	var extra struct {
+		TMDBContentDetails
+		Credits TMDBContentCredits `json:"credits"`
+	}
+	if err = json.Unmarshal(resp, &extra); err != nil {
+		slog.Error("fetchContent: Failed to parse genres and credits", "error", err)
+	}
+	genres := JSONList[string]{}
+	for _, g := range extra.Genres {
+		genres = append(genres, g.Name)
+	}
+	if contentType == MOVIE {
+		for _, c := range extra.Credits.Crew {
+			if c.Job == "Director" {
+				directors = append(directors, c.Name)
+			}
+		}
+	}
+	now := timeNow()
+	return Content{
+		TmdbID:              id,
+		Provider:            PROVIDER_TMDB,
+		ProviderID:          id,
+		Title:               title,
+		OriginalTitle:       originalTitle,
+		Overview:            overview,
+		PosterPath:          posterPath,
+		Type:                contentType,
+		ReleaseDate:         releaseDate,
+		Popularity:          popularity,
+		VoteAverage:         voteAverage,
+		VoteCount:           voteCount,
+		ImdbID:              imdbID,
+		Status:              status,
+		InProduction:        inProduction,
+		Budget:              budget,
+		Revenue:             revenue,
+		Runtime:             runtime,
+		NumberOfEpisodes:    numberOfEpisodes,
+		NumberOfSeasons:     numberOfSeasons,
+		Certification:       certification,
+		NextEpisodeAirDate:  nextEpisodeAir,
+		OriginalLanguage:    originalLanguage,
+		SpokenLanguages:     spokenLanguages,
+		ProductionCountries: countries,
+		KeywordList:         keywords,
+		KeywordsCachedAt:    &now,
+		Genres:              genres,
+		Directors:           directors,
+		Cast:                contentCast(extra.Credits),
+	}, nil
+}
+
+// Names of the top billed cast in credits (TMDB lists them in billing order).
+func contentCast(credits TMDBContentCredits) JSONList[string] {
+	names := JSONList[string]{}
+	for _, c := range credits.Cast[:min(len(credits.Cast), contentCastSize)] {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// Get production countries from content details.
+func contentCountries(d TMDBContentDetails) JSONList[ContentCountry] {
+	countries := JSONList[ContentCountry]{}
+	for _, c := range d.ProductionCountries {
+		countries = append(countries, ContentCountry{Iso31661: c.Iso31661, Name: c.Name})
+	}
+	return countries
+}
+
+// Get original and spoken languages from content details.
+func contentLanguages(d TMDBContentDetails) (string, JSONList[ContentLanguage]) {
+	spoken := JSONList[ContentLanguage]{}
+	for _, l := range d.SpokenLanguages {
+		spoken = append(spoken, ContentLanguage{Iso6391: l.Iso6391, Name: l.EnglishName})
+	}
+	return d.OriginalLanguage, spoken
+}
+
+type ExternalIDQuery struct {
+	Source string `form:"source"`
+}
+
+type externalIDCacheEntry struct {
+	results []TMDBSearchMultiResults
+	expires time.Time
+}
+
+// External id lookups are common when importing, so cache them.
+var externalIDCache sync.Map
+
+// How long to cache external id lookups for.
+const externalIDCacheTTL = 24 * time.Hour
+
+// TMDB external_source for each source we support finding by.
+var externalIDSources = map[string]string{
+	"imdb": "imdb_id",
+	"tvdb": "tvdb_id",
+}
+
+// Find movies/shows on TMDB by an external id (eg. IMDb id).
+// Returns all matches, there can be more than one if the
+// id matches both a movie and a show.
+func findByExternalID(id string, source string) ([]TMDBSearchMultiResults, error) {
+	externalSource, ok := externalIDSources[source]
+	if !ok {
+		return []TMDBSearchMultiResults{}, errors.New("unsupported external id source")
+	}
+	key := source + "/" + id
+	if e, ok := externalIDCache.Load(key); ok && timeNow().Before(e.(externalIDCacheEntry).expires) {
+		return e.(externalIDCacheEntry).results, nil
+	}
+	resp := new(TMDBFindResponse)
+	err := tmdbRequest("/find/"+url.PathEscape(id), map[string]string{"external_source": externalSource}, &resp)
+	if err != nil {
+		slog.Error("Failed to complete find by external id request!", "error", err.Error())
+		return []TMDBSearchMultiResults{}, errors.New("failed to complete find by external id request")
+	}
+	results := []TMDBSearchMultiResults{}
+	for _, r := range resp.MovieResults {
+		r.MediaType = string(MOVIE)
+		results = append(results, r)
+	}
+	for _, r := range resp.TvResults {
+		r.MediaType = string(SHOW)
+		results = append(results, r)
+	}
+	externalIDCache.Store(key, externalIDCacheEntry{results: results, expires: timeNow().Add(externalIDCacheTTL)})
+	return results, nil
+}
+
+// Get the country we should use when picking region
+// specific data (eg. certifications) from TMDB responses.
+func getDefaultCountry() string {
+	if c := os.Getenv("DEFAULT_COUNTRY"); c != "" {
+		return strings.ToUpper(c)
+	}
+	return "US"
+}
+
+// Get movie certification for country from release dates.
+// Returns empty string if none could be found.
+func movieCertification(rd TMDBMovieReleaseDates, country string) string {
+	for _, r := range rd.Results {
+		if r.Iso31661 != country {
+			continue
+		}
+		for _, d := range r.ReleaseDates {
+			if d.Certification != "" {
+				return d.Certification
+			}
+		}
+	}
+	return ""
+}
+
+// Minimum age for known certifications, used to compare them against
+// a users max rating. Covers the US and UK rating systems.
+var certificationAges = map[string]int{
+	// US movies
+	"G": 0, "PG": 8, "PG-13": 13, "R": 17, "NC-17": 18,
+	// US tv
+	"TV-Y": 0, "TV-Y7": 7, "TV-G": 0, "TV-PG": 10, "TV-14": 14, "TV-MA": 17,
+	// UK
+	"U": 0, "12A": 12, "12": 12, "15": 15, "18": 18, "R18": 18,
+}
+
+// Get the minimum age for a certification. Numeric certifications
+// (used by many countries, eg. 16) are treated as the age itself.
+// Returns -1 for unknown certifications.
+func certificationAge(cert string) int {
+	if age, ok := certificationAges[strings.ToUpper(cert)]; ok {
+		return age
+	}
+	if age, err := strconv.Atoi(cert); err == nil && age >= 0 {
+		return age
+	}
+	return -1
+}
+
+// If content with certification is allowed by the users settings.
+func certificationAllowed(s UserSettings, cert string) bool {
+	if s.MaxRating == "" {
+		return true
+	}
+	age := certificationAge(cert)
+	if age < 0 {
+		return s.ShowUnrated
+	}
+	return age <= certificationAge(s.MaxRating)
+}
+
+// Certifications we have looked up for content not in our db, keyed by type/id.
+var certificationCache sync.Map
+
+// Get certification for content, from our db if we have it cached,
+// otherwise from TMDB. Returns empty string if content has none.
+func getCertification(db *gorm.DB, contentType ContentType, tmdbId int) string {
+	var content Content
+	db.Model(&Content{}).Select("certification").Where("tmdb_id = ? AND type = ?", tmdbId, contentType).Limit(1).Find(&content)
+	if content.Certification != "" {
+		return content.Certification
+	}
+	key := string(contentType) + "/" + strconv.Itoa(tmdbId)
+	if cert, ok := certificationCache.Load(key); ok {
+		return cert.(string)
+	}
+	var cert string
+	if contentType == MOVIE {
+		resp := new(TMDBMovieReleaseDates)
+		if err := tmdbRequest("/movie/"+strconv.Itoa(tmdbId)+"/release_dates", map[string]string{}, &resp); err != nil {
+			slog.Error("Failed to get movie release dates for certification", "id", tmdbId, "error", err)
+			return ""
+		}
+		cert = movieCertification(*resp, getDefaultCountry())
+	} else {
+		resp := new(TMDBShowContentRatings)
+		if err := tmdbRequest("/tv/"+strconv.Itoa(tmdbId)+"/content_ratings", map[string]string{}, &resp); err != nil {
+			slog.Error("Failed to get tv content ratings for certification", "id", tmdbId, "error", err)
+			return ""
+		}
+		cert = showCertification(*resp, getDefaultCountry())
+	}
+	certificationCache.Store(key, cert)
+	return cert
+}
+
+// Remove search results the user isn't allowed to see from their max rating.
+// Certifications for each result are looked up concurrently.
+func filterSearchByCertification(db *gorm.DB, s UserSettings, results []TMDBSearchMultiResults) []TMDBSearchMultiResults {
+	if s.MaxRating == "" {
+		return results
+	}
+	allowed := make([]bool, len(results))
+	sem := make(chan struct{}, 5)
+	var wg sync.WaitGroup
+	for i, r := range results {
+		if r.MediaType != string(MOVIE) && r.MediaType != string(SHOW) {
+			allowed[i] = true
+			continue
+		}
+		// Other providers don't have certifications, only whether content is adult.
+		if r.Provider != "" && r.Provider != PROVIDER_TMDB {
+			allowed[i] = !r.Adult
+			continue
+		}
+		wg.Add(1)
+		go func(i int, r TMDBSearchMultiResults) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			allowed[i] = certificationAllowed(s, getCertification(db, ContentType(r.MediaType), r.ID))
+		}(i, r)
+	}
+	wg.Wait()
+	filtered := []TMDBSearchMultiResults{}
+	for i, r := range results {
+		if allowed[i] {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// Mark search results that are already on the users watched list,
+// along with their status and rating. Done in a single query.
+func markSearchInLibrary(db *gorm.DB, userId uint, profileId uint, results []TMDBSearchMultiResults) {
+	ids := []int{}
+	for _, r := range results {
+		if r.MediaType == string(MOVIE) || r.MediaType == string(SHOW) {
+			ids = append(ids, r.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	var rows []struct {
+		Provider   ContentProvider
+		ProviderID int
+		Type       ContentType
+		Status     WatchedStatus
+		Rating     int8
+	}
+	res := db.Model(&Watched{}).
+		Select("contents.provider, contents.provider_id, contents.type, watcheds.status, watcheds.rating").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ? AND contents.provider_id IN ?", userId, profileId, ids).
+		Scan(&rows)
+	if res.Error != nil {
+		slog.Error("markSearchInLibrary: Failed to get watched entries", "error", res.Error)
+		return
+	}
+	for _, w := range rows {
+		for i := range results {
+			provider := results[i].Provider
+			if provider == "" {
+				provider = PROVIDER_TMDB
+			}
+			if results[i].ID == w.ProviderID && provider == w.Provider && results[i].MediaType == string(w.Type) {
+				results[i].InLibrary = true
+				results[i].WatchedStatus = w.Status
+				results[i].WatchedRating = w.Rating
+			}
+		}
+	}
+}
+
+// Get show certification for country from content ratings.
+// Returns empty string if none could be found.
+func showCertification(cr TMDBShowContentRatings, country string) string {
+	for _, r := range cr.Results {
+		if r.Iso31661 == country {
+			return r.Rating
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TMDB list of movies or shows (eg. upcoming), for discovering content.
+type ContentList string
+
+const (
+	LIST_UPCOMING    ContentList = "upcoming"
+	LIST_NOW_PLAYING ContentList = "now-playing"
+	LIST_TOP_RATED   ContentList = "top-rated"
+)
+
+// How long content lists are cached for, they only change daily on TMDB.
+const contentListCacheTTL = 6 * time.Hour
+
+type ContentListQuery struct {
+	// TMDB only serves up to page 500.
+	Page int `form:"page" binding:"omitempty,min=1,max=500"`
+	// Two letter country code, defaults to the users region setting.
+	Country string `form:"country"`
+	// Alias of Country, what TMDB calls it.
+	Region string `form:"region"`
+}
+
+type ContentListResult struct {
+	TMDBSearchMultiResults
+	// In the users list with any status other than planned.
+	UserWatched bool `json:"userWatched"`
+	// In the users list as planned.
+	UserPlanning bool `json:"userPlanning"`
+}
+
+type ContentListResponse struct {
+	Page         int                 `json:"page"`
+	Results      []ContentListResult `json:"results"`
+	TotalPages   int                 `json:"total_pages"`
+	TotalResults int                 `json:"total_results"`
+}
+
+type contentListCacheEntry struct {
+	resp    TMDBSearchMultiResponse
+	expires time.Time
+}
+
+// Content lists from TMDB, by list, type, country and page.
+var contentListCache sync.Map
+
+// TMDB endpoint for each list and type.
+// Upcoming shows are ones with an episode airing in the next week,
+// and now playing shows are ones with an episode airing today.
+var contentListEndpoints = map[ContentList]map[ContentType]string{
+	LIST_UPCOMING: {
+		MOVIE: "/movie/upcoming",
+		SHOW:  "/tv/on_the_air",
+	},
+	LIST_NOW_PLAYING: {
+		MOVIE: "/movie/now_playing",
+		SHOW:  "/tv/airing_today",
+	},
+	LIST_TOP_RATED: {
+		MOVIE: "/movie/top_rated",
+		SHOW:  "/tv/top_rated",
+	},
+}
+
+// Get a page of a content list, with the users status for each result.
+func getContentList(db *gorm.DB, userId uint, profileId uint, list ContentList, contentType ContentType, q ContentListQuery) (ContentListResponse, error) {
+	settings, err := getUserSettings(db, userId)
+	if err != nil {
+		return ContentListResponse{}, err
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.Country == "" {
+		q.Country = q.Region
+	}
+	if q.Country == "" {
+		q.Country = settings.Region
+	}
+	if q.Country != "" && !isValidRegion(q.Country) {
+		return ContentListResponse{}, errors.New("country must be a two letter country code")
+	}
+	resp, err := fetchContentList(list, contentType, q.Page, strings.ToUpper(q.Country))
+	if err != nil {
+		return ContentListResponse{}, err
+	}
+	// Copy, so the cached results aren't changed.
+	results := filterSearchByCertification(db, settings, append([]TMDBSearchMultiResults{}, resp.Results...))
+	markSearchInLibrary(db, userId, profileId, results)
+	cl := ContentListResponse{
+		Page:         resp.Page,
+		Results:      make([]ContentListResult, len(results)),
+		TotalPages:   resp.TotalPages,
+		TotalResults: resp.TotalResults,
+	}
+	for i, r := range results {
+		cl.Results[i] = ContentListResult{
+			TMDBSearchMultiResults: r,
+			UserWatched:            r.InLibrary && r.WatchedStatus != PLANNED,
+			UserPlanning:           r.InLibrary && r.WatchedStatus == PLANNED,
+		}
+	}
+	return cl, nil
+}
+
+// Get a content list from TMDB, cached for contentListCacheTTL.
+func fetchContentList(list ContentList, contentType ContentType, page int, country string) (TMDBSearchMultiResponse, error) {
+	key := string(list) + "/" + string(contentType) + "/" + country + "/" + strconv.Itoa(page)
+	if e, ok := contentListCache.Load(key); ok && timeNow().Before(e.(contentListCacheEntry).expires) {
+		return e.(contentListCacheEntry).resp, nil
+	}
+	params := map[string]string{"page": strconv.Itoa(page)}
+	if country != "" {
+		params["region"] = country
+	}
+	resp := new(TMDBSearchMultiResponse)
+	err := tmdbRequest(contentListEndpoints[list][contentType], params, &resp)
+	if err != nil {
+		slog.Error("Failed to complete content list request!", "list", list, "type", contentType, "error", err.Error())
+		return TMDBSearchMultiResponse{}, errors.New("failed to complete " + string(list) + " request")
+	}
+	if resp.Results == nil {
+		resp.Results = []TMDBSearchMultiResults{}
+	}
+	// Like discover, these results don't say what they are.
+	for i := range resp.Results {
+		resp.Results[i].MediaType = string(contentType)
+	}
+	contentListCache.Store(key, contentListCacheEntry{resp: *resp, expires: timeNow().Add(contentListCacheTTL)})
+	return *resp, nil
+}
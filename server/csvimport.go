@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Most rows accepted in one simple csv import, each one is a TMDB search.
+const simpleCSVMaxRows = 500
+
+type SimpleCSVImportQuery struct {
+	// Only report what would happen, nothing is written.
+	Preview bool `form:"preview"`
+	// Import in the background, a job is returned to follow progress with.
+	Async bool `form:"async"`
+}
+
+// A row from a simple csv, year and rating are 0 when not given.
+type SimpleCSVRow struct {
+	// Line in the csv, so rows can be found when fixing them up.
+	Line   int    `json:"line"`
+	Title  string `json:"title"`
+	Year   int    `json:"year"`
+	Rating int8   `json:"rating"`
+	// When it was watched, nil when not given.
+	WatchedDate *time.Time `json:"watchedDate"`
+}
+
+type SimpleCSVRowResult struct {
+	Row SimpleCSVRow `json:"row"`
+	ImportRowResult
+}
+
+type SimpleCSVImportReport struct {
+	Preview bool                 `json:"preview"`
+	Rows    []SimpleCSVRowResult `json:"rows"`
+	// Number of rows we couldn't find a close enough match for.
+	Unmatched int `json:"unmatched"`
+}
+
+// Import a csv with `title,year,rating,date` columns (all but title optional)
+// into a users watched list. Each title is searched for and the first result
+// is only used if it closely matches the title and year.
+func importSimpleCSV(db *gorm.DB, userId uint, profileId uint, r io.Reader, preview bool) (SimpleCSVImportReport, error) {
+	rows, err := parseSimpleCSV(r)
+	if err != nil {
+		return SimpleCSVImportReport{}, err
+	}
+	return importSimpleCSVRows(db, userId, profileId, rows, preview, nil), nil
+}
+
+// Import already parsed simple csv rows. onProgress (if not nil)
+// is called after each row with how many are done.
+func importSimpleCSVRows(db *gorm.DB, userId uint, profileId uint, rows []SimpleCSVRow, preview bool, onProgress func(done int, total int)) SimpleCSVImportReport {
+	report := SimpleCSVImportReport{Preview: preview, Rows: []SimpleCSVRowResult{}}
+	for i, row := range rows {
+		if onProgress != nil && i > 0 {
+			onProgress(i, len(rows))
+		}
+		result := SimpleCSVRowResult{Row: row}
+		match, reason := matchSimpleCSVRow(row)
+		if match == nil {
+			result.Action = IMPORT_ACTION_ERROR
+			result.Reason = reason
+			report.Unmatched++
+			report.Rows = append(report.Rows, result)
+			continue
+		}
+		ir := ImportRow{ContentID: match.ID, ContentType: ContentType(match.MediaType), Rating: row.Rating, WatchedDate: row.WatchedDate}
+		result.ImportRowResult = importRow(db, userId, profileId, ir, IMPORT_CONFLICT_SKIP, SOURCE_CSV_IMPORT, preview)
+		report.Rows = append(report.Rows, result)
+	}
+	if onProgress != nil {
+		onProgress(len(rows), len(rows))
+	}
+	slog.Info("Imported simple csv", "userId", userId, "profileId", profileId, "rows", len(rows), "unmatched", report.Unmatched, "preview", preview)
+	return report
+}
+
+// What a background simple csv import needs, the csv is
+// parsed before queueing so bad files are rejected straight away.
+type simpleCSVImportJobPayload struct {
+	ProfileID uint
+	Rows      []SimpleCSVRow
+	Preview   bool
+}
+
+// Queue a simple csv import to run in the background.
+func queueSimpleCSVImport(db *gorm.DB, userId uint, profileId uint, r io.Reader, preview bool) (Job, error) {
+	rows, err := parseSimpleCSV(r)
+	if err != nil {
+		return Job{}, err
+	}
+	return enqueueJob(db, userId, JOB_SIMPLE_CSV_IMPORT, simpleCSVImportJobPayload{ProfileID: profileId, Rows: rows, Preview: preview})
+}
+
+func runSimpleCSVImportJob(jc *JobContext) (any, error) {
+	var p simpleCSVImportJobPayload
+	if err := jc.payload(&p); err != nil {
+		slog.Error("runSimpleCSVImportJob: Failed to read payload", "job_id", jc.job.ID, "error", err)
+		return nil, errors.New("invalid job payload")
+	}
+	jc.setProgress(0, len(p.Rows))
+	return importSimpleCSVRows(jc.db, jc.job.UserID, p.ProfileID, p.Rows, p.Preview, jc.setProgress), nil
+}
+
+// Read rows from a simple csv. A header row is optional, if there
+// is one, columns can be in any order, otherwise they must be `title,year,rating,date`.
+// Dates are YYYY-MM-DD.
+func parseSimpleCSV(r io.Reader) ([]SimpleCSVRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	// Blank lines are skipped by the reader, so keep track of which line each record is on.
+	records := [][]string{}
+	lines := []int{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return []SimpleCSVRow{}, fmt.Errorf("invalid csv: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		records = append(records, rec)
+		lines = append(lines, line)
+	}
+	cols := map[string]int{"title": 0, "year": 1, "rating": 2, "date": 3}
+	start := 0
+	if len(records) > 0 && hasCSVColumn(records[0], "title") {
+		cols = map[string]int{}
+		for i, name := range records[0] {
+			cols[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		start = 1
+	}
+	if len(records)-start > simpleCSVMaxRows {
+		return []SimpleCSVRow{}, fmt.Errorf("too many rows, at most %d can be imported at once", simpleCSVMaxRows)
+	}
+	get := func(rec []string, col string) string {
+		i, ok := cols[col]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	rows := []SimpleCSVRow{}
+	for i, rec := range records[start:] {
+		row := SimpleCSVRow{Line: lines[start+i], Title: sanitizeString(get(rec, "title"))}
+		if row.Title == "" {
+			// Rows of only whitespace are fine, rows without a title aren't.
+			if strings.TrimSpace(strings.Join(rec, "")) == "" {
+				continue
+			}
+			return []SimpleCSVRow{}, fmt.Errorf("line %d: title is required", row.Line)
+		}
+		if y := get(rec, "year"); y != "" {
+			year, err := strconv.Atoi(y)
+			if err != nil || year < 1800 || year > 9999 {
+				return []SimpleCSVRow{}, fmt.Errorf("line %d: invalid year %q", row.Line, y)
+			}
+			row.Year = year
+		}
+		if rt := get(rec, "rating"); rt != "" {
+			rating, err := strconv.ParseFloat(rt, 64)
+			if err != nil || rating < 0 || rating > 10 {
+				return []SimpleCSVRow{}, fmt.Errorf("line %d: invalid rating %q, must be between 0 and 10", row.Line, rt)
+			}
+			row.Rating = int8(math.Round(rating))
+		}
+		if d := get(rec, "date"); d != "" {
+			date, err := time.Parse(time.DateOnly, d)
+			if err != nil {
+				return []SimpleCSVRow{}, fmt.Errorf("line %d: invalid date %q, must be YYYY-MM-DD", row.Line, d)
+			}
+			row.WatchedDate = &date
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return []SimpleCSVRow{}, errors.New("csv has no rows")
+	}
+	return rows, nil
+}
+
+func hasCSVColumn(rec []string, name string) bool {
+	for _, c := range rec {
+		if strings.EqualFold(strings.TrimSpace(c), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search for a rows title, returning the first movie or show
+// if it is a close match, or why there isn't a match.
+func matchSimpleCSVRow(row SimpleCSVRow) (*TMDBSearchMultiResults, string) {
+	search, err := searchContent(row.Title, SearchQuery{})
+	if err != nil {
+		return nil, err.Error()
+	}
+	for _, r := range search.Results {
+		if r.MediaType != string(MOVIE) && r.MediaType != string(SHOW) {
+			continue
+		}
+		if !isCloseTitleMatch(row.Title, r.Title, r.OriginalTitle, r.Name, r.OriginalName) {
+			return nil, "no close match found, best result was " + strings.TrimSpace(r.Title+r.Name)
+		}
+		if row.Year != 0 {
+			date := r.ReleaseDate
+			if date == "" {
+				date = r.FirstAirDate
+			}
+			// Release years often differ by one between sources (festival vs wide release).
+			year, err := strconv.Atoi(strings.SplitN(date, "-", 2)[0])
+			if err != nil || year < row.Year-1 || year > row.Year+1 {
+				return nil, "no close match found, best result was from " + strings.SplitN(date, "-", 2)[0]
+			}
+		}
+		return &r, ""
+	}
+	return nil, "no results found"
+}
+
+// If title is close enough to one of candidates, allowing
+// one edit for every five characters (so short titles must be exact).
+func isCloseTitleMatch(title string, candidates ...string) bool {
+	title = normalizeTitle(title)
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		c = normalizeTitle(c)
+		maxLen := max(len([]rune(title)), len([]rune(c)))
+		if levenshtein(title, c) <= maxLen/5 {
+			return true
+		}
+	}
+	return false
+}
+
+// Lowercase title and drop punctuation, so `Spider-Man: Homecoming`
+// and `spider man homecoming` are the same.
+func normalizeTitle(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteRune(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// Number of single character edits needed to turn a into b.
+func levenshtein(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
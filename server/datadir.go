@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Sub directories of the data dir, paths inside them are built with dataPath too.
+const (
+	// Downloaded posters and episode stills.
+	imgDir = "img"
+	// Uploaded user avatars.
+	avatarsDir = "avatars"
+	// Database backups.
+	backupsDir = "backups"
+)
+
+// Sub directories of the data dir that we create at startup.
+var dataSubDirs = []string{imgDir, avatarsDir, backupsDir}
+
+// Get data dir from DATA_DIR, defaulting to ./data.
+func getDataDir() string {
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "./data"
+}
+
+// Build a path inside the data dir.
+// All paths to files we store must be built with this.
+func dataPath(elem ...string) string {
+	return path.Join(append([]string{getDataDir()}, elem...)...)
+}
+
+// Create the data dir structure and make sure we can write to it.
+// Exits with an error explaining what to fix if anything is wrong,
+// so we don't fail at random points later on (eg. first poster download).
+func ensureDataDir() {
+	dir := getDataDir()
+	if real, err := filepath.EvalSymlinks(dir); err == nil && real != filepath.Clean(dir) {
+		slog.Info("Data dir is a symlink", "data_dir", dir, "resolved", real)
+	}
+	for _, d := range append([]string{""}, dataSubDirs...) {
+		if err := os.MkdirAll(dataPath(d), 0764); err != nil {
+			log.Fatalf("Failed to create data dir %q: %v. Make sure the parent directory exists and is writable by this user, or set DATA_DIR to a different path.", dataPath(d), err)
+		}
+	}
+	if err := checkDirWritable(dir); err != nil {
+		log.Fatalf("Data dir %q is not writable: %v. Make sure it is owned by (or writable by) the user running watcharr, or set DATA_DIR to a different path.", dir, err)
+	}
+	warnOldDataDir(dir)
+}
+
+// Write and remove a probe file to check a dir is writable.
+func checkDirWritable(dir string) error {
+	probe := path.Join(dir, ".write_probe")
+	if err := os.WriteFile(probe, []byte{}, 0664); err != nil {
+		return err
+	}
+	return os.Remove(probe)
+}
+
+// Existing installs keep their data in ./data. If DATA_DIR has been changed
+// and there is no database at the new location, but there is one in ./data,
+// the user has likely forgotten to move their data over.
+func warnOldDataDir(dir string) {
+	if filepath.Clean(dir) == "data" {
+		return
+	}
+	if fileExists(path.Join(dir, "watcharr.db")) || !fileExists("./data/watcharr.db") {
+		return
+	}
+	if same, err := sameDir(dir, "./data"); err == nil && same {
+		return
+	}
+	slog.Warn("DATA_DIR is set, but an existing database was found in ./data and not in DATA_DIR. "+
+		"A new empty database will be created. To keep your data, stop watcharr and move the contents of ./data into DATA_DIR "+
+		"(or symlink DATA_DIR to ./data).", "data_dir", dir)
+}
+
+// If two paths point to the same directory (eg. one is a symlink to the other).
+func sameDir(a string, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if !ai.IsDir() || !bi.IsDir() {
+		return false, errors.New("not a directory")
+	}
+	return os.SameFile(ai, bi), nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DuplicateGroup struct {
+	// Id (at Provider) of the content all entries are for.
+	ContentID int                   `json:"contentId"`
+	Provider  ContentProvider       `json:"provider"`
+	Type      ContentType           `json:"type"`
+	Title     string                `json:"title"`
+	Entries   []DuplicateGroupEntry `json:"entries"`
+}
+
+type DuplicateGroupEntry struct {
+	ID      uint          `json:"id"`
+	AddedAt time.Time     `json:"addedAt"`
+	Source  WatchedSource `json:"source"`
+	Status  WatchedStatus `json:"status"`
+	Rating  int8          `json:"rating"`
+}
+
+type DuplicatesMergeRequest struct {
+	// Watched entry to keep.
+	KeepID uint `json:"keepId" binding:"required"`
+	// Watched entries to merge into KeepID and then remove.
+	DeleteIDs []uint `json:"deleteIds" binding:"required,min=1"`
+}
+
+type DuplicatesMergeResponse struct {
+	// Watched entry that was kept, with its merged activity.
+	Kept Watched `json:"kept"`
+}
+
+// Find content that is on a users watched list more than once.
+// This can happen when the same TMDB content has ended up in
+// our content table multiple times (eg. from different imports).
+func getWatchedDuplicates(db *gorm.DB, userId uint, profileId uint) ([]DuplicateGroup, error) {
+	var dupes []struct {
+		Provider   ContentProvider
+		ProviderID int
+		Type       ContentType
+	}
+	res := db.Model(&Watched{}).
+		Select("contents.provider, contents.provider_id, contents.type").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId).
+		Group("contents.provider, contents.provider_id, contents.type").
+		Having("COUNT(*) > 1").
+		Scan(&dupes)
+	if res.Error != nil {
+		slog.Error("Failed to find duplicate watched entries", "error", res.Error)
+		return []DuplicateGroup{}, errors.New("failed to find duplicates")
+	}
+	groups := []DuplicateGroup{}
+	for _, d := range dupes {
+		var watched []Watched
+		res = db.Model(&Watched{}).Preload("Content").
+			Where("user_id = ? AND sub_profile_id = ?", userId, profileId).
+			Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("provider = ? AND provider_id = ? AND type = ?", d.Provider, d.ProviderID, d.Type)).
+			Order("created_at").
+			Find(&watched)
+		if res.Error != nil {
+			slog.Error("Failed to get duplicate watched entries", "error", res.Error)
+			return []DuplicateGroup{}, errors.New("failed to find duplicates")
+		}
+		if len(watched) == 0 {
+			continue
+		}
+		g := DuplicateGroup{ContentID: d.ProviderID, Provider: d.Provider, Type: d.Type, Title: watched[0].Content.Title}
+		for _, w := range watched {
+			g.Entries = append(g.Entries, DuplicateGroupEntry{ID: w.ID, AddedAt: w.CreatedAt, Source: w.Source, Status: w.Status, Rating: w.Rating})
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// Merge duplicate watched entries into one. Everything attached to
+// the removed entries is moved over to the one being kept.
+// All entries must be for the same content as the kept one.
+func mergeWatchedDuplicates(db *gorm.DB, userId uint, profileId uint, mr DuplicatesMergeRequest) (DuplicatesMergeResponse, error) {
+	for _, id := range mr.DeleteIDs {
+		if id == mr.KeepID {
+			return DuplicatesMergeResponse{}, errors.New("keepId can't also be in deleteIds")
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var keep Watched
+		if res := tx.Preload("Content").Where("id = ? AND user_id = ? AND sub_profile_id = ?", mr.KeepID, userId, profileId).Take(&keep); res.Error != nil {
+			return errors.New("not all watched entries were found")
+		}
+		// Duplicates can be on different content rows, so they are matched by what the content is.
+		var count int64
+		res := tx.Model(&Watched{}).
+			Joins("JOIN contents ON contents.id = watcheds.content_id").
+			Where("watcheds.id IN ? AND watcheds.user_id = ? AND watcheds.sub_profile_id = ?", mr.DeleteIDs, userId, profileId).
+			Where("contents.provider = ? AND contents.provider_id = ? AND contents.type = ?", keep.Content.Provider, keep.Content.ProviderID, keep.Content.Type).
+			Count(&count)
+		if res.Error != nil {
+			slog.Error("Failed to get duplicate watched entries", "error", res.Error)
+			return errors.New("failed to find watched entries")
+		}
+		if count != int64(len(mr.DeleteIDs)) {
+			return errors.New("not all watched entries were found for the same content")
+		}
+		for _, id := range mr.DeleteIDs {
+			if err := moveWatchedChildren(tx, id, keep.ID); err != nil {
+				slog.Error("Failed to move duplicate watched entry to kept entry", "id", id, "error", err)
+				return errors.New("failed to merge duplicates")
+			}
+		}
+		res = tx.Where("id IN ? AND user_id = ?", mr.DeleteIDs, userId).Delete(&Watched{})
+		if res.Error != nil {
+			slog.Error("Failed to remove duplicate watched entries", "error", res.Error)
+			return errors.New("failed to remove duplicates")
+		}
+		return nil
+	})
+	if err != nil {
+		return DuplicatesMergeResponse{}, err
+	}
+	var kept Watched
+	res := db.Model(&Watched{}).Preload("Content").Preload("Activity").Where("id = ?", mr.KeepID).Take(&kept)
+	if res.Error != nil {
+		return DuplicatesMergeResponse{}, errors.New("merged, but failed to get kept entry")
+	}
+	slog.Info("Merged duplicate watched entries", "userId", userId, "kept", mr.KeepID, "removed", mr.DeleteIDs)
+	return DuplicatesMergeResponse{Kept: kept}, nil
+}
+
+type ContentDuplicatesMergeResponse struct {
+	// Number of titles that had more than one content row.
+	Groups int `json:"groups"`
+	// Duplicate content rows removed.
+	ContentRemoved int `json:"contentRemoved"`
+	// Watched entries moved over to the content row that was kept.
+	WatchedRepointed int `json:"watchedRepointed"`
+	// Watched entries merged into another entry for the same user and profile,
+	// because they had the same title on their list twice.
+	WatchedMerged int `json:"watchedMerged"`
+}
+
+// Find content rows that are for the same content and merge
+// them into the oldest one, moving everything that references them over.
+// The content unique index stops new duplicates, but databases
+// from before it existed can still have them.
+func mergeDuplicateContent(db *gorm.DB) (ContentDuplicatesMergeResponse, error) {
+	var groups []struct {
+		Provider   ContentProvider
+		ProviderID int
+		Type       ContentType
+		KeepID     int
+	}
+	res := db.Model(&Content{}).
+		Select("provider, provider_id, type, MIN(id) AS keep_id").
+		Group("provider, provider_id, type").
+		Having("COUNT(*) > 1").
+		Scan(&groups)
+	if res.Error != nil {
+		slog.Error("mergeDuplicateContent: Failed to find duplicate content", "error", res.Error)
+		return ContentDuplicatesMergeResponse{}, errors.New("failed to find duplicate content")
+	}
+	resp := ContentDuplicatesMergeResponse{Groups: len(groups)}
+	for _, g := range groups {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var dupeIds []int
+			if res := tx.Model(&Content{}).Where("provider = ? AND provider_id = ? AND type = ? AND id != ?", g.Provider, g.ProviderID, g.Type, g.KeepID).Pluck("id", &dupeIds); res.Error != nil {
+				return res.Error
+			}
+			repointed, merged, err := repointContent(tx, g.KeepID, dupeIds)
+			if err != nil {
+				return err
+			}
+			if res := tx.Where("id IN ?", dupeIds).Delete(&Content{}); res.Error != nil {
+				return res.Error
+			}
+			slog.Info("Merged duplicate content", "provider", g.Provider, "id", g.ProviderID, "type", g.Type, "kept", g.KeepID, "removed", dupeIds, "watchedRepointed", repointed, "watchedMerged", merged)
+			resp.ContentRemoved += len(dupeIds)
+			resp.WatchedRepointed += repointed
+			resp.WatchedMerged += merged
+			return nil
+		})
+		if err != nil {
+			slog.Error("mergeDuplicateContent: Failed to merge duplicate content", "provider", g.Provider, "id", g.ProviderID, "type", g.Type, "error", err)
+			return resp, errors.New("failed to merge duplicate content")
+		}
+	}
+	return resp, nil
+}
+
+// Point everything referencing the dupeIds content rows at keepId instead.
+// Users can only have a title on each profiles list once, so when a user
+// has watched entries for more than one of the rows, they are merged into
+// one (preferring entries that aren't removed, then the kept contents entry).
+func repointContent(tx *gorm.DB, keepId int, dupeIds []int) (repointed int, merged int, err error) {
+	var watched []Watched
+	res := tx.Unscoped().Model(&Watched{}).
+		Select("id", "user_id", "sub_profile_id", "content_id", "deleted_at").
+		Where("content_id IN ?", append([]int{keepId}, dupeIds...)).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "deleted_at IS NOT NULL, content_id != ?, id", Vars: []interface{}{keepId}, WithoutParentheses: true}}).
+		Find(&watched)
+	if res.Error != nil {
+		return 0, 0, res.Error
+	}
+	type listKey struct{ userId, profileId uint }
+	keep := map[listKey]Watched{}
+	toRepoint := []uint{}
+	for _, w := range watched {
+		k := listKey{w.UserID, w.SubProfileID}
+		target, ok := keep[k]
+		if !ok {
+			keep[k] = w
+			if w.ContentID != keepId {
+				toRepoint = append(toRepoint, w.ID)
+			}
+			continue
+		}
+		if err := mergeWatchedInto(tx, target.ID, w.ID); err != nil {
+			return 0, 0, err
+		}
+		merged++
+	}
+	// Merged entries are gone now, so these can't clash with them.
+	if len(toRepoint) > 0 {
+		if res := tx.Unscoped().Model(&Watched{}).Where("id IN ?", toRepoint).Update("content_id", keepId); res.Error != nil {
+			return 0, 0, res.Error
+		}
+	}
+	if res := tx.Model(&Notification{}).Where("content_id IN ?", dupeIds).Update("content_id", keepId); res.Error != nil {
+		return 0, 0, res.Error
+	}
+	return len(toRepoint), merged, nil
+}
+
+// Move everything attached to watched entry fromId (activity, episodes, rewatches
+// and tags) over to toId. Episodes and tags toId already has are dropped.
+// Only moves rows, callers update their user if toId belongs to someone else.
+func moveWatchedChildren(tx *gorm.DB, fromId uint, toId uint) error {
+	if res := tx.Unscoped().Model(&Activity{}).Where("watched_id = ?", fromId).Update("watched_id", toId); res.Error != nil {
+		return res.Error
+	}
+	res := tx.Unscoped().
+		Where("watched_id = ? AND EXISTS (SELECT 1 FROM watched_episodes t WHERE t.watched_id = ? AND t.season_number = watched_episodes.season_number AND t.episode_number = watched_episodes.episode_number)", fromId, toId).
+		Delete(&WatchedEpisode{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res := tx.Unscoped().Model(&WatchedEpisode{}).Where("watched_id = ?", fromId).Update("watched_id", toId); res.Error != nil {
+		return res.Error
+	}
+	if res := tx.Unscoped().Model(&ReWatchEntry{}).Where("watched_id = ?", fromId).Update("watched_id", toId); res.Error != nil {
+		return res.Error
+	}
+	return moveWatchedTags(tx, "watched_id", fromId, toId)
+}
+
+// Move everything from watched entry fromId to toId, then remove fromId.
+func mergeWatchedInto(tx *gorm.DB, toId uint, fromId uint) error {
+	if err := moveWatchedChildren(tx, fromId, toId); err != nil {
+		return err
+	}
+	// Hard delete, the entry points at content that is being removed.
+	if res := tx.Unscoped().Delete(&Watched{}, fromId); res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
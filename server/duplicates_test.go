@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Create rows, failing the test if any can't be.
+func mustCreate(t *testing.T, db *gorm.DB, rows ...any) {
+	t.Helper()
+	for _, r := range rows {
+		if err := db.Create(r).Error; err != nil {
+			t.Fatalf("failed to create %T: %v", r, err)
+		}
+	}
+}
+
+// Count rows of table whose watched_id no longer points at a watched entry.
+func countOrphans(t *testing.T, db *gorm.DB, table string) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Table(table).Where("watched_id NOT IN (SELECT id FROM watcheds)").Count(&n).Error; err != nil {
+		t.Fatalf("failed to count %s orphans: %v", table, err)
+	}
+	return n
+}
+
+func TestMergeDuplicateContent(t *testing.T) {
+	db := newTestDB(t)
+	// Databases from before the index could have duplicates, make some.
+	if err := db.Migrator().DropIndex(&Content{}, "contentprovideridx"); err != nil {
+		t.Fatalf("failed to drop content index: %v", err)
+	}
+	kept := Content{ID: 1, TmdbID: 1399, Provider: PROVIDER_TMDB, ProviderID: 1399, Type: SHOW, Title: "Game of Thrones"}
+	dupe := Content{ID: 2, TmdbID: 1399, Provider: PROVIDER_TMDB, ProviderID: 1399, Type: SHOW, Title: "Game of Thrones"}
+	other := Content{ID: 3, TmdbID: 550, Provider: PROVIDER_TMDB, ProviderID: 550, Type: MOVIE, Title: "Fight Club"}
+	mustCreate(t, db, &kept, &dupe, &other)
+
+	// Alice has both rows on her list, so her entries are merged.
+	// Bob only has the duplicate, so his entry is just repointed.
+	aliceKept := Watched{UserID: 1, ContentID: 1, Status: WATCHING}
+	aliceDupe := Watched{UserID: 1, ContentID: 2, Status: FINISHED}
+	bobDupe := Watched{UserID: 2, ContentID: 2, Status: PLANNED}
+	aliceOther := Watched{UserID: 1, ContentID: 3, Status: FINISHED}
+	mustCreate(t, db, &aliceKept, &aliceDupe, &bobDupe, &aliceOther)
+
+	tag := Tag{UserID: 1, Name: "fantasy"}
+	otherTag := Tag{UserID: 1, Name: "rewatch"}
+	mustCreate(t, db, &tag, &otherTag)
+	notifyContent := 2
+	mustCreate(t, db,
+		&Activity{UserID: 1, WatchedID: aliceKept.ID, Type: ADDED_WATCHED},
+		&Activity{UserID: 1, WatchedID: aliceDupe.ID, Type: ADDED_WATCHED},
+		&Activity{UserID: 2, WatchedID: bobDupe.ID, Type: ADDED_WATCHED},
+		// S1E1 is on both of alices entries, S1E2 only on the duplicate.
+		&WatchedEpisode{WatchedID: aliceKept.ID, SeasonNumber: 1, EpisodeNumber: 1},
+		&WatchedEpisode{WatchedID: aliceDupe.ID, SeasonNumber: 1, EpisodeNumber: 1},
+		&WatchedEpisode{WatchedID: aliceDupe.ID, SeasonNumber: 1, EpisodeNumber: 2},
+		&ReWatchEntry{UserID: 1, WatchedID: aliceDupe.ID, WatchedAt: time.Now()},
+		&ReWatchEntry{UserID: 2, WatchedID: bobDupe.ID, WatchedAt: time.Now()},
+		// Tagged on both entries and only on the duplicate.
+		&WatchedTag{WatchedID: aliceKept.ID, TagID: tag.ID},
+		&WatchedTag{WatchedID: aliceDupe.ID, TagID: tag.ID},
+		&WatchedTag{WatchedID: aliceDupe.ID, TagID: otherTag.ID},
+		&Notification{UserID: 2, Type: "test", ContentID: &notifyContent},
+	)
+
+	resp, err := mergeDuplicateContent(db)
+	if err != nil {
+		t.Fatalf("mergeDuplicateContent failed: %v", err)
+	}
+	want := ContentDuplicatesMergeResponse{Groups: 1, ContentRemoved: 1, WatchedRepointed: 1, WatchedMerged: 1}
+	if resp != want {
+		t.Errorf("got response %+v, want %+v", resp, want)
+	}
+
+	var contentIds []int
+	db.Model(&Content{}).Order("id").Pluck("id", &contentIds)
+	if len(contentIds) != 2 || contentIds[0] != 1 || contentIds[1] != 3 {
+		t.Errorf("got content %v left, want [1 3]", contentIds)
+	}
+	var watched []Watched
+	db.Unscoped().Order("id").Find(&watched)
+	if len(watched) != 3 {
+		t.Fatalf("got %d watched entries, want 3: %+v", len(watched), watched)
+	}
+	for _, w := range watched {
+		if w.ID == aliceDupe.ID {
+			t.Errorf("merged watched entry %d wasn't removed", w.ID)
+		}
+		if w.ID == bobDupe.ID && w.ContentID != 1 {
+			t.Errorf("bobs watched points at content %d, want 1", w.ContentID)
+		}
+		if w.ID == aliceOther.ID && w.ContentID != 3 {
+			t.Errorf("unrelated watched was changed to content %d", w.ContentID)
+		}
+	}
+
+	for _, tc := range []struct {
+		table     string
+		watchedId uint
+		want      int64
+	}{
+		{"activities", aliceKept.ID, 2},
+		{"activities", bobDupe.ID, 1},
+		{"watched_episodes", aliceKept.ID, 2},
+		{"re_watch_entries", aliceKept.ID, 1},
+		{"re_watch_entries", bobDupe.ID, 1},
+		{"watched_tags", aliceKept.ID, 2},
+	} {
+		var n int64
+		db.Table(tc.table).Where("watched_id = ?", tc.watchedId).Count(&n)
+		if n != tc.want {
+			t.Errorf("got %d %s on watched %d, want %d", n, tc.table, tc.watchedId, tc.want)
+		}
+	}
+	for _, table := range []string{"activities", "watched_episodes", "re_watch_entries", "watched_tags"} {
+		if n := countOrphans(t, db, table); n != 0 {
+			t.Errorf("%d %s left pointing at removed watched entries", n, table)
+		}
+	}
+	var n Notification
+	db.Take(&n)
+	if n.ContentID == nil || *n.ContentID != 1 {
+		t.Errorf("notification points at content %v, want 1", n.ContentID)
+	}
+}
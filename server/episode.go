@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// An episode of a show on a users watched list, that they have watched.
+type WatchedEpisode struct {
+	GormModel
+	WatchedID     uint `json:"watchedId" gorm:"uniqueIndex:wtchdssnepidx;not null"`
+	SeasonNumber  int  `json:"seasonNumber" gorm:"uniqueIndex:wtchdssnepidx;not null"`
+	EpisodeNumber int  `json:"episodeNumber" gorm:"uniqueIndex:wtchdssnepidx;not null"`
+	// When the episode was watched, if known.
+	WatchedDate *time.Time `json:"watchedDate"`
+	// Length of the episode in minutes, from its season details. 0 when unknown.
+	Runtime uint32 `json:"runtime" gorm:"not null;default:0"`
+}
+
+type WatchedSeasonCompleteRequest struct {
+	// Optional date all episodes were watched on.
+	WatchedDate *time.Time `json:"watchedDate"`
+}
+
+type WatchedShowProgress struct {
+	// Number of episodes watched.
+	Watched int64 `json:"watched"`
+	// Total number of episodes in the show, from our cached content.
+	Total uint32 `json:"total"`
+}
+
+type WatchedSeasonResponse struct {
+	// Episodes marked (or unmarked) as watched.
+	Changed  int64               `json:"changed"`
+	Progress WatchedShowProgress `json:"progress"`
+}
+
+// Get the users watched show, erroring if it doesn't exist or isn't a show.
+func getWatchedShow(db *gorm.DB, userId uint, profileId uint, id uint) (Watched, error) {
+	var w Watched
+	res := db.Model(&Watched{}).Preload("Content").Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Take(&w)
+	if res.Error != nil {
+		return Watched{}, errors.New("no watched entry found")
+	}
+	if w.Content.Type != SHOW {
+		return Watched{}, errors.New("watched entry is not a show")
+	}
+	return w, nil
+}
+
+func getShowProgress(db *gorm.DB, w Watched) WatchedShowProgress {
+	var count int64
+	db.Model(&WatchedEpisode{}).Where("watched_id = ?", w.ID).Count(&count)
+	return WatchedShowProgress{Watched: count, Total: w.Content.NumberOfEpisodes}
+}
+
+// Mark every episode in a season as watched,
+// episodes already marked as watched are left alone.
+func completeWatchedSeason(db *gorm.DB, userId uint, profileId uint, id uint, seasonNum int, sr WatchedSeasonCompleteRequest) (WatchedSeasonResponse, error) {
+	w, err := getWatchedShow(db, userId, profileId, id)
+	if err != nil {
+		return WatchedSeasonResponse{}, err
+	}
+	season, err := seasonDetails(strconv.Itoa(w.Content.TmdbID), strconv.Itoa(seasonNum))
+	if err != nil {
+		return WatchedSeasonResponse{}, err
+	}
+	if len(season.Episodes) == 0 {
+		return WatchedSeasonResponse{}, errors.New("season has no episodes")
+	}
+	episodes := []WatchedEpisode{}
+	for _, ep := range season.Episodes {
+		episodes = append(episodes, WatchedEpisode{WatchedID: w.ID, SeasonNumber: seasonNum, EpisodeNumber: ep.EpisodeNumber, WatchedDate: sr.WatchedDate, Runtime: uint32(max(ep.Runtime, 0))})
+	}
+	var created int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&episodes)
+		if res.Error != nil {
+			return res.Error
+		}
+		created = res.RowsAffected
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to mark season as watched", "watched_id", w.ID, "season", seasonNum, "error", err)
+		return WatchedSeasonResponse{}, errors.New("failed to mark season as watched")
+	}
+	return WatchedSeasonResponse{Changed: created, Progress: getShowProgress(db, w)}, nil
+}
+
+// Unmark every episode in a season as watched.
+func uncompleteWatchedSeason(db *gorm.DB, userId uint, profileId uint, id uint, seasonNum int) (WatchedSeasonResponse, error) {
+	w, err := getWatchedShow(db, userId, profileId, id)
+	if err != nil {
+		return WatchedSeasonResponse{}, err
+	}
+	// Hard delete, so episodes can be marked again without hitting the unique index.
+	res := db.Unscoped().Where("watched_id = ? AND season_number = ?", w.ID, seasonNum).Delete(&WatchedEpisode{})
+	if res.Error != nil {
+		slog.Error("Failed to unmark season as watched", "watched_id", w.ID, "season", seasonNum, "error", res.Error)
+		return WatchedSeasonResponse{}, errors.New("failed to unmark season as watched")
+	}
+	return WatchedSeasonResponse{Changed: res.RowsAffected, Progress: getShowProgress(db, w)}, nil
+}
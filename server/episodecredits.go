@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How long episode credits we have stored are used for, they rarely change once an episode airs.
+const episodeCreditsCacheTTL = 7 * 24 * time.Hour
+
+type ContentCreditKind string
+
+const (
+	CREDIT_CAST  ContentCreditKind = "cast"
+	CREDIT_GUEST ContentCreditKind = "guest"
+	CREDIT_CREW  ContentCreditKind = "crew"
+)
+
+// A person credited on a shows episode, stored so episode credits
+// don't have to be requested from TMDB every time they are viewed.
+type ContentCredit struct {
+	ID uint `json:"-" gorm:"primarykey"`
+	// TMDB id of the show.
+	TmdbID        int               `json:"-" gorm:"index:contentcreditepidx;not null"`
+	SeasonNumber  int               `json:"-" gorm:"index:contentcreditepidx;not null"`
+	EpisodeNumber int               `json:"-" gorm:"index:contentcreditepidx;not null"`
+	Kind          ContentCreditKind `json:"-" gorm:"not null"`
+	// TMDB id of the person.
+	PersonID    int    `json:"id"`
+	Name        string `json:"name"`
+	ProfilePath string `json:"profilePath"`
+	// Who they played, for cast and guest stars.
+	Character string `json:"character,omitempty"`
+	// What they did, for crew (eg. Directing, Director).
+	Department string `json:"department,omitempty"`
+	Job        string `json:"job,omitempty"`
+	// Billing order, cast and guest stars are returned in it.
+	Order     int       `json:"order"`
+	CreatedAt time.Time `json:"-"`
+}
+
+type EpisodeCreditsResponse struct {
+	// TMDB id of the show.
+	ID            int `json:"id"`
+	SeasonNumber  int `json:"seasonNumber"`
+	EpisodeNumber int `json:"episodeNumber"`
+	// Regular cast of the show appearing in the episode.
+	Cast []ContentCredit `json:"cast"`
+	// People only appearing in this episode (eg. "the one where X appears").
+	GuestStars []ContentCredit `json:"guestStars"`
+	Crew       []ContentCredit `json:"crew"`
+}
+
+// Get credits of a shows episode, from what we have stored when it's recent enough,
+// otherwise from TMDB (replacing what we had). Episodes TMDB has no credits for
+// have nothing stored, so are requested each time.
+func episodeCredits(db *gorm.DB, tvId string, season string, episode string) (EpisodeCreditsResponse, error) {
+	tmdbId, _ := strconv.Atoi(tvId)
+	seasonNumber, _ := strconv.Atoi(season)
+	episodeNumber, _ := strconv.Atoi(episode)
+
+	credits := []ContentCredit{}
+	res := db.Model(&ContentCredit{}).
+		Where("tmdb_id = ? AND season_number = ? AND episode_number = ?", tmdbId, seasonNumber, episodeNumber).
+		Order("kind, \"order\", id").Find(&credits)
+	if res.Error != nil {
+		slog.Error("episodeCredits: Failed to get stored credits", "tmdb_id", tmdbId, "season", seasonNumber, "episode", episodeNumber, "error", res.Error)
+	}
+	if len(credits) > 0 && timeNow().Sub(credits[0].CreatedAt) < episodeCreditsCacheTTL {
+		return episodeCreditsResponse(tmdbId, seasonNumber, episodeNumber, credits), nil
+	}
+
+	resp := new(TMDBEpisodeCredits)
+	err := tmdbRequest("/tv/"+tvId+"/season/"+season+"/episode/"+episode+"/credits", map[string]string{}, &resp)
+	if err != nil {
+		slog.Error("Failed to complete episode credits request!", "error", err.Error())
+		return EpisodeCreditsResponse{}, errors.New("failed to complete episode credits request")
+	}
+	credits = []ContentCredit{}
+	add := func(kind ContentCreditKind, people []TMDBEpisodeCredit) {
+		for _, p := range people {
+			credits = append(credits, ContentCredit{
+				TmdbID:        tmdbId,
+				SeasonNumber:  seasonNumber,
+				EpisodeNumber: episodeNumber,
+				Kind:          kind,
+				PersonID:      p.ID,
+				Name:          p.Name,
+				ProfilePath:   p.ProfilePath,
+				Character:     p.Character,
+				Department:    p.Department,
+				Job:           p.Job,
+				Order:         p.Order,
+			})
+		}
+	}
+	add(CREDIT_CAST, resp.Cast)
+	add(CREDIT_GUEST, resp.GuestStars)
+	add(CREDIT_CREW, resp.Crew)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Where("tmdb_id = ? AND season_number = ? AND episode_number = ?", tmdbId, seasonNumber, episodeNumber).Delete(&ContentCredit{}); res.Error != nil {
+			return res.Error
+		}
+		if len(credits) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&credits, 500).Error
+	})
+	if err != nil {
+		slog.Error("episodeCredits: Failed to store credits", "tmdb_id", tmdbId, "season", seasonNumber, "episode", episodeNumber, "error", err)
+	}
+	return episodeCreditsResponse(tmdbId, seasonNumber, episodeNumber, credits), nil
+}
+
+// Split stored credits up by kind.
+func episodeCreditsResponse(tmdbId int, seasonNumber int, episodeNumber int, credits []ContentCredit) EpisodeCreditsResponse {
+	resp := EpisodeCreditsResponse{
+		ID:            tmdbId,
+		SeasonNumber:  seasonNumber,
+		EpisodeNumber: episodeNumber,
+		Cast:          []ContentCredit{},
+		GuestStars:    []ContentCredit{},
+		Crew:          []ContentCredit{},
+	}
+	for _, c := range credits {
+		switch c.Kind {
+		case CREDIT_CAST:
+			resp.Cast = append(resp.Cast, c)
+		case CREDIT_GUEST:
+			resp.GuestStars = append(resp.GuestStars, c)
+		case CREDIT_CREW:
+			resp.Crew = append(resp.Crew, c)
+		}
+	}
+	return resp
+}
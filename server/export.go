@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Version of the export format, bumped when it changes in a way importers need to know about.
+const accountExportVersion = 1
+
+// Number of watched items loaded at a time when exporting,
+// so large lists don't have to be held in memory.
+const exportBatchSize = 100
+
+// Everything we store about a user, for backups and data access requests.
+// Only used to document the export, it is streamed field by field.
+type AccountExport struct {
+	Version       int             `json:"version"`
+	ExportedAt    time.Time       `json:"exportedAt"`
+	User          AuthMeResponse  `json:"user"`
+	Profile       UserProfile     `json:"profile"`
+	Settings      UserSettings    `json:"settings"`
+	SubProfiles   []SubProfile    `json:"subProfiles"`
+	Watched       []ExportWatched `json:"watched"`
+	Notifications []Notification  `json:"notifications"`
+}
+
+// Watched item with the fields we normally hide from clients, with its activity and episodes.
+type ExportWatched struct {
+	Watched
+	SubProfileID uint             `json:"subProfileId"`
+	Episodes     []WatchedEpisode `json:"episodes"`
+}
+
+// Write all of a users data to w as json. Nothing belonging to other users
+// is included (content is shared, but is just TMDB metadata).
+func exportAccount(db *gorm.DB, userId uint, w io.Writer) error {
+	var user User
+	res := db.Model(&User{}).Omit("password", "third_party_id").Where("id = ?", userId).Take(&user)
+	if res.Error != nil {
+		slog.Error("exportAccount: Failed to get user", "userId", userId, "error", res.Error)
+		return errors.New("failed to get user")
+	}
+	profile, err := getUserProfile(db, userId)
+	if err != nil {
+		return err
+	}
+	subProfiles, err := getSubProfiles(db, userId)
+	if err != nil {
+		return err
+	}
+	notifications := []Notification{}
+	res = db.Model(&Notification{}).Preload("Content").Where("user_id = ?", userId).Order("id").Find(&notifications)
+	if res.Error != nil {
+		slog.Error("exportAccount: Failed to get notifications", "userId", userId, "error", res.Error)
+		return errors.New("failed to get notifications")
+	}
+
+	enc := json.NewEncoder(w)
+	write := func(s string) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+	field := func(name string, v any) error {
+		if err := write(`"` + name + `":`); err != nil {
+			return err
+		}
+		return enc.Encode(v)
+	}
+
+	if err := write("{"); err != nil {
+		return err
+	}
+	if err := field("version", accountExportVersion); err != nil {
+		return err
+	}
+	me := AuthMeResponse{ID: user.ID, Username: user.Username, Type: user.Type, CreatedAt: user.CreatedAt, IsAdmin: user.Permissions&PERM_ADMIN != 0}
+	for _, f := range []struct {
+		name string
+		v    any
+	}{{"exportedAt", timeNow().UTC()}, {"user", me}, {"profile", profile}, {"settings", user.Settings}, {"subProfiles", subProfiles}} {
+		if err := write(","); err != nil {
+			return err
+		}
+		if err := field(f.name, f.v); err != nil {
+			return err
+		}
+	}
+
+	if err := write(`,"watched":[`); err != nil {
+		return err
+	}
+	first := true
+	var batch []Watched
+	res = db.Model(&Watched{}).Preload("Content").Preload("Activity").Where("user_id = ?", userId).
+		FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+			ids := []uint{}
+			for _, wt := range batch {
+				ids = append(ids, wt.ID)
+			}
+			episodes := []WatchedEpisode{}
+			if res := db.Where("watched_id IN ?", ids).Order("season_number, episode_number").Find(&episodes); res.Error != nil {
+				return res.Error
+			}
+			for _, wt := range batch {
+				item := ExportWatched{Watched: wt, SubProfileID: wt.SubProfileID, Episodes: []WatchedEpisode{}}
+				for _, ep := range episodes {
+					if ep.WatchedID == wt.ID {
+						item.Episodes = append(item.Episodes, ep)
+					}
+				}
+				if !first {
+					if err := write(","); err != nil {
+						return err
+					}
+				}
+				first = false
+				if err := enc.Encode(item); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if res.Error != nil {
+		slog.Error("exportAccount: Failed to export watched list", "userId", userId, "error", res.Error)
+		return errors.New("failed to export watched list")
+	}
+	if err := write("],"); err != nil {
+		return err
+	}
+	if err := field("notifications", notifications); err != nil {
+		return err
+	}
+	if err := write("}"); err != nil {
+		return err
+	}
+	slog.Info("Exported account", "userId", userId)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// If we should serve the frontend build ourselves (SERVE_FRONTEND=true),
+// instead of proxying to the node UI server.
+func isServingFrontend() bool {
+	return os.Getenv("SERVE_FRONTEND") == "true"
+}
+
+// Get frontend build dir from FRONTEND_DIR, defaulting to ./ui.
+func getFrontendDir() string {
+	if dir := os.Getenv("FRONTEND_DIR"); dir != "" {
+		return dir
+	}
+	return "./ui"
+}
+
+// Serve files from the frontend build dir. Any path that isn't
+// a file falls back to index.html, so the SPA can route it.
+// Used as the NoRoute handler, so api routes always take precedence.
+func serveFrontend(dir string) gin.HandlerFunc {
+	fs := http.Dir(dir)
+	fileServer := http.FileServer(fs)
+	index := filepath.Join(dir, "index.html")
+	return func(c *gin.Context) {
+		p := c.Request.URL.Path
+		if isAPIPath(p) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "not found"})
+			return
+		}
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Status(http.StatusMethodNotAllowed)
+			return
+		}
+		// Prerendered pages are built as `page.html`, so try that too.
+		for _, name := range []string{p, p + ".html"} {
+			if isFrontendFile(fs, name) {
+				c.Request.URL.Path = name
+				fileServer.ServeHTTP(c.Writer, c.Request)
+				return
+			}
+		}
+		c.File(index)
+	}
+}
+
+func isFrontendFile(fs http.FileSystem, name string) bool {
+	f, err := fs.Open(path.Clean("/" + name))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	return err == nil && !fi.IsDir()
+}
+
+// If path is under one of our api prefixes.
+func isAPIPath(p string) bool {
+	for _, prefix := range []string{getAPIPrefix(), legacyAPIPrefix} {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// What a watch goal counts.
+type GoalType string
+
+const (
+	// Movies finished.
+	GOAL_MOVIES GoalType = "movies"
+	// Show episodes watched.
+	GOAL_EPISODES GoalType = "episodes"
+	// Movies finished and episodes watched together.
+	GOAL_COMBINED GoalType = "combined"
+	// Shows finished.
+	GOAL_SHOWS GoalType = "shows"
+	// Hours spent watching movies finished and episodes watched.
+	GOAL_HOURS GoalType = "hours"
+)
+
+var (
+	ErrGoalNotFound = errors.New("goal not found")
+	ErrGoalExists   = errors.New("a goal of this type already exists for this year")
+	ErrGoalYearOver = errors.New("goals for years that are over can't be changed")
+)
+
+// A target number of movies and/or episodes to watch in a year.
+// Users can have one goal of each type per year, on each profile.
+type WatchGoal struct {
+	GormModel
+	UserID       uint     `json:"-" gorm:"uniqueIndex:usrprflyeartypeidx;not null"`
+	SubProfileID uint     `json:"-" gorm:"uniqueIndex:usrprflyeartypeidx;not null;default:0"`
+	Year         int      `json:"year" gorm:"uniqueIndex:usrprflyeartypeidx;not null"`
+	TargetCount  int      `json:"targetCount" gorm:"not null"`
+	Type         GoalType `json:"type" gorm:"uniqueIndex:usrprflyeartypeidx;not null"`
+}
+
+type WatchGoalRequest struct {
+	Year        int      `json:"year" binding:"required,min=1900,max=9999"`
+	TargetCount int      `json:"targetCount" binding:"required,min=1,max=100000"`
+	Type        GoalType `json:"type" binding:"required,oneof=movies episodes combined shows hours"`
+}
+
+type WatchGoalUpdateRequest struct {
+	Year        *int      `json:"year" binding:"omitempty,min=1900,max=9999"`
+	TargetCount *int      `json:"targetCount" binding:"omitempty,min=1,max=100000"`
+	Type        *GoalType `json:"type" binding:"omitempty,oneof=movies episodes combined shows hours"`
+}
+
+// Set a years goal of a type, adding it or changing its target.
+type WatchGoalSetRequest struct {
+	Year   int      `json:"year" binding:"required,min=1900,max=9999"`
+	Target int      `json:"target" binding:"required,min=1,max=100000"`
+	Type   GoalType `json:"type" binding:"required,oneof=movies episodes combined shows hours"`
+}
+
+type WatchGoalProgressQuery struct {
+	// Which of the years goals to get progress of, only needed when it has more than one.
+	Type GoalType `form:"type" binding:"omitempty,oneof=movies episodes combined shows hours"`
+}
+
+type WatchGoalProgress struct {
+	Year   int      `json:"year"`
+	Type   GoalType `json:"type"`
+	Target int      `json:"target"`
+	// Movies and/or episodes watched so far this year.
+	Current int `json:"current"`
+	// Of target reached, can go over 100.
+	Percentage float64 `json:"percentage"`
+	// If current pace reaches the target by the end of the year.
+	OnTrack bool `json:"onTrack"`
+	// What current will be at the end of the year, if the current pace keeps up.
+	ProjectedYearEnd int `json:"projectedYearEnd"`
+	// How far ahead (positive) or behind (negative) current
+	// is of where it needs to be by now to reach the target.
+	Pace int `json:"pace"`
+}
+
+func getWatchGoals(db *gorm.DB, userId uint, profileId uint) ([]WatchGoal, error) {
+	goals := []WatchGoal{}
+	res := db.Model(&WatchGoal{}).Where("user_id = ? AND sub_profile_id = ?", userId, profileId).Order("year DESC, type").Find(&goals)
+	if res.Error != nil {
+		slog.Error("getWatchGoals: Failed to get goals", "userId", userId, "error", res.Error)
+		return []WatchGoal{}, errors.New("failed to get goals")
+	}
+	return goals, nil
+}
+
+func addWatchGoal(db *gorm.DB, userId uint, profileId uint, ar WatchGoalRequest) (WatchGoal, error) {
+	if err := checkGoalYear(db, userId, ar.Year); err != nil {
+		return WatchGoal{}, err
+	}
+	goal := WatchGoal{UserID: userId, SubProfileID: profileId, Year: ar.Year, TargetCount: ar.TargetCount, Type: ar.Type}
+	if res := db.Create(&goal); res.Error != nil {
+		if isDuplicateErr(res.Error) {
+			return WatchGoal{}, ErrGoalExists
+		}
+		slog.Error("addWatchGoal: Failed to add goal", "userId", userId, "error", res.Error)
+		return WatchGoal{}, errors.New("failed to add goal")
+	}
+	return goal, nil
+}
+
+func updateWatchGoal(db *gorm.DB, userId uint, profileId uint, id uint, ur WatchGoalUpdateRequest) (WatchGoal, error) {
+	var goal WatchGoal
+	res := db.Model(&WatchGoal{}).Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Take(&goal)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return WatchGoal{}, ErrGoalNotFound
+		}
+		slog.Error("updateWatchGoal: Failed to get goal", "id", id, "error", res.Error)
+		return WatchGoal{}, errors.New("failed to get goal")
+	}
+	if err := checkGoalYear(db, userId, goal.Year); err != nil {
+		return WatchGoal{}, err
+	}
+	if ur.Year != nil {
+		if err := checkGoalYear(db, userId, *ur.Year); err != nil {
+			return WatchGoal{}, err
+		}
+		goal.Year = *ur.Year
+	}
+	if ur.TargetCount != nil {
+		goal.TargetCount = *ur.TargetCount
+	}
+	if ur.Type != nil {
+		goal.Type = *ur.Type
+	}
+	res = db.Model(&WatchGoal{}).Where("id = ?", id).Updates(map[string]interface{}{"year": goal.Year, "target_count": goal.TargetCount, "type": goal.Type})
+	if res.Error != nil {
+		if isDuplicateErr(res.Error) {
+			return WatchGoal{}, ErrGoalExists
+		}
+		slog.Error("updateWatchGoal: Failed to update goal", "id", id, "error", res.Error)
+		return WatchGoal{}, errors.New("failed to update goal")
+	}
+	return goal, nil
+}
+
+// Add a goal for a year and type, or change its target if there already is one.
+func setWatchGoal(db *gorm.DB, userId uint, profileId uint, sr WatchGoalSetRequest) (WatchGoal, error) {
+	if err := checkGoalYear(db, userId, sr.Year); err != nil {
+		return WatchGoal{}, err
+	}
+	var goal WatchGoal
+	res := db.Model(&WatchGoal{}).Where("user_id = ? AND sub_profile_id = ? AND year = ? AND type = ?", userId, profileId, sr.Year, sr.Type).Take(&goal)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return addWatchGoal(db, userId, profileId, WatchGoalRequest{Year: sr.Year, TargetCount: sr.Target, Type: sr.Type})
+		}
+		slog.Error("setWatchGoal: Failed to get goal", "userId", userId, "year", sr.Year, "error", res.Error)
+		return WatchGoal{}, errors.New("failed to get goal")
+	}
+	if res := db.Model(&WatchGoal{}).Where("id = ?", goal.ID).Update("target_count", sr.Target); res.Error != nil {
+		slog.Error("setWatchGoal: Failed to update goal", "id", goal.ID, "error", res.Error)
+		return WatchGoal{}, errors.New("failed to update goal")
+	}
+	goal.TargetCount = sr.Target
+	return goal, nil
+}
+
+// Goals can only be set for this year (in the users timezone) or later.
+func checkGoalYear(db *gorm.DB, userId uint, year int) error {
+	settings, err := getUserSettings(db, userId)
+	if err != nil {
+		return err
+	}
+	loc, err := getUserLocation(settings, "")
+	if err != nil {
+		return err
+	}
+	if year < timeNow().In(loc).Year() {
+		return ErrGoalYearOver
+	}
+	return nil
+}
+
+// Goals are removed for good, so one for the same year and type can be added again.
+func deleteWatchGoal(db *gorm.DB, userId uint, profileId uint, id uint) error {
+	res := db.Unscoped().Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Delete(&WatchGoal{})
+	if res.Error != nil {
+		slog.Error("deleteWatchGoal: Failed to delete goal", "id", id, "error", res.Error)
+		return errors.New("failed to delete goal")
+	}
+	if res.RowsAffected == 0 {
+		return ErrGoalNotFound
+	}
+	return nil
+}
+
+// Get progress towards a years goal, counted in the users timezone.
+// When goalType is empty, the year must only have one goal.
+func getWatchGoalProgress(db *gorm.DB, userId uint, profileId uint, year int, goalType GoalType) (WatchGoalProgress, error) {
+	q := db.Model(&WatchGoal{}).Where("user_id = ? AND sub_profile_id = ? AND year = ?", userId, profileId, year)
+	if goalType != "" {
+		q = q.Where("type = ?", goalType)
+	}
+	var goals []WatchGoal
+	if res := q.Find(&goals); res.Error != nil {
+		slog.Error("getWatchGoalProgress: Failed to get goal", "userId", userId, "year", year, "error", res.Error)
+		return WatchGoalProgress{}, errors.New("failed to get goal")
+	}
+	if len(goals) == 0 {
+		return WatchGoalProgress{}, ErrGoalNotFound
+	}
+	if len(goals) > 1 {
+		return WatchGoalProgress{}, errors.New("year has more than one goal, pass a type")
+	}
+	goal := goals[0]
+	settings, err := getUserSettings(db, userId)
+	if err != nil {
+		return WatchGoalProgress{}, err
+	}
+	loc, err := getUserLocation(settings, "")
+	if err != nil {
+		return WatchGoalProgress{}, err
+	}
+	current := 0
+	minutes := 0
+	if goal.Type == GOAL_MOVIES || goal.Type == GOAL_COMBINED || goal.Type == GOAL_HOURS {
+		movies, err := finishedIn(db, userId, profileId, MOVIE, year, loc)
+		if err != nil {
+			return WatchGoalProgress{}, err
+		}
+		current += len(movies)
+		for _, runtime := range movies {
+			minutes += int(runtime)
+		}
+	}
+	if goal.Type == GOAL_SHOWS {
+		shows, err := finishedIn(db, userId, profileId, SHOW, year, loc)
+		if err != nil {
+			return WatchGoalProgress{}, err
+		}
+		current += len(shows)
+	}
+	if goal.Type == GOAL_EPISODES || goal.Type == GOAL_COMBINED || goal.Type == GOAL_HOURS {
+		n, m, err := episodesWatchedIn(db, userId, profileId, year, loc)
+		if err != nil {
+			return WatchGoalProgress{}, err
+		}
+		current += n
+		minutes += m
+	}
+	if goal.Type == GOAL_HOURS {
+		current = minutes / 60
+	}
+	return goalProgress(goal, current, timeNow().In(loc)), nil
+}
+
+// Work out how a goal is going from current, as of now.
+func goalProgress(goal WatchGoal, current int, now time.Time) WatchGoalProgress {
+	p := WatchGoalProgress{
+		Year:       goal.Year,
+		Type:       goal.Type,
+		Target:     goal.TargetCount,
+		Current:    current,
+		Percentage: math.Round(float64(current)/float64(goal.TargetCount)*1000) / 10,
+	}
+	// How much of the year has gone, by day. Past years are over, future ones haven't started.
+	elapsed := 1.0
+	if now.Year() < goal.Year {
+		elapsed = 0
+	} else if now.Year() == goal.Year {
+		daysInYear := time.Date(goal.Year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		elapsed = float64(now.YearDay()) / float64(daysInYear)
+	}
+	p.Pace = current - int(math.Round(float64(goal.TargetCount)*elapsed))
+	if elapsed == 0 {
+		p.ProjectedYearEnd = current
+		p.OnTrack = true
+		return p
+	}
+	pace := float64(current) / elapsed
+	p.ProjectedYearEnd = int(math.Round(pace))
+	p.OnTrack = pace >= float64(goal.TargetCount)
+	return p
+}
+
+// Get movies or shows finished (or rewatched) in year, each only counts once.
+// Returned by watched id, with their content runtime in minutes.
+func finishedIn(db *gorm.DB, userId uint, profileId uint, contentType ContentType, year int, loc *time.Location) (map[uint]uint32, error) {
+	events, err := finishEvents(db, userId, profileId, contentType)
+	if err != nil {
+		return nil, err
+	}
+	// Dates can be stored with different offsets, so years are checked here, not in the query.
+	finished := map[uint]uint32{}
+	for _, e := range events {
+		if e.CreatedAt.In(loc).Year() == year {
+			finished[e.WatchedID] = e.Runtime
+		}
+	}
+	return finished, nil
+}
+
+// When a movie or show was finished (or rewatched), from its activity.
+type finishEvent struct {
+	WatchedID uint
+	Runtime   uint32
+	CreatedAt time.Time
+}
+
+// Get every time movies or shows were finished (or rewatched).
+func finishEvents(db *gorm.DB, userId uint, profileId uint, contentType ContentType) ([]finishEvent, error) {
+	var events []finishEvent
+	res := db.Model(&Activity{}).
+		Select("activities.watched_id, contents.runtime, activities.created_at").
+		Joins("JOIN watcheds ON watcheds.id = activities.watched_id AND watcheds.deleted_at IS NULL").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ? AND contents.type = ?", userId, profileId, contentType).
+		Where("(activities.type = ? AND activities.data = ?) OR (activities.type = ? AND activities.data LIKE ?) OR activities.type = ?",
+			STATUS_CHANGED, FINISHED, ADDED_WATCHED, `%"status":"`+string(FINISHED)+`"%`, REWATCHED).
+		Scan(&events)
+	if res.Error != nil {
+		slog.Error("finishEvents: Failed to get activity", "userId", userId, "type", contentType, "error", res.Error)
+		return nil, errors.New("failed to count " + string(contentType) + " watched")
+	}
+	return events, nil
+}
+
+// Count episodes watched in year, and minutes spent watching them (from the episodes
+// runtime, or the shows average). Ones without a watched date count from when they were marked.
+func episodesWatchedIn(db *gorm.DB, userId uint, profileId uint, year int, loc *time.Location) (int, int, error) {
+	var rows []struct {
+		Runtime     uint32
+		WatchedDate *time.Time
+		CreatedAt   time.Time
+	}
+	res := db.Model(&WatchedEpisode{}).
+		Select("CASE WHEN watched_episodes.runtime > 0 THEN watched_episodes.runtime ELSE contents.runtime END AS runtime, watched_episodes.watched_date, watched_episodes.created_at").
+		Joins("JOIN watcheds ON watcheds.id = watched_episodes.watched_id AND watcheds.deleted_at IS NULL").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId).
+		Scan(&rows)
+	if res.Error != nil {
+		slog.Error("episodesWatchedIn: Failed to get episodes", "userId", userId, "error", res.Error)
+		return 0, 0, errors.New("failed to count episodes watched")
+	}
+	count, minutes := 0, 0
+	for _, r := range rows {
+		d := r.CreatedAt
+		if r.WatchedDate != nil {
+			d = *r.WatchedDate
+		}
+		if d.In(loc).Year() == year {
+			count++
+			minutes += int(r.Runtime)
+		}
+	}
+	return count, minutes, nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Default base url TMDB images are downloaded from.
+const defaultTMDBImageBase = "https://image.tmdb.org/t/p/"
+
+// Get base url of TMDB images from TMDB_IMAGE_BASE (eg. a mirror
+// or caching proxy), defaulting to TMDBs own CDN.
+func getTMDBImageBase() string {
+	base := os.Getenv("TMDB_IMAGE_BASE")
+	if base == "" {
+		return defaultTMDBImageBase
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base
+}
+
+// Url of a TMDB image at size (eg. w500).
+func tmdbImageURL(size string, path string) string {
+	return getTMDBImageBase() + size + path
+}
+
+type imageDownload struct {
+	url  string
+	outf string
+	done chan error
+}
+
+type PosterRepairResponse struct {
+	// Content rows with a poster that were checked.
+	Checked int `json:"checked"`
+	// Posters that were missing from disk.
+	Missing int `json:"missing"`
+	// Missing posters that were downloaded again.
+	Repaired int `json:"repaired"`
+	// Missing posters that failed to download.
+	Failed int `json:"failed"`
+}
+
+type ContentImagesRedownloadResponse struct {
+	// If the poster was downloaded again, false when content has no poster.
+	PosterDownloaded bool `json:"posterDownloaded"`
+	// If cached episode stills were removed (only for shows).
+	StillsCleared bool `json:"stillsCleared"`
+}
+
+// Every image download (except adding content) goes through
+// this queue, so we never hammer TMDB with requests.
+var imageQueue = make(chan imageDownload, 1000)
+
+// How long to wait between each queued download.
+const imageDownloadInterval = 200 * time.Millisecond
+
+// Process the image download queue, should only be ran once.
+func startImageDownloader() {
+	ticker := time.NewTicker(imageDownloadInterval)
+	defer ticker.Stop()
+	for d := range imageQueue {
+		<-ticker.C
+		d.done <- download(d.url, d.outf)
+	}
+}
+
+// Queue an image download, the returned channel will
+// receive the result once it has been processed.
+func queueImageDownload(url string, outf string) <-chan error {
+	done := make(chan error, 1)
+	imageQueue <- imageDownload{url: url, outf: outf, done: done}
+	return done
+}
+
+// Local path we store a poster at.
+func posterFilePath(posterPath string) string {
+	return dataPath(imgDir, posterPath)
+}
+
+// If a poster is on disk and not empty, failed downloads can leave an empty file behind.
+func posterOnDisk(posterPath string) bool {
+	fi, err := os.Stat(posterFilePath(posterPath))
+	return err == nil && fi.Size() > 0
+}
+
+// Download new poster for content and swap it in, the old poster
+// file is only removed once the new one is verified on disk.
+// If the new poster fails to download, the content is left untouched.
+func replaceContentPoster(db *gorm.DB, content *Content, newPosterPath string) error {
+	oldPosterPath := content.PosterPath
+	posterSize := getPosterSize()
+	err := <-queueImageDownload(contentPosterURL(content.Provider, posterSize, newPosterPath), posterFilePath(newPosterPath))
+	if err != nil {
+		slog.Error("replaceContentPoster: Failed to download new poster", "content_id", content.ID, "error", err)
+		return err
+	}
+	if !posterOnDisk(newPosterPath) {
+		return errors.New("new poster not found on disk after download")
+	}
+	res := db.Model(&Content{}).Where("id = ?", content.ID).Updates(map[string]interface{}{"poster_path": newPosterPath, "poster_size": posterSize})
+	if res.Error != nil {
+		slog.Error("replaceContentPoster: Failed to update content", "content_id", content.ID, "error", res.Error)
+		return res.Error
+	}
+	content.PosterPath = newPosterPath
+	content.PosterSize = posterSize
+	content.HasPoster = true
+	if oldPosterPath != "" && oldPosterPath != newPosterPath {
+		err = os.Remove(posterFilePath(oldPosterPath))
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("replaceContentPoster: Failed to remove old poster", "path", oldPosterPath, "error", err)
+		}
+	}
+	slog.Info("Replaced content poster", "content_id", content.ID, "old", oldPosterPath, "new", newPosterPath)
+	return nil
+}
+
+// Queue a job repairing missing posters (see repairPosters), for adminId.
+// When a repair is already queued or running, its job is returned instead.
+func queuePosterRepair(db *gorm.DB, adminId uint) (Job, error) {
+	var job Job
+	res := db.Model(&Job{}).Where("type = ? AND status IN ?", JOB_POSTER_REPAIR, []JobStatus{JOB_QUEUED, JOB_RUNNING}).Limit(1).Find(&job)
+	if res.Error != nil {
+		slog.Error("queuePosterRepair: Failed to check for a running repair", "error", res.Error)
+		return Job{}, errors.New("failed to queue poster repair")
+	}
+	if res.RowsAffected > 0 {
+		return job, nil
+	}
+	return enqueueJob(db, adminId, JOB_POSTER_REPAIR, struct{}{})
+}
+
+func runPosterRepairJob(jc *JobContext) (any, error) {
+	return repairPosters(jc.db, jc.setProgress)
+}
+
+// Re-download the poster for all content where it is missing from disk (or empty).
+// Downloads go through the queue, so at most 5 a second, progress is reported as they finish.
+func repairPosters(db *gorm.DB, progress func(done int, total int)) (PosterRepairResponse, error) {
+	var content []Content
+	res := db.Model(&Content{}).Where("poster_path != ''").Find(&content)
+	if res.Error != nil {
+		slog.Error("repairPosters: Failed to get content", "error", res.Error)
+		return PosterRepairResponse{}, errors.New("failed to get content")
+	}
+	resp := PosterRepairResponse{}
+	pending := []<-chan error{}
+	for _, c := range content {
+		resp.Checked++
+		if posterOnDisk(c.PosterPath) {
+			continue
+		}
+		resp.Missing++
+		pending = append(pending, queueImageDownload(contentPosterURL(c.Provider, getPosterSize(), c.PosterPath), posterFilePath(c.PosterPath)))
+	}
+	progress(0, len(pending))
+	for i, p := range pending {
+		if err := <-p; err != nil {
+			resp.Failed++
+		} else {
+			resp.Repaired++
+		}
+		progress(i+1, len(pending))
+	}
+	slog.Info("Repaired posters", "summary", resp)
+	return resp, nil
+}
+
+// Delete a contents cached images and download its poster again, for when they
+// are corrupt. Episode stills aren't downloaded again until the season is next viewed.
+func redownloadContentImages(db *gorm.DB, id int) (ContentImagesRedownloadResponse, error) {
+	var content Content
+	if res := db.Model(&Content{}).Omit("cached_detail").Where("id = ?", id).Take(&content); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ContentImagesRedownloadResponse{}, ErrContentNotFound
+		}
+		slog.Error("redownloadContentImages: Failed to get content", "id", id, "error", res.Error)
+		return ContentImagesRedownloadResponse{}, errors.New("failed to get content")
+	}
+	resp := ContentImagesRedownloadResponse{}
+	if content.Type == SHOW && content.Provider == PROVIDER_TMDB {
+		if err := os.RemoveAll(dataPath(imgDir, "stills", strconv.Itoa(content.TmdbID))); err != nil {
+			slog.Error("redownloadContentImages: Failed to remove stills", "tmdb_id", content.TmdbID, "error", err)
+			return ContentImagesRedownloadResponse{}, errors.New("failed to remove episode stills")
+		}
+		resp.StillsCleared = true
+	}
+	if content.PosterPath == "" {
+		return resp, nil
+	}
+	if err := os.Remove(posterFilePath(content.PosterPath)); err != nil && !os.IsNotExist(err) {
+		slog.Error("redownloadContentImages: Failed to remove poster", "path", content.PosterPath, "error", err)
+		return ContentImagesRedownloadResponse{}, errors.New("failed to remove poster")
+	}
+	posterSize := getPosterSize()
+	if err := <-queueImageDownload(contentPosterURL(content.Provider, posterSize, content.PosterPath), posterFilePath(content.PosterPath)); err != nil {
+		slog.Error("redownloadContentImages: Failed to download poster", "content_id", content.ID, "error", err)
+		return ContentImagesRedownloadResponse{}, errors.New("failed to download poster")
+	}
+	if res := db.Model(&Content{}).Where("id = ?", content.ID).Update("poster_size", posterSize); res.Error != nil {
+		slog.Error("redownloadContentImages: Failed to update poster size", "content_id", content.ID, "error", res.Error)
+	}
+	resp.PosterDownloaded = true
+	slog.Info("Downloaded content images again", "content_id", content.ID, "summary", resp)
+	return resp, nil
+}
+
+// Size we download episode stills at.
+const stillSize = "w300"
+
+// Limits how many seasons can have their stills cached at once,
+// each seasons downloads still go through the image queue.
+var stillCacheSlots = make(chan struct{}, 2)
+
+// Local path we store an episode still at. Stills are kept in
+// a directory per show, so they are easy to clean up together.
+func stillFilePath(tmdbId int, stillPath string) string {
+	return dataPath(imgDir, "stills", strconv.Itoa(tmdbId), stillPath)
+}
+
+// Seasons (`tvId/seasonNumber`) having their stills cached right now.
+var stillsCaching sync.Map
+
+// Cache stills for a season in the background, unless they are all on disk
+// already or the season is already being cached (eg. by another request).
+func queueEpisodeStills(db *gorm.DB, userId uint, tvId string, seasonNumber int, season TMDBSeasonDetails) {
+	tmdbId, err := strconv.Atoi(tvId)
+	if err != nil {
+		return
+	}
+	missing := false
+	for _, ep := range season.Episodes {
+		if ep.StillPath != "" && !fileExists(stillFilePath(tmdbId, ep.StillPath)) {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return
+	}
+	key := tvId + "/" + strconv.Itoa(seasonNumber)
+	if _, caching := stillsCaching.LoadOrStore(key, true); caching {
+		return
+	}
+	go func() {
+		defer stillsCaching.Delete(key)
+		cacheEpisodeStills(db, userId, tvId, season)
+	}()
+}
+
+// Download stills for all episodes in a season, if the show is
+// on the users watched list. Episodes without a still are skipped.
+func cacheEpisodeStills(db *gorm.DB, userId uint, tvId string, season TMDBSeasonDetails) {
+	tmdbId, err := strconv.Atoi(tvId)
+	if err != nil {
+		return
+	}
+	var count int64
+	db.Model(&Watched{}).
+		Where("user_id = ?", userId).
+		Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("tmdb_id = ? AND type = ?", tmdbId, SHOW)).
+		Count(&count)
+	if count == 0 {
+		return
+	}
+	stillCacheSlots <- struct{}{}
+	defer func() { <-stillCacheSlots }()
+	pending := []<-chan error{}
+	for _, ep := range season.Episodes {
+		if ep.StillPath == "" || fileExists(stillFilePath(tmdbId, ep.StillPath)) {
+			continue
+		}
+		pending = append(pending, queueImageDownload(tmdbImageURL(stillSize, ep.StillPath), stillFilePath(tmdbId, ep.StillPath)))
+	}
+	failed := 0
+	for _, p := range pending {
+		if err := <-p; err != nil {
+			failed++
+		}
+	}
+	if len(pending) > 0 {
+		slog.Debug("Cached episode stills", "tmdb_id", tmdbId, "downloaded", len(pending)-failed, "failed", failed)
+	}
+}
+
+// Remove cached stills for a show, once it is no longer on anyones watched list.
+func removeEpisodeStills(db *gorm.DB, contentId int) {
+	var content Content
+	res := db.Model(&Content{}).Where("id = ?", contentId).Take(&content)
+	if res.Error != nil || content.Type != SHOW {
+		return
+	}
+	var count int64
+	db.Model(&Watched{}).Where("content_id = ?", contentId).Count(&count)
+	if count > 0 {
+		return
+	}
+	err := os.RemoveAll(dataPath(imgDir, "stills", strconv.Itoa(content.TmdbID)))
+	if err != nil {
+		slog.Warn("removeEpisodeStills: Failed to remove stills", "tmdb_id", content.TmdbID, "error", err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// What an import does when a row matches an existing watched list item.
+type ImportConflictStrategy string
+
+const (
+	// Leave the existing item as it is.
+	IMPORT_CONFLICT_SKIP ImportConflictStrategy = "skip"
+	// Replace the existing items status and rating with the rows.
+	IMPORT_CONFLICT_OVERWRITE ImportConflictStrategy = "overwrite"
+	// Keep the existing item, but take the rows rating if it is higher.
+	IMPORT_CONFLICT_MERGE_HIGHER_RATING ImportConflictStrategy = "merge-keep-higher-rating"
+)
+
+// What was (or would be, in a dry run) done with an import row.
+type ImportAction string
+
+const (
+	IMPORT_ACTION_ADD       ImportAction = "add"
+	IMPORT_ACTION_SKIP      ImportAction = "skip"
+	IMPORT_ACTION_OVERWRITE ImportAction = "overwrite"
+	IMPORT_ACTION_MERGE     ImportAction = "merge"
+	IMPORT_ACTION_RESTORE   ImportAction = "restore"
+	IMPORT_ACTION_ERROR     ImportAction = "error"
+)
+
+type ImportRow struct {
+	ContentID   int           `json:"contentId" binding:"required"`
+	ContentType ContentType   `json:"contentType" binding:"required,oneof=movie tv"`
+	Status      WatchedStatus `json:"status" binding:"omitempty,oneof=FINISHED WATCHING PLANNED ONHOLD DROPPED"`
+	Rating      int8          `json:"rating" binding:"max=10"`
+	// When the item was watched, only used when it is added.
+	WatchedDate *time.Time `json:"watchedDate"`
+}
+
+type ImportRequest struct {
+	ConflictStrategy ImportConflictStrategy `json:"conflictStrategy" binding:"omitempty,oneof=skip overwrite merge-keep-higher-rating"`
+	Rows             []ImportRow            `json:"rows" binding:"required,min=1,dive"`
+}
+
+type ImportQuery struct {
+	// Only report what would happen, nothing is written.
+	DryRun bool `form:"dryRun"`
+}
+
+// Report for a single row, shared by all importers.
+type ImportRowResult struct {
+	Input          ImportRow    `json:"input"`
+	MatchedContent *Content     `json:"matchedContent"`
+	Action         ImportAction `json:"action"`
+	Reason         string       `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun bool              `json:"dryRun"`
+	Rows   []ImportRowResult `json:"rows"`
+}
+
+// Import rows into a users watched list. In a dry run, every row is matched
+// and the action that would be taken is reported, but nothing is written.
+func importWatched(db *gorm.DB, userId uint, profileId uint, ir ImportRequest, dryRun bool) ImportReport {
+	if ir.ConflictStrategy == "" {
+		ir.ConflictStrategy = IMPORT_CONFLICT_SKIP
+	}
+	report := ImportReport{DryRun: dryRun, Rows: []ImportRowResult{}}
+	for _, row := range ir.Rows {
+		report.Rows = append(report.Rows, importRow(db, userId, profileId, row, ir.ConflictStrategy, SOURCE_JSON_IMPORT, dryRun))
+	}
+	slog.Info("Imported watched list", "userId", userId, "profileId", profileId, "rows", len(ir.Rows), "dryRun", dryRun)
+	return report
+}
+
+// Import a single row, items that are added are marked as coming from source.
+func importRow(db *gorm.DB, userId uint, profileId uint, row ImportRow, strategy ImportConflictStrategy, source WatchedSource, dryRun bool) ImportRowResult {
+	result := ImportRowResult{Input: row}
+	var content Content
+	res := db.Where("tmdb_id = ? AND type = ?", row.ContentID, row.ContentType).Limit(1).Find(&content)
+	if res.Error != nil {
+		result.Action = IMPORT_ACTION_ERROR
+		result.Reason = "failed to look up content"
+		return result
+	}
+	if content.ID == 0 {
+		// Not cached yet, so can't already be on the list.
+		fetched, err := fetchContent(row.ContentType, row.ContentID)
+		if err != nil {
+			result.Action = IMPORT_ACTION_ERROR
+			result.Reason = err.Error()
+			return result
+		}
+		result.MatchedContent = &fetched
+		return importAdd(db, userId, profileId, row, result, source, dryRun)
+	}
+	result.MatchedContent = &content
+
+	var existing Watched
+	res = db.Where("user_id = ? AND sub_profile_id = ? AND content_id = ?", userId, profileId, content.ID).Limit(1).Find(&existing)
+	if res.Error != nil {
+		result.Action = IMPORT_ACTION_ERROR
+		result.Reason = "failed to look up existing watched entry"
+		return result
+	}
+	if existing.ID == 0 {
+		return importAdd(db, userId, profileId, row, result, source, dryRun)
+	}
+
+	ur := WatchedUpdateRequest{}
+	switch strategy {
+	case IMPORT_CONFLICT_OVERWRITE:
+		result.Action = IMPORT_ACTION_OVERWRITE
+		result.Reason = "already on watched list, overwriting"
+		ur.Status = row.Status
+		ur.Rating = row.Rating
+	case IMPORT_CONFLICT_MERGE_HIGHER_RATING:
+		result.Action = IMPORT_ACTION_MERGE
+		result.Reason = "already on watched list, keeping higher rating"
+		if row.Rating > existing.Rating {
+			ur.Rating = row.Rating
+		}
+	default:
+		result.Action = IMPORT_ACTION_SKIP
+		result.Reason = "already on watched list"
+		return result
+	}
+	if (ur.Status == "" || ur.Status == existing.Status) && (ur.Rating == 0 || ur.Rating == existing.Rating) {
+		result.Action = IMPORT_ACTION_SKIP
+		result.Reason = "already on watched list, nothing to change"
+		return result
+	}
+	if dryRun {
+		return result
+	}
+	if _, err := updateWatched(db, userId, profileId, existing.ID, ur); err != nil {
+		result.Action = IMPORT_ACTION_ERROR
+		result.Reason = err.Error()
+	}
+	return result
+}
+
+func importAdd(db *gorm.DB, userId uint, profileId uint, row ImportRow, result ImportRowResult, source WatchedSource, dryRun bool) ImportRowResult {
+	result.Action = IMPORT_ACTION_ADD
+	// Checked before dry runs return, so they report it too.
+	if row.WatchedDate != nil && row.WatchedDate.After(timeNow()) && row.Status != PLANNED {
+		result.Action = IMPORT_ACTION_ERROR
+		result.Reason = ErrWatchedDateInFuture.Error()
+		return result
+	}
+	if err := validateRating(row.Rating); err != nil {
+		result.Action = IMPORT_ACTION_ERROR
+		result.Reason = err.Error()
+		return result
+	}
+	if dryRun {
+		return result
+	}
+	w, err := addWatched(db, userId, profileId, WatchedAddRequest{ContentID: row.ContentID, ContentType: row.ContentType, Status: row.Status, Rating: row.Rating, WatchedDate: row.WatchedDate}, source)
+	if err != nil {
+		result.Action = IMPORT_ACTION_ERROR
+		result.Reason = err.Error()
+		return result
+	}
+	result.MatchedContent = &w.Content
+	return result
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Fight Club is already on the list as FINISHED and rated 6 in every fixture,
+// The Matrix isn't on it and 404 doesn't exist on TMDB.
+var importConflictFixtures = []struct {
+	strategy   ImportConflictStrategy
+	rows       string
+	wantAction []ImportAction
+	// Fight Clubs status and rating after the import.
+	wantStatus WatchedStatus
+	wantRating int8
+}{
+	{"", `[{"contentId":550,"contentType":"movie","status":"WATCHING","rating":8}]`, []ImportAction{IMPORT_ACTION_SKIP}, FINISHED, 6},
+	{IMPORT_CONFLICT_SKIP, `[{"contentId":550,"contentType":"movie","status":"WATCHING","rating":8}]`, []ImportAction{IMPORT_ACTION_SKIP}, FINISHED, 6},
+	{IMPORT_CONFLICT_OVERWRITE, `[{"contentId":550,"contentType":"movie","status":"WATCHING","rating":8}]`, []ImportAction{IMPORT_ACTION_OVERWRITE}, WATCHING, 8},
+	{IMPORT_CONFLICT_OVERWRITE, `[{"contentId":550,"contentType":"movie","status":"FINISHED","rating":6}]`, []ImportAction{IMPORT_ACTION_SKIP}, FINISHED, 6},
+	{IMPORT_CONFLICT_MERGE_HIGHER_RATING, `[{"contentId":550,"contentType":"movie","status":"WATCHING","rating":8}]`, []ImportAction{IMPORT_ACTION_MERGE}, FINISHED, 8},
+	{IMPORT_CONFLICT_MERGE_HIGHER_RATING, `[{"contentId":550,"contentType":"movie","status":"WATCHING","rating":4}]`, []ImportAction{IMPORT_ACTION_SKIP}, FINISHED, 6},
+	// Rows that don't conflict are handled the same by every strategy.
+	{IMPORT_CONFLICT_OVERWRITE, `[{"contentId":603,"contentType":"movie","status":"PLANNED"},{"contentId":404,"contentType":"movie"}]`, []ImportAction{IMPORT_ACTION_ADD, IMPORT_ACTION_ERROR}, FINISHED, 6},
+	{IMPORT_CONFLICT_MERGE_HIGHER_RATING, `[{"contentId":603,"contentType":"movie","status":"PLANNED"},{"contentId":404,"contentType":"movie"}]`, []ImportAction{IMPORT_ACTION_ADD, IMPORT_ACTION_ERROR}, FINISHED, 6},
+}
+
+// Start a test server with alice, who has Fight Club on her list.
+func newImportTestServer(t *testing.T) (*testServer, string) {
+	t.Helper()
+	s := newTestServer(t)
+	token := s.register("alice")
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie","status":"FINISHED","rating":6}`, http.StatusOK, nil)
+	return s, token
+}
+
+func importBody(strategy ImportConflictStrategy, rows string) string {
+	return `{"conflictStrategy":"` + string(strategy) + `","rows":` + rows + `}`
+}
+
+func reportActions(r ImportReport) []ImportAction {
+	actions := []ImportAction{}
+	for _, row := range r.Rows {
+		actions = append(actions, row.Action)
+	}
+	return actions
+}
+
+func TestImportConflictStrategies(t *testing.T) {
+	for _, f := range importConflictFixtures {
+		t.Run(string(f.strategy)+" "+f.rows, func(t *testing.T) {
+			s, token := newImportTestServer(t)
+			var report ImportReport
+			s.expect("POST", "/import", token, importBody(f.strategy, f.rows), http.StatusOK, &report)
+			if got := reportActions(report); !reflect.DeepEqual(got, f.wantAction) {
+				t.Errorf("got actions %v, want %v (report: %+v)", got, f.wantAction, report)
+			}
+			var list []Watched
+			s.expect("GET", "/watched", token, "", http.StatusOK, &list)
+			added := 0
+			for _, w := range list {
+				switch w.Content.TmdbID {
+				case 550:
+					if w.Status != f.wantStatus || w.Rating != f.wantRating {
+						t.Errorf("got Fight Club %s rated %d, want %s rated %d", w.Status, w.Rating, f.wantStatus, f.wantRating)
+					}
+				default:
+					added++
+				}
+			}
+			wantAdded := 0
+			for _, a := range f.wantAction {
+				if a == IMPORT_ACTION_ADD {
+					wantAdded++
+				}
+			}
+			if added != wantAdded {
+				t.Errorf("got %d items added, want %d", added, wantAdded)
+			}
+		})
+	}
+}
+
+// Get every row of the tables an import can write to.
+func importSnapshot(t *testing.T, s *testServer) string {
+	t.Helper()
+	snap := map[string][]map[string]any{}
+	for _, table := range []string{"watcheds", "activities", "contents"} {
+		rows := []map[string]any{}
+		if err := s.db.Table(table).Order("id").Find(&rows).Error; err != nil {
+			t.Fatalf("failed to read %s: %v", table, err)
+		}
+		snap[table] = rows
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatalf("failed to encode snapshot: %v", err)
+	}
+	return string(b)
+}
+
+func TestImportDryRunWritesNothing(t *testing.T) {
+	for _, f := range importConflictFixtures {
+		t.Run(string(f.strategy)+" "+f.rows, func(t *testing.T) {
+			s, token := newImportTestServer(t)
+			before := importSnapshot(t, s)
+			var report ImportReport
+			s.expect("POST", "/import?dryRun=true", token, importBody(f.strategy, f.rows), http.StatusOK, &report)
+			if !report.DryRun {
+				t.Error("report isn't marked as a dry run")
+			}
+			// Reports the same as a real import would.
+			if got := reportActions(report); !reflect.DeepEqual(got, f.wantAction) {
+				t.Errorf("got actions %v, want %v", got, f.wantAction)
+			}
+			if after := importSnapshot(t, s); after != before {
+				t.Errorf("dry run wrote to the database\nbefore: %s\nafter:  %s", before, after)
+			}
+		})
+	}
+}
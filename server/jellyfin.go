@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrJellyfinNotEnabled     = errors.New("jellyfin login not enabled")
+	ErrJellyfinServerNotFound = errors.New("jellyfin server not found")
+	ErrJellyfinServerInUse    = errors.New("jellyfin server still has users, they must be deleted first")
+	ErrJellyfinServerExists   = errors.New("a jellyfin server with this name already exists")
+)
+
+// A Jellyfin server users can login with.
+type JellyfinServer struct {
+	GormModel
+	Name string `json:"name" gorm:"uniqueIndex;not null"`
+	// Base url of the server, eg. https://my.jellyfin.example
+	Host string `json:"host" gorm:"not null"`
+}
+
+type JellyfinServerRequest struct {
+	Name string `json:"name" binding:"required,max=50"`
+	Host string `json:"host" binding:"required"`
+}
+
+// Only fields that are set will be updated.
+type JellyfinServerUpdateRequest struct {
+	Name *string `json:"name" binding:"omitempty,min=1,max=50"`
+	Host *string `json:"host"`
+}
+
+type JellyfinLoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	// Server to login with, the first one configured if not set.
+	ServerID uint `json:"serverId"`
+}
+
+// What /auth/available lists about a server, its host isn't needed to login.
+type JellyfinServerInfo struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type AvailableAuthResponse struct {
+	// Auth providers other than Watcharr that can be used (eg. jellyfin).
+	Providers       []string             `json:"providers"`
+	JellyfinServers []JellyfinServerInfo `json:"jellyfinServers"`
+}
+
+// List the auth providers that are available and the jellyfin servers users can pick from.
+func getAvailableAuthProviders(db *gorm.DB) (AvailableAuthResponse, error) {
+	resp := AvailableAuthResponse{Providers: []string{}, JellyfinServers: []JellyfinServerInfo{}}
+	res := db.Model(&JellyfinServer{}).Select("id", "name").Order("id").Find(&resp.JellyfinServers)
+	if res.Error != nil {
+		slog.Error("getAvailableAuthProviders: Failed to get jellyfin servers", "error", res.Error)
+		return AvailableAuthResponse{}, errors.New("failed to get auth providers")
+	}
+	if len(resp.JellyfinServers) > 0 {
+		resp.Providers = append(resp.Providers, "jellyfin")
+	}
+	return resp, nil
+}
+
+// Get the jellyfin server to login with, the first one if id is 0.
+func getJellyfinServer(db *gorm.DB, id uint) (JellyfinServer, error) {
+	var server JellyfinServer
+	q := db.Order("id")
+	if id != 0 {
+		q = q.Where("id = ?", id)
+	}
+	if res := q.Take(&server); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			if id == 0 {
+				return JellyfinServer{}, ErrJellyfinNotEnabled
+			}
+			return JellyfinServer{}, ErrJellyfinServerNotFound
+		}
+		slog.Error("getJellyfinServer: Failed to get server", "id", id, "error", res.Error)
+		return JellyfinServer{}, errors.New("failed to get jellyfin server")
+	}
+	return server, nil
+}
+
+func getJellyfinServers(db *gorm.DB) ([]JellyfinServer, error) {
+	servers := []JellyfinServer{}
+	if res := db.Order("id").Find(&servers); res.Error != nil {
+		slog.Error("getJellyfinServers: Failed to get servers", "error", res.Error)
+		return nil, errors.New("failed to get jellyfin servers")
+	}
+	return servers, nil
+}
+
+func addJellyfinServer(db *gorm.DB, ar JellyfinServerRequest) (JellyfinServer, error) {
+	host, err := normalizeJellyfinHost(ar.Host)
+	if err != nil {
+		return JellyfinServer{}, err
+	}
+	server := JellyfinServer{Name: strings.TrimSpace(ar.Name), Host: host}
+	if server.Name == "" {
+		return JellyfinServer{}, errors.New("name must not be empty")
+	}
+	if err := ensureJellyfinServerNameFree(db, server.Name, 0); err != nil {
+		return JellyfinServer{}, err
+	}
+	if res := db.Create(&server); res.Error != nil {
+		slog.Error("addJellyfinServer: Failed to create server", "error", res.Error)
+		return JellyfinServer{}, errors.New("failed to add jellyfin server")
+	}
+	slog.Info("Jellyfin server added", "id", server.ID, "name", server.Name, "host", server.Host)
+	return server, nil
+}
+
+func updateJellyfinServer(db *gorm.DB, id uint, ur JellyfinServerUpdateRequest) (JellyfinServer, error) {
+	server, err := getJellyfinServer(db, id)
+	if err != nil {
+		return JellyfinServer{}, err
+	}
+	if ur.Name != nil {
+		name := strings.TrimSpace(*ur.Name)
+		if name == "" {
+			return JellyfinServer{}, errors.New("name must not be empty")
+		}
+		if err := ensureJellyfinServerNameFree(db, name, id); err != nil {
+			return JellyfinServer{}, err
+		}
+		server.Name = name
+	}
+	if ur.Host != nil {
+		host, err := normalizeJellyfinHost(*ur.Host)
+		if err != nil {
+			return JellyfinServer{}, err
+		}
+		server.Host = host
+	}
+	res := db.Model(&JellyfinServer{}).Where("id = ?", id).Updates(map[string]interface{}{"name": server.Name, "host": server.Host})
+	if res.Error != nil {
+		slog.Error("updateJellyfinServer: Failed to update server", "id", id, "error", res.Error)
+		return JellyfinServer{}, errors.New("failed to update jellyfin server")
+	}
+	return server, nil
+}
+
+// Delete a jellyfin server, only allowed once no users are from it,
+// otherwise they would be left unable to login.
+func deleteJellyfinServer(db *gorm.DB, id uint) error {
+	if _, err := getJellyfinServer(db, id); err != nil {
+		return err
+	}
+	var users int64
+	if res := db.Model(&User{}).Where("jellyfin_server_id = ?", id).Count(&users); res.Error != nil {
+		slog.Error("deleteJellyfinServer: Failed to count users", "id", id, "error", res.Error)
+		return errors.New("failed to delete jellyfin server")
+	}
+	if users > 0 {
+		return ErrJellyfinServerInUse
+	}
+	// Unscoped, so the name can be used again.
+	if res := db.Unscoped().Delete(&JellyfinServer{}, id); res.Error != nil {
+		slog.Error("deleteJellyfinServer: Failed to delete server", "id", id, "error", res.Error)
+		return errors.New("failed to delete jellyfin server")
+	}
+	slog.Info("Jellyfin server deleted", "id", id)
+	return nil
+}
+
+func ensureJellyfinServerNameFree(db *gorm.DB, name string, exceptId uint) error {
+	var count int64
+	if res := db.Model(&JellyfinServer{}).Where("name = ? AND id != ?", name, exceptId).Count(&count); res.Error != nil {
+		slog.Error("Failed to check if jellyfin server name is taken", "error", res.Error)
+		return errors.New("failed to check server name")
+	}
+	if count > 0 {
+		return ErrJellyfinServerExists
+	}
+	return nil
+}
+
+// Check host is a http(s) url and remove any trailing slash,
+// so api paths can be appended to it.
+func normalizeJellyfinHost(host string) (string, error) {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	u, err := url.Parse(host)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("host must be a http(s) url (eg. https://my.jellyfin.example)")
+	}
+	return host, nil
+}
+
+// Installs from before multiple servers were supported configured their one
+// server with JELLYFIN_HOST. Add it as the first server if there are none yet,
+// and move its existing users onto it.
+func migrateJellyfinHost(db *gorm.DB) {
+	host := os.Getenv("JELLYFIN_HOST")
+	if host == "" {
+		return
+	}
+	var count int64
+	if res := db.Model(&JellyfinServer{}).Count(&count); res.Error != nil {
+		slog.Error("migrateJellyfinHost: Failed to count servers", "error", res.Error)
+		return
+	}
+	if count > 0 {
+		return
+	}
+	host, err := normalizeJellyfinHost(host)
+	if err != nil {
+		slog.Error("JELLYFIN_HOST env var is invalid, not adding it as a jellyfin server", "error", err)
+		return
+	}
+	server := JellyfinServer{Name: "Jellyfin", Host: host}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(&server); res.Error != nil {
+			return res.Error
+		}
+		return tx.Model(&User{}).
+			Where("type = ? AND jellyfin_server_id = 0", JELLYFIN_USER).
+			Update("jellyfin_server_id", server.ID).Error
+	})
+	if err != nil {
+		slog.Error("migrateJellyfinHost: Failed to add server from JELLYFIN_HOST", "error", err)
+		return
+	}
+	slog.Info("Added jellyfin server from JELLYFIN_HOST, it can now be managed from the admin api", "host", host)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kind of work a job does, each has a handler registered in jobHandlers.
+type JobType string
+
+const (
+	JOB_SIMPLE_CSV_IMPORT JobType = "simple_csv_import"
+	JOB_POSTER_REPAIR     JobType = "poster_repair"
+)
+
+type JobStatus string
+
+const (
+	JOB_QUEUED  JobStatus = "queued"
+	JOB_RUNNING JobStatus = "running"
+	JOB_DONE    JobStatus = "done"
+	JOB_FAILED  JobStatus = "failed"
+)
+
+// Number of jobs run at once.
+const jobWorkers = 2
+
+// Most jobs waiting to run, adding more fails until some have run.
+const jobQueueSize = 100
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Long running work, done in the background so requests don't have to
+// wait for it. Users can only see their own jobs.
+type Job struct {
+	GormModel
+	UserID uint      `json:"-" gorm:"not null;index"`
+	Type   JobType   `json:"type" gorm:"not null"`
+	Status JobStatus `json:"status" gorm:"not null"`
+	// How far along the job is, Done out of Total (eg. rows imported).
+	// Total is 0 until the worker knows how much there is to do.
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// What the job needs to run (json), only used by its handler.
+	Payload string `json:"-"`
+	// What the job returned (json), once it is done.
+	Result JobResult `json:"result" gorm:"type:text"`
+	// Why the job failed.
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+// Json stored as is in a text column, so any result can be returned as it was.
+type JobResult json.RawMessage
+
+func (r JobResult) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return string(r), nil
+}
+
+// Jobs that haven't finished (or failed) have no result.
+func (r JobResult) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+func (r *JobResult) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = nil
+	case string:
+		*r = JobResult(v)
+	case []byte:
+		*r = append(JobResult{}, v...)
+	default:
+		return errors.New("unsupported type for JobResult")
+	}
+	return nil
+}
+
+type JobsQuery struct {
+	PageQuery
+	// Only jobs with this status.
+	Status JobStatus `form:"status" binding:"omitempty,oneof=queued running done failed"`
+}
+
+type JobsResponse struct {
+	Jobs  []Job `json:"jobs"`
+	Page  int   `json:"page"`
+	Total int64 `json:"total"`
+}
+
+// What a job handler is given to do its work.
+type JobContext struct {
+	db  *gorm.DB
+	job Job
+}
+
+// Record how far along the job is.
+func (jc *JobContext) setProgress(done int, total int) {
+	res := jc.db.Model(&Job{}).Where("id = ?", jc.job.ID).Updates(map[string]interface{}{"done": done, "total": total})
+	if res.Error != nil {
+		slog.Error("setProgress: Failed to update job progress", "job_id", jc.job.ID, "error", res.Error)
+	}
+}
+
+// Read the jobs payload into v.
+func (jc *JobContext) payload(v any) error {
+	return json.Unmarshal([]byte(jc.job.Payload), v)
+}
+
+// Does a jobs work, returning its result (to be stored as json).
+type jobHandler func(jc *JobContext) (any, error)
+
+var jobHandlers = map[JobType]jobHandler{
+	JOB_SIMPLE_CSV_IMPORT: runSimpleCSVImportJob,
+	JOB_POSTER_REPAIR:     runPosterRepairJob,
+}
+
+// Ids of jobs waiting for a worker.
+var jobQueue = make(chan uint, jobQueueSize)
+
+// Start the workers that run queued jobs. Jobs that were
+// queued or running when the server stopped can't be resumed, they are failed.
+func startJobWorkers(db *gorm.DB) {
+	res := db.Model(&Job{}).Where("status IN ?", []JobStatus{JOB_QUEUED, JOB_RUNNING}).Updates(map[string]interface{}{
+		"status":      JOB_FAILED,
+		"error":       "server restarted before the job finished",
+		"finished_at": timeNow(),
+	})
+	if res.Error != nil {
+		slog.Error("startJobWorkers: Failed to fail interrupted jobs", "error", res.Error)
+	} else if res.RowsAffected > 0 {
+		slog.Warn("Failed jobs interrupted by a restart", "count", res.RowsAffected)
+	}
+	for i := 0; i < jobWorkers; i++ {
+		go func() {
+			for id := range jobQueue {
+				runJob(db, id)
+			}
+		}()
+	}
+}
+
+// Add a job for userId, it runs once a worker is free.
+func enqueueJob(db *gorm.DB, userId uint, jobType JobType, payload any) (Job, error) {
+	if _, ok := jobHandlers[jobType]; !ok {
+		return Job{}, errors.New("unknown job type")
+	}
+	p, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("enqueueJob: Failed to marshal payload", "type", jobType, "error", err)
+		return Job{}, errors.New("failed to queue job")
+	}
+	job := Job{UserID: userId, Type: jobType, Status: JOB_QUEUED, Payload: string(p)}
+	if res := db.Create(&job); res.Error != nil {
+		slog.Error("enqueueJob: Failed to create job", "type", jobType, "error", res.Error)
+		return Job{}, errors.New("failed to queue job")
+	}
+	select {
+	case jobQueue <- job.ID:
+	default:
+		finishJob(db, job.ID, nil, errors.New("too many jobs queued, try again later"))
+		return Job{}, errors.New("too many jobs queued, try again later")
+	}
+	slog.Info("Queued job", "job_id", job.ID, "type", jobType, "userId", userId)
+	return job, nil
+}
+
+func runJob(db *gorm.DB, id uint) {
+	var job Job
+	if res := db.Where("id = ?", id).Take(&job); res.Error != nil {
+		slog.Error("runJob: Failed to get job", "job_id", id, "error", res.Error)
+		return
+	}
+	now := timeNow()
+	res := db.Model(&Job{}).Where("id = ?", id).Updates(map[string]interface{}{"status": JOB_RUNNING, "started_at": now})
+	if res.Error != nil {
+		slog.Error("runJob: Failed to mark job as running", "job_id", id, "error", res.Error)
+		return
+	}
+	slog.Info("Running job", "job_id", id, "type", job.Type)
+	result, err := runJobHandler(&JobContext{db: db, job: job})
+	finishJob(db, id, result, err)
+	slog.Info("Finished job", "job_id", id, "type", job.Type, "took", timeNow().Sub(now), "error", err)
+}
+
+// Run a jobs handler, a panic fails the job instead of taking the worker down.
+func runJobHandler(jc *JobContext) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("runJobHandler: Job panicked", "job_id", jc.job.ID, "panic", r)
+			err = errors.New("job crashed")
+		}
+	}()
+	return jobHandlers[jc.job.Type](jc)
+}
+
+func finishJob(db *gorm.DB, id uint, result any, err error) {
+	updates := map[string]interface{}{"status": JOB_DONE, "finished_at": timeNow()}
+	if err != nil {
+		updates["status"] = JOB_FAILED
+		updates["error"] = err.Error()
+	} else if result != nil {
+		r, err := json.Marshal(result)
+		if err != nil {
+			slog.Error("finishJob: Failed to marshal result", "job_id", id, "error", err)
+		} else {
+			updates["result"] = string(r)
+		}
+	}
+	if res := db.Model(&Job{}).Where("id = ?", id).Updates(updates); res.Error != nil {
+		slog.Error("finishJob: Failed to save job result", "job_id", id, "error", res.Error)
+	}
+}
+
+// Get a page of a users jobs, newest first.
+func getJobs(db *gorm.DB, userId uint, q JobsQuery) (JobsResponse, error) {
+	q.setDefaults()
+	resp := JobsResponse{Jobs: []Job{}, Page: q.Page}
+	base := db.Model(&Job{}).Where("user_id = ?", userId)
+	if q.Status != "" {
+		base = base.Where("status = ?", q.Status)
+	}
+	if res := base.Session(&gorm.Session{}).Count(&resp.Total); res.Error != nil {
+		slog.Error("getJobs: Failed to count jobs", "error", res.Error)
+		return JobsResponse{}, errors.New("failed to get jobs")
+	}
+	res := base.Session(&gorm.Session{}).
+		Order("created_at DESC, id DESC").
+		Offset(q.offset()).
+		Limit(q.Limit).
+		Find(&resp.Jobs)
+	if res.Error != nil {
+		slog.Error("getJobs: Failed to get jobs", "error", res.Error)
+		return JobsResponse{}, errors.New("failed to get jobs")
+	}
+	return resp, nil
+}
+
+func getJob(db *gorm.DB, userId uint, id uint) (Job, error) {
+	var job Job
+	res := db.Model(&Job{}).Where("id = ? AND user_id = ?", id, userId).Take(&job)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return Job{}, ErrJobNotFound
+		}
+		slog.Error("getJob: Failed to get job", "id", id, "error", res.Error)
+		return Job{}, errors.New("failed to get job")
+	}
+	return job, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How long keywords we have cached are used for, they rarely change.
+const keywordsCacheTTL = 30 * 24 * time.Hour
+
+// A keyword TMDB has tagged content with.
+type ContentKeyword struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ContentKeywordsResponse struct {
+	ID       int              `json:"id"`
+	Keywords []ContentKeyword `json:"keywords"`
+}
+
+type KeywordDiscoverQuery struct {
+	// Type of content to discover, defaults to movie.
+	Type ContentType `form:"type" binding:"omitempty,oneof=movie tv"`
+	// TMDB only serves up to page 500.
+	Page int `form:"page" binding:"omitempty,min=1,max=500"`
+}
+
+// Get keywords of content, from our cache when we have them, otherwise from TMDB
+// (caching them if we have the content). Content without keywords has an empty list.
+func contentKeywords(db *gorm.DB, contentType ContentType, id string) (ContentKeywordsResponse, error) {
+	var content Content
+	res := db.Model(&Content{}).Select("id", "keyword_list", "keywords_cached_at").Where("tmdb_id = ? AND type = ?", id, contentType).Limit(1).Find(&content)
+	if res.Error != nil {
+		slog.Error("contentKeywords: Failed to get cached keywords", "tmdb_id", id, "type", contentType, "error", res.Error)
+	}
+	tmdbId, _ := strconv.Atoi(id)
+	if res.RowsAffected > 0 && content.KeywordsCachedAt != nil && timeNow().Sub(*content.KeywordsCachedAt) < keywordsCacheTTL {
+		return ContentKeywordsResponse{ID: tmdbId, Keywords: content.KeywordList}, nil
+	}
+	var keywords JSONList[ContentKeyword]
+	if contentType == MOVIE {
+		resp := new(TMDBMovieKeywords)
+		if err := tmdbRequest("/movie/"+id+"/keywords", map[string]string{}, &resp); err != nil {
+			slog.Error("Failed to complete movie keywords request!", "error", err.Error())
+			return ContentKeywordsResponse{}, errors.New("failed to complete movie keywords request")
+		}
+		keywords = movieKeywordList(*resp)
+	} else {
+		resp := new(TMDBShowKeywords)
+		if err := tmdbRequest("/tv/"+id+"/keywords", map[string]string{}, &resp); err != nil {
+			slog.Error("Failed to complete tv keywords request!", "error", err.Error())
+			return ContentKeywordsResponse{}, errors.New("failed to complete tv keywords request")
+		}
+		keywords = showKeywordList(*resp)
+	}
+	if res.RowsAffected > 0 {
+		res = db.Model(&Content{}).Where("id = ?", content.ID).Updates(map[string]interface{}{
+			"keyword_list":       keywords,
+			"keywords_cached_at": timeNow(),
+		})
+		if res.Error != nil {
+			slog.Error("contentKeywords: Failed to cache keywords", "tmdb_id", id, "type", contentType, "error", res.Error)
+		}
+	}
+	return ContentKeywordsResponse{ID: tmdbId, Keywords: keywords}, nil
+}
+
+// Discover popular content tagged with a keyword (by its TMDB id).
+// Results are in the same form as search results, so they can be shown the same way.
+func discoverByKeyword(keywordId string, q KeywordDiscoverQuery) (TMDBSearchMultiResponse, error) {
+	if q.Type == "" {
+		q.Type = MOVIE
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	resp := new(TMDBSearchMultiResponse)
+	err := tmdbRequest("/discover/"+string(q.Type), map[string]string{"with_keywords": keywordId, "page": strconv.Itoa(q.Page), "sort_by": "popularity.desc"}, &resp)
+	if err != nil {
+		slog.Error("Failed to complete discover by keyword request!", "error", err.Error())
+		return TMDBSearchMultiResponse{}, errors.New("failed to complete discover request")
+	}
+	if resp.Results == nil {
+		resp.Results = []TMDBSearchMultiResults{}
+	}
+	// Discover results don't say what they are, unlike search results.
+	for i := range resp.Results {
+		resp.Results[i].MediaType = string(q.Type)
+	}
+	return *resp, nil
+}
+
+func movieKeywordList(k TMDBMovieKeywords) JSONList[ContentKeyword] {
+	keywords := JSONList[ContentKeyword]{}
+	for _, kw := range k.Keywords {
+		keywords = append(keywords, ContentKeyword{ID: kw.ID, Name: kw.Name})
+	}
+	return keywords
+}
+
+func showKeywordList(k TMDBShowKeywords) JSONList[ContentKeyword] {
+	keywords := JSONList[ContentKeyword]{}
+	for _, kw := range k.Results {
+		keywords = append(keywords, ContentKeyword{ID: kw.ID, Name: kw.Name})
+	}
+	return keywords
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Level logs are output at. Starts at LOG_LEVEL and
+// can be changed by admins at runtime (until restarted).
+var logLevel = new(slog.LevelVar)
+
+type LogLevelRequest struct {
+	// One of debug, info, warn or error.
+	Level string `json:"level" binding:"required"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// Change the log level, without needing a restart.
+func setLogLevel(lr LogLevelRequest) (LogLevelResponse, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(lr.Level)); err != nil {
+		return LogLevelResponse{}, errors.New("invalid level, must be one of debug, info, warn or error")
+	}
+	old := logLevel.Level()
+	logLevel.Set(level)
+	slog.Info("Logging level changed", "old_level", old, "logging_level", level)
+	return LogLevelResponse{Level: strings.ToLower(level.String())}, nil
+}
+
+// Writer that outputs each line written to it as a log,
+// so anything gin prints ends up in the same place (and format) as our logs.
+type slogWriter struct {
+	level slog.Level
+}
+
+func (w slogWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			slog.Log(context.Background(), w.level, string(line), "component", "gin")
+		}
+	}
+	return len(p), nil
+}
+
+// Log each request, replacing gins own access logs.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		attrs := []any{
+			"status", c.Writer.Status(),
+			"method", c.Request.Method,
+			// Query is left out, so nothing sensitive in it is logged.
+			"path", c.Request.URL.Path,
+			"ip", c.ClientIP(),
+			"latency", time.Since(start),
+			"size", c.Writer.Size(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		// Requests made while an admin impersonates a user are tagged
+		// with both, so what they did can be audited.
+		if id, ok := c.Get("impersonatorId"); ok {
+			attrs = append(attrs, "userId", c.MustGet("userId"), "impersonatorId", id)
+		}
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelWarn
+		}
+		slog.Log(c.Request.Context(), level, "Request", attrs...)
+	}
+}
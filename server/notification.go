@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NotificationType string
+
+const (
+	// A show on the users list changed air status (eg. ended or was canceled).
+	NOTIFICATION_SHOW_STATUS_CHANGED NotificationType = "SHOW_STATUS_CHANGED"
+)
+
+// How long notifications are kept for, read or not.
+const notificationRetention = 90 * 24 * time.Hour
+
+// How often old notifications are pruned.
+const notificationPruneInterval = 24 * time.Hour
+
+type Notification struct {
+	GormModel
+	UserID    uint             `json:"-" gorm:"not null;index"`
+	Type      NotificationType `json:"type" gorm:"not null"`
+	Message   string           `json:"message"`
+	ContentID *int             `json:"-"`
+	Content   *Content         `json:"content,omitempty"`
+	Read      bool             `json:"read" gorm:"not null;default:false"`
+	// What the notification is about (eg. a watched entry), so clients
+	// can link to it. RelatedType says what RelatedID is the id of.
+	RelatedID   *uint  `json:"relatedId"`
+	RelatedType string `json:"relatedType,omitempty"`
+}
+
+type NotificationsQuery struct {
+	PageQuery
+	// Only unread notifications.
+	Unread bool `form:"unread"`
+}
+
+type NotificationsUnreadCountResponse struct {
+	Count int64 `json:"count"`
+}
+
+type NotificationsResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int64          `json:"unreadCount"`
+	Page          int            `json:"page"`
+	Total         int64          `json:"total"`
+}
+
+func getNotifications(db *gorm.DB, userId uint, q NotificationsQuery) (NotificationsResponse, error) {
+	q.setDefaults()
+	resp := NotificationsResponse{Notifications: []Notification{}, Page: q.Page}
+	base := db.Model(&Notification{}).Where("user_id = ?", userId)
+	if res := base.Session(&gorm.Session{}).Count(&resp.Total); res.Error != nil {
+		slog.Error("Failed to count notifications", "error", res.Error)
+		return NotificationsResponse{}, errors.New("failed to get notifications")
+	}
+	if res := base.Session(&gorm.Session{}).Where("read = ?", false).Count(&resp.UnreadCount); res.Error != nil {
+		slog.Error("Failed to count unread notifications", "error", res.Error)
+		return NotificationsResponse{}, errors.New("failed to get notifications")
+	}
+	if q.Unread {
+		base = base.Where("read = ?", false)
+		resp.Total = resp.UnreadCount
+	}
+	res := base.Session(&gorm.Session{}).Preload("Content").
+		Order("created_at DESC, id DESC").
+		Offset(q.offset()).
+		Limit(q.Limit).
+		Find(&resp.Notifications)
+	if res.Error != nil {
+		slog.Error("Failed to get notifications", "error", res.Error)
+		return NotificationsResponse{}, errors.New("failed to get notifications")
+	}
+	return resp, nil
+}
+
+// Count a users unread notifications, for showing on a badge.
+func getUnreadNotificationCount(db *gorm.DB, userId uint) (NotificationsUnreadCountResponse, error) {
+	var resp NotificationsUnreadCountResponse
+	res := db.Model(&Notification{}).Where("user_id = ? AND read = ?", userId, false).Count(&resp.Count)
+	if res.Error != nil {
+		slog.Error("Failed to count unread notifications", "error", res.Error)
+		return NotificationsUnreadCountResponse{}, errors.New("failed to count unread notifications")
+	}
+	return resp, nil
+}
+
+func readNotification(db *gorm.DB, userId uint, id uint) error {
+	res := db.Model(&Notification{}).Where("id = ? AND user_id = ?", id, userId).Update("read", true)
+	if res.Error != nil {
+		slog.Error("Failed to mark notification as read", "id", id, "error", res.Error)
+		return errors.New("failed to mark notification as read")
+	}
+	if res.RowsAffected <= 0 {
+		return errors.New("no notification found")
+	}
+	return nil
+}
+
+func readAllNotifications(db *gorm.DB, userId uint) error {
+	res := db.Model(&Notification{}).Where("user_id = ? AND read = ?", userId, false).Update("read", true)
+	if res.Error != nil {
+		slog.Error("Failed to mark all notifications as read", "error", res.Error)
+		return errors.New("failed to mark notifications as read")
+	}
+	return nil
+}
+
+// Notify every user that has a show on their list (and hasn't dropped it)
+// that its air status has changed.
+func notifyShowStatusChanged(db *gorm.DB, content Content, oldStatus string) {
+	var watched []Watched
+	res := db.Model(&Watched{}).Select("id", "user_id").Where("content_id = ? AND status != ?", content.ID, DROPPED).Order("id").Find(&watched)
+	if res.Error != nil {
+		slog.Error("notifyShowStatusChanged: Failed to get users with show", "content_id", content.ID, "error", res.Error)
+		return
+	}
+	// Users can have the show on more than one (sub) profile, they are only notified once.
+	notified := map[uint]bool{}
+	notifications := []Notification{}
+	for _, w := range watched {
+		if notified[w.UserID] {
+			continue
+		}
+		notified[w.UserID] = true
+		watchedId := w.ID
+		notifications = append(notifications, Notification{
+			UserID:      w.UserID,
+			Type:        NOTIFICATION_SHOW_STATUS_CHANGED,
+			Message:     fmt.Sprintf("%s has changed from %s to %s", content.Title, oldStatus, content.Status),
+			ContentID:   &content.ID,
+			RelatedID:   &watchedId,
+			RelatedType: "watched",
+		})
+	}
+	if len(notifications) == 0 {
+		return
+	}
+	if res := db.Create(&notifications); res.Error != nil {
+		slog.Error("notifyShowStatusChanged: Failed to create notifications", "content_id", content.ID, "error", res.Error)
+		return
+	}
+	slog.Info("Notified users of show status change", "content_id", content.ID, "old", oldStatus, "new", content.Status, "users", len(notifications))
+}
+
+// Periodically remove notifications older than notificationRetention.
+func startNotificationPruneJob(db *gorm.DB) {
+	for {
+		pruneNotifications(db)
+		time.Sleep(notificationPruneInterval)
+	}
+}
+
+func pruneNotifications(db *gorm.DB) {
+	res := db.Unscoped().Where("created_at < ?", timeNow().Add(-notificationRetention)).Delete(&Notification{})
+	if res.Error != nil {
+		slog.Error("pruneNotifications: Failed to prune notifications", "error", res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		slog.Info("Pruned old notifications", "count", res.RowsAffected)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// Version of our api, bumped when a breaking change is made.
+const apiVersion = "1"
+
+// Documentation for a single api route, used to generate our OpenAPI spec.
+type APIRoute struct {
+	Method string
+	// Path relative to the api router group, in gin format (eg. /watched/:id).
+	Path    string
+	Summary string
+	// If route is behind the AuthRequired middleware.
+	Auth bool
+	// Struct with `form` tags describing accepted query params.
+	Query any
+	// Expected request body.
+	Request any
+	// Content type of Request, defaults to application/json.
+	RequestType string
+	// Response body returned on success.
+	Response any
+}
+
+// Every route we register should be documented here, checkAPIDocs
+// will warn on startup about any that have been missed.
+var apiRoutes = []APIRoute{
+	// Auth
+	{Method: "POST", Path: "/auth/", Summary: "Login", Request: User{}, Response: AuthResponse{}},
+	{Method: "POST", Path: "/auth/jellyfin", Summary: "Login with Jellyfin (serverId defaults to the first server)", Request: JellyfinLoginRequest{}, Response: AuthResponse{}},
+	{Method: "POST", Path: "/auth/register", Summary: "Register a new user", Request: User{}, Response: AuthResponse{}},
+	{Method: "GET", Path: "/auth/available", Summary: "Get available auth providers and jellyfin servers", Response: AvailableAuthResponse{}},
+	{Method: "GET", Path: "/auth/me", Summary: "Get authenticated users basic info", Auth: true, Response: AuthMeResponse{}},
+	{Method: "PUT", Path: "/auth/password", Summary: "Change your password", Auth: true, Request: PasswordChangeRequest{}, Response: AuthResponse{}},
+	{Method: "POST", Path: "/auth/logout", Summary: "Logout, clearing the auth cookie"},
+	{Method: "POST", Path: "/auth/verify-2fa", Summary: "Exchange a login's mfa token and a 2fa code for an auth token", Request: MFAVerifyRequest{}, Response: AuthResponse{}},
+	{Method: "POST", Path: "/auth/2fa/setup", Summary: "Generate a new 2fa (TOTP) secret", Auth: true, Response: TOTPSetupResponse{}},
+	{Method: "POST", Path: "/auth/2fa/enable", Summary: "Enable 2fa with a code from the setup secret", Auth: true, Request: TOTPCodeRequest{}},
+	{Method: "POST", Path: "/auth/2fa/disable", Summary: "Disable 2fa", Auth: true, Request: TOTPCodeRequest{}},
+
+	// Content
+	{Method: "GET", Path: "/content/:query", Summary: "Search for content (and people)", Auth: true, Query: SearchQuery{}, Response: TMDBSearchMultiResponse{}},
+	{Method: "GET", Path: "/content/find/:externalId", Summary: "Find content by external id (source=imdb|tvdb)", Auth: true, Query: ExternalIDQuery{}, Response: []TMDBSearchMultiResults{}},
+	{Method: "GET", Path: "/content/movie/:id", Summary: "Get movie details", Auth: true, Response: TMDBMovieDetails{}},
+	{Method: "GET", Path: "/content/movie/:id/credits", Summary: "Get movie credits", Auth: true, Response: TMDBContentCredits{}},
+	{Method: "GET", Path: "/content/movie/:id/keywords", Summary: "Get movie keywords", Auth: true, Response: ContentKeywordsResponse{}},
+	{Method: "GET", Path: "/content/movie/:id/alternative_titles", Summary: "Get movie alternative titles", Auth: true, Response: ContentAlternativeTitlesResponse{}},
+	{Method: "GET", Path: "/content/movies/upcoming", Summary: "Get movies coming soon to theaters", Auth: true, Query: ContentListQuery{}, Response: ContentListResponse{}},
+	{Method: "GET", Path: "/content/movies/now-playing", Summary: "Get movies in theaters now", Auth: true, Query: ContentListQuery{}, Response: ContentListResponse{}},
+	{Method: "GET", Path: "/content/movies/top-rated", Summary: "Get top rated movies", Auth: true, Query: ContentListQuery{}, Response: ContentListResponse{}},
+	{Method: "GET", Path: "/content/tv/upcoming", Summary: "Get shows with episodes airing soon", Auth: true, Query: ContentListQuery{}, Response: ContentListResponse{}},
+	{Method: "GET", Path: "/content/tv/airing-today", Summary: "Get shows with episodes airing today", Auth: true, Query: ContentListQuery{}, Response: ContentListResponse{}},
+	{Method: "GET", Path: "/content/tv/top-rated", Summary: "Get top rated shows", Auth: true, Query: ContentListQuery{}, Response: ContentListResponse{}},
+	{Method: "GET", Path: "/content/tv/:id", Summary: "Get tv details", Auth: true, Response: TMDBShowDetails{}},
+	{Method: "GET", Path: "/content/tv/:id/credits", Summary: "Get tv credits", Auth: true, Response: TMDBContentCredits{}},
+	{Method: "GET", Path: "/content/tv/:id/keywords", Summary: "Get tv keywords", Auth: true, Response: ContentKeywordsResponse{}},
+	{Method: "GET", Path: "/content/tv/:id/alternative_titles", Summary: "Get tv alternative titles", Auth: true, Response: ContentAlternativeTitlesResponse{}},
+	{Method: "GET", Path: "/content/tv/:id/season/:num", Summary: "Get season details", Auth: true, Response: TMDBSeasonDetails{}},
+	{Method: "GET", Path: "/content/tv/:id/season/:num/episode/:ep/credits", Summary: "Get episode credits", Auth: true, Response: EpisodeCreditsResponse{}},
+	{Method: "GET", Path: "/content/person/:id", Summary: "Get person details", Auth: true, Response: TMDBPersonDetails{}},
+	{Method: "GET", Path: "/content/person/:id/credits", Summary: "Get person credits", Auth: true, Response: TMDBPersonCombinedCredits{}},
+	{Method: "GET", Path: "/content/person/:id/credits/combined", Summary: "Get person cast and crew credits merged, sorted by popularity or date", Auth: true, Query: PersonCombinedCreditsQuery{}, Response: PersonCombinedCreditsResponse{}},
+	{Method: "GET", Path: "/content/discover/keyword/:id", Summary: "Discover popular content tagged with a keyword", Auth: true, Query: KeywordDiscoverQuery{}, Response: TMDBSearchMultiResponse{}},
+	{Method: "GET", Path: "/content/discover/provider", Summary: "Discover popular content streaming on any of the given watch providers in a region", Auth: true, Query: ProviderDiscoverQuery{}, Response: TMDBSearchMultiResponse{}},
+	{Method: "GET", Path: "/content/watch-providers", Summary: "Get streaming services (watch providers) available in a region", Auth: true, Query: WatchProvidersQuery{}, Response: WatchProvidersResponse{}},
+	{Method: "GET", Path: "/content/provider/:provider/:type/:id", Summary: "Get content from a provider (tmdb|anilist)", Auth: true, Response: Content{}},
+
+	// Watched
+	{Method: "GET", Path: "/watched", Summary: "Get watched list", Auth: true, Query: WatchedFilters{}, Response: []Watched{}},
+	{Method: "POST", Path: "/watched", Summary: "Add to watched list", Auth: true, Request: WatchedAddRequest{}, Response: Watched{}},
+	{Method: "PUT", Path: "/watched/reorder", Summary: "Set custom order of watched list", Auth: true, Request: WatchedReorderRequest{}},
+	{Method: "GET", Path: "/watched/stats/count", Summary: "Get counts of watched list items", Auth: true, Response: WatchedCountResponse{}},
+	{Method: "GET", Path: "/watched/stats/monthly", Summary: "Get number of watched list items added, and rewatches logged, per month", Auth: true, Query: WatchedStatsQuery{}, Response: []WatchedMonthlyStat{}},
+	{Method: "GET", Path: "/watched/stats/watchtime", Summary: "Get total time spent watching movies and episodes, overall and per year", Auth: true, Query: WatchedStatsQuery{}, Response: WatchTimeStats{}},
+	{Method: "GET", Path: "/watched/services", Summary: "Get services items were watched on (most used first), with counts", Auth: true, Response: []WatchedServiceStat{}},
+	{Method: "GET", Path: "/watched/search", Summary: "Search watched list by title or keyword", Auth: true, Query: WatchedSearchQuery{}, Response: []Watched{}},
+	{Method: "GET", Path: "/watched/random", Summary: "Get a random watched list item, takes the same filters as /watched", Auth: true, Query: WatchedFilters{}, Response: Watched{}},
+	{Method: "GET", Path: "/watched/random/batch", Summary: "Get a few random watched list items (at most 20) to pick from", Auth: true, Query: RandomWatchedQuery{}, Response: []Watched{}},
+	{Method: "GET", Path: "/watched/:id", Summary: "Get watched list item", Auth: true, Response: Watched{}},
+	{Method: "PUT", Path: "/watched/:id", Summary: "Update watched list item", Auth: true, Request: WatchedUpdateRequest{}, Response: WatchedUpdateResponse{}},
+	{Method: "PUT", Path: "/watched/:id/progress", Summary: "Update how far through a movie playback is, marking it finished near the end", Auth: true, Request: WatchedProgressRequest{}, Response: WatchedProgressResponse{}},
+	{Method: "DELETE", Path: "/watched/:id", Summary: "Remove watched list item", Auth: true, Response: WatchedRemoveResponse{}},
+	{Method: "POST", Path: "/watched/:id/rewatch", Summary: "Log another viewing of a watched list item, with its own rating and review", Auth: true, Request: ReWatchRequest{}, Response: ReWatchResponse{}},
+	{Method: "GET", Path: "/watched/:id/rewatches", Summary: "Get rewatches of a watched list item, most recent first", Auth: true, Response: []ReWatchEntry{}},
+	{Method: "POST", Path: "/watched/:id/season/:num/complete", Summary: "Mark all episodes in a season as watched", Auth: true, Request: WatchedSeasonCompleteRequest{}, Response: WatchedSeasonResponse{}},
+	{Method: "DELETE", Path: "/watched/:id/season/:num/complete", Summary: "Unmark all episodes in a season as watched", Auth: true, Response: WatchedSeasonResponse{}},
+	{Method: "GET", Path: "/watched/duplicates", Summary: "Get content on watched list more than once", Auth: true, Response: []DuplicateGroup{}},
+	{Method: "POST", Path: "/watched/duplicates/merge", Summary: "Merge duplicate watched list items", Auth: true, Request: DuplicatesMergeRequest{}, Response: DuplicatesMergeResponse{}},
+
+	// Activity
+	{Method: "GET", Path: "/activity/:watchedId", Summary: "Get activity for watched list item", Auth: true, Query: ActivityFilters{}, Response: []Activity{}},
+	{Method: "POST", Path: "/activity", Summary: "Add activity", Auth: true, Request: ActivityAddRequest{}, Response: Activity{}},
+
+	// Profile
+	{Method: "GET", Path: "/profile", Summary: "Get profile", Auth: true, Response: ProfileResponse{}},
+	{Method: "GET", Path: "/profile/user/:username", Summary: "Get another users profile, only available when they share with the instance", Auth: true, Response: PublicProfileResponse{}},
+	{Method: "PUT", Path: "/profile", Summary: "Update profile details", Auth: true, Request: UserProfileUpdateRequest{}, Response: UserProfile{}},
+	{Method: "GET", Path: "/profile/upcoming", Summary: "Get tracked shows that are still airing, by next air date", Auth: true, Response: []Content{}},
+	{Method: "GET", Path: "/profile/stats/countries", Summary: "Get number of watched list items from each production country", Auth: true, Response: []CountryStat{}},
+	{Method: "GET", Path: "/profile/logins", Summary: "Get recent logins and failed login attempts, most recent first", Auth: true, Query: PageQuery{}, Response: AuthLogsResponse{}},
+	{Method: "PUT", Path: "/profile/goal", Summary: "Set a years goal for movies, shows or hours watched", Auth: true, Request: WatchGoalSetRequest{}, Response: WatchGoal{}},
+	{Method: "GET", Path: "/profile/goal/:year", Summary: "Get progress and pace towards a years watch goal", Auth: true, Query: WatchGoalProgressQuery{}, Response: WatchGoalProgress{}},
+	{Method: "GET", Path: "/profile/wrapped/:year", Summary: "Get a summary of a years watching", Auth: true, Query: WatchedStatsQuery{}, Response: YearWrapped{}},
+	{Method: "GET", Path: "/profile/settings", Summary: "Get user settings", Auth: true, Response: UserSettings{}},
+	{Method: "PUT", Path: "/profile/settings", Summary: "Update user settings", Auth: true, Request: UserSettingsUpdateRequest{}, Response: UserSettings{}},
+	{Method: "GET", Path: "/profile/export", Summary: "Download all of your account data", Auth: true, Response: AccountExport{}},
+	{Method: "POST", Path: "/profile/import", Summary: "Restore an account export into your account", Auth: true, Query: ImportQuery{}, Request: AccountImportBundle{}, Response: AccountImportReport{}},
+
+	// Sub profiles
+	{Method: "GET", Path: "/profiles", Summary: "Get sub profiles", Auth: true, Response: []SubProfile{}},
+	{Method: "POST", Path: "/profiles", Summary: "Add a sub profile", Auth: true, Request: SubProfileAddRequest{}, Response: SubProfile{}},
+	{Method: "DELETE", Path: "/profiles/:id", Summary: "Remove a sub profile and its watched list", Auth: true},
+	{Method: "POST", Path: "/profiles/:id/token", Summary: "Get a token scoped to a sub profile", Auth: true, Response: AuthResponse{}},
+
+	// Admin
+	{Method: "GET", Path: "/admin/users", Summary: "Get a page of users, with how active they are", Auth: true, Query: AdminUsersQuery{}, Response: AdminUsersResponse{}},
+	{Method: "POST", Path: "/admin/users/merge", Summary: "Merge one user into another", Auth: true, Request: UserMergeRequest{}, Response: UserMergeResponse{}},
+	{Method: "PUT", Path: "/admin/users/:id/reset-password", Summary: "Reset a users password to a temporary one", Auth: true, Request: AdminPasswordResetRequest{}, Response: AdminPasswordResetResponse{}},
+	{Method: "DELETE", Path: "/admin/users/:id", Summary: "Delete a user, their data is removed for good after 24 hours", Auth: true},
+	{Method: "POST", Path: "/admin/users/:id/promote", Summary: "Make a user an admin", Auth: true},
+	{Method: "POST", Path: "/admin/users/:id/demote", Summary: "Remove a users admin permission, the last admin can't be demoted", Auth: true},
+	{Method: "POST", Path: "/admin/users/:id/impersonate", Summary: "Get a 15 minute token to act as a (non admin) user, for support", Auth: true, Response: ImpersonationResponse{}},
+	{Method: "GET", Path: "/admin/auth-logs", Summary: "Get recent auth events (logins, registers, password changes), most recent first", Auth: true, Query: AuthLogsQuery{}, Response: AuthLogsResponse{}},
+	{Method: "GET", Path: "/admin/stats", Summary: "Get server wide usage stats", Auth: true, Query: AdminStatsQuery{}, Response: AdminStats{}},
+	{Method: "PUT", Path: "/admin/loglevel", Summary: "Change the log level, until the server is restarted", Auth: true, Request: LogLevelRequest{}, Response: LogLevelResponse{}},
+	{Method: "POST", Path: "/admin/repair/posters", Summary: "Queue a job re-downloading missing content posters (its result is a PosterRepairResponse)", Auth: true, Response: Job{}},
+	{Method: "POST", Path: "/admin/repair/content-duplicates", Summary: "Merge content rows that are for the same TMDB content", Auth: true, Response: ContentDuplicatesMergeResponse{}},
+	{Method: "GET", Path: "/admin/settings", Summary: "Get server settings", Auth: true, Response: ServerSettings{}},
+	{Method: "PUT", Path: "/admin/settings", Summary: "Update server settings (defaults for new users, signup, instance name)", Auth: true, Request: ServerSettingsUpdateRequest{}, Response: ServerSettings{}},
+	{Method: "GET", Path: "/admin/content", Summary: "Get a page of cached content, with how many users reference each", Auth: true, Query: AdminContentQuery{}, Response: AdminContentResponse{}},
+	{Method: "DELETE", Path: "/admin/content/:id", Summary: "Delete cached content, only allowed when no watched entry references it", Auth: true},
+	{Method: "POST", Path: "/admin/content/:id/refresh", Summary: "Refresh cached content from TMDB", Auth: true, Response: Content{}},
+	{Method: "POST", Path: "/admin/content/:id/redownload-images", Summary: "Delete and download a contents cached images again", Auth: true, Response: ContentImagesRedownloadResponse{}},
+	{Method: "POST", Path: "/admin/content/redownload-all-missing", Summary: "Queue a job re-downloading content posters missing from disk (same as /admin/repair/posters)", Auth: true, Response: Job{}},
+	{Method: "GET", Path: "/admin/jellyfin-servers", Summary: "List jellyfin servers", Auth: true, Response: []JellyfinServer{}},
+	{Method: "POST", Path: "/admin/jellyfin-servers", Summary: "Add a jellyfin server", Auth: true, Request: JellyfinServerRequest{}, Response: JellyfinServer{}},
+	{Method: "PUT", Path: "/admin/jellyfin-servers/:id", Summary: "Update a jellyfin server", Auth: true, Request: JellyfinServerUpdateRequest{}, Response: JellyfinServer{}},
+	{Method: "DELETE", Path: "/admin/jellyfin-servers/:id", Summary: "Delete a jellyfin server with no users", Auth: true},
+	{Method: "POST", Path: "/import", Summary: "Import items into watched list", Auth: true, Query: ImportQuery{}, Request: ImportRequest{}, Response: ImportReport{}},
+	{Method: "POST", Path: "/import/simple-csv", Summary: "Import a csv of titles (title,year,rating columns) into watched list", Auth: true, Query: SimpleCSVImportQuery{}, Request: "", RequestType: "text/csv", Response: SimpleCSVImportReport{}},
+	{Method: "GET", Path: "/jobs", Summary: "Get background jobs, newest first", Auth: true, Query: JobsQuery{}, Response: JobsResponse{}},
+	{Method: "GET", Path: "/jobs/:id", Summary: "Get a background job, with its progress and result", Auth: true, Response: Job{}},
+	{Method: "GET", Path: "/tags", Summary: "Get tags, smart tags are marked as dynamic", Auth: true, Response: []Tag{}},
+	{Method: "POST", Path: "/tags", Summary: "Add a tag, or a smart tag when a filter is given", Auth: true, Request: TagAddRequest{}, Response: Tag{}},
+	{Method: "DELETE", Path: "/tags/:id", Summary: "Delete a tag", Auth: true},
+	{Method: "POST", Path: "/tags/:id/apply", Summary: "Add a tag to every watched entry matching the filters", Auth: true, Query: WatchedFilters{}, Response: TagApplyResponse{}},
+	{Method: "POST", Path: "/tags/:id/remove", Summary: "Take a tag off every watched entry matching the filters", Auth: true, Query: WatchedFilters{}, Response: TagApplyResponse{}},
+	{Method: "GET", Path: "/notifications", Summary: "Get notifications, newest first (?unread=true for only unread)", Auth: true, Query: NotificationsQuery{}, Response: NotificationsResponse{}},
+	{Method: "GET", Path: "/notifications/unread-count", Summary: "Count unread notifications", Auth: true, Response: NotificationsUnreadCountResponse{}},
+	{Method: "PUT", Path: "/notifications/:id/read", Summary: "Mark a notification as read", Auth: true},
+	{Method: "PUT", Path: "/notifications/read-all", Summary: "Mark all notifications as read", Auth: true},
+	{Method: "GET", Path: "/goals", Summary: "Get watch goals, newest year first", Auth: true, Response: []WatchGoal{}},
+	{Method: "POST", Path: "/goals", Summary: "Add a goal for movies, shows, episodes or hours watched in a year", Auth: true, Request: WatchGoalRequest{}, Response: WatchGoal{}},
+	{Method: "PUT", Path: "/goals/:id", Summary: "Update a watch goal", Auth: true, Request: WatchGoalUpdateRequest{}, Response: WatchGoal{}},
+	{Method: "DELETE", Path: "/goals/:id", Summary: "Delete a watch goal", Auth: true},
+	{Method: "GET", Path: "/goals/:year/progress", Summary: "Get progress towards a years watch goal", Auth: true, Query: WatchGoalProgressQuery{}, Response: WatchGoalProgress{}},
+
+	// Misc
+	{Method: "GET", Path: "/img/*filepath", Summary: "Get cached image"},
+	{Method: "GET", Path: "/config", Summary: "Get public server config (instance name, if signup is enabled, auth providers)", Response: PublicServerConfig{}},
+	{Method: "GET", Path: "/openapi.json", Summary: "Get OpenAPI spec"},
+	{Method: "GET", Path: "/openapi.yaml", Summary: "Get OpenAPI spec as yaml"},
+	{Method: "GET", Path: "/docs", Summary: "Swagger UI (when ENABLE_SWAGGER_UI is true)"},
+}
+
+func (b *BaseRouter) addDocsRoutes() {
+	spec := buildOpenAPISpec(b.rg.BasePath())
+
+	b.rg.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+
+	specYaml, err := yaml.Marshal(spec)
+	if err != nil {
+		slog.Error("Failed to marshal OpenAPI spec to yaml", "error", err)
+	}
+	b.rg.GET("/openapi.yaml", func(c *gin.Context) {
+		if specYaml == nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "application/yaml", specYaml)
+	})
+
+	b.rg.GET("/docs", func(c *gin.Context) {
+		if os.Getenv("ENABLE_SWAGGER_UI") != "true" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage(b.rg.BasePath()+"/openapi.json")))
+	})
+}
+
+// Log a warning for any registered route that isn't in apiRoutes,
+// so our spec doesn't silently fall out of sync with the handlers.
+func checkAPIDocs(basePath string, routes gin.RoutesInfo) {
+	for _, r := range undocumentedRoutes(basePath, routes) {
+		slog.Warn("Route is missing from api docs", "method", r.Method, "path", r.Path)
+	}
+}
+
+// Get routes under basePath that aren't in apiRoutes.
+func undocumentedRoutes(basePath string, routes gin.RoutesInfo) gin.RoutesInfo {
+	documented := map[string]bool{}
+	for _, r := range apiRoutes {
+		documented[r.Method+" "+basePath+r.Path] = true
+	}
+	missing := gin.RoutesInfo{}
+	for _, r := range routes {
+		if r.Method == "HEAD" || !strings.HasPrefix(r.Path, basePath) {
+			continue
+		}
+		if !documented[r.Method+" "+r.Path] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+func buildOpenAPISpec(basePath string) map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	for _, r := range apiRoutes {
+		p, params := openAPIPath(basePath + r.Path)
+		if r.Query != nil {
+			params = append(params, openAPIQueryParams(r.Query)...)
+		}
+		op := map[string]any{
+			"summary": r.Summary,
+			"responses": map[string]any{
+				"200": openAPIResponse("Success", r.Response, schemas),
+				"400": openAPIResponse("Bad request", ErrorResponse{}, schemas),
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if r.Auth {
+			op["security"] = []map[string][]string{{"token": {}}}
+			op["responses"].(map[string]any)["401"] = map[string]any{"description": "Unauthorized"}
+		}
+		if r.Request != nil {
+			reqType := r.RequestType
+			if reqType == "" {
+				reqType = "application/json"
+			}
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					reqType: map[string]any{"schema": openAPISchema(reflect.TypeOf(r.Request), schemas)},
+				},
+			}
+		}
+		if paths[p] == nil {
+			paths[p] = map[string]any{}
+		}
+		paths[p][strings.ToLower(r.Method)] = op
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Watcharr API",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "apiKey", "in": "header", "name": "Authorization"},
+			},
+		},
+	}
+}
+
+// Convert gin path to OpenAPI format, returning
+// the new path along with its path params.
+func openAPIPath(p string) (string, []map[string]any) {
+	params := []map[string]any{}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			name := part[1:]
+			parts[i] = "{" + name + "}"
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func openAPIQueryParams(q any) []map[string]any {
+	params := []map[string]any{}
+	t := reflect.TypeOf(q)
+	for i := 0; i < t.NumField(); i++ {
+		// Shared params (eg. PageQuery) are embedded.
+		if f := t.Field(i); f.Anonymous && f.Type.Kind() == reflect.Struct {
+			params = append(params, openAPIQueryParams(reflect.Zero(f.Type).Interface())...)
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("form"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		params = append(params, map[string]any{
+			"name":   name,
+			"in":     "query",
+			"schema": openAPISchema(t.Field(i).Type, nil),
+		})
+	}
+	return params
+}
+
+func openAPIResponse(desc string, body any, schemas map[string]any) map[string]any {
+	resp := map[string]any{"description": desc}
+	if body != nil {
+		resp["content"] = map[string]any{
+			"application/json": map[string]any{"schema": openAPISchema(reflect.TypeOf(body), schemas)},
+		}
+	}
+	return resp
+}
+
+// Generate schema for type. Named structs are added to schemas
+// and referenced, unless schemas is nil, then they are inlined.
+func openAPISchema(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(gorm.DeletedAt{}):
+		return map[string]any{"type": "string", "format": "date-time", "nullable": true}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" || schemas == nil {
+			return openAPIStructSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Set placeholder first so recursive types don't loop forever.
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = openAPIStructSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func openAPIStructSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = openAPISchema(f.Type, schemas)
+		}
+	}
+	addFields(t)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func swaggerUIPage(specUrl string) string {
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8" />
+	<title>Watcharr API Docs</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		window.ui = SwaggerUIBundle({ url: "` + specUrl + `", dom_id: "#swagger-ui" });
+	</script>
+</body>
+</html>`
+}
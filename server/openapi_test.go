@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every route we register must be documented in apiRoutes.
+func TestAllRoutesDocumented(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("API_PREFIX", "")
+	db, err := openDB(dataPath("watcharr.db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	gine, err := newEngine(db)
+	if err != nil {
+		t.Fatalf("failed to create engine: %v", err)
+	}
+	routes := gine.Routes()
+	if len(routes) == 0 {
+		t.Fatal("no routes registered")
+	}
+	for _, r := range undocumentedRoutes(getAPIPrefix(), routes) {
+		t.Errorf("%s %s is missing from apiRoutes", r.Method, r.Path)
+	}
+}
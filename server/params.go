@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page query params, shared by all paginated routes.
+// Page is capped so the offset it becomes can't overflow.
+type PageQuery struct {
+	Page  int `form:"page" binding:"omitempty,min=1,max=100000"`
+	Limit int `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// Fill in the default page (1) and limit (20) when they weren't given.
+func (q *PageQuery) setDefaults() {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.Limit == 0 {
+		q.Limit = 20
+	}
+}
+
+// Number of rows to skip to get to the page.
+func (q PageQuery) offset() int {
+	return (q.Page - 1) * q.Limit
+}
+
+// Parse route param name as an id (a positive number).
+// When invalid, a 400 is sent and false returned, so handlers can just return.
+func idParam(c *gin.Context, name string) (int, bool) {
+	return intParam(c, name, 1)
+}
+
+// Parse route param name as a TMDB id. Returned as a string, since that is
+// what our TMDB helpers take, but only ever a number so nothing else ends up in TMDB urls.
+func tmdbIDParam(c *gin.Context, name string) (string, bool) {
+	id, ok := idParam(c, name)
+	return strconv.Itoa(id), ok
+}
+
+// Parse route param name as a number, no less than min.
+// When invalid, a 400 is sent and false returned, so handlers can just return.
+func intParam(c *gin.Context, name string, min int) (int, bool) {
+	n, err := strconv.Atoi(c.Param(name))
+	if err != nil || n < min || n > math.MaxInt32 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid %s route param, must be a whole number no less than %d", name, min)})
+		return 0, false
+	}
+	return n, true
+}
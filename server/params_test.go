@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Test context with route param name set to value.
+func newParamContext(name string, value string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: name, Value: value}}
+	return c, w
+}
+
+func TestIntParam(t *testing.T) {
+	for _, tc := range []struct {
+		value  string
+		min    int
+		want   int
+		wantOk bool
+	}{
+		{"0", 0, 0, true},
+		{"1", 1, 1, true},
+		{"2147483647", 1, 2147483647, true},
+		{"1900", 1900, 1900, true},
+		{"0", 1, 0, false},
+		{"-1", 0, 0, false},
+		{"1899", 1900, 0, false},
+		{"2147483648", 1, 0, false},
+		{"9223372036854775808", 1, 0, false},
+		{"", 0, 0, false},
+		{"1.5", 0, 0, false},
+		{"1e3", 0, 0, false},
+		{"+1", 1, 1, true},
+		{" 1", 1, 0, false},
+		{"0x10", 1, 0, false},
+		{"1;DROP TABLE watcheds", 1, 0, false},
+		{"1/../2", 1, 0, false},
+	} {
+		c, w := newParamContext("id", tc.value)
+		got, ok := intParam(c, "id", tc.min)
+		if ok != tc.wantOk || got != tc.want {
+			t.Errorf("intParam(%q, min %d) = %d, %v, want %d, %v", tc.value, tc.min, got, ok, tc.want, tc.wantOk)
+		}
+		if !ok && (w.Code != http.StatusBadRequest || !c.IsAborted()) {
+			t.Errorf("intParam(%q, min %d) invalid but got status %d (aborted: %v), want an aborted 400", tc.value, tc.min, w.Code, c.IsAborted())
+		}
+		if ok && c.IsAborted() {
+			t.Errorf("intParam(%q, min %d) valid but request was aborted", tc.value, tc.min)
+		}
+	}
+}
+
+func TestIDParams(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		wantId   int
+		wantTmdb string
+		wantOk   bool
+	}{
+		{"1", 1, "1", true},
+		{"550", 550, "550", true},
+		{"007", 7, "7", true},
+		{"0", 0, "", false},
+		{"-550", 0, "", false},
+		{"550abc", 0, "", false},
+		{"550?api_key=x", 0, "", false},
+		{"../tv/1399", 0, "", false},
+	} {
+		c, _ := newParamContext("id", tc.value)
+		if id, ok := idParam(c, "id"); ok != tc.wantOk || id != tc.wantId {
+			t.Errorf("idParam(%q) = %d, %v, want %d, %v", tc.value, id, ok, tc.wantId, tc.wantOk)
+		}
+		c, _ = newParamContext("id", tc.value)
+		// Only a valid id is used, so only check it then.
+		if id, ok := tmdbIDParam(c, "id"); ok != tc.wantOk || (ok && id != tc.wantTmdb) {
+			t.Errorf("tmdbIDParam(%q) = %q, %v, want %q, %v", tc.value, id, ok, tc.wantTmdb, tc.wantOk)
+		}
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query      string
+		wantOk     bool
+		wantPage   int
+		wantLimit  int
+		wantOffset int
+	}{
+		{"", true, 1, 20, 0},
+		{"page=1&limit=1", true, 1, 1, 0},
+		{"page=2", true, 2, 20, 20},
+		{"page=3&limit=100", true, 3, 100, 200},
+		{"page=100000&limit=100", true, 100000, 100, 9999900},
+		{"page=0", true, 1, 20, 0},
+		{"limit=0", true, 1, 20, 0},
+		{"page=-1", false, 0, 0, 0},
+		{"page=100001", false, 0, 0, 0},
+		{"page=9223372036854775807", false, 0, 0, 0},
+		{"page=9223372036854775808", false, 0, 0, 0},
+		{"limit=-1", false, 0, 0, 0},
+		{"limit=101", false, 0, 0, 0},
+		{"limit=1000000", false, 0, 0, 0},
+		{"page=one", false, 0, 0, 0},
+		{"limit=1.5", false, 0, 0, 0},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/?"+tc.query, nil)
+			var q PageQuery
+			err := c.ShouldBindQuery(&q)
+			if (err == nil) != tc.wantOk {
+				t.Fatalf("got bind error %v, want ok: %v", err, tc.wantOk)
+			}
+			if !tc.wantOk {
+				return
+			}
+			q.setDefaults()
+			if q.Page != tc.wantPage || q.Limit != tc.wantLimit || q.offset() != tc.wantOffset {
+				t.Errorf("got page %d, limit %d, offset %d, want %d, %d, %d", q.Page, q.Limit, q.offset(), tc.wantPage, tc.wantLimit, tc.wantOffset)
+			}
+		})
+	}
+}
+
+// Invalid params get a 400 across route groups, before anything else is done.
+func TestRouteParamBoundaries(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	for _, tc := range []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"GET", "/content/movie/0", http.StatusBadRequest},
+		{"GET", "/content/movie/-550", http.StatusBadRequest},
+		{"GET", "/content/movie/" + url.PathEscape("550 OR 1=1"), http.StatusBadRequest},
+		{"GET", "/content/movie/2147483648", http.StatusBadRequest},
+		{"GET", "/content/movie/550", http.StatusOK},
+		{"DELETE", "/watched/0", http.StatusBadRequest},
+		{"DELETE", "/watched/abc", http.StatusBadRequest},
+		{"GET", "/profile/logins?page=0&limit=0", http.StatusOK},
+		{"GET", "/profile/logins?page=-1", http.StatusBadRequest},
+		{"GET", "/profile/logins?limit=101", http.StatusBadRequest},
+		{"GET", "/profile/logins?page=100001", http.StatusBadRequest},
+	} {
+		if status, b := s.do(tc.method, tc.path, token, ""); status != tc.want {
+			t.Errorf("%s %s: got status %d, want %d (body: %s)", tc.method, tc.path, status, tc.want, b)
+		}
+	}
+}
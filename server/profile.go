@@ -2,45 +2,229 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-type Profile struct {
-	Joined        time.Time `json:"joined"`
-	ShowsWatched  int32     `json:"showsWatched"`
-	MoviesWatched int32     `json:"moviesWatched"`
+// Public facing profile details of a user, kept
+// separate from User so sensitive fields are never loaded with it.
+type UserProfile struct {
+	GormModel
+	UserID   uint   `json:"-" gorm:"uniqueIndex;not null"`
+	Bio      string `json:"bio"`
+	Location string `json:"location"`
+	Website  string `json:"website"`
 }
 
+type UserProfileUpdateRequest struct {
+	Bio      *string `json:"bio" binding:"omitempty,max=500"`
+	Location *string `json:"location" binding:"omitempty,max=100"`
+	Website  *string `json:"website" binding:"omitempty,max=200"`
+}
+
+// Only the fields listed here are ever returned, never add User directly.
+type ProfileResponse struct {
+	PublicProfileResponse
+	// Users settings.
+	Settings UserSettings `json:"settings"`
+	// If an admin is acting as the user, so a banner can be shown.
+	Impersonating bool `json:"impersonating"`
+}
+
+// Profile shown to other users, only the fields listed here are ever returned.
+type PublicProfileResponse struct {
+	Username string    `json:"username"`
+	Joined   time.Time `json:"joined"`
+	Bio      string    `json:"bio"`
+	Location string    `json:"location"`
+	Website  string    `json:"website"`
+	// Finished shows and movies.
+	ShowsWatched  int64 `json:"showsWatched"`
+	MoviesWatched int64 `json:"moviesWatched"`
+	// Number of watched list items by content type then status (eg. movie.FINISHED).
+	WatchedCounts map[ContentType]map[WatchedStatus]int64 `json:"watchedCounts"`
+}
+
+var ErrProfilePrivate = errors.New("profile not found or is private")
+
+// How long profile watched counts are cached, so viewing profiles doesn't
+// count a whole watched list every time.
+const profileCountsCacheTTL = 30 * time.Second
+
+type cachedProfileCounts struct {
+	counts map[ContentType]map[WatchedStatus]int64
+	at     time.Time
+}
+
+// Watched counts of each user profile, keyed by `userId:profileId`.
+var profileCountsCache sync.Map
+
 // Gets any data required for profile page
-func getProfile(db *gorm.DB, userId uint) (Profile, error) {
+func getProfile(db *gorm.DB, userId uint, profileId uint) (ProfileResponse, error) {
 	user := new(User)
-	res := db.Model(&User{}).Where("id = ?", userId).Take(&user)
+	res := db.Model(&User{}).Omit("password", "third_party_id").Where("id = ?", userId).Take(&user)
 	if res.Error != nil {
 		slog.Error("Failed to get profile:", "error", res.Error.Error())
-		return Profile{}, errors.New("failed to get profile")
+		return ProfileResponse{}, errors.New("failed to get profile")
+	}
+	public, err := buildPublicProfile(db, *user, profileId)
+	if err != nil {
+		return ProfileResponse{}, err
+	}
+	return ProfileResponse{PublicProfileResponse: public, Settings: user.Settings}, nil
+}
+
+// Get another users profile. Only users who share with the
+// instance have a visible profile, for anyone else ErrProfilePrivate is returned.
+func getPublicProfile(db *gorm.DB, username string) (PublicProfileResponse, error) {
+	var user User
+	res := db.Model(&User{}).
+		Select("id", "created_at", "username").
+		Where("username = ? AND setting_share_with_instance = ?", username, true).
+		Order("id").
+		Limit(1).
+		Find(&user)
+	if res.Error != nil {
+		slog.Error("Failed to get public profile", "username", username, "error", res.Error)
+		return PublicProfileResponse{}, errors.New("failed to get profile")
+	}
+	if res.RowsAffected == 0 {
+		return PublicProfileResponse{}, ErrProfilePrivate
+	}
+	// Only the users main profile is shared.
+	return buildPublicProfile(db, user, 0)
+}
+
+func buildPublicProfile(db *gorm.DB, user User, profileId uint) (PublicProfileResponse, error) {
+	up, err := getUserProfile(db, user.ID)
+	if err != nil {
+		return PublicProfileResponse{}, err
+	}
+	counts, err := getProfileWatchedCounts(db, user.ID, profileId)
+	if err != nil {
+		return PublicProfileResponse{}, err
+	}
+	return PublicProfileResponse{
+		Username:      user.Username,
+		Joined:        user.CreatedAt,
+		Bio:           up.Bio,
+		Location:      up.Location,
+		Website:       up.Website,
+		ShowsWatched:  counts[SHOW][FINISHED],
+		MoviesWatched: counts[MOVIE][FINISHED],
+		WatchedCounts: counts,
+	}, nil
+}
+
+// Count a profiles watched list by content type and status, cached for profileCountsCacheTTL.
+func getProfileWatchedCounts(db *gorm.DB, userId uint, profileId uint) (map[ContentType]map[WatchedStatus]int64, error) {
+	key := fmt.Sprintf("%d:%d", userId, profileId)
+	if c, ok := profileCountsCache.Load(key); ok && timeNow().Sub(c.(cachedProfileCounts).at) < profileCountsCacheTTL {
+		return c.(cachedProfileCounts).counts, nil
 	}
-	watched := new([]Watched)
-	res = db.Model(&Watched{}).Preload("Content").Where("user_id = ?", userId).Find(&watched)
+	var rows []struct {
+		Type   ContentType
+		Status WatchedStatus
+		Count  int64
+	}
+	res := db.Model(&Watched{}).
+		Select("contents.type, watcheds.status, COUNT(*) AS count").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId).
+		Group("contents.type, watcheds.status").
+		Scan(&rows)
+	if res.Error != nil {
+		slog.Error("Profile: Failed to count watched list", "userId", userId, "error", res.Error)
+		return nil, errors.New("failed to get watched counts")
+	}
+	counts := map[ContentType]map[WatchedStatus]int64{MOVIE: {}, SHOW: {}}
+	for _, r := range rows {
+		if counts[r.Type] == nil {
+			counts[r.Type] = map[WatchedStatus]int64{}
+		}
+		counts[r.Type][r.Status] = r.Count
+	}
+	profileCountsCache.Store(key, cachedProfileCounts{counts: counts, at: timeNow()})
+	return counts, nil
+}
+
+// Get a users profile details, users without any yet get an empty profile.
+func getUserProfile(db *gorm.DB, userId uint) (UserProfile, error) {
+	var up UserProfile
+	res := db.Where("user_id = ?", userId).Limit(1).Find(&up)
 	if res.Error != nil {
-		slog.Error("Profile: Failed to get watched for processing:", "error", res.Error.Error())
-		return Profile{}, errors.New("failed to get watched for processing")
-	}
-	var (
-		showsWatched  int32
-		moviesWatched int32
-	)
-	for _, w := range *watched {
-		if w.Status == FINISHED {
-			if w.Content.Type == SHOW {
-				showsWatched++
-			} else if w.Content.Type == MOVIE {
-				moviesWatched++
+		slog.Error("Failed to get user profile", "userId", userId, "error", res.Error.Error())
+		return UserProfile{}, errors.New("failed to get profile")
+	}
+	up.UserID = userId
+	return up, nil
+}
+
+func updateUserProfile(db *gorm.DB, userId uint, ur UserProfileUpdateRequest) (UserProfile, error) {
+	up, err := getUserProfile(db, userId)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	if ur.Bio != nil {
+		up.Bio = sanitizeString(*ur.Bio)
+	}
+	if ur.Location != nil {
+		up.Location = sanitizeString(*ur.Location)
+	}
+	if ur.Website != nil {
+		if *ur.Website != "" {
+			u, err := url.Parse(*ur.Website)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return UserProfile{}, errors.New("website must be a http(s) url")
 			}
 		}
+		up.Website = *ur.Website
+	}
+	if res := db.Save(&up); res.Error != nil {
+		slog.Error("Failed to update user profile", "userId", userId, "error", res.Error.Error())
+		return UserProfile{}, errors.New("failed to update profile")
+	}
+	return up, nil
+}
+
+// Get shows on users watched list that are still airing,
+// soonest next episode first. Shows with no known next air date go last.
+func getUpcoming(db *gorm.DB, userId uint, profileId uint) ([]Content, error) {
+	content := []Content{}
+	res := whereAiring(db.Model(&Content{})).
+		Where("id IN (?)", db.Model(&Watched{}).Select("content_id").Where("user_id = ? AND sub_profile_id = ?", userId, profileId)).
+		Order("next_episode_air_date IS NULL, next_episode_air_date").
+		Find(&content)
+	if res.Error != nil {
+		slog.Error("Failed to get upcoming shows", "error", res.Error.Error())
+		return []Content{}, errors.New("failed to get upcoming shows")
+	}
+	return content, nil
+}
+
+type CountryStat struct {
+	// ISO 3166-1 code.
+	CountryCode string `json:"countryCode"`
+	Count       int64  `json:"count"`
+}
+
+// Count watched list items by production country, most watched first.
+func getCountryStats(db *gorm.DB, userId uint, profileId uint) ([]CountryStat, error) {
+	stats := []CountryStat{}
+	res := db.Raw(`SELECT json_extract(c.value, '$.iso_3166_1') AS country_code, COUNT(*) AS count
+		FROM watcheds w
+		JOIN contents ON contents.id = w.content_id, json_each(contents.production_countries) c
+		WHERE w.user_id = ? AND w.sub_profile_id = ? AND w.deleted_at IS NULL
+		GROUP BY country_code
+		ORDER BY count DESC, country_code`, userId, profileId).Scan(&stats)
+	if res.Error != nil {
+		slog.Error("Failed to get country stats", "error", res.Error.Error())
+		return []CountryStat{}, errors.New("failed to get country stats")
 	}
-	profile := Profile{Joined: user.CreatedAt, ShowsWatched: showsWatched, MoviesWatched: moviesWatched}
-	return profile, nil
+	return stats, nil
 }
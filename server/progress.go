@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"gorm.io/gorm"
+)
+
+// How far through (0-1) a movie must be before it is marked finished,
+// so sitting through the credits isn't needed.
+const watchedProgressFinishedThreshold = 0.9
+
+type WatchedProgressRequest struct {
+	// Seconds into the movie playback is at.
+	ProgressSeconds *uint32 `json:"progressSeconds" binding:"required"`
+	// Length of the movie in seconds. Only needed when it differs from
+	// the last one sent, or the contents runtime (eg. a directors cut).
+	Runtime uint32 `json:"runtime"`
+}
+
+type WatchedProgressResponse struct {
+	ProgressSeconds uint32        `json:"progressSeconds"`
+	ProgressRuntime uint32        `json:"progressRuntime"`
+	Status          WatchedStatus `json:"status"`
+	// Set when the progress changed the items status.
+	NewActivity *Activity `json:"newActivity,omitempty"`
+}
+
+// Store how far through a movie a user is, so they can resume it later.
+// Once progress crosses watchedProgressFinishedThreshold, the movie
+// is marked finished and its progress is reset.
+func updateWatchedProgress(db *gorm.DB, userId uint, profileId uint, id uint, pr WatchedProgressRequest) (WatchedProgressResponse, error) {
+	var w Watched
+	res := db.Model(&Watched{}).Preload("Content").Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Take(&w)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return WatchedProgressResponse{}, errors.New("watched entry not found")
+		}
+		slog.Error("updateWatchedProgress: Failed to get watched entry", "id", id, "error", res.Error)
+		return WatchedProgressResponse{}, errors.New("failed to update progress")
+	}
+	if w.Content.Type != MOVIE {
+		return WatchedProgressResponse{}, errors.New("progress can only be tracked for movies, track episodes for shows")
+	}
+	runtime := pr.Runtime
+	if runtime == 0 {
+		runtime = w.ProgressRuntime
+	}
+	if runtime == 0 {
+		runtime = w.Content.Runtime * 60
+	}
+	if runtime == 0 {
+		return WatchedProgressResponse{}, errors.New("runtime of this movie is unknown, it must be provided")
+	}
+	progress := *pr.ProgressSeconds
+	if progress > runtime {
+		return WatchedProgressResponse{}, errors.New("progressSeconds can't be more than runtime")
+	}
+
+	resp := WatchedProgressResponse{ProgressSeconds: progress, ProgressRuntime: runtime, Status: w.Status}
+	var newStatus WatchedStatus
+	if float64(progress) >= float64(runtime)*watchedProgressFinishedThreshold {
+		resp.ProgressSeconds = 0
+		if w.Status != FINISHED {
+			newStatus = FINISHED
+		}
+	} else if w.Status == PLANNED {
+		newStatus = WATCHING
+	}
+	res = db.Model(&Watched{}).Where("id = ?", w.ID).Updates(map[string]interface{}{"progress_seconds": resp.ProgressSeconds, "progress_runtime": runtime})
+	if res.Error != nil {
+		slog.Error("updateWatchedProgress: Failed to update progress", "id", id, "error", res.Error)
+		return WatchedProgressResponse{}, errors.New("failed to update progress")
+	}
+	if newStatus != "" {
+		ur, err := updateWatched(db, userId, profileId, id, WatchedUpdateRequest{Status: newStatus})
+		if err != nil {
+			return WatchedProgressResponse{}, err
+		}
+		resp.Status = newStatus
+		resp.NewActivity = &ur.NewActivity
+	}
+	return resp, nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Where content is from. Everything was from TMDB before other providers were added.
+type ContentProvider string
+
+const (
+	PROVIDER_TMDB ContentProvider = "tmdb"
+	// Anime, from AniList.
+	PROVIDER_ANILIST ContentProvider = "anilist"
+)
+
+var ErrUnknownProvider = errors.New("unknown content provider")
+
+// A source of content we can search and cache, other than how
+// they are searched and fetched, content from all providers is the same.
+type contentSource interface {
+	search(query string, q SearchQuery) (TMDBSearchMultiResponse, error)
+	fetch(contentType ContentType, id int) (Content, error)
+	// Url to download a poster at size (eg. w500) from, path is a contents PosterPath.
+	posterURL(size string, path string) string
+}
+
+var contentSources = map[ContentProvider]contentSource{
+	PROVIDER_TMDB:    tmdbSource{},
+	PROVIDER_ANILIST: anilistSource{},
+}
+
+// Get source for provider, an empty provider is TMDB.
+func getContentSource(provider ContentProvider) (contentSource, error) {
+	if provider == "" {
+		provider = PROVIDER_TMDB
+	}
+	s, ok := contentSources[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return s, nil
+}
+
+// Url to download a contents poster from, depending on where it is from.
+func contentPosterURL(provider ContentProvider, size string, path string) string {
+	s, err := getContentSource(provider)
+	if err != nil {
+		return tmdbImageURL(size, path)
+	}
+	return s.posterURL(size, path)
+}
+
+// Content from before providers were added is all from TMDB. Its provider
+// columns are filled in before the unique index over them is created.
+func migrateContentProvider(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Content{}) || db.Migrator().HasColumn(&Content{}, "ProviderID") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, f := range []string{"Provider", "ProviderID"} {
+			if !tx.Migrator().HasColumn(&Content{}, f) {
+				if err := tx.Migrator().AddColumn(&Content{}, f); err != nil {
+					return err
+				}
+			}
+		}
+		res := tx.Model(&Content{}).Where("1 = 1").Updates(map[string]interface{}{"provider": PROVIDER_TMDB, "provider_id": gorm.Expr("tmdb_id")})
+		if res.Error != nil {
+			return res.Error
+		}
+		slog.Info("Set provider of existing content to tmdb", "count", res.RowsAffected)
+		return nil
+	})
+}
+
+type tmdbSource struct{}
+
+func (tmdbSource) search(query string, q SearchQuery) (TMDBSearchMultiResponse, error) {
+	return searchContent(query, q)
+}
+
+func (tmdbSource) fetch(contentType ContentType, id int) (Content, error) {
+	return fetchContent(contentType, id)
+}
+
+func (tmdbSource) posterURL(size string, path string) string {
+	return tmdbImageURL(size, path)
+}
+
+const anilistAPIURL = "https://graphql.anilist.co"
+
+// Where AniList covers are served from, our poster paths for
+// AniList content are the file name under /anilist.
+const anilistCoverBase = "https://s4.anilist.co/file/anilistcdn/media/anime/cover/large/"
+
+// Descriptions use html line breaks even when asking for plain text.
+var anilistLineBreaks = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n")
+
+// Results per page of an AniList search, the same as TMDB.
+const anilistPageSize = 20
+
+// Fields we get for every AniList media.
+const anilistMediaFields = `
+	id
+	format
+	status
+	title { romaji english }
+	description(asHtml: false)
+	coverImage { large }
+	bannerImage
+	startDate { year month day }
+	averageScore
+	popularity
+	favourites
+	isAdult
+	episodes
+	duration
+	countryOfOrigin
+	genres
+	nextAiringEpisode { airingAt }
+`
+
+const anilistSearchQuery = `query ($search: String, $page: Int, $perPage: Int, $formats: [MediaFormat], $notFormats: [MediaFormat]) {
+	Page(page: $page, perPage: $perPage) {
+		pageInfo { total lastPage currentPage }
+		media(search: $search, type: ANIME, format_in: $formats, format_not_in: $notFormats, sort: SEARCH_MATCH) {` + anilistMediaFields + `}
+	}
+}`
+
+const anilistMediaQuery = `query ($id: Int) {
+	Media(id: $id, type: ANIME) {` + anilistMediaFields + `}
+}`
+
+type AniListMedia struct {
+	ID     int    `json:"id"`
+	Format string `json:"format"`
+	Status string `json:"status"`
+	Title  struct {
+		Romaji  string `json:"romaji"`
+		English string `json:"english"`
+	} `json:"title"`
+	Description string `json:"description"`
+	CoverImage  struct {
+		Large string `json:"large"`
+	} `json:"coverImage"`
+	BannerImage string `json:"bannerImage"`
+	StartDate   struct {
+		Year  int `json:"year"`
+		Month int `json:"month"`
+		Day   int `json:"day"`
+	} `json:"startDate"`
+	AverageScore    int      `json:"averageScore"`
+	Popularity      int      `json:"popularity"`
+	Favourites      int      `json:"favourites"`
+	IsAdult         bool     `json:"isAdult"`
+	Episodes        int      `json:"episodes"`
+	Duration        int      `json:"duration"`
+	CountryOfOrigin string   `json:"countryOfOrigin"`
+	Genres          []string `json:"genres"`
+	// Nil when nothing is scheduled, airingAt is a unix timestamp.
+	NextAiringEpisode *struct {
+		AiringAt int64 `json:"airingAt"`
+	} `json:"nextAiringEpisode"`
+}
+
+type anilistResponse[T any] struct {
+	Data   T `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+		Status  int    `json:"status"`
+	} `json:"errors"`
+}
+
+type anilistSource struct{}
+
+func (anilistSource) search(query string, q SearchQuery) (TMDBSearchMultiResponse, error) {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	vars := map[string]any{"search": query, "page": q.Page, "perPage": anilistPageSize}
+	switch q.Type {
+	case string(MOVIE):
+		vars["formats"] = []string{"MOVIE"}
+	case string(SHOW):
+		vars["notFormats"] = []string{"MOVIE", "MUSIC"}
+	case "person":
+		return TMDBSearchMultiResponse{}, errors.New("people can't be searched for on anilist")
+	default:
+		vars["notFormats"] = []string{"MUSIC"}
+	}
+	var resp anilistResponse[struct {
+		Page struct {
+			PageInfo struct {
+				Total       int `json:"total"`
+				LastPage    int `json:"lastPage"`
+				CurrentPage int `json:"currentPage"`
+			} `json:"pageInfo"`
+			Media []AniListMedia `json:"media"`
+		} `json:"Page"`
+	}]
+	if err := anilistRequest(anilistSearchQuery, vars, &resp); err != nil {
+		slog.Error("Failed to complete anilist search request!", "error", err)
+		return TMDBSearchMultiResponse{}, errors.New("failed to complete search request")
+	}
+	sr := TMDBSearchMultiResponse{
+		Page:         resp.Data.Page.PageInfo.CurrentPage,
+		Results:      []TMDBSearchMultiResults{},
+		TotalPages:   resp.Data.Page.PageInfo.LastPage,
+		TotalResults: resp.Data.Page.PageInfo.Total,
+	}
+	for _, m := range resp.Data.Page.Media {
+		c := m.toContent()
+		r := TMDBSearchMultiResults{
+			Adult:        m.IsAdult,
+			BackdropPath: m.BannerImage,
+			ID:           m.ID,
+			Overview:     c.Overview,
+			PosterPath:   c.PosterPath,
+			MediaType:    string(c.Type),
+			Popularity:   c.Popularity,
+			VoteAverage:  c.VoteAverage,
+			Provider:     PROVIDER_ANILIST,
+		}
+		date := ""
+		if !c.ReleaseDate.IsZero() {
+			date = c.ReleaseDate.Format(time.DateOnly)
+		}
+		// Same fields TMDB uses for each type, so results look the same to clients.
+		if c.Type == MOVIE {
+			r.Title = c.Title
+			r.OriginalTitle = m.Title.Romaji
+			r.ReleaseDate = date
+		} else {
+			r.Name = c.Title
+			r.OriginalName = m.Title.Romaji
+			r.FirstAirDate = date
+		}
+		sr.Results = append(sr.Results, r)
+	}
+	return sr, nil
+}
+
+func (anilistSource) fetch(contentType ContentType, id int) (Content, error) {
+	var resp anilistResponse[struct {
+		Media *AniListMedia `json:"Media"`
+	}]
+	if err := anilistRequest(anilistMediaQuery, map[string]any{"id": id}, &resp); err != nil {
+		slog.Error("Failed to complete anilist media request!", "id", id, "error", err)
+		return Content{}, errors.New("failed to find content")
+	}
+	if resp.Data.Media == nil {
+		return Content{}, errors.New("failed to find content")
+	}
+	c := resp.Data.Media.toContent()
+	if c.Type != contentType {
+		return Content{}, errors.New("content is a " + string(c.Type) + ", not a " + string(contentType))
+	}
+	return c, nil
+}
+
+func (anilistSource) posterURL(size string, p string) string {
+	// AniList only has a few fixed sizes, we always get the large one.
+	return anilistCoverBase + path.Base(p)
+}
+
+// Convert AniList media to our content. Movies are movies, every other format (tv, ova, etc) is a show.
+func (m AniListMedia) toContent() Content {
+	c := Content{
+		Provider:         PROVIDER_ANILIST,
+		ProviderID:       m.ID,
+		Title:            m.Title.English,
+		OriginalTitle:    m.Title.Romaji,
+		Overview:         sanitizeString(anilistLineBreaks.Replace(m.Description)),
+		Type:             SHOW,
+		Popularity:       float32(m.Popularity),
+		VoteAverage:      float32(m.AverageScore) / 10,
+		VoteCount:        uint32(m.Favourites),
+		Status:           anilistStatus(m.Status),
+		InProduction:     m.Status == "RELEASING" || m.Status == "NOT_YET_RELEASED" || m.Status == "HIATUS",
+		NumberOfEpisodes: uint32(max(m.Episodes, 0)),
+		OriginalLanguage: anilistLanguage(m.CountryOfOrigin),
+		Genres:           JSONList[string](m.Genres),
+	}
+	if c.Title == "" {
+		c.Title = m.Title.Romaji
+	}
+	if m.Format == "MOVIE" {
+		c.Type = MOVIE
+		c.Runtime = uint32(max(m.Duration, 0))
+	}
+	if m.CoverImage.Large != "" {
+		c.PosterPath = "/anilist/" + path.Base(m.CoverImage.Large)
+	}
+	if m.NextAiringEpisode != nil && m.NextAiringEpisode.AiringAt > 0 {
+		d := time.Unix(m.NextAiringEpisode.AiringAt, 0).UTC()
+		c.NextEpisodeAirDate = &d
+	}
+	if m.StartDate.Year != 0 {
+		c.ReleaseDate = time.Date(m.StartDate.Year, time.Month(max(m.StartDate.Month, 1)), max(m.StartDate.Day, 1), 0, 0, 0, 0, time.UTC)
+	}
+	return c
+}
+
+// AniList status in the same form TMDB uses, so shows are treated the same.
+func anilistStatus(s string) string {
+	switch s {
+	case "FINISHED":
+		return "Ended"
+	case "RELEASING":
+		return "Returning Series"
+	case "NOT_YET_RELEASED":
+		return "Planned"
+	case "CANCELLED":
+		return "Canceled"
+	case "HIATUS":
+		return "In Production"
+	}
+	return ""
+}
+
+// Main language of content from a country, AniList only tells us the country.
+func anilistLanguage(country string) string {
+	switch country {
+	case "JP":
+		return "ja"
+	case "KR":
+		return "ko"
+	case "CN", "TW":
+		return "zh"
+	}
+	return ""
+}
+
+// Run a graphql query against AniList, decoding the response into resp.
+func anilistRequest[T any](query string, vars map[string]any, resp *anilistResponse[T]) error {
+	body, err := json.Marshal(map[string]any{"query": query, "variables": vars})
+	if err != nil {
+		return err
+	}
+	res, err := http.Post(anilistAPIURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return err
+	}
+	// Media that doesn't exist is a 404 error, not an empty response.
+	for _, e := range resp.Errors {
+		if e.Status == http.StatusNotFound {
+			return nil
+		}
+	}
+	if res.StatusCode != http.StatusOK || len(resp.Errors) > 0 {
+		msg := []string{}
+		for _, e := range resp.Errors {
+			msg = append(msg, e.Message)
+		}
+		slog.Error("AniList non 200 status code:", "status_code", res.StatusCode, "errors", msg)
+		return errors.New("anilist request failed: " + strings.Join(msg, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How often the refresh job checks for changed content.
+const contentRefreshInterval = 24 * time.Hour
+
+// How old content must be before it is refreshed by a full refresh.
+const contentRefreshAge = 7 * 24 * time.Hour
+
+// Delay between each content refresh, so we don't hammer TMDB.
+const contentRefreshDelay = time.Second
+
+// Furthest back TMDB's changes endpoints go. When we haven't
+// synced changes within this, a full refresh is done instead.
+const contentChangesMaxWindow = 14 * 24 * time.Hour
+
+// Most ids looked up in one query, to stay under sqlites variable limit.
+const contentChangesChunkSize = 500
+
+// Periodically refresh our cached content metadata from TMDB.
+func startContentRefreshJob(db *gorm.DB) {
+	for {
+		refreshChangedContent(db)
+		time.Sleep(contentRefreshInterval)
+	}
+}
+
+// Refresh only the content TMDB reports as changed since we last synced,
+// catching up on every day missed. Falls back to refreshing all stale content
+// on first run, or when we last synced too long ago for TMDB to tell us what changed.
+func refreshChangedContent(db *gorm.DB) {
+	settings, err := getServerSettings(db)
+	if err != nil {
+		return
+	}
+	now := timeNow().UTC()
+	synced := settings.ContentChangesSyncedAt
+	if synced == nil || now.Sub(*synced) >= contentChangesMaxWindow {
+		slog.Info("refreshChangedContent: Changes not synced recently, doing a full refresh", "synced_at", synced)
+		refreshStaleContent(db)
+	} else {
+		content, err := getChangedContent(db, *synced, now)
+		if err != nil {
+			// Window isn't moved on, so these changes are picked up next time.
+			slog.Error("refreshChangedContent: Failed to get changed content", "error", err)
+			return
+		}
+		content = append(content, getAiredShows(db)...)
+		slog.Info("Refreshing changed content", "count", len(content), "since", *synced)
+		refreshContentList(db, content)
+	}
+	res := db.Model(&ServerSettings{}).Where("id = ?", settings.ID).Update("content_changes_synced_at", now)
+	if res.Error != nil {
+		slog.Error("refreshChangedContent: Failed to save changes sync time", "error", res.Error)
+	}
+}
+
+// Get content we have cached that TMDB says changed between start and end.
+func getChangedContent(db *gorm.DB, start time.Time, end time.Time) ([]Content, error) {
+	content := []Content{}
+	for _, t := range []ContentType{MOVIE, SHOW} {
+		ids, err := contentChanges(t, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(ids); i += contentChangesChunkSize {
+			chunk := ids[i:min(i+contentChangesChunkSize, len(ids))]
+			var found []Content
+			if res := db.Model(&Content{}).Where("provider = ? AND type = ? AND tmdb_id IN ?", PROVIDER_TMDB, t, chunk).Find(&found); res.Error != nil {
+				return nil, res.Error
+			}
+			content = append(content, found...)
+		}
+	}
+	return content, nil
+}
+
+// Get ids of all content of type t that changed between start and end.
+func contentChanges(t ContentType, start time.Time, end time.Time) ([]int, error) {
+	ids := []int{}
+	seen := map[int]bool{}
+	for page := 1; ; page++ {
+		var resp TMDBChangesResponse
+		err := tmdbRequest("/"+string(t)+"/changes", map[string]string{
+			"start_date": start.Format(time.DateOnly),
+			"end_date":   end.Format(time.DateOnly),
+			"page":       strconv.Itoa(page),
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Results {
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				ids = append(ids, r.ID)
+			}
+		}
+		if page >= resp.TotalPages {
+			return ids, nil
+		}
+	}
+}
+
+// Get airing shows whose next episode has aired, their next air date needs updating.
+func getAiredShows(db *gorm.DB) []Content {
+	var content []Content
+	res := whereAiring(db.Model(&Content{})).Where("next_episode_air_date < ?", timeNow()).Find(&content)
+	if res.Error != nil {
+		slog.Error("getAiredShows: Failed to get aired shows", "error", res.Error)
+	}
+	return content
+}
+
+func refreshStaleContent(db *gorm.DB) {
+	var content []Content
+	res := db.Model(&Content{}).
+		Where("last_refreshed_at IS NULL OR last_refreshed_at < ?", timeNow().Add(-contentRefreshAge)).
+		// Airing shows whose next episode has aired need their next air date updating.
+		Or(whereAiring(db).Where("next_episode_air_date < ?", timeNow())).
+		Find(&content)
+	if res.Error != nil {
+		slog.Error("refreshStaleContent: Failed to get stale content", "error", res.Error)
+		return
+	}
+	slog.Info("Refreshing stale content", "count", len(content))
+	refreshContentList(db, content)
+}
+
+// Refresh each content, skipping any we've already refreshed (eg. a changed show that also aired).
+func refreshContentList(db *gorm.DB, content []Content) {
+	done := map[int]bool{}
+	for i := range content {
+		if done[content[i].ID] {
+			continue
+		}
+		done[content[i].ID] = true
+		if err := refreshContent(db, &content[i]); err != nil {
+			slog.Error("refreshContentList: Failed to refresh content", "content_id", content[i].ID, "error", err)
+		}
+		time.Sleep(contentRefreshDelay)
+	}
+}
+
+// Refresh a single content row with the latest data from its provider.
+func refreshContent(db *gorm.DB, content *Content) error {
+	source, err := getContentSource(content.Provider)
+	if err != nil {
+		return err
+	}
+	fresh, err := source.fetch(content.Type, content.ProviderID)
+	if err != nil {
+		return err
+	}
+	// Poster is handled separately, it's only swapped once the new one is downloaded.
+	newPosterPath := fresh.PosterPath
+	fresh.ID = content.ID
+	fresh.PosterPath = content.PosterPath
+	fresh.PosterSize = content.PosterSize
+	fresh.CreatedAt = content.CreatedAt
+	// Alternative titles are only fetched when asked for, keep the ones we have.
+	fresh.AlternativeTitles = content.AlternativeTitles
+	fresh.AlternativeTitlesCachedAt = content.AlternativeTitlesCachedAt
+	now := timeNow()
+	fresh.LastRefreshedAt = &now
+	res := db.Save(&fresh)
+	if res.Error != nil {
+		return res.Error
+	}
+	if content.Type == SHOW && content.Status != "" && fresh.Status != content.Status {
+		notifyShowStatusChanged(db, fresh, content.Status)
+	}
+	if newPosterPath != "" && newPosterPath != content.PosterPath {
+		slog.Info("refreshContent: Poster path changed", "content_id", content.ID, "old", content.PosterPath, "new", newPosterPath)
+		// On failure the old poster is kept, we will try again next refresh.
+		replaceContentPoster(db, &fresh, newPosterPath)
+	}
+	*content = fresh
+	return nil
+}
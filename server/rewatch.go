@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWatchedNotFound = errors.New("watched entry not found")
+	ErrReWatchInFuture = errors.New("watchedAt can't be in the future")
+)
+
+// Another viewing of a watched list item, logged separately so the
+// original entry (and its rating) stays as it was.
+type ReWatchEntry struct {
+	GormModel
+	WatchedID uint `json:"watchedId" gorm:"not null;index"`
+	UserID    uint `json:"-" gorm:"not null;index"`
+	// Rating given this viewing, nil when not rated.
+	Rating    *int8     `json:"rating"`
+	Review    string    `json:"review"`
+	WatchedAt time.Time `json:"watchedAt" gorm:"not null"`
+}
+
+type ReWatchRequest struct {
+	Rating *int8  `json:"rating"`
+	Review string `json:"review" binding:"max=5000"`
+	// When it was watched, now if not given.
+	WatchedAt *time.Time `json:"watchedAt"`
+}
+
+type ReWatchResponse struct {
+	ReWatch     ReWatchEntry `json:"rewatch"`
+	NewActivity Activity     `json:"newActivity"`
+}
+
+// Check a watched entry belongs to the user (on profileId).
+func ownsWatched(db *gorm.DB, userId uint, profileId uint, id uint) error {
+	var count int64
+	res := db.Model(&Watched{}).Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Count(&count)
+	if res.Error != nil {
+		slog.Error("ownsWatched: Failed to get watched entry", "id", id, "error", res.Error)
+		return errors.New("failed to get watched entry")
+	}
+	if count == 0 {
+		return ErrWatchedNotFound
+	}
+	return nil
+}
+
+// Log another viewing of a watched entry, with its own rating and review.
+func addReWatch(db *gorm.DB, userId uint, profileId uint, id uint, rr ReWatchRequest) (ReWatchResponse, error) {
+	if err := ownsWatched(db, userId, profileId, id); err != nil {
+		return ReWatchResponse{}, err
+	}
+	entry := ReWatchEntry{WatchedID: id, UserID: userId, Review: sanitizeString(rr.Review), WatchedAt: timeNow().UTC()}
+	if rr.Rating != nil && *rr.Rating != 0 {
+		if err := validateRating(*rr.Rating); err != nil {
+			return ReWatchResponse{}, err
+		}
+		entry.Rating = rr.Rating
+	}
+	if rr.WatchedAt != nil {
+		if rr.WatchedAt.After(timeNow()) {
+			return ReWatchResponse{}, ErrReWatchInFuture
+		}
+		entry.WatchedAt = rr.WatchedAt.UTC()
+	}
+	if res := db.Create(&entry); res.Error != nil {
+		slog.Error("addReWatch: Failed to add rewatch", "watched_id", id, "error", res.Error)
+		return ReWatchResponse{}, errors.New("failed to add rewatch")
+	}
+	data := ""
+	if entry.Rating != nil {
+		data = strconv.Itoa(int(*entry.Rating))
+	}
+	activity, _ := addActivity(db, userId, ActivityAddRequest{WatchedID: id, Type: REWATCHED, Data: data, CustomDate: &entry.WatchedAt})
+	return ReWatchResponse{ReWatch: entry, NewActivity: activity}, nil
+}
+
+// Get every rewatch logged for a watched entry, most recent viewing first.
+func getReWatches(db *gorm.DB, userId uint, profileId uint, id uint) ([]ReWatchEntry, error) {
+	if err := ownsWatched(db, userId, profileId, id); err != nil {
+		return []ReWatchEntry{}, err
+	}
+	rewatches := []ReWatchEntry{}
+	res := db.Model(&ReWatchEntry{}).Where("watched_id = ? AND user_id = ?", id, userId).Order("watched_at DESC, id DESC").Find(&rewatches)
+	if res.Error != nil {
+		slog.Error("getReWatches: Failed to get rewatches", "watched_id", id, "error", res.Error)
+		return []ReWatchEntry{}, errors.New("failed to get rewatches")
+	}
+	return rewatches, nil
+}
+
+// Rewatches of a users (current) watched list items on profileId.
+func reWatchesOf(db *gorm.DB, userId uint, profileId uint) *gorm.DB {
+	return db.Model(&ReWatchEntry{}).
+		Joins("JOIN watcheds ON watcheds.id = re_watch_entries.watched_id AND watcheds.deleted_at IS NULL").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId)
+}
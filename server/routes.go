@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -24,293 +29,2203 @@ func newBaseRouter(db *gorm.DB, rg *gin.RouterGroup) *BaseRouter {
 	}
 }
 
+// Register all of our api routes on the routers group.
+func (b *BaseRouter) addAPIRoutes() {
+	b.addAuthRoutes()
+	b.addContentRoutes()
+	b.addWatchedRoutes()
+	b.addWatchedDuplicatesRoutes()
+	b.addActivityRoutes()
+	b.addProfileRoutes()
+	b.addSubProfileRoutes()
+	b.addAdminRoutes()
+	b.addNotificationRoutes()
+	b.addGoalRoutes()
+	b.addImportRoutes()
+	b.addJobRoutes()
+	b.addTagRoutes()
+	b.addConfigRoutes()
+	b.addDocsRoutes()
+	b.rg.Static("/img", dataPath(imgDir))
+}
+
+func (b *BaseRouter) addConfigRoutes() {
+	b.rg.GET("/config", b.handleGetServerConfig)
+}
+
+// Get server config the frontend needs before anyone has logged in
+func (b *BaseRouter) handleGetServerConfig(c *gin.Context) {
+	response, err := getPublicServerConfig(b.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Marks responses from deprecated (unversioned) api routes as such,
+// pointing clients to the versioned routes that replace them.
+func deprecatedAPI(successorPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successorPrefix+strings.TrimPrefix(c.Request.URL.Path, legacyAPIPrefix)))
+		c.Next()
+	}
+}
+
 func (b *BaseRouter) addContentRoutes() {
-	content := b.rg.Group("/content").Use(AuthRequired())
+	content := b.rg.Group("/content").Use(AuthRequired(b.db))
 
 	// Get trending content
 	// content.GET("/", func(c *gin.Context) {
 	// 	c.JSON(http.StatusOK, getWatched(b.db))
 	// })
 
-	// Search for content
-	content.GET("/:query", func(c *gin.Context) {
-		println(c.Param("query"))
-		if c.Param("query") == "" {
-			c.Status(400)
-			return
-		}
-		content, err := searchContent(c.Param("query"))
+	content.GET("/:query", b.handleSearchContent)
+	content.GET("/find/:externalId", b.handleFindContentByExternalID)
+	content.GET("/movie/:id", b.handleGetMovie)
+	content.GET("/movie/:id/credits", b.handleGetMovieCredits)
+	content.GET("/movie/:id/keywords", b.handleGetKeywords(MOVIE))
+	content.GET("/movie/:id/alternative_titles", b.handleGetAlternativeTitles(MOVIE))
+	content.GET("/movies/upcoming", b.handleGetContentList(LIST_UPCOMING, MOVIE))
+	content.GET("/movies/now-playing", b.handleGetContentList(LIST_NOW_PLAYING, MOVIE))
+	content.GET("/movies/top-rated", b.handleGetContentList(LIST_TOP_RATED, MOVIE))
+	content.GET("/tv/:id", b.handleGetTv)
+	content.GET("/tv/:id/credits", b.handleGetTvCredits)
+	content.GET("/tv/:id/keywords", b.handleGetKeywords(SHOW))
+	content.GET("/tv/:id/alternative_titles", b.handleGetAlternativeTitles(SHOW))
+	content.GET("/tv/upcoming", b.handleGetContentList(LIST_UPCOMING, SHOW))
+	content.GET("/tv/airing-today", b.handleGetContentList(LIST_NOW_PLAYING, SHOW))
+	content.GET("/tv/top-rated", b.handleGetContentList(LIST_TOP_RATED, SHOW))
+	content.GET("/tv/:id/season/:num", b.handleGetSeason)
+	content.GET("/tv/:id/season/:num/episode/:ep/credits", b.handleGetEpisodeCredits)
+	content.GET("/person/:id", b.handleGetPerson)
+	content.GET("/person/:id/credits", b.handleGetPersonCredits)
+	content.GET("/person/:id/credits/combined", b.handleGetPersonCombinedCredits)
+	content.GET("/discover/keyword/:id", b.handleDiscoverByKeyword)
+	content.GET("/discover/provider", b.handleDiscoverByProvider)
+	content.GET("/watch-providers", b.handleGetWatchProviders)
+	content.GET("/provider/:provider/:type/:id", b.handleGetProviderContent)
+}
+
+// Search for content
+func (b *BaseRouter) handleSearchContent(c *gin.Context) {
+	slog.Debug("Searching for content", "query", c.Param("query"))
+	if c.Param("query") == "" {
+		c.Status(400)
+		return
+	}
+	var q SearchQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	source, err := getContentSource(q.Provider)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	content, err := source.search(c.Param("query"), q)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	settings, err := getUserSettings(b.db, c.MustGet("userId").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	// Filter out results above users max rating server side, so they never reach the client.
+	content.Results = filterSearchByCertification(b.db, settings, content.Results)
+	markSearchInLibrary(b.db, c.MustGet("userId").(uint), c.MustGet("profileId").(uint), content.Results)
+	c.JSON(http.StatusOK, content)
+}
+
+// Find content by an external id (eg. imdb)
+func (b *BaseRouter) handleFindContentByExternalID(c *gin.Context) {
+	q := ExternalIDQuery{Source: "imdb"}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	content, err := findByExternalID(c.Param("externalId"), q.Source)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// Get movie details (for movie page)
+func (b *BaseRouter) handleGetMovie(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := movieDetails(b.db, id)
+	if err != nil {
+		c.JSON(tmdbErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	content.OthersWatched = getOthersWatched(b.db, c.MustGet("userId").(uint), MOVIE, id)
+	c.JSON(http.StatusOK, content)
+}
+
+// Get movie cast
+func (b *BaseRouter) handleGetMovieCredits(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := movieCredits(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// Get movie or tv keywords
+func (b *BaseRouter) handleGetKeywords(contentType ContentType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := tmdbIDParam(c, "id")
+		if !ok {
+			return
+		}
+		keywords, err := contentKeywords(b.db, contentType, id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, keywords)
+	}
+}
+
+// Get movie or tv alternative titles
+func (b *BaseRouter) handleGetAlternativeTitles(contentType ContentType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := tmdbIDParam(c, "id")
+		if !ok {
+			return
+		}
+		titles, err := contentAlternativeTitles(b.db, contentType, id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, titles)
+	}
+}
+
+// Get a page of a content list (eg. upcoming movies)
+func (b *BaseRouter) handleGetContentList(list ContentList, contentType ContentType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var q ContentListQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		content, err := getContentList(b.db, c.MustGet("userId").(uint), c.MustGet("profileId").(uint), list, contentType, q)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, content)
+	}
+}
+
+// Get tv details (for tv page)
+func (b *BaseRouter) handleGetTv(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := tvDetails(id)
+	if err != nil {
+		c.JSON(tmdbErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	content.OthersWatched = getOthersWatched(b.db, c.MustGet("userId").(uint), SHOW, id)
+	c.JSON(http.StatusOK, content)
+}
+
+// Get tv cast
+func (b *BaseRouter) handleGetTvCredits(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := tvCredits(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// Get season details
+func (b *BaseRouter) handleGetSeason(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	num, ok := intParam(c, "num", 0)
+	if !ok {
+		return
+	}
+	content, err := seasonDetails(id, strconv.Itoa(num))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	queueEpisodeStills(b.db, c.MustGet("userId").(uint), id, num, content)
+	c.JSON(http.StatusOK, content)
+}
+
+// Get who is credited on an episode, including guest stars
+func (b *BaseRouter) handleGetEpisodeCredits(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	num, ok := intParam(c, "num", 0)
+	if !ok {
+		return
+	}
+	ep, ok := intParam(c, "ep", 1)
+	if !ok {
+		return
+	}
+	credits, err := episodeCredits(b.db, id, strconv.Itoa(num), strconv.Itoa(ep))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, credits)
+}
+
+// Discover content tagged with a keyword
+func (b *BaseRouter) handleDiscoverByKeyword(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	var q KeywordDiscoverQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	content, err := discoverByKeyword(id, q)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	settings, err := getUserSettings(b.db, c.MustGet("userId").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	content.Results = filterSearchByCertification(b.db, settings, content.Results)
+	markSearchInLibrary(b.db, c.MustGet("userId").(uint), c.MustGet("profileId").(uint), content.Results)
+	c.JSON(http.StatusOK, content)
+}
+
+// Discover content streaming on watch providers in a region
+func (b *BaseRouter) handleDiscoverByProvider(c *gin.Context) {
+	var q ProviderDiscoverQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if q.Type == "" {
+		q.Type = MOVIE
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	settings, err := getUserSettings(b.db, c.MustGet("userId").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	region, err := watchProviderRegion(settings, q.Region)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	providers, err := parseWatchProviders(q.Type, region, q.Providers)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	content, err := discoverByProvider(q.Type, region, providers, q.Page)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	// Copy, so the cached results aren't changed.
+	content.Results = filterSearchByCertification(b.db, settings, append([]TMDBSearchMultiResults{}, content.Results...))
+	markSearchInLibrary(b.db, c.MustGet("userId").(uint), c.MustGet("profileId").(uint), content.Results)
+	c.JSON(http.StatusOK, content)
+}
+
+// Get streaming services available in a region
+func (b *BaseRouter) handleGetWatchProviders(c *gin.Context) {
+	var q WatchProvidersQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if q.Type == "" {
+		q.Type = MOVIE
+	}
+	settings, err := getUserSettings(b.db, c.MustGet("userId").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	region, err := watchProviderRegion(settings, q.Region)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getWatchProviders(q.Type, region)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get person details
+func (b *BaseRouter) handleGetPerson(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := personDetails(id)
+	if err != nil {
+		c.JSON(tmdbErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// Get person credits
+func (b *BaseRouter) handleGetPersonCredits(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := personCredits(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// Get person cast and crew credits merged into one list
+func (b *BaseRouter) handleGetPersonCombinedCredits(c *gin.Context) {
+	id, ok := tmdbIDParam(c, "id")
+	if !ok {
+		return
+	}
+	var q PersonCombinedCreditsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	content, err := personCombinedCredits(id, q.Sort)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+// Get content from any provider, in the same form it is cached in.
+// For content without a details page (eg. from anilist).
+func (b *BaseRouter) handleGetProviderContent(c *gin.Context) {
+	source, err := getContentSource(ContentProvider(c.Param("provider")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	contentType := ContentType(c.Param("type"))
+	if contentType != MOVIE && contentType != SHOW {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "type must be movie or tv"})
+		return
+	}
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	content, err := source.fetch(contentType, id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, content)
+}
+
+func (b *BaseRouter) addWatchedRoutes() {
+	watched := b.rg.Group("/watched").Use(AuthRequired(b.db))
+
+	watched.GET("", b.handleGetWatched)
+	watched.POST("", b.handleAddWatched)
+	watched.PUT("reorder", b.handleReorderWatched)
+	watched.GET("stats/count", b.handleGetWatchedCount)
+	watched.GET("stats/monthly", b.handleGetWatchedMonthly)
+	watched.GET("stats/watchtime", b.handleGetWatchTime)
+	watched.GET("services", b.handleGetWatchedServices)
+	watched.GET("search", b.handleSearchWatched)
+	watched.GET("random", b.handleGetRandomWatched)
+	watched.GET("random/batch", b.handleGetRandomWatchedBatch)
+	watched.GET(":id", b.handleGetWatchedItem)
+	watched.PUT(":id", b.handleUpdateWatched)
+	watched.PUT(":id/progress", b.handleUpdateWatchedProgress)
+	watched.DELETE(":id", b.handleRemoveWatched)
+	watched.POST(":id/rewatch", b.handleAddReWatch)
+	watched.GET(":id/rewatches", b.handleGetReWatches)
+	watched.POST(":id/season/:num/complete", b.handleCompleteWatchedSeason)
+	watched.DELETE(":id/season/:num/complete", b.handleUncompleteWatchedSeason)
+}
+
+func (b *BaseRouter) handleGetWatched(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var f WatchedFilters
+	if err := c.ShouldBindQuery(&f); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if f.Tag != 0 {
+		if _, err := getTag(b.db, userId, profileId, f.Tag); err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, getWatched(b.db, userId, profileId, f))
+}
+
+func (b *BaseRouter) handleAddWatched(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var ar WatchedAddRequest
+	err := c.ShouldBindJSON(&ar)
+	if err == nil {
+		if ar.ContentID == 0 {
+			candidates, err := resolveWatchedAddImdbID(&ar)
+			if err != nil {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+				return
+			}
+			if candidates != nil {
+				c.JSON(http.StatusMultipleChoices, WatchedAddAmbiguousResponse{Error: "imdb id matches multiple items, provide contentType", Candidates: candidates})
+				return
+			}
+		}
+		response, err := addWatched(b.db, userId, profileId, ar, SOURCE_MANUAL)
+		if err != nil {
+			if errors.Is(err, ErrWatchedDateInFuture) || errors.Is(err, ErrInvalidRating) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Set custom order of watched list
+func (b *BaseRouter) handleReorderWatched(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var rr WatchedReorderRequest
+	if err := c.ShouldBindJSON(&rr); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := reorderWatched(b.db, userId, profileId, rr); err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// Get counts of watched list items
+func (b *BaseRouter) handleGetWatchedCount(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, etag, err := getWatchedCount(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get number of watched list items added per month
+func (b *BaseRouter) handleGetWatchedMonthly(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var q WatchedStatsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	settings, err := getUserSettings(b.db, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	loc, err := getUserLocation(settings, q.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getWatchedMonthly(b.db, userId, profileId, loc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get total time spent watching, overall and per year
+func (b *BaseRouter) handleGetWatchTime(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var q WatchedStatsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	settings, err := getUserSettings(b.db, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	loc, err := getUserLocation(settings, q.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getWatchTime(b.db, userId, profileId, loc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get services items were watched on, with counts
+func (b *BaseRouter) handleGetWatchedServices(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getWatchedServices(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleSearchWatched(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var q WatchedSearchQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := searchWatched(b.db, userId, profileId, q.Query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Pick a random entry from the (filtered) watched list
+func (b *BaseRouter) handleGetRandomWatched(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var f WatchedFilters
+	if err := c.ShouldBindQuery(&f); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getRandomWatched(b.db, userId, profileId, f, 1)
+	if err != nil {
+		if errors.Is(err, ErrNoWatchedMatch) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response[0])
+}
+
+// Pick a few random entries from the (filtered) watched list, ?count= (default 5)
+func (b *BaseRouter) handleGetRandomWatchedBatch(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	q := RandomWatchedQuery{Count: 5}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getRandomWatched(b.db, userId, profileId, q.WatchedFilters, q.Count)
+	if err != nil {
+		if errors.Is(err, ErrNoWatchedMatch) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleGetWatchedItem(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getWatchedItem(b.db, userId, profileId, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleUpdateWatched(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var ur WatchedUpdateRequest
+	err := c.ShouldBindJSON(&ur)
+	if err == nil {
+		response, err := updateWatched(b.db, userId, profileId, uint(id), ur)
+		if err != nil {
+			if errors.Is(err, ErrInvalidRating) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Update how far through a movie playback is
+func (b *BaseRouter) handleUpdateWatchedProgress(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var pr WatchedProgressRequest
+	err := c.ShouldBindJSON(&pr)
+	if err == nil {
+		response, err := updateWatchedProgress(b.db, userId, profileId, uint(id), pr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+func (b *BaseRouter) handleRemoveWatched(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := removeWatched(b.db, userId, profileId, uint(id))
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Log another viewing of a watched list item
+func (b *BaseRouter) handleAddReWatch(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var rr ReWatchRequest
+	if err := c.ShouldBindJSON(&rr); err != nil {
+		c.JSON(bodyErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := addReWatch(b.db, userId, profileId, uint(id), rr)
+	if err != nil {
+		if errors.Is(err, ErrWatchedNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleGetReWatches(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getReWatches(b.db, userId, profileId, uint(id))
+	if err != nil {
+		if errors.Is(err, ErrWatchedNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Mark all episodes in a season as watched
+func (b *BaseRouter) handleCompleteWatchedSeason(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	num, ok := intParam(c, "num", 0)
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var sr WatchedSeasonCompleteRequest
+	// Body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&sr); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	response, err := completeWatchedSeason(b.db, userId, profileId, uint(id), num, sr)
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Unmark all episodes in a season as watched
+func (b *BaseRouter) handleUncompleteWatchedSeason(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	num, ok := intParam(c, "num", 0)
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := uncompleteWatchedSeason(b.db, userId, profileId, uint(id), num)
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) addWatchedDuplicatesRoutes() {
+	duplicates := b.rg.Group("/watched/duplicates").Use(AuthRequired(b.db))
+
+	duplicates.GET("", b.handleGetWatchedDuplicates)
+	duplicates.POST("/merge", b.handleMergeWatchedDuplicates)
+}
+
+// Get content that is on watched list more than once
+func (b *BaseRouter) handleGetWatchedDuplicates(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getWatchedDuplicates(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Merge duplicate watched entries
+func (b *BaseRouter) handleMergeWatchedDuplicates(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var mr DuplicatesMergeRequest
+	err := c.ShouldBindJSON(&mr)
+	if err == nil {
+		response, err := mergeWatchedDuplicates(b.db, userId, profileId, mr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+func (b *BaseRouter) addActivityRoutes() {
+	activity := b.rg.Group("/activity").Use(AuthRequired(b.db))
+
+	activity.GET(":watchedId", b.handleGetActivity)
+	activity.POST("", b.handleAddActivity)
+}
+
+func (b *BaseRouter) handleGetActivity(c *gin.Context) {
+	watchedId, ok := idParam(c, "watchedId")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var f ActivityFilters
+	if err := c.ShouldBindQuery(&f); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	activity, err := getActivity(b.db, userId, profileId, uint(watchedId), f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, activity)
+}
+
+func (b *BaseRouter) handleAddActivity(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var ar ActivityAddRequest
+	err := c.ShouldBindJSON(&ar)
+	if err == nil {
+		ar.Data = sanitizeString(ar.Data)
+		response, err := addProfileActivity(b.db, userId, profileId, ar)
+		if err != nil {
+			if errors.Is(err, ErrActivityWatchedNotFound) {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+func (b *BaseRouter) addAuthRoutes() {
+	auth := b.rg.Group("/auth")
+
+	auth.POST("/", b.handleLogin)
+	auth.POST("/jellyfin", b.handleLoginJellyfin)
+	auth.POST("/register", b.handleRegister)
+	auth.GET("/available", b.handleGetAvailableAuthProviders)
+	auth.GET("/me", AuthRequired(b.db), b.handleGetMe)
+	auth.PUT("/password", AuthRequired(b.db), b.handleChangePassword)
+	auth.POST("/logout", b.handleLogout)
+	auth.POST("/verify-2fa", b.handleVerify2FA)
+	auth.POST("/2fa/setup", AuthRequired(b.db), b.handleSetup2FA)
+	auth.POST("/2fa/enable", AuthRequired(b.db), b.handleEnable2FA)
+	auth.POST("/2fa/disable", AuthRequired(b.db), b.handleDisable2FA)
+}
+
+// Login
+func (b *BaseRouter) handleLogin(c *gin.Context) {
+	var user User
+	if c.ShouldBindJSON(&user) == nil {
+		response, err := login(&user, b.db)
+		if err != nil {
+			recordAuthEvent(b.db, c, AUTH_LOGIN_FAILED, authLogUserId(b.db, user.Username), user.Username, err.Error())
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		// With 2fa, the login is recorded once the code is verified.
+		if !response.MFARequired {
+			recordAuthEvent(b.db, c, AUTH_LOGIN_SUCCESS, &response.User.ID, response.User.Username, "")
+		}
+		setAuthCookie(c, response.Token)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.Status(400)
+}
+
+// Jellyfin login
+func (b *BaseRouter) handleLoginJellyfin(c *gin.Context) {
+	var lr JellyfinLoginRequest
+	err := c.ShouldBindJSON(&lr)
+	if err == nil {
+		response, err := loginJellyfin(lr, b.db)
+		if err != nil {
+			if errors.Is(err, ErrJellyfinServerNotFound) {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+				return
+			}
+			recordAuthEvent(b.db, c, AUTH_LOGIN_FAILED, nil, lr.Username, "jellyfin: "+err.Error())
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		recordAuthEvent(b.db, c, AUTH_LOGIN_SUCCESS, &response.User.ID, response.User.Username, "jellyfin")
+		setAuthCookie(c, response.Token)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Register
+func (b *BaseRouter) handleRegister(c *gin.Context) {
+	var user User
+	if c.ShouldBindJSON(&user) == nil {
+		response, err := register(&user, b.db)
+		if err != nil {
+			if errors.Is(err, ErrUserExists) {
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, ErrPasswordHash) {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		recordAuthEvent(b.db, c, AUTH_REGISTER, &response.User.ID, response.User.Username, "")
+		setAuthCookie(c, response.Token)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.Status(400)
+}
+
+// Get available auth providers
+func (b *BaseRouter) handleGetAvailableAuthProviders(c *gin.Context) {
+	response, err := getAvailableAuthProviders(b.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get basic info about the authenticated user, served straight
+// from what AuthRequired already loaded, so no extra query is needed.
+func (b *BaseRouter) handleGetMe(c *gin.Context) {
+	user := c.MustGet("user").(User)
+	c.JSON(http.StatusOK, AuthMeResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Type:      user.Type,
+		CreatedAt: user.CreatedAt,
+		IsAdmin:   user.Permissions&PERM_ADMIN != 0,
+	})
+}
+
+// Change the authenticated users password
+func (b *BaseRouter) handleChangePassword(c *gin.Context) {
+	var pr PasswordChangeRequest
+	err := c.ShouldBindJSON(&pr)
+	if err == nil {
+		user := c.MustGet("user").(User)
+		response, err := changePassword(b.db, user.ID, pr)
+		if err != nil {
+			if errors.Is(err, ErrPasswordHash) {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+				return
+			}
+			recordAuthEvent(b.db, c, AUTH_PASSWORD_CHANGE_FAILED, &user.ID, user.Username, err.Error())
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		recordAuthEvent(b.db, c, AUTH_PASSWORD_CHANGED, &user.ID, user.Username, "")
+		setAuthCookie(c, response.Token)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Logout, clears the auth cookie. Header tokens are just forgotten by the client.
+func (b *BaseRouter) handleLogout(c *gin.Context) {
+	// Logout doesn't need a valid token, but when there is one we know who it was.
+	if token, err := parseAuthToken(getRequestToken(c)); err == nil && token.Valid {
+		claims := token.Claims.(*TokenClaims)
+		recordAuthEvent(b.db, c, AUTH_LOGOUT, &claims.UserID, claims.Username, "")
+	}
+	clearAuthCookie(c)
+	c.Status(http.StatusOK)
+}
+
+// Exchange the mfa token from a login and a TOTP code for an auth token
+func (b *BaseRouter) handleVerify2FA(c *gin.Context) {
+	var vr MFAVerifyRequest
+	err := c.ShouldBindJSON(&vr)
+	if err == nil {
+		response, err := verifyMFA(b.db, vr)
+		if err != nil {
+			var userId *uint
+			if id := mfaTokenUserId(vr.MFAToken); id != 0 {
+				userId = &id
+			}
+			recordAuthEvent(b.db, c, AUTH_LOGIN_FAILED, userId, "", "2fa: "+err.Error())
+			if errors.Is(err, ErrInvalidMFAToken) {
+				c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		recordAuthEvent(b.db, c, AUTH_LOGIN_SUCCESS, &response.User.ID, response.User.Username, "2fa")
+		setAuthCookie(c, response.Token)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Generate a new TOTP secret for the authenticated user
+func (b *BaseRouter) handleSetup2FA(c *gin.Context) {
+	response, err := setupTOTP(b.db, c.MustGet("userId").(uint))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Enable 2fa, once a code from the secret generated in setup is provided
+func (b *BaseRouter) handleEnable2FA(c *gin.Context) {
+	var cr TOTPCodeRequest
+	err := c.ShouldBindJSON(&cr)
+	if err == nil {
+		err = enableTOTP(b.db, c.MustGet("userId").(uint), cr.Code)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Disable 2fa, a current code is required
+func (b *BaseRouter) handleDisable2FA(c *gin.Context) {
+	var cr TOTPCodeRequest
+	err := c.ShouldBindJSON(&cr)
+	if err == nil {
+		err = disableTOTP(b.db, c.MustGet("userId").(uint), cr.Code)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+func (b *BaseRouter) addProfileRoutes() {
+	profile := b.rg.Group("/profile").Use(AuthRequired(b.db))
+
+	profile.GET("", b.handleGetProfile)
+	profile.GET("/user/:username", b.handleGetPublicProfile)
+	profile.PUT("", b.handleUpdateProfile)
+	profile.GET("/upcoming", b.handleGetUpcoming)
+	profile.GET("/stats/countries", b.handleGetCountryStats)
+	profile.GET("/logins", b.handleGetRecentLogins)
+	profile.PUT("/goal", b.handleSetWatchGoal)
+	profile.GET("/goal/:year", b.handleGetWatchGoalProgress)
+	profile.GET("/wrapped/:year", b.handleGetYearlyWrapped)
+	profile.GET("/settings", b.handleGetUserSettings)
+	profile.PUT("/settings", b.handleUpdateUserSettings)
+	profile.GET("/export", b.handleExportAccount)
+	profile.POST("/import", b.handleImportAccount)
+}
+
+// Get user profile details
+func (b *BaseRouter) handleGetProfile(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getProfile(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	_, response.Impersonating = c.Get("impersonatorId")
+	c.JSON(http.StatusOK, response)
+}
+
+// Get a summary of the users year of watching
+func (b *BaseRouter) handleGetYearlyWrapped(c *gin.Context) {
+	year, ok := intParam(c, "year", 1900)
+	if !ok {
+		return
+	}
+	var q WatchedStatsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	settings, err := getUserSettings(b.db, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	loc, err := getUserLocation(settings, q.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getYearlyWrapped(b.db, userId, profileId, year, loc)
+	if err != nil {
+		if errors.Is(err, ErrWrappedFutureYear) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get another users profile, if they share with the instance
+func (b *BaseRouter) handleGetPublicProfile(c *gin.Context) {
+	response, err := getPublicProfile(b.db, c.Param("username"))
+	if err != nil {
+		if errors.Is(err, ErrProfilePrivate) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Update user profile details
+func (b *BaseRouter) handleUpdateProfile(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var ur UserProfileUpdateRequest
+	err := c.ShouldBindJSON(&ur)
+	if err == nil {
+		response, err := updateUserProfile(b.db, userId, ur)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Get users tracked shows that are still airing
+func (b *BaseRouter) handleGetUpcoming(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getUpcoming(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get number of watched list items from each production country
+// Get the users recent logins and failed login attempts, most recent first
+func (b *BaseRouter) handleGetRecentLogins(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var q PageQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getRecentLogins(b.db, userId, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleGetCountryStats(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getCountryStats(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get user settings
+func (b *BaseRouter) handleGetUserSettings(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	response, err := getUserSettings(b.db, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Update user settings
+func (b *BaseRouter) handleUpdateUserSettings(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var ur UserSettingsUpdateRequest
+	err := c.ShouldBindJSON(&ur)
+	if err == nil {
+		response, err := updateUserSettings(b.db, userId, ur)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Download all of the users data
+func (b *BaseRouter) handleExportAccount(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	c.Header("Content-Disposition", `attachment; filename="watcharr-export.json"`)
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	// Export is streamed, so once it has started we can't send an error response.
+	if err := exportAccount(b.db, userId, c.Writer); err != nil {
+		slog.Error("Account export failed", "userId", userId, "error", err)
+	}
+}
+
+// Restore an account export, ?dryRun=true to only get the report
+func (b *BaseRouter) handleImportAccount(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var q ImportQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	var bundle AccountImportBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		c.JSON(bodyErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := importAccount(b.db, userId, bundle, q.DryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) addSubProfileRoutes() {
+	profiles := b.rg.Group("/profiles").Use(AuthRequired(b.db))
+
+	profiles.GET("", b.handleGetSubProfiles)
+	profiles.POST("", b.handleAddSubProfile)
+	profiles.DELETE(":id", b.handleRemoveSubProfile)
+	profiles.POST(":id/token", b.handleGetSubProfileToken)
+}
+
+// Get users sub profiles
+func (b *BaseRouter) handleGetSubProfiles(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	response, err := getSubProfiles(b.db, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Add a sub profile
+func (b *BaseRouter) handleAddSubProfile(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var ar SubProfileAddRequest
+	err := c.ShouldBindJSON(&ar)
+	if err == nil {
+		response, err := addSubProfile(b.db, userId, ar)
+		if err != nil {
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Remove a sub profile (and its watched list)
+func (b *BaseRouter) handleRemoveSubProfile(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	err := removeSubProfile(b.db, userId, uint(id))
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// Get a token scoped to a sub profile
+func (b *BaseRouter) handleGetSubProfileToken(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	response, err := signSubProfileToken(b.db, userId, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) addAdminRoutes() {
+	admin := b.rg.Group("/admin").Use(AuthRequired(b.db), AdminRequired())
+
+	admin.GET("/users", b.handleGetAdminUsers)
+	admin.POST("/users/merge", b.handleMergeUsers)
+	admin.PUT("/users/:id/reset-password", b.handleResetUserPassword)
+	admin.DELETE("/users/:id", b.handleDeleteUser)
+	admin.POST("/users/:id/promote", b.handlePromoteUser)
+	admin.POST("/users/:id/demote", b.handleDemoteUser)
+	admin.POST("/users/:id/impersonate", b.handleImpersonateUser)
+	admin.GET("/stats", b.handleGetAdminStats)
+	admin.GET("/auth-logs", b.handleGetAuthLogs)
+	admin.PUT("/loglevel", b.handleSetLogLevel)
+	admin.POST("/repair/posters", b.handleRepairPosters)
+	admin.POST("/repair/content-duplicates", b.handleMergeDuplicateContent)
+	admin.GET("/settings", b.handleGetServerSettings)
+	admin.PUT("/settings", b.handleUpdateServerSettings)
+	admin.GET("/content", b.handleGetAdminContent)
+	admin.DELETE("/content/:id", b.handleDeleteAdminContent)
+	admin.POST("/content/:id/refresh", b.handleRefreshAdminContent)
+	admin.POST("/content/:id/redownload-images", b.handleRedownloadContentImages)
+	// Same as /repair/posters, alongside the other content tools.
+	admin.POST("/content/redownload-all-missing", b.handleRepairPosters)
+	admin.GET("/jellyfin-servers", b.handleGetJellyfinServers)
+	admin.POST("/jellyfin-servers", b.handleAddJellyfinServer)
+	admin.PUT("/jellyfin-servers/:id", b.handleUpdateJellyfinServer)
+	admin.DELETE("/jellyfin-servers/:id", b.handleDeleteJellyfinServer)
+}
+
+// Get a page of users
+func (b *BaseRouter) handleGetAdminUsers(c *gin.Context) {
+	var q AdminUsersQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getAdminUsers(b.db, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Merge one user into another
+func (b *BaseRouter) handleMergeUsers(c *gin.Context) {
+	var mr UserMergeRequest
+	err := c.ShouldBindJSON(&mr)
+	if err == nil {
+		response, err := mergeUsers(b.db, mr)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
 
-	// Get movie details (for movie page)
-	content.GET("/movie/:id", func(c *gin.Context) {
-		if c.Param("id") == "" {
-			c.Status(400)
+// Reset a users password to a temporary one
+func (b *BaseRouter) handleResetUserPassword(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var rr AdminPasswordResetRequest
+	// Body is optional, a temporary password is generated without one.
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&rr); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		content, err := movieDetails(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	}
+	adminId := c.MustGet("userId").(uint)
+	response, err := resetUserPassword(b.db, adminId, uint(id), rr)
+	if err != nil {
+		if errors.Is(err, ErrPasswordHash) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	userId := uint(id)
+	recordAuthEvent(b.db, c, AUTH_PASSWORD_RESET_BY_ADMIN, &userId, "", "by admin "+strconv.Itoa(int(adminId)))
+	c.JSON(http.StatusOK, response)
+}
 
-	// Get movie cast
-	content.GET("/movie/:id/credits", func(c *gin.Context) {
-		if c.Param("id") == "" {
-			c.Status(400)
+// Get a short lived token to act as a user
+func (b *BaseRouter) handleImpersonateUser(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	adminId := c.MustGet("userId").(uint)
+	response, err := impersonateUser(b.db, adminId, uint(id))
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		content, err := movieCredits(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		if errors.Is(err, ErrImpersonateAdmin) {
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	admin := c.MustGet("user").(User)
+	recordAuthEvent(b.db, c, AUTH_IMPERSONATION_STARTED, &response.UserID, response.Username,
+		fmt.Sprintf("by admin %s (%d), expires %s", admin.Username, adminId, response.ExpiresAt.Format(time.RFC3339)))
+	c.JSON(http.StatusOK, response)
+}
 
-	// Get tv details (for tv page)
-	content.GET("/tv/:id", func(c *gin.Context) {
-		if c.Param("id") == "" {
-			c.Status(400)
+// Delete a user and all of their data
+func (b *BaseRouter) handleDeleteUser(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	err := deleteUserWithCascade(b.db, c.MustGet("userId").(uint), uint(id))
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		content, err := tvDetails(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Make a user an admin
+func (b *BaseRouter) handlePromoteUser(c *gin.Context) {
+	b.handleSetUserAdmin(c, true)
+}
+
+// Remove a users admin permission
+func (b *BaseRouter) handleDemoteUser(c *gin.Context) {
+	b.handleSetUserAdmin(c, false)
+}
+
+func (b *BaseRouter) handleSetUserAdmin(c *gin.Context, admin bool) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	err := setUserAdmin(b.db, uint(id), admin)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
-
-	// Get tv cast
-	content.GET("/tv/:id/credits", func(c *gin.Context) {
-		if c.Param("id") == "" {
-			c.Status(400)
+		if errors.Is(err, ErrLastAdmin) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
 			return
 		}
-		content, err := tvCredits(c.Param("id"))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Get server wide usage stats
+// Get recent auth events (logins, password changes, etc), most recent first
+func (b *BaseRouter) handleGetAuthLogs(c *gin.Context) {
+	var q AuthLogsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getAuthLogs(b.db, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleGetAdminStats(c *gin.Context) {
+	var q AdminStatsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getAdminStats(b.db, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Change the log level until restarted
+func (b *BaseRouter) handleSetLogLevel(c *gin.Context) {
+	var lr LogLevelRequest
+	err := c.ShouldBindJSON(&lr)
+	if err == nil {
+		response, err := setLogLevel(lr)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
 
-	// Get season details
-	content.GET("/tv/:id/season/:num", func(c *gin.Context) {
-		if c.Param("id") == "" || c.Param("num") == "" {
-			c.Status(400)
-			return
-		}
-		content, err := seasonDetails(c.Param("id"), c.Param("num"))
+// Re-download any content posters missing from disk, in the background.
+// The repairs job is returned, its result is a PosterRepairResponse.
+func (b *BaseRouter) handleRepairPosters(c *gin.Context) {
+	job, err := queuePosterRepair(b.db, c.MustGet("userId").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Merge content rows that are for the same TMDB content
+func (b *BaseRouter) handleMergeDuplicateContent(c *gin.Context) {
+	response, err := mergeDuplicateContent(b.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Get server settings
+func (b *BaseRouter) handleGetServerSettings(c *gin.Context) {
+	response, err := getServerSettings(b.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Update server settings
+func (b *BaseRouter) handleUpdateServerSettings(c *gin.Context) {
+	var ur ServerSettingsUpdateRequest
+	err := c.ShouldBindJSON(&ur)
+	if err == nil {
+		response, err := updateServerSettings(b.db, ur)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Get a page of cached content, ?orphaned=true for only unreferenced content
+func (b *BaseRouter) handleGetAdminContent(c *gin.Context) {
+	var q AdminContentQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getAdminContent(b.db, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Get person details
-	content.GET("/person/:id", func(c *gin.Context) {
-		if c.Param("id") == "" {
-			c.Status(400)
+// Delete cached content that isn't on any watched list
+func (b *BaseRouter) handleDeleteAdminContent(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	count, err := deleteAdminContent(b.db, id)
+	if err != nil {
+		if errors.Is(err, ErrContentNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		content, err := personDetails(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		if errors.Is(err, ErrContentReferenced) {
+			c.JSON(http.StatusConflict, AdminContentReferencedResponse{Error: err.Error(), WatchedCount: count})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
 
-	// Get person credits
-	content.GET("/person/:id/credits", func(c *gin.Context) {
-		if c.Param("id") == "" {
-			c.Status(400)
+// Refresh cached content from TMDB now
+func (b *BaseRouter) handleRefreshAdminContent(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	response, err := refreshAdminContent(b.db, id)
+	if err != nil {
+		if errors.Is(err, ErrContentNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		content, err := personCredits(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Delete and download a contents images again
+func (b *BaseRouter) handleRedownloadContentImages(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	response, err := redownloadContentImages(b.db, id)
+	if err != nil {
+		if errors.Is(err, ErrContentNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, content)
-	})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func (b *BaseRouter) addWatchedRoutes() {
-	watched := b.rg.Group("/watched").Use(AuthRequired())
-
-	watched.GET("", func(c *gin.Context) {
-		userId := c.MustGet("userId").(uint)
-		c.JSON(http.StatusOK, getWatched(b.db, userId))
-	})
+// Get all jellyfin servers users can login with
+func (b *BaseRouter) handleGetJellyfinServers(c *gin.Context) {
+	response, err := getJellyfinServers(b.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	watched.POST("", func(c *gin.Context) {
-		userId := c.MustGet("userId").(uint)
-		var ar WatchedAddRequest
-		err := c.ShouldBindJSON(&ar)
-		if err == nil {
-			response, err := addWatched(b.db, userId, ar)
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+// Add a jellyfin server
+func (b *BaseRouter) handleAddJellyfinServer(c *gin.Context) {
+	var ar JellyfinServerRequest
+	err := c.ShouldBindJSON(&ar)
+	if err == nil {
+		response, err := addJellyfinServer(b.db, ar)
+		if err != nil {
+			if errors.Is(err, ErrJellyfinServerExists) {
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, response)
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-	})
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
 
-	watched.PUT(":id", func(c *gin.Context) {
-		id, err := strconv.Atoi(c.Param("id"))
+// Update a jellyfin servers name or host
+func (b *BaseRouter) handleUpdateJellyfinServer(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var ur JellyfinServerUpdateRequest
+	err := c.ShouldBindJSON(&ur)
+	if err == nil {
+		response, err := updateJellyfinServer(b.db, uint(id), ur)
 		if err != nil {
-			c.Status(400)
-			return
-		}
-		userId := c.MustGet("userId").(uint)
-		var ur WatchedUpdateRequest
-		err = c.ShouldBindJSON(&ur)
-		if err == nil {
-			response, err := updateWatched(b.db, userId, uint(id), ur)
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+			if errors.Is(err, ErrJellyfinServerNotFound) {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, ErrJellyfinServerExists) {
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, response)
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-	})
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
 
-	watched.DELETE(":id", func(c *gin.Context) {
-		id, err := strconv.Atoi(c.Param("id"))
-		if err != nil {
-			c.Status(400)
+// Delete a jellyfin server that has no users
+func (b *BaseRouter) handleDeleteJellyfinServer(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	err := deleteJellyfinServer(b.db, uint(id))
+	if err != nil {
+		if errors.Is(err, ErrJellyfinServerNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		userId := c.MustGet("userId").(uint)
-		if err == nil {
-			response, err := removeWatched(b.db, userId, uint(id))
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
-				return
-			}
-			c.JSON(http.StatusOK, response)
+		if errors.Is(err, ErrJellyfinServerInUse) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-	})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
-func (b *BaseRouter) addActivityRoutes() {
-	activity := b.rg.Group("/activity").Use(AuthRequired())
+func (b *BaseRouter) addNotificationRoutes() {
+	notifications := b.rg.Group("/notifications").Use(AuthRequired(b.db))
 
-	activity.GET(":watchedId", func(c *gin.Context) {
-		watchedId, err := strconv.ParseUint(c.Param("watchedId"), 10, 32)
+	notifications.GET("", b.handleGetNotifications)
+	notifications.GET("unread-count", b.handleGetUnreadNotificationCount)
+	notifications.PUT(":id/read", b.handleReadNotification)
+	notifications.PUT("read-all", b.handleReadAllNotifications)
+}
+
+func (b *BaseRouter) handleGetNotifications(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var q NotificationsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getNotifications(b.db, userId, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleGetUnreadNotificationCount(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	response, err := getUnreadNotificationCount(b.db, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleReadNotification(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	if err := readNotification(b.db, userId, uint(id)); err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (b *BaseRouter) handleReadAllNotifications(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	if err := readAllNotifications(b.db, userId); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (b *BaseRouter) addGoalRoutes() {
+	goals := b.rg.Group("/goals").Use(AuthRequired(b.db))
+
+	goals.GET("", b.handleGetWatchGoals)
+	goals.POST("", b.handleAddWatchGoal)
+	goals.PUT(":id", b.handleUpdateWatchGoal)
+	goals.DELETE(":id", b.handleDeleteWatchGoal)
+	goals.GET(":year/progress", b.handleGetWatchGoalProgress)
+}
+
+// Get users watch goals, newest year first
+func (b *BaseRouter) handleGetWatchGoals(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getWatchGoals(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Add a watch goal
+func (b *BaseRouter) handleAddWatchGoal(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var ar WatchGoalRequest
+	err := c.ShouldBindJSON(&ar)
+	if err == nil {
+		response, err := addWatchGoal(b.db, userId, profileId, ar)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "check watched id route param"})
+			if errors.Is(err, ErrGoalExists) {
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, ErrGoalYearOver) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 			return
 		}
-		userId := c.MustGet("userId").(uint)
-		activity, err := getActivity(b.db, userId, uint(watchedId))
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// Update a watch goal
+func (b *BaseRouter) handleUpdateWatchGoal(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var ur WatchGoalUpdateRequest
+	err := c.ShouldBindJSON(&ur)
+	if err == nil {
+		response, err := updateWatchGoal(b.db, userId, profileId, uint(id), ur)
 		if err != nil {
+			if errors.Is(err, ErrGoalNotFound) {
+				c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, ErrGoalExists) {
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+				return
+			}
+			if errors.Is(err, ErrGoalYearOver) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, activity)
-	})
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
 
-	activity.POST("", func(c *gin.Context) {
-		userId := c.MustGet("userId").(uint)
-		var ar ActivityAddRequest
-		err := c.ShouldBindJSON(&ar)
-		if err == nil {
-			response, err := addActivity(b.db, userId, ar)
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
-				return
-			}
-			c.JSON(http.StatusOK, response)
+// Set a years watch goal, adding it or changing its target
+func (b *BaseRouter) handleSetWatchGoal(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var sr WatchGoalSetRequest
+	if err := c.ShouldBindJSON(&sr); err != nil {
+		c.JSON(bodyErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := setWatchGoal(b.db, userId, profileId, sr)
+	if err != nil {
+		if errors.Is(err, ErrGoalYearOver) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-	})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func (b *BaseRouter) addAuthRoutes() {
-	auth := b.rg.Group("/auth")
+// Delete a watch goal
+func (b *BaseRouter) handleDeleteWatchGoal(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	if err := deleteWatchGoal(b.db, userId, profileId, uint(id)); err != nil {
+		if errors.Is(err, ErrGoalNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
 
-	// Login
-	auth.POST("/", func(c *gin.Context) {
-		var user User
-		if c.ShouldBindJSON(&user) == nil {
-			response, err := login(&user, b.db)
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
-				return
-			}
-			c.JSON(http.StatusOK, response)
+// Get progress towards a years watch goal
+func (b *BaseRouter) handleGetWatchGoalProgress(c *gin.Context) {
+	year, ok := intParam(c, "year", 1900)
+	if !ok {
+		return
+	}
+	var q WatchGoalProgressQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getWatchGoalProgress(b.db, userId, profileId, year, q.Type)
+	if err != nil {
+		if errors.Is(err, ErrGoalNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.Status(400)
-	})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Jellyfin login
-	auth.POST("/jellyfin", func(c *gin.Context) {
-		var user User
-		if c.ShouldBindJSON(&user) == nil {
-			response, err := loginJellyfin(&user, b.db)
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
-				return
-			}
-			c.JSON(http.StatusOK, response)
+func (b *BaseRouter) addImportRoutes() {
+	imp := b.rg.Group("/import").Use(AuthRequired(b.db))
+
+	imp.POST("", b.handleImport)
+	imp.POST("/simple-csv", b.handleImportSimpleCSV)
+}
+
+// Import rows into watched list, ?dryRun=true to only get the report
+func (b *BaseRouter) handleImport(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var q ImportQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	var ir ImportRequest
+	if err := c.ShouldBindJSON(&ir); err != nil {
+		c.JSON(bodyErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, importWatched(b.db, userId, profileId, ir, q.DryRun))
+}
+
+// Import a csv of titles into watched list, ?preview=true to only get the report.
+// With ?async=true the import is queued and its job is returned.
+func (b *BaseRouter) handleImportSimpleCSV(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var q SimpleCSVImportQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if q.Async {
+		job, err := queueSimpleCSVImport(b.db, userId, profileId, c.Request.Body, q.Preview)
+		if err != nil {
+			c.JSON(bodyErrorStatus(err), ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.Status(400)
-	})
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+	response, err := importSimpleCSV(b.db, userId, profileId, c.Request.Body, q.Preview)
+	if err != nil {
+		c.JSON(bodyErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Register
-	auth.POST("/register", func(c *gin.Context) {
-		var user User
-		if c.ShouldBindJSON(&user) == nil {
-			response, err := register(&user, b.db)
-			if err != nil {
-				c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
-				return
-			}
-			c.JSON(http.StatusOK, response)
+func (b *BaseRouter) addJobRoutes() {
+	jobs := b.rg.Group("/jobs").Use(AuthRequired(b.db))
+
+	jobs.GET("", b.handleGetJobs)
+	jobs.GET(":id", b.handleGetJob)
+}
+
+// Get users background jobs, newest first
+func (b *BaseRouter) handleGetJobs(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	var q JobsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := getJobs(b.db, userId, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleGetJob(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	response, err := getJob(b.db, userId, uint(id))
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.Status(400)
-	})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Get available auth providers
-	auth.GET("/available", func(c *gin.Context) {
-		c.JSON(http.StatusOK, AvailableAuthProviders)
-	})
+func (b *BaseRouter) addTagRoutes() {
+	tags := b.rg.Group("/tags").Use(AuthRequired(b.db))
+
+	tags.GET("", b.handleGetTags)
+	tags.POST("", b.handleAddTag)
+	tags.DELETE(":id", b.handleDeleteTag)
+	tags.POST(":id/apply", b.handleApplyTag)
+	tags.POST(":id/remove", b.handleRemoveTag)
 }
 
-func (b *BaseRouter) addProfileRoutes() {
-	profile := b.rg.Group("/profile").Use(AuthRequired())
+// Get users tags, smart tags are marked as dynamic
+func (b *BaseRouter) handleGetTags(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := getTags(b.db, userId, profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Get user profile details
-	profile.GET("", func(c *gin.Context) {
-		userId := c.MustGet("userId").(uint)
-		response, err := getProfile(b.db, userId)
-		if err != nil {
-			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+// Add a tag, or a smart tag when a filter is given
+func (b *BaseRouter) handleAddTag(c *gin.Context) {
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	var ar TagAddRequest
+	if err := c.ShouldBindJSON(&ar); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := addTag(b.db, userId, profileId, ar)
+	if err != nil {
+		if errors.Is(err, ErrTagExists) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, response)
-	})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (b *BaseRouter) handleDeleteTag(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	if err := deleteTag(b.db, userId, profileId, uint(id)); err != nil {
+		if errors.Is(err, ErrTagNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// Add a tag to every watched entry matching the filter query params
+func (b *BaseRouter) handleApplyTag(c *gin.Context) {
+	b.handleBulkTag(c, applyTag)
+}
+
+// Take a tag off every watched entry matching the filter query params
+func (b *BaseRouter) handleRemoveTag(c *gin.Context) {
+	b.handleBulkTag(c, removeTag)
+}
+
+func (b *BaseRouter) handleBulkTag(c *gin.Context, op func(*gorm.DB, uint, uint, uint, WatchedFilters) (TagApplyResponse, error)) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var f WatchedFilters
+	if err := c.ShouldBindQuery(&f); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	userId := c.MustGet("userId").(uint)
+	profileId := c.MustGet("profileId").(uint)
+	response, err := op(b.db, userId, profileId, uint(id), f)
+	if err != nil {
+		if errors.Is(err, ErrTagNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, ErrTagIsSmart) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// What the clock is fixed at while a test server is running.
+var testNow = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// Movies our fake TMDB knows about, by path.
+var fakeTMDBMovies = map[string]string{
+	"/3/movie/550": `{"id":550,"title":"Fight Club","overview":"An insomniac office worker...","release_date":"1999-10-15","runtime":139,"status":"Released"}`,
+	"/3/movie/603": `{"id":603,"title":"The Matrix","overview":"Set in the 22nd century...","release_date":"1999-03-30","runtime":136,"status":"Released"}`,
+}
+
+// Fake TMDB, only knows about fakeTMDBMovies.
+// Anything with the id 500500 fails with a 500.
+func fakeTMDB(w http.ResponseWriter, r *http.Request) {
+	if movie, ok := fakeTMDBMovies[r.URL.Path]; ok {
+		io.WriteString(w, movie)
+		return
+	}
+	if strings.Contains(r.URL.Path, "/500500") {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `{"status_code":11,"status_message":"Internal error: Something went wrong, contact TMDb."}`)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	io.WriteString(w, `{"status_code":34,"status_message":"The resource you requested could not be found."}`)
+}
+
+// Send TMDB requests to handler until the test is done.
+func useFakeTMDB(t testing.TB, handler http.HandlerFunc) {
+	t.Helper()
+	tmdb := httptest.NewServer(handler)
+	t.Cleanup(tmdb.Close)
+	origBaseURL, origClient := tmdbBaseURL, tmdbClient
+	tmdbBaseURL, tmdbClient = tmdb.URL+"/3", tmdb.Client()
+	t.Cleanup(func() { tmdbBaseURL, tmdbClient = origBaseURL, origClient })
+}
+
+// Open a fresh, migrated database in a temp data dir.
+func newTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	t.Setenv("DATA_DIR", t.TempDir())
+	db, err := openDB(dataPath("watcharr.db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := db.AutoMigrate(dbModels...); err != nil {
+		t.Fatalf("failed to migrate db: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+type testServer struct {
+	t   *testing.T
+	db  *gorm.DB
+	url string
+}
+
+// Start our api on a fresh database in a temp data dir.
+// TMDB requests go to fakeTMDB and the clock is fixed at testNow.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("API_PREFIX", "")
+
+	useFakeTMDB(t, fakeTMDB)
+	origNow := timeNow
+	timeNow = func() time.Time { return testNow }
+	t.Cleanup(func() { timeNow = origNow })
+
+	db := newTestDB(t)
+	gine, err := newEngine(db)
+	if err != nil {
+		t.Fatalf("failed to create engine: %v", err)
+	}
+	srv := httptest.NewServer(gine)
+	t.Cleanup(srv.Close)
+	return &testServer{t: t, db: db, url: srv.URL + getAPIPrefix()}
+}
+
+// Make a request to the api, body is sent as is.
+// Returns the response status and body.
+func (s *testServer) do(method string, path string, token string, body string) (int, []byte) {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.url+path, strings.NewReader(body))
+	if err != nil {
+		s.t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		s.t.Fatalf("failed to read %s %s response: %v", method, path, err)
+	}
+	return res.StatusCode, b
+}
+
+// Same as do, but fails the test unless the response has status want,
+// then decodes the response into resp (if not nil).
+func (s *testServer) expect(method string, path string, token string, body string, want int, resp any) {
+	s.t.Helper()
+	status, b := s.do(method, path, token, body)
+	if status != want {
+		s.t.Fatalf("%s %s: got status %d, want %d (body: %s)", method, path, status, want, b)
+	}
+	if resp != nil {
+		if err := json.Unmarshal(b, resp); err != nil {
+			s.t.Fatalf("%s %s: failed to decode response %s: %v", method, path, b, err)
+		}
+	}
+}
+
+// Register a user, returning their token.
+func (s *testServer) register(username string) string {
+	s.t.Helper()
+	var ar AuthResponse
+	s.expect("POST", "/auth/register", "", `{"username":"`+username+`","password":"password123"}`, http.StatusOK, &ar)
+	if ar.Token == "" {
+		s.t.Fatalf("no token returned registering %s", username)
+	}
+	return ar.Token
+}
+
+func TestAuthRequired(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+
+	s.expect("GET", "/watched", "", "", http.StatusUnauthorized, nil)
+	s.expect("GET", "/watched", "not-a-token", "", http.StatusUnauthorized, nil)
+	s.expect("GET", "/watched", token+"x", "", http.StatusUnauthorized, nil)
+	s.expect("GET", "/watched", token, "", http.StatusOK, nil)
+
+	// Tokens stop working once invalidated (eg. on password change).
+	s.db.Model(&User{}).Where("username = ?", "alice").Update("token_version", gorm.Expr("token_version + 1"))
+	s.expect("GET", "/watched", token, "", http.StatusUnauthorized, nil)
+}
+
+func TestAuthRequiredForgedTokens(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	var user User
+	s.db.Where("username = ?", "alice").Take(&user)
+
+	claims := func(issuer string) TokenClaims {
+		return TokenClaims{
+			UserID:           user.ID,
+			Username:         user.Username,
+			TokenVersion:     user.TokenVersion,
+			RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(testNow), Issuer: issuer},
+		}
+	}
+	sign := func(method jwt.SigningMethod, c TokenClaims, key any) string {
+		t.Helper()
+		signed, err := jwt.NewWithClaims(method, c).SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign %s token: %v", method.Alg(), err)
+		}
+		return signed
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	sig := []byte(parts[2])
+	sig[0] ^= 1
+	otherUser, _ := json.Marshal(claims(jwtIssuer))
+	otherUser = []byte(strings.Replace(string(otherUser), `"userId":1`, `"userId":2`, 1))
+	s.register("bob")
+
+	// Make sure tokens are built right, so the failures below are for the reason expected.
+	s.expect("GET", "/watched", sign(jwt.SigningMethodHS256, claims(jwtIssuer), []byte("test-secret")), "", http.StatusOK, nil)
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"alg none", sign(jwt.SigningMethodNone, claims(jwtIssuer), jwt.UnsafeAllowNoneSignatureType)},
+		{"rs256", sign(jwt.SigningMethodRS256, claims(jwtIssuer), rsaKey)},
+		{"hs512", sign(jwt.SigningMethodHS512, claims(jwtIssuer), []byte("test-secret"))},
+		{"wrong secret", sign(jwt.SigningMethodHS256, claims(jwtIssuer), []byte("not-the-secret"))},
+		{"wrong issuer", sign(jwt.SigningMethodHS256, claims("someone-else"), []byte("test-secret"))},
+		{"no issuer", sign(jwt.SigningMethodHS256, claims(""), []byte("test-secret"))},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + string(sig)},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString(otherUser) + "." + parts[2]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, b := s.do("GET", "/watched", tc.token, "")
+			if status != http.StatusUnauthorized {
+				t.Errorf("got status %d, want 401 (body: %s)", status, b)
+			}
+		})
+	}
+}
+
+func TestWatchedCRUD(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+
+	var w Watched
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie","status":"PLANNED"}`, http.StatusOK, &w)
+	if w.ID == 0 || w.Status != PLANNED {
+		t.Fatalf("unexpected added watched: %+v", w)
+	}
+	if !w.CreatedAt.Equal(testNow) {
+		t.Errorf("added watched created at %s, want %s", w.CreatedAt, testNow)
+	}
+	// Content we don't have is fetched from TMDB, unknown ids aren't added.
+	s.expect("POST", "/watched", token, `{"contentId":404,"contentType":"movie"}`, http.StatusForbidden, nil)
+	// Watched dates can't be in the future (by our clock) unless only planned.
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie","status":"FINISHED","watchedDate":"2024-06-02T00:00:00Z"}`, http.StatusBadRequest, nil)
+
+	id := strconv.Itoa(int(w.ID))
+	var list []Watched
+	s.expect("GET", "/watched", token, "", http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != w.ID || list[0].Content.Title != "Fight Club" {
+		t.Fatalf("unexpected watched list: %+v", list)
+	}
+
+	s.expect("PUT", "/watched/"+id, token, `{"status":"FINISHED","rating":8,"thoughts":"<b>great</b>"}`, http.StatusOK, nil)
+	s.expect("GET", "/watched/"+id, token, "", http.StatusOK, &w)
+	if w.Status != FINISHED || w.Rating != 8 || w.Thoughts != "great" {
+		t.Fatalf("watched not updated as expected: %+v", w)
+	}
+	s.expect("PUT", "/watched/"+id, token, `{"status":"NOTASTATUS"}`, http.StatusBadRequest, nil)
+	s.expect("PUT", "/watched/"+id, token, `{"rating":11}`, http.StatusBadRequest, nil)
+
+	s.expect("DELETE", "/watched/"+id, token, "", http.StatusOK, nil)
+	s.expect("GET", "/watched/"+id, token, "", http.StatusNotFound, nil)
+	s.expect("GET", "/watched", token, "", http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("watched list not empty after removing: %+v", list)
+	}
+}
+
+func TestWatchedOwnership(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.register("alice")
+	bob := s.register("bob")
+
+	var w Watched
+	s.expect("POST", "/watched", alice, `{"contentId":550,"contentType":"movie","status":"WATCHING"}`, http.StatusOK, &w)
+	id := strconv.Itoa(int(w.ID))
+
+	s.expect("GET", "/watched/"+id, bob, "", http.StatusNotFound, nil)
+	s.expect("PUT", "/watched/"+id, bob, `{"status":"DROPPED"}`, http.StatusForbidden, nil)
+	s.expect("DELETE", "/watched/"+id, bob, "", http.StatusForbidden, nil)
+	s.expect("POST", "/watched/"+id+"/rewatch", bob, `{}`, http.StatusNotFound, nil)
+	var list []Watched
+	s.expect("GET", "/watched", bob, "", http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("bob can see alices watched list: %+v", list)
+	}
+
+	// Still there and untouched for its owner.
+	s.expect("GET", "/watched/"+id, alice, "", http.StatusOK, &w)
+	if w.Status != WATCHING {
+		t.Fatalf("alices watched was changed by bob: %+v", w)
+	}
+}
+
+func TestMalformedJSON(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+
+	var w Watched
+	s.expect("POST", "/watched", token, `{"contentId":550,"contentType":"movie"}`, http.StatusOK, &w)
+	id := strconv.Itoa(int(w.ID))
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/auth/register", `{"username":`},
+		{"POST", "/watched", `{"contentId":550,`},
+		{"POST", "/watched", `{"contentId":"550","contentType":"movie"}`},
+		{"POST", "/watched", `not json`},
+		{"PUT", "/watched/" + id, `{"status":`},
+		{"PUT", "/watched/" + id, `["FINISHED"]`},
+		{"PUT", "/watched/reorder", `{`},
+	} {
+		status, b := s.do(tc.method, tc.path, token, tc.body)
+		if status != http.StatusBadRequest {
+			t.Errorf("%s %s with %q: got status %d, want 400 (body: %s)", tc.method, tc.path, tc.body, status, b)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Instance wide settings, changeable by admins. There is only ever one row.
+type ServerSettings struct {
+	GormModel
+	// Defaults copied into a new users settings when they are created,
+	// changing these later doesn't affect existing users.
+	DefaultRatingScale    int    `json:"defaultRatingScale" gorm:"not null;default:10"`
+	DefaultRegion         string `json:"defaultRegion"`
+	DefaultLanguage       string `json:"defaultLanguage"`
+	DefaultPrivateProfile bool   `json:"defaultPrivateProfile" gorm:"not null;default:true"`
+	DefaultStatusOnAdd    string `json:"defaultStatusOnAdd"`
+
+	// Name shown for the instance (eg. on the login page), empty for the default.
+	InstanceName string `json:"instanceName"`
+	// If new users can register with a password. The first user can always register.
+	SignupEnabled bool `json:"signupEnabled" gorm:"not null;default:true"`
+
+	// End of the last TMDB changes window the refresh job processed,
+	// nil until it has done its first full refresh.
+	ContentChangesSyncedAt *time.Time `json:"-"`
+}
+
+// Only fields that are set will be updated.
+type ServerSettingsUpdateRequest struct {
+	DefaultRatingScale    *int    `json:"defaultRatingScale"`
+	DefaultRegion         *string `json:"defaultRegion"`
+	DefaultLanguage       *string `json:"defaultLanguage"`
+	DefaultPrivateProfile *bool   `json:"defaultPrivateProfile"`
+	DefaultStatusOnAdd    *string `json:"defaultStatusOnAdd"`
+	InstanceName          *string `json:"instanceName" binding:"omitempty,max=64"`
+	SignupEnabled         *bool   `json:"signupEnabled"`
+}
+
+// Server config anyone (even before logging in) can see. Fields are copied
+// over one by one, so nothing new is exposed without being added here.
+type PublicServerConfig struct {
+	// Version of the api, so clients can check they are compatible.
+	APIVersion      string `json:"apiVersion"`
+	InstanceName    string `json:"instanceName"`
+	SignupEnabled   bool   `json:"signupEnabled"`
+	DefaultLanguage string `json:"defaultLanguage"`
+	// Auth providers other than Watcharr that can be used (eg. jellyfin).
+	AuthProviders   []string             `json:"authProviders"`
+	JellyfinServers []JellyfinServerInfo `json:"jellyfinServers"`
+	// If TMDB images are downloaded through a proxy (TMDB_IMAGE_BASE).
+	ImageProxyEnabled bool `json:"imageProxyEnabled"`
+}
+
+// Scales ratings can be shown in, ratings are always stored out of 10.
+var validRatingScales = []int{5, 10}
+
+// Get server settings, creating them with defaults on first use.
+func getServerSettings(db *gorm.DB) (ServerSettings, error) {
+	s := ServerSettings{GormModel: GormModel{ID: 1}}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&s)
+	if res.Error != nil {
+		slog.Error("Failed to create server settings", "error", res.Error.Error())
+		return ServerSettings{}, errors.New("failed to get server settings")
+	}
+	res = db.Take(&s, 1)
+	if res.Error != nil {
+		slog.Error("Failed to get server settings", "error", res.Error.Error())
+		return ServerSettings{}, errors.New("failed to get server settings")
+	}
+	return s, nil
+}
+
+func updateServerSettings(db *gorm.DB, ur ServerSettingsUpdateRequest) (ServerSettings, error) {
+	s, err := getServerSettings(db)
+	if err != nil {
+		return ServerSettings{}, err
+	}
+	if ur.DefaultRatingScale != nil {
+		if !slices.Contains(validRatingScales, *ur.DefaultRatingScale) {
+			return ServerSettings{}, errors.New("invalid defaultRatingScale")
+		}
+		s.DefaultRatingScale = *ur.DefaultRatingScale
+	}
+	if ur.DefaultRegion != nil {
+		if *ur.DefaultRegion != "" && !isValidRegion(*ur.DefaultRegion) {
+			return ServerSettings{}, errors.New("unknown region for defaultRegion")
+		}
+		s.DefaultRegion = strings.ToUpper(*ur.DefaultRegion)
+	}
+	if ur.DefaultLanguage != nil {
+		if *ur.DefaultLanguage != "" && !isValidLanguage(*ur.DefaultLanguage) {
+			return ServerSettings{}, errors.New("unknown language for defaultLanguage")
+		}
+		s.DefaultLanguage = strings.ToLower(*ur.DefaultLanguage)
+	}
+	if ur.DefaultPrivateProfile != nil {
+		s.DefaultPrivateProfile = *ur.DefaultPrivateProfile
+	}
+	if ur.DefaultStatusOnAdd != nil {
+		if !slices.Contains(validDefaultStatusesOnAdd, *ur.DefaultStatusOnAdd) {
+			return ServerSettings{}, errors.New("invalid defaultStatusOnAdd")
+		}
+		s.DefaultStatusOnAdd = *ur.DefaultStatusOnAdd
+	}
+	if ur.InstanceName != nil {
+		s.InstanceName = sanitizeString(strings.TrimSpace(*ur.InstanceName))
+	}
+	if ur.SignupEnabled != nil {
+		s.SignupEnabled = *ur.SignupEnabled
+	}
+	res := db.Save(&s)
+	if res.Error != nil {
+		slog.Error("Failed to update server settings", "error", res.Error.Error())
+		return ServerSettings{}, errors.New("failed to update server settings")
+	}
+	slog.Info("Server settings updated", "settings", s)
+	return s, nil
+}
+
+// Get the public view of our config. Settings are read every time, so admin changes show straight away.
+func getPublicServerConfig(db *gorm.DB) (PublicServerConfig, error) {
+	s, err := getServerSettings(db)
+	if err != nil {
+		return PublicServerConfig{}, err
+	}
+	auth, err := getAvailableAuthProviders(db)
+	if err != nil {
+		return PublicServerConfig{}, err
+	}
+	return PublicServerConfig{
+		APIVersion:        apiVersion,
+		InstanceName:      s.InstanceName,
+		SignupEnabled:     s.SignupEnabled,
+		DefaultLanguage:   s.DefaultLanguage,
+		AuthProviders:     auth.Providers,
+		JellyfinServers:   auth.JellyfinServers,
+		ImageProxyEnabled: getTMDBImageBase() != defaultTMDBImageBase,
+	}, nil
+}
+
+// Get settings a new user should start with, from the server defaults.
+// Falls back to our own defaults if server settings can't be loaded,
+// so users can still be created.
+func newUserSettings(db *gorm.DB) UserSettings {
+	s, err := getServerSettings(db)
+	if err != nil {
+		return UserSettings{RatingScale: 10}
+	}
+	return UserSettings{
+		RatingScale:        s.DefaultRatingScale,
+		Region:             s.DefaultRegion,
+		Language:           s.DefaultLanguage,
+		ShareWithInstance:  !s.DefaultPrivateProfile,
+		DefaultStatusOnAdd: s.DefaultStatusOnAdd,
+	}
+}
+
+// If region is a known ISO 3166-1 alpha-2 country code (eg. GB).
+func isValidRegion(region string) bool {
+	if len(region) != 2 {
+		return false
+	}
+	r, err := language.ParseRegion(region)
+	return err == nil && r.IsCountry()
+}
+
+// If lang is a known ISO 639-1 language code (eg. en).
+func isValidLanguage(lang string) bool {
+	if len(lang) != 2 {
+		return false
+	}
+	_, err := language.ParseBase(lang)
+	return err == nil
+}
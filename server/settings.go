@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	// Embed timezone database, so users timezones can be loaded
+	// even when the host has no tzdata installed (eg. in docker).
+	_ "time/tzdata"
+
+	"gorm.io/gorm"
+)
+
+// Users preferences, embedded into the users table.
+type UserSettings struct {
+	// Highest certification (eg. PG-13) a user wants to see in search
+	// results. Anything above it is hidden. Empty to show everything.
+	MaxRating string `json:"maxRating"`
+	// If content with no certification should be shown when MaxRating is set.
+	ShowUnrated bool `json:"showUnrated" gorm:"not null;default:false"`
+	// If other users on this instance can see what this user has watched,
+	// shown on content pages (eg. "also watched by").
+	ShareWithInstance bool `json:"shareWithInstance" gorm:"not null;default:false"`
+	// If ratings and reviews (thoughts) are shown with what the user has watched
+	// when they share it (see ShareWithInstance). Both are hidden by default.
+	SharePrivateRatings bool `json:"sharePrivateRatings" gorm:"not null;default:false"`
+	ShareReviews        bool `json:"shareReviews" gorm:"not null;default:false"`
+	// IANA timezone (eg. Europe/London) date based stats are grouped in. Empty for UTC.
+	Timezone string `json:"timezone"`
+	// Status given to content added without one. A WatchedStatus, or `ask`
+	// for clients to prompt the user (we fall back to WATCHING). Empty for WATCHING.
+	DefaultStatusOnAdd string `json:"defaultStatusOnAdd"`
+	// If clients should prompt for a rating when adding content.
+	IncludeRatingPrompt bool `json:"includeRatingPrompt" gorm:"not null;default:false"`
+	// Scale (5 or 10) clients show ratings in, ratings are always stored out of 10.
+	RatingScale int `json:"ratingScale" gorm:"not null;default:10"`
+	// ISO 3166-1 code of the users region (eg. GB). Empty for the servers DEFAULT_COUNTRY.
+	Region string `json:"region"`
+	// ISO 639-1 code of the users preferred language (eg. en). Empty for English.
+	Language string `json:"language"`
+}
+
+// Values allowed for UserSettings.DefaultStatusOnAdd.
+var validDefaultStatusesOnAdd = []string{"", "ask", string(PLANNED), string(WATCHING), string(FINISHED), string(HOLD), string(DROPPED)}
+
+// Only fields that are set will be updated.
+type UserSettingsUpdateRequest struct {
+	MaxRating           *string `json:"maxRating"`
+	ShowUnrated         *bool   `json:"showUnrated"`
+	ShareWithInstance   *bool   `json:"shareWithInstance"`
+	SharePrivateRatings *bool   `json:"sharePrivateRatings"`
+	ShareReviews        *bool   `json:"shareReviews"`
+	Timezone            *string `json:"timezone"`
+	DefaultStatusOnAdd  *string `json:"defaultStatusOnAdd"`
+	IncludeRatingPrompt *bool   `json:"includeRatingPrompt"`
+	RatingScale         *int    `json:"ratingScale"`
+	Region              *string `json:"region"`
+	Language            *string `json:"language"`
+}
+
+func getUserSettings(db *gorm.DB, userId uint) (UserSettings, error) {
+	user := new(User)
+	res := db.Model(&User{}).Where("id = ?", userId).Take(&user)
+	if res.Error != nil {
+		slog.Error("Failed to get user settings", "userId", userId, "error", res.Error.Error())
+		return UserSettings{}, errors.New("failed to get user settings")
+	}
+	return user.Settings, nil
+}
+
+func updateUserSettings(db *gorm.DB, userId uint, ur UserSettingsUpdateRequest) (UserSettings, error) {
+	user := new(User)
+	res := db.Model(&User{}).Where("id = ?", userId).Take(&user)
+	if res.Error != nil {
+		slog.Error("Failed to get user for settings update", "userId", userId, "error", res.Error.Error())
+		return UserSettings{}, errors.New("failed to get user settings")
+	}
+	// Only the settings changed are saved, so nothing else on the user is overwritten.
+	var cols []string
+	if ur.MaxRating != nil {
+		if *ur.MaxRating != "" && certificationAge(*ur.MaxRating) < 0 {
+			return UserSettings{}, errors.New("unknown certification for maxRating")
+		}
+		user.Settings.MaxRating = *ur.MaxRating
+		cols = append(cols, "setting_max_rating")
+	}
+	if ur.ShowUnrated != nil {
+		user.Settings.ShowUnrated = *ur.ShowUnrated
+		cols = append(cols, "setting_show_unrated")
+	}
+	if ur.ShareWithInstance != nil {
+		user.Settings.ShareWithInstance = *ur.ShareWithInstance
+		cols = append(cols, "setting_share_with_instance")
+	}
+	if ur.SharePrivateRatings != nil {
+		user.Settings.SharePrivateRatings = *ur.SharePrivateRatings
+		cols = append(cols, "setting_share_private_ratings")
+	}
+	if ur.ShareReviews != nil {
+		user.Settings.ShareReviews = *ur.ShareReviews
+		cols = append(cols, "setting_share_reviews")
+	}
+	if ur.Timezone != nil {
+		if _, err := time.LoadLocation(*ur.Timezone); err != nil {
+			return UserSettings{}, errors.New("unknown timezone")
+		}
+		user.Settings.Timezone = *ur.Timezone
+		cols = append(cols, "setting_timezone")
+	}
+	if ur.DefaultStatusOnAdd != nil {
+		if !slices.Contains(validDefaultStatusesOnAdd, *ur.DefaultStatusOnAdd) {
+			return UserSettings{}, errors.New("invalid defaultStatusOnAdd")
+		}
+		user.Settings.DefaultStatusOnAdd = *ur.DefaultStatusOnAdd
+		cols = append(cols, "setting_default_status_on_add")
+	}
+	if ur.IncludeRatingPrompt != nil {
+		user.Settings.IncludeRatingPrompt = *ur.IncludeRatingPrompt
+		cols = append(cols, "setting_include_rating_prompt")
+	}
+	if ur.RatingScale != nil {
+		if !slices.Contains(validRatingScales, *ur.RatingScale) {
+			return UserSettings{}, errors.New("invalid ratingScale")
+		}
+		user.Settings.RatingScale = *ur.RatingScale
+		cols = append(cols, "setting_rating_scale")
+	}
+	if ur.Region != nil {
+		if *ur.Region != "" && !isValidRegion(*ur.Region) {
+			return UserSettings{}, errors.New("unknown region")
+		}
+		user.Settings.Region = strings.ToUpper(*ur.Region)
+		cols = append(cols, "setting_region")
+	}
+	if ur.Language != nil {
+		if *ur.Language != "" && !isValidLanguage(*ur.Language) {
+			return UserSettings{}, errors.New("unknown language")
+		}
+		user.Settings.Language = strings.ToLower(*ur.Language)
+		cols = append(cols, "setting_language")
+	}
+	if len(cols) == 0 {
+		return user.Settings, nil
+	}
+	res = db.Model(user).Select(cols).Updates(user)
+	if res.Error != nil {
+		slog.Error("Failed to update user settings", "userId", userId, "error", res.Error.Error())
+		return UserSettings{}, errors.New("failed to update user settings")
+	}
+	return user.Settings, nil
+}
+
+// Get location to use for a user, override (eg. from a ?tz= param) is used if set.
+func getUserLocation(s UserSettings, override string) (*time.Location, error) {
+	tz := s.Timezone
+	if override != "" {
+		tz = override
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errors.New("unknown timezone")
+	}
+	return loc, nil
+}
+
+// Get status to give content a user adds without one.
+func getDefaultStatusOnAdd(db *gorm.DB, userId uint) WatchedStatus {
+	settings, err := getUserSettings(db, userId)
+	if err != nil || settings.DefaultStatusOnAdd == "" || settings.DefaultStatusOnAdd == "ask" {
+		return WATCHING
+	}
+	return WatchedStatus(settings.DefaultStatusOnAdd)
+}
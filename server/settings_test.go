@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestUpdateUserSettings(t *testing.T) {
+	db := newTestDB(t)
+	user := User{Username: "alice", Password: "x", Settings: UserSettings{RatingScale: 10, Region: "GB"}}
+	mustCreate(t, db, &user)
+	yes, no := true, false
+	scale, language := 5, "FR"
+	for _, tc := range []struct {
+		name string
+		ur   UserSettingsUpdateRequest
+		want UserSettings
+	}{
+		{"set some", UserSettingsUpdateRequest{ShareWithInstance: &yes, ShareReviews: &yes, RatingScale: &scale}, UserSettings{ShareWithInstance: true, ShareReviews: true, RatingScale: 5, Region: "GB"}},
+		{"unset one", UserSettingsUpdateRequest{ShareReviews: &no}, UserSettings{ShareWithInstance: true, RatingScale: 5, Region: "GB"}},
+		{"nothing", UserSettingsUpdateRequest{}, UserSettings{ShareWithInstance: true, RatingScale: 5, Region: "GB"}},
+		{"normalized", UserSettingsUpdateRequest{Language: &language}, UserSettings{ShareWithInstance: true, RatingScale: 5, Region: "GB", Language: "fr"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := updateUserSettings(db, user.ID, tc.ur)
+			if err != nil {
+				t.Fatalf("updateUserSettings failed: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got settings %+v, want %+v", got, tc.want)
+			}
+			var saved User
+			db.Take(&saved, user.ID)
+			if saved.Settings != tc.want {
+				t.Errorf("got saved settings %+v, want %+v", saved.Settings, tc.want)
+			}
+			if saved.Username != "alice" || saved.Password != "x" {
+				t.Errorf("user was changed: %+v", saved)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// A sub profile of a user, each with its own watched list, so
+// one account can be shared by multiple people (eg. a household).
+// A profile id of 0 refers to the users main profile.
+type SubProfile struct {
+	GormModel
+	UserID uint   `json:"-" gorm:"not null;index"`
+	Name   string `json:"name" gorm:"not null"`
+}
+
+type SubProfileAddRequest struct {
+	Name string `json:"name" binding:"required,max=50"`
+}
+
+func getSubProfiles(db *gorm.DB, userId uint) ([]SubProfile, error) {
+	profiles := []SubProfile{}
+	res := db.Model(&SubProfile{}).Where("user_id = ?", userId).Find(&profiles)
+	if res.Error != nil {
+		slog.Error("Failed getting sub profiles from database", "error", res.Error.Error())
+		return []SubProfile{}, errors.New("failed getting profiles")
+	}
+	return profiles, nil
+}
+
+func addSubProfile(db *gorm.DB, userId uint, ar SubProfileAddRequest) (SubProfile, error) {
+	name := sanitizeString(ar.Name)
+	if name == "" {
+		return SubProfile{}, errors.New("profile name must not be empty")
+	}
+	profile := SubProfile{UserID: userId, Name: name}
+	res := db.Create(&profile)
+	if res.Error != nil {
+		slog.Error("Error adding sub profile to database", "error", res.Error.Error())
+		return SubProfile{}, errors.New("failed adding new profile")
+	}
+	return profile, nil
+}
+
+// Delete a sub profile along with its watched list, goals and tags.
+func removeSubProfile(db *gorm.DB, userId uint, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND user_id = ?", id, userId).Delete(&SubProfile{})
+		if res.Error != nil {
+			slog.Error("Removing sub profile failed", "id", id, "error", res.Error.Error())
+			return errors.New("failed to remove profile")
+		}
+		if res.RowsAffected <= 0 {
+			return errors.New("no profile found")
+		}
+		res = tx.Where("user_id = ? AND sub_profile_id = ?", userId, id).Delete(&Watched{})
+		if res.Error != nil {
+			slog.Error("Removing sub profiles watched list failed", "id", id, "error", res.Error.Error())
+			return errors.New("failed to remove profiles watched list")
+		}
+		res = tx.Unscoped().Where("user_id = ? AND sub_profile_id = ?", userId, id).Delete(&WatchGoal{})
+		if res.Error != nil {
+			slog.Error("Removing sub profiles goals failed", "id", id, "error", res.Error.Error())
+			return errors.New("failed to remove profiles goals")
+		}
+		res = tx.Unscoped().Where("user_id = ? AND sub_profile_id = ?", userId, id).Delete(&Tag{})
+		if res.Error != nil {
+			slog.Error("Removing sub profiles tags failed", "id", id, "error", res.Error.Error())
+			return errors.New("failed to remove profiles tags")
+		}
+		return nil
+	})
+}
+
+// Parse requested profile id and ensure it belongs to the user.
+func resolveSubProfile(db *gorm.DB, userId uint, requested string) (uint, error) {
+	if requested == "" || requested == "0" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(requested, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid profile id")
+	}
+	var count int64
+	db.Model(&SubProfile{}).Where("id = ? AND user_id = ?", id, userId).Count(&count)
+	if count == 0 {
+		return 0, errors.New("profile not found")
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("a tag with this name already exists")
+	// Smart tags are worked out from their filter, so can't be added or removed by hand.
+	ErrTagIsSmart = errors.New("smart tags can't be applied or removed")
+)
+
+// Filters that can't be part of a smart tags filter. Tags can't be nested,
+// and sorting doesn't change what matches.
+var tagFilterExcluded = []string{"tag", "sort"}
+
+// A users label for watched entries (on a profile). Smart tags have a filter
+// instead of being applied to entries, what they match is worked out when read.
+type Tag struct {
+	GormModel
+	UserID       uint   `json:"-" gorm:"uniqueIndex:usrprfltagidx;not null"`
+	SubProfileID uint   `json:"-" gorm:"uniqueIndex:usrprfltagidx;not null;default:0"`
+	Name         string `json:"name" gorm:"uniqueIndex:usrprfltagidx;not null"`
+	// Watched list filter of a smart tag, as GET /watched query params (eg. status=FINISHED&type=movie).
+	Filter string `json:"filter,omitempty"`
+	// If the tag is a smart tag. Not stored, filled in by our hooks from Filter.
+	Dynamic bool `json:"dynamic" gorm:"-"`
+}
+
+func (t *Tag) AfterFind(tx *gorm.DB) (err error) {
+	t.Dynamic = t.Filter != ""
+	return
+}
+
+func (t *Tag) AfterCreate(tx *gorm.DB) (err error) {
+	t.Dynamic = t.Filter != ""
+	return
+}
+
+// A (non smart) tag applied to a watched entry.
+type WatchedTag struct {
+	WatchedID uint `gorm:"primaryKey"`
+	TagID     uint `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+type TagAddRequest struct {
+	Name string `json:"name" binding:"required,max=50"`
+	// Makes the tag a smart tag, see Tag.Filter.
+	Filter string `json:"filter" binding:"max=1000"`
+}
+
+type TagApplyResponse struct {
+	// Entries the tag was added to (or removed from), ones that
+	// already had it (or didn't) aren't counted.
+	Count int64 `json:"count"`
+}
+
+// Get a users tags on profileId, by name.
+func getTags(db *gorm.DB, userId uint, profileId uint) ([]Tag, error) {
+	tags := []Tag{}
+	res := db.Model(&Tag{}).Where("user_id = ? AND sub_profile_id = ?", userId, profileId).Order("name").Find(&tags)
+	if res.Error != nil {
+		slog.Error("getTags: Failed to get tags", "userId", userId, "error", res.Error)
+		return []Tag{}, errors.New("failed to get tags")
+	}
+	return tags, nil
+}
+
+func getTag(db *gorm.DB, userId uint, profileId uint, id uint) (Tag, error) {
+	var tag Tag
+	res := db.Model(&Tag{}).Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Take(&tag)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return Tag{}, ErrTagNotFound
+		}
+		slog.Error("getTag: Failed to get tag", "id", id, "error", res.Error)
+		return Tag{}, errors.New("failed to get tag")
+	}
+	return tag, nil
+}
+
+func addTag(db *gorm.DB, userId uint, profileId uint, ar TagAddRequest) (Tag, error) {
+	tag := Tag{UserID: userId, SubProfileID: profileId, Name: sanitizeString(strings.TrimSpace(ar.Name))}
+	if tag.Name == "" {
+		return Tag{}, errors.New("name can't be empty")
+	}
+	if ar.Filter != "" {
+		if _, err := parseTagFilter(ar.Filter); err != nil {
+			return Tag{}, err
+		}
+		tag.Filter = ar.Filter
+	}
+	if res := db.Create(&tag); res.Error != nil {
+		if isDuplicateErr(res.Error) {
+			return Tag{}, ErrTagExists
+		}
+		slog.Error("addTag: Failed to add tag", "userId", userId, "error", res.Error)
+		return Tag{}, errors.New("failed to add tag")
+	}
+	return tag, nil
+}
+
+// Delete a tag, taking it off every entry it was applied to.
+func deleteTag(db *gorm.DB, userId uint, profileId uint, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Delete(&Tag{})
+		if res.Error != nil {
+			slog.Error("deleteTag: Failed to delete tag", "id", id, "error", res.Error)
+			return errors.New("failed to delete tag")
+		}
+		if res.RowsAffected == 0 {
+			return ErrTagNotFound
+		}
+		if res := tx.Where("tag_id = ?", id).Delete(&WatchedTag{}); res.Error != nil {
+			slog.Error("deleteTag: Failed to remove tag from watched entries", "id", id, "error", res.Error)
+			return errors.New("failed to delete tag")
+		}
+		return nil
+	})
+}
+
+// Add a tag to every watched entry matching f, in one transaction.
+func applyTag(db *gorm.DB, userId uint, profileId uint, id uint, f WatchedFilters) (TagApplyResponse, error) {
+	tag, err := getTag(db, userId, profileId, id)
+	if err != nil {
+		return TagApplyResponse{}, err
+	}
+	if tag.Dynamic {
+		return TagApplyResponse{}, ErrTagIsSmart
+	}
+	var resp TagApplyResponse
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if res := filterWatched(tx, userId, profileId, f).Pluck("id", &ids); res.Error != nil {
+			return res.Error
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		rows := make([]WatchedTag, len(ids))
+		for i, wid := range ids {
+			rows[i] = WatchedTag{WatchedID: wid, TagID: tag.ID}
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, 500)
+		resp.Count = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		slog.Error("applyTag: Failed to apply tag", "id", id, "error", err)
+		return TagApplyResponse{}, errors.New("failed to apply tag")
+	}
+	return resp, nil
+}
+
+// Take a tag off every watched entry matching f.
+func removeTag(db *gorm.DB, userId uint, profileId uint, id uint, f WatchedFilters) (TagApplyResponse, error) {
+	tag, err := getTag(db, userId, profileId, id)
+	if err != nil {
+		return TagApplyResponse{}, err
+	}
+	if tag.Dynamic {
+		return TagApplyResponse{}, ErrTagIsSmart
+	}
+	res := db.Where("tag_id = ? AND watched_id IN (?)", tag.ID, filterWatched(db, userId, profileId, f).Select("id")).Delete(&WatchedTag{})
+	if res.Error != nil {
+		slog.Error("removeTag: Failed to remove tag", "id", id, "error", res.Error)
+		return TagApplyResponse{}, errors.New("failed to remove tag")
+	}
+	return TagApplyResponse{Count: res.RowsAffected}, nil
+}
+
+// Parse and validate a smart tags filter. It's bound the same way GET /watched
+// query params are, so only filters the list endpoint accepts are allowed.
+func parseTagFilter(filter string) (WatchedFilters, error) {
+	values, err := url.ParseQuery(filter)
+	if err != nil {
+		return WatchedFilters{}, errors.New("filter must be query params, eg. status=FINISHED&type=movie")
+	}
+	allowed := []string{}
+	for _, p := range openAPIQueryParams(WatchedFilters{}) {
+		if name := p["name"].(string); !slices.Contains(tagFilterExcluded, name) {
+			allowed = append(allowed, name)
+		}
+	}
+	for k := range values {
+		if !slices.Contains(allowed, k) {
+			return WatchedFilters{}, errors.New("filter can't use " + k + ", allowed filters are: " + strings.Join(allowed, ", "))
+		}
+	}
+	var f WatchedFilters
+	req := &http.Request{URL: &url.URL{RawQuery: values.Encode()}}
+	if err := binding.Query.Bind(req, &f); err != nil {
+		return WatchedFilters{}, errors.New("invalid filter: " + err.Error())
+	}
+	return f, nil
+}
+
+// Only entries with tag id. For smart tags, entries matching their filter.
+// Unknown tags match nothing.
+func whereTagged(db *gorm.DB, q *gorm.DB, userId uint, profileId uint, id uint) *gorm.DB {
+	tag, err := getTag(db, userId, profileId, id)
+	if err != nil {
+		return q.Where("1 = 0")
+	}
+	if tag.Dynamic {
+		f, err := parseTagFilter(tag.Filter)
+		if err != nil {
+			slog.Warn("whereTagged: Smart tag has an invalid filter", "id", id, "error", err)
+			return q.Where("1 = 0")
+		}
+		return q.Where("id IN (?)", filterWatched(db, userId, profileId, f).Select("id"))
+	}
+	return q.Where("id IN (?)", db.Model(&WatchedTag{}).Select("watched_id").Where("tag_id = ?", tag.ID))
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type TMDBSearchMultiResponse struct {
@@ -38,6 +41,21 @@ type TMDBSearchMultiResults struct {
 	OriginalName     string   `json:"original_name,omitempty"`
 	FirstAirDate     string   `json:"first_air_date,omitempty"`
 	OriginCountry    []string `json:"origin_country,omitempty"`
+
+	// Only set for people.
+	KnownForDepartment string `json:"known_for_department,omitempty"`
+
+	// Our own additions, not from TMDB.
+	InLibrary     bool          `json:"inLibrary"`
+	WatchedStatus WatchedStatus `json:"watchedStatus,omitempty"`
+	WatchedRating int8          `json:"watchedRating,omitempty"`
+	// Set when the result isn't from TMDB.
+	Provider ContentProvider `json:"provider,omitempty"`
+}
+
+type TMDBFindResponse struct {
+	MovieResults []TMDBSearchMultiResults `json:"movie_results"`
+	TvResults    []TMDBSearchMultiResults `json:"tv_results"`
 }
 
 type TMDBContentDetails struct {
@@ -89,7 +107,11 @@ type TMDBMovieDetails struct {
 	// Extra items because we use `append_to_response` on the request
 	Videos 					TMDBContentVideos 				`json:"videos"`
 	WatchProviders 	TMDBContentWatchProviders `json:"watch/providers"`
+	ReleaseDates    TMDBMovieReleaseDates     `json:"release_dates"`
 
+	// Our own additions, not from TMDB.
+	Certification string          `json:"certification"`
+	OthersWatched []OthersWatched `json:"othersWatched"`
 }
 
 type TMDBShowDetails struct {
@@ -113,6 +135,7 @@ type TMDBShowDetails struct {
 		Name           string  `json:"name"`
 		Overview       string  `json:"overview"`
 		ProductionCode string  `json:"production_code"`
+		Runtime        int     `json:"runtime"`
 		SeasonNumber   int     `json:"season_number"`
 		StillPath      string  `json:"still_path"`
 		VoteAverage    float64 `json:"vote_average"`
@@ -144,6 +167,31 @@ type TMDBShowDetails struct {
 	// Extra items because we use `append_to_response` on the request
 	Videos 					TMDBContentVideos 				`json:"videos"`
 	WatchProviders 	TMDBContentWatchProviders `json:"watch/providers"`
+	ContentRatings  TMDBShowContentRatings    `json:"content_ratings"`
+
+	// Our own additions, not from TMDB.
+	Certification string          `json:"certification"`
+	OthersWatched []OthersWatched `json:"othersWatched"`
+}
+
+type TMDBMovieReleaseDates struct {
+	Results []struct {
+		Iso31661     string `json:"iso_3166_1"`
+		ReleaseDates []struct {
+			Certification string `json:"certification"`
+			Iso6391       string `json:"iso_639_1"`
+			Note          string `json:"note"`
+			ReleaseDate   string `json:"release_date"`
+			Type          int    `json:"type"`
+		} `json:"release_dates"`
+	} `json:"results"`
+}
+
+type TMDBShowContentRatings struct {
+	Results []struct {
+		Iso31661 string `json:"iso_3166_1"`
+		Rating   string `json:"rating"`
+	} `json:"results"`
 }
 
 type TMDBWatchProvider struct {
@@ -249,7 +297,7 @@ type TMDBPersonDetails struct {
 type TMDBPersonCombinedCredits struct {
 	ID   int                             `json:"id"`
 	Cast []TMDBPersonCombinedCreditsCast `json:"cast"`
-	// crew TMDBPersonCombinedCreditsCrew
+	Crew []TMDBPersonCombinedCreditsCrew `json:"crew"`
 }
 
 type TMDBPersonCombinedCreditsCast struct {
@@ -277,6 +325,33 @@ type TMDBPersonCombinedCreditsCast struct {
 	Adult            bool     `json:"adult"`
 }
 
+// Same as TMDBPersonCombinedCreditsCast, but with the persons job instead of character.
+type TMDBPersonCombinedCreditsCrew struct {
+	ID               int      `json:"id"`
+	OriginalLanguage string   `json:"original_language"`
+	EpisodeCount     int      `json:"episode_count"`
+	Overview         string   `json:"overview"`
+	OriginCountry    []string `json:"origin_country"`
+	OriginalName     string   `json:"original_name"`
+	GenreIDs         []int    `json:"genre_ids"`
+	Name             string   `json:"name"`
+	MediaType        string   `json:"media_type"`
+	PosterPath       string   `json:"poster_path"`
+	FirstAirDate     string   `json:"first_air_date"`
+	VoteAverage      float64  `json:"vote_average"`
+	VoteCount        uint32   `json:"vote_count"`
+	Department       string   `json:"department"`
+	Job              string   `json:"job"`
+	BackdropPath     string   `json:"backdrop_path"`
+	Popularity       float64  `json:"popularity"`
+	CreditID         string   `json:"credit_id"`
+	OriginalTitle    string   `json:"original_title"`
+	Video            bool     `json:"video"`
+	ReleaseDate      string   `json:"release_date"`
+	Title            string   `json:"title"`
+	Adult            bool     `json:"adult"`
+}
+
 type TMDBContentCredits struct {
 	ID   int `json:"id"`
 	Cast []struct {
@@ -308,9 +383,53 @@ type TMDBContentCredits struct {
 	} `json:"crew"`
 }
 
+type TMDBMovieKeywords struct {
+	ID       int `json:"id"`
+	Keywords []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"keywords"`
+}
+
+// Same as TMDBMovieKeywords, but TMDB calls the list results for shows.
+type TMDBShowKeywords struct {
+	ID      int `json:"id"`
+	Results []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"results"`
+}
+
+// Ids of content that changed in a time window (from /movie/changes or /tv/changes).
+type TMDBChangesResponse struct {
+	Results []struct {
+		ID    int  `json:"id"`
+		Adult bool `json:"adult"`
+	} `json:"results"`
+	Page         int `json:"page"`
+	TotalPages   int `json:"total_pages"`
+	TotalResults int `json:"total_results"`
+}
+
+// Deduplicates concurrent identical TMDB requests.
+var tmdbRequestGroup singleflight.Group
+
+var (
+	// Where TMDB requests are sent and what sends them, tests point these at a fake TMDB.
+	tmdbBaseURL = "https://api.themoviedb.org/3"
+	tmdbClient  = http.DefaultClient
+)
+
+var (
+	// TMDB has nothing with the id requested.
+	ErrTMDBNotFound = errors.New("not found on tmdb")
+	// TMDB couldn't be reached or failed to answer, so it's not the requests fault.
+	ErrTMDBUnavailable = errors.New("tmdb is unavailable")
+)
+
 func tmdbAPIRequest(ep string, p map[string]string) ([]byte, error) {
 	slog.Debug("tmdbAPIRequest", "endpoint", ep, "params", p)
-	base, err := url.Parse("https://api.themoviedb.org/3")
+	base, err := url.Parse(tmdbBaseURL)
 	if err != nil {
 		return nil, errors.New("failed to parse api uri")
 	}
@@ -329,21 +448,53 @@ func tmdbAPIRequest(ep string, p map[string]string) ([]byte, error) {
 	// Add params to url
 	base.RawQuery = params.Encode()
 
-	// Run get request
-	res, err := http.Get(base.String())
+	// Identical requests already in flight share one upstream request (the url is the key,
+	// Encode sorts params so their order doesn't matter). Callers only read the shared body.
+	body, err, _ := tmdbRequestGroup.Do(base.String(), func() (interface{}, error) {
+		// Run get request
+		res, err := tmdbClient.Get(base.String())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTMDBUnavailable, err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != 200 {
+			slog.Error("TMDB non 200 status code:", "status_code", res.StatusCode)
+			if res.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("%w: %s", ErrTMDBNotFound, body)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrTMDBUnavailable, body)
+		}
+		return body, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	body, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return nil, err
+	return body.([]byte), nil
+}
+
+// Error for a failed TMDB request, saying what failed (msg) while keeping whether TMDB
+// didn't have what was asked for or failed itself, so handlers can respond with tmdbErrorStatus.
+func tmdbRequestError(msg string, err error) error {
+	if errors.Is(err, ErrTMDBNotFound) {
+		return fmt.Errorf("%s: %w", msg, ErrTMDBNotFound)
 	}
-	if res.StatusCode != 200 {
-		slog.Error("TMDB non 200 status code:", "status_code", res.StatusCode)
-		return nil, errors.New(string(body))
+	return fmt.Errorf("%s: %w", msg, ErrTMDBUnavailable)
+}
+
+// Status to respond with for an error from tmdbRequestError. A 404 when TMDB
+// doesn't know the id, a 502 when TMDB failed, otherwise the request was bad.
+func tmdbErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTMDBNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTMDBUnavailable):
+		return http.StatusBadGateway
 	}
-	return body, nil
+	return http.StatusBadRequest
 }
 
 func tmdbRequest(ep string, p map[string]string, resp interface{}) error {
@@ -357,3 +508,44 @@ func tmdbRequest(ep string, p map[string]string, resp interface{}) error {
 	}
 	return nil
 }
+
+type TMDBMovieAlternativeTitles struct {
+	ID     int `json:"id"`
+	Titles []struct {
+		Iso3166_1 string `json:"iso_3166_1"`
+		Title     string `json:"title"`
+		Type      string `json:"type"`
+	} `json:"titles"`
+}
+
+// Same as TMDBMovieAlternativeTitles, but TMDB calls the list results for shows.
+type TMDBShowAlternativeTitles struct {
+	ID      int `json:"id"`
+	Results []struct {
+		Iso3166_1 string `json:"iso_3166_1"`
+		Title     string `json:"title"`
+		Type      string `json:"type"`
+	} `json:"results"`
+}
+
+// A person credited on an episode, as cast, guest star or crew.
+type TMDBEpisodeCredit struct {
+	ID                 int     `json:"id"`
+	Name               string  `json:"name"`
+	OriginalName       string  `json:"original_name"`
+	KnownForDepartment string  `json:"known_for_department"`
+	Popularity         float64 `json:"popularity"`
+	ProfilePath        string  `json:"profile_path"`
+	CreditID           string  `json:"credit_id"`
+	Character          string  `json:"character"`
+	Order              int     `json:"order"`
+	Department         string  `json:"department"`
+	Job                string  `json:"job"`
+}
+
+type TMDBEpisodeCredits struct {
+	ID         int                 `json:"id"`
+	Cast       []TMDBEpisodeCredit `json:"cast"`
+	GuestStars []TMDBEpisodeCredit `json:"guest_stars"`
+	Crew       []TMDBEpisodeCredit `json:"crew"`
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Concurrent identical requests must share one upstream request.
+func TestTMDBRequestsShared(t *testing.T) {
+	var hits atomic.Int32
+	useFakeTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		// Slow enough that every request is made while the first is in flight.
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, `{"id":550,"title":"Fight Club"}`)
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp TMDBMovieDetails
+			if err := tmdbRequest("/movie/550", map[string]string{"append_to_response": "videos"}, &resp); err != nil {
+				errs <- err
+				return
+			}
+			if resp.Title != "Fight Club" {
+				t.Errorf("got title %q, want Fight Club", resp.Title)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("request failed: %v", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+}
+
+// Detail routes respond 404 when TMDB doesn't have the id and 502 when TMDB fails.
+func TestTMDBDetailErrors(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/content/movie/550", http.StatusOK},
+		{"/content/movie/404404", http.StatusNotFound},
+		{"/content/movie/500500", http.StatusBadGateway},
+		{"/content/tv/404404", http.StatusNotFound},
+		{"/content/tv/500500", http.StatusBadGateway},
+		{"/content/person/404404", http.StatusNotFound},
+		{"/content/person/500500", http.StatusBadGateway},
+	} {
+		status, b := s.do("GET", tc.path, token, "")
+		if status != tc.want {
+			t.Errorf("GET %s: got status %d, want %d (body: %s)", tc.path, status, tc.want, b)
+		}
+	}
+
+	// TMDB being unreachable is its fault too.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	tmdbBaseURL = down.URL + "/3"
+	for _, p := range []string{"/content/movie/603", "/content/tv/1399", "/content/person/287"} {
+		status, b := s.do("GET", p, token, "")
+		if status != http.StatusBadGateway {
+			t.Errorf("GET %s with tmdb down: got status %d, want 502 (body: %s)", p, status, b)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// Issuer of mfa tokens, different to jwtIssuer so AuthRequired never accepts them.
+const jwtMFAIssuer = "watcharr-mfa"
+
+// How long users have to enter their code after logging in.
+const mfaTokenLifetime = 5 * time.Minute
+
+// Wrong codes allowed for one mfa token, so codes can't be guessed.
+const mfaMaxAttempts = 5
+
+// Seconds each TOTP code is valid for, and how many steps either
+// side of now are accepted (to allow for clock drift).
+const (
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+var (
+	ErrInvalidMFAToken = errors.New("invalid or expired mfa token, login again")
+	ErrInvalidTOTPCode = errors.New("invalid code")
+)
+
+type MFATokenClaims struct {
+	UserID       uint   `json:"userId"`
+	TokenVersion uint   `json:"tokenVersion"`
+	Type         string `json:"type"`
+	jwt.RegisteredClaims
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TOTPSetupResponse struct {
+	// Base32 secret, for entering into an authenticator app manually.
+	Secret string `json:"secret"`
+	// otpauth:// uri, usually shown as a qr code.
+	URI string `json:"uri"`
+}
+
+// Failed code attempts for each mfa token (by its id).
+var mfaAttempts sync.Map
+
+// Response for a user who has logged in, but must still enter a code.
+func mfaRequired(user *User) (AuthResponse, error) {
+	id, err := generateRandomBytes(16)
+	if err != nil {
+		slog.Error("mfaRequired: Failed to generate token id", "error", err)
+		return AuthResponse{}, errors.New("failed to get auth token")
+	}
+	now := timeNow()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, MFATokenClaims{
+		UserID:       user.ID,
+		TokenVersion: user.TokenVersion,
+		Type:         "mfa_pending",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        fmt.Sprintf("%x", id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenLifetime)),
+			Issuer:    jwtMFAIssuer,
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		slog.Error("mfaRequired: Failed to sign mfa token", "error", err)
+		return AuthResponse{}, errors.New("failed to get auth token")
+	}
+	return AuthResponse{MFARequired: true, MFAToken: token}, nil
+}
+
+// Exchange an mfa token and a valid code for a full auth token.
+func verifyMFA(db *gorm.DB, vr MFAVerifyRequest) (AuthResponse, error) {
+	claims := new(MFATokenClaims)
+	_, err := jwt.ParseWithClaims(vr.MFAToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(jwtMFAIssuer))
+	if err != nil || claims.Type != "mfa_pending" || claims.ExpiresAt == nil {
+		return AuthResponse{}, ErrInvalidMFAToken
+	}
+	pruneMFAAttempts()
+	if a, ok := mfaAttempts.Load(claims.ID); ok && a.(mfaAttempt).count >= mfaMaxAttempts {
+		return AuthResponse{}, ErrInvalidMFAToken
+	}
+	var user User
+	if res := db.Where("id = ?", claims.UserID).Take(&user); res.Error != nil {
+		return AuthResponse{}, ErrInvalidMFAToken
+	}
+	if user.TokenVersion != claims.TokenVersion || !user.TOTPEnabled {
+		return AuthResponse{}, ErrInvalidMFAToken
+	}
+	if err := useTOTPCode(db, &user, user.TOTPSecret, vr.Code); err != nil {
+		a, _ := mfaAttempts.LoadOrStore(claims.ID, mfaAttempt{expires: claims.ExpiresAt.Time})
+		mfaAttempts.Store(claims.ID, mfaAttempt{count: a.(mfaAttempt).count + 1, expires: claims.ExpiresAt.Time})
+		slog.Warn("verifyMFA: Invalid code", "userId", user.ID)
+		return AuthResponse{}, err
+	}
+	mfaAttempts.Delete(claims.ID)
+	token, err := signJWT(&user)
+	if err != nil {
+		slog.Error("Failed to sign new (mfa verified) jwt", "error", err)
+		return AuthResponse{}, errors.New("failed to get auth token")
+	}
+	return newAuthResponse(&user, token), nil
+}
+
+// User an mfa token was given to, 0 if it isn't a valid one we signed.
+func mfaTokenUserId(mfaToken string) uint {
+	claims := new(MFATokenClaims)
+	_, err := jwt.ParseWithClaims(mfaToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(jwtMFAIssuer))
+	if err != nil || claims.Type != "mfa_pending" {
+		return 0
+	}
+	return claims.UserID
+}
+
+type mfaAttempt struct {
+	count   int
+	expires time.Time
+}
+
+// Forget attempts for mfa tokens that have expired.
+func pruneMFAAttempts() {
+	mfaAttempts.Range(func(k, v any) bool {
+		if timeNow().After(v.(mfaAttempt).expires) {
+			mfaAttempts.Delete(k)
+		}
+		return true
+	})
+}
+
+// Generate a new TOTP secret for a user, it isn't used until they enable it with a valid code.
+func setupTOTP(db *gorm.DB, userId uint) (TOTPSetupResponse, error) {
+	var user User
+	if res := db.Select("id", "username", "totp_enabled").Where("id = ?", userId).Take(&user); res.Error != nil {
+		slog.Error("setupTOTP: Failed to get user", "userId", userId, "error", res.Error)
+		return TOTPSetupResponse{}, errors.New("failed to get user")
+	}
+	if user.TOTPEnabled {
+		return TOTPSetupResponse{}, errors.New("2fa is already enabled, disable it first")
+	}
+	b, err := generateRandomBytes(20)
+	if err != nil {
+		slog.Error("setupTOTP: Failed to generate secret", "error", err)
+		return TOTPSetupResponse{}, errors.New("failed to generate secret")
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	if res := db.Model(&User{}).Where("id = ?", userId).Update("totp_secret", secret); res.Error != nil {
+		slog.Error("setupTOTP: Failed to save secret", "userId", userId, "error", res.Error)
+		return TOTPSetupResponse{}, errors.New("failed to save secret")
+	}
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/Watcharr:" + user.Username,
+		RawQuery: url.Values{"secret": {secret}, "issuer": {"Watcharr"}}.Encode(),
+	}
+	return TOTPSetupResponse{Secret: secret, URI: uri.String()}, nil
+}
+
+// Turn on 2fa, once the user has proven their authenticator has the secret from setup.
+func enableTOTP(db *gorm.DB, userId uint, code string) error {
+	var user User
+	if res := db.Where("id = ?", userId).Take(&user); res.Error != nil {
+		return errors.New("failed to get user")
+	}
+	if user.TOTPEnabled {
+		return errors.New("2fa is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return errors.New("2fa hasn't been setup yet")
+	}
+	if err := useTOTPCode(db, &user, user.TOTPSecret, code); err != nil {
+		return err
+	}
+	if res := db.Model(&User{}).Where("id = ?", userId).Update("totp_enabled", true); res.Error != nil {
+		slog.Error("enableTOTP: Failed to enable 2fa", "userId", userId, "error", res.Error)
+		return errors.New("failed to enable 2fa")
+	}
+	slog.Info("User enabled 2fa", "userId", userId)
+	return nil
+}
+
+// Turn off 2fa, a valid code is needed so a stolen session can't remove it.
+func disableTOTP(db *gorm.DB, userId uint, code string) error {
+	var user User
+	if res := db.Where("id = ?", userId).Take(&user); res.Error != nil {
+		return errors.New("failed to get user")
+	}
+	if !user.TOTPEnabled {
+		return errors.New("2fa isn't enabled")
+	}
+	if err := useTOTPCode(db, &user, user.TOTPSecret, code); err != nil {
+		return err
+	}
+	res := db.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_counter": 0})
+	if res.Error != nil {
+		slog.Error("disableTOTP: Failed to disable 2fa", "userId", userId, "error", res.Error)
+		return errors.New("failed to disable 2fa")
+	}
+	slog.Info("User disabled 2fa", "userId", userId)
+	return nil
+}
+
+// Check code is valid for secret right now, and hasn't been used before.
+// The time step it was for is recorded, so it can't be used again.
+func useTOTPCode(db *gorm.DB, user *User, secret string, code string) error {
+	counter, ok := validateTOTP(secret, code, timeNow())
+	if !ok || counter <= user.TOTPLastCounter {
+		return ErrInvalidTOTPCode
+	}
+	// Conditional, so the same code used twice at once only succeeds once.
+	res := db.Model(&User{}).Where("id = ? AND totp_last_counter < ?", user.ID, counter).Update("totp_last_counter", counter)
+	if res.Error != nil {
+		slog.Error("useTOTPCode: Failed to save used code", "userId", user.ID, "error", res.Error)
+		return errors.New("failed to check code")
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidTOTPCode
+	}
+	user.TOTPLastCounter = counter
+	return nil
+}
+
+// Check a TOTP code (RFC 6238) against secret at time t, allowing totpSkew steps
+// either side. Returns the time step the code was for.
+func validateTOTP(secret string, code string, t time.Time) (uint64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != 6 {
+		return 0, false
+	}
+	now := uint64(t.Unix()) / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := uint64(int64(now) + int64(i))
+		if subtle.ConstantTimeCompare([]byte(hotp(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// HOTP code (RFC 4226) for key at counter.
+func hotp(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
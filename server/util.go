@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+// Current time, everything should get it from here (not time.Now)
+// so tests can set the clock instead of depending on when they run.
+var timeNow = time.Now
+
+// Strip all html tags from a user supplied string, so it can't cause stored
+// xss in a client that renders it without escaping. Text is kept as entered,
+// entities included (they're never decoded, so they can't turn into tags).
+// Stripping is repeated until nothing changes, since removing a tag can
+// leave the text around it forming a new one (eg. `<<b>img ...>`).
+func sanitizeString(s string) string {
+	for {
+		stripped := stripTags(s)
+		if stripped == s {
+			return strings.TrimSpace(s)
+		}
+		s = stripped
+	}
+}
+
+// Remove tags, comments and the contents of script and style elements from s.
+func stripTags(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			if !skip {
+				b.Write(z.Raw())
+			}
+		case html.StartTagToken, html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "script" || string(name) == "style" {
+				skip = tt == html.StartTagToken
+			}
+		}
+	}
+}
+
+// If error is from a unique constraint failing. Requires
+// gorm to be opened with TranslateError enabled.
+func isDuplicateErr(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// Escape LIKE wildcards in s, for queries using ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// If a file exists at path.
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// If string contains any control characters (eg. null bytes, escape codes).
+func hasControlChars(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) != -1
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestSanitizeString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Don't & won't", "Don't & won't"},
+		{"  padded  ", "padded"},
+		{"<b>bold</b> text", "bold text"},
+		{"<img src=x onerror=alert(1)>", ""},
+		{"&lt;img src=x onerror=alert(1)&gt;", "&lt;img src=x onerror=alert(1)&gt;"},
+		{"<<b>img src=x onerror=alert(1)>", ""},
+		{"<script>alert(1)</script>hi", "hi"},
+		{"<!-- note -->hi", "hi"},
+		{"1 < 2", "1 < 2"},
+		{"기생충", "기생충"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeString(tt.in); got != tt.want {
+			t.Errorf("sanitizeString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+var postgresDSN = flag.String("postgres", "", "dsn of a postgres database to also run database tests against (eg. go test -postgres 'host=localhost user=watcharr dbname=watcharr_test'), its tables are dropped")
+
+// Databases to run a test against, sqlite and postgres when the -postgres flag is given.
+// Only models are migrated.
+func testDatabases(t *testing.T, models ...any) map[string]*gorm.DB {
+	t.Helper()
+	dbs := map[string]*gorm.DB{"sqlite": newTestDB(t)}
+	if *postgresDSN == "" {
+		return dbs
+	}
+	db, err := gorm.Open(postgres.Open(*postgresDSN), newGormConfig())
+	if err != nil {
+		t.Fatalf("failed to open postgres: %v", err)
+	}
+	dropTables := func() {
+		if err := db.Migrator().DropTable(models...); err != nil {
+			t.Errorf("failed to drop postgres tables: %v", err)
+		}
+	}
+	dropTables()
+	t.Cleanup(func() {
+		dropTables()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("failed to migrate postgres: %v", err)
+	}
+	dbs["postgres"] = db
+	return dbs
+}
+
+func TestIsDuplicateErr(t *testing.T) {
+	for name, db := range testDatabases(t, &User{}, &Tag{}) {
+		mustCreate(t, db, &User{Username: "alice", Password: "x"}, &Tag{UserID: 1, Name: "cozy"})
+		for _, tc := range []struct {
+			name   string
+			create func() error
+			want   bool
+		}{
+			{"duplicate user", func() error { return db.Create(&User{Username: "alice", Password: "x"}).Error }, true},
+			{"duplicate tag", func() error { return db.Create(&Tag{UserID: 1, Name: "cozy"}).Error }, true},
+			{"same username different type", func() error { return db.Create(&User{Username: "alice", Type: JELLYFIN_USER, Password: "x"}).Error }, false},
+			{"same tag name other user", func() error { return db.Create(&Tag{UserID: 2, Name: "cozy"}).Error }, false},
+			{"not null", func() error { return db.Exec("INSERT INTO tags (user_id, name) VALUES (1, NULL)").Error }, false},
+			{"missing table", func() error { return db.Exec("INSERT INTO nothing (id) VALUES (1)").Error }, false},
+			{"other error", func() error { return errors.New("UNIQUE constraint failed: users.username") }, false},
+		} {
+			if got := isDuplicateErr(tc.create()); got != tc.want {
+				t.Errorf("%s: isDuplicateErr for %s = %v, want %v", name, tc.name, got, tc.want)
+			}
+		}
+	}
+}
+
+// Duplicate usernames are a conflict, not an unknown error.
+func TestRegisterDuplicateUser(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice")
+	s.expect("POST", "/auth/register", "", `{"username":"alice","password":"password123"}`, http.StatusConflict, nil)
+	var n int64
+	s.db.Model(&User{}).Where("username = ?", "alice").Count(&n)
+	if n != 1 {
+		t.Errorf("got %d users named alice, want 1", n)
+	}
+}
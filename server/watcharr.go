@@ -2,14 +2,21 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -27,23 +34,17 @@ type GormModel struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"`
 }
 
-var AvailableAuthProviders = []string{}
-
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Failed to load vars from .env file:", err)
 	}
+	// Before anything else, so all logs are output in the configured format.
+	setupLogging()
 	ensureEnv()
+	ensureDataDir()
 
-	multiw := setupLogging()
-	slog.Info("Watcharr Starting")
-
-	// Ensure data dir exists
-	err = ensureDirExists("./data")
-	if err != nil {
-		log.Fatal("Failed to create data dir:", err)
-	}
+	slog.Info("Watcharr Starting", "data_dir", getDataDir())
 
 	// Check if we want to be in DEV or PROD
 	isProd := true
@@ -51,31 +52,81 @@ func main() {
 		isProd = false
 	}
 
-	db, err := gorm.Open(sqlite.Open("./data/watcharr.db"), &gorm.Config{})
+	db, err := openDB(dataPath("watcharr.db"))
 	if err != nil {
 		panic("failed to connect to database")
 	}
 
-	err = db.AutoMigrate(&User{}, &Content{}, &Watched{}, &Activity{})
+	if err := migrateContentProvider(db); err != nil {
+		log.Fatal("Failed to add provider to existing content:", err)
+	}
+	// Duplicate content would stop the content unique index being created, merge it first.
+	if db.Migrator().HasTable(&Content{}) && !db.Migrator().HasIndex(&Content{}, "contentprovideridx") {
+		if _, err := mergeDuplicateContent(db); err != nil {
+			slog.Error("Failed to merge duplicate content before migrating", "error", err)
+		}
+	}
+	err = db.AutoMigrate(dbModels...)
 	if err != nil {
 		log.Fatal("Failed to auto migrate database:", err)
 	}
+	// Watched unique index now includes sub profile, drop the old one.
+	if db.Migrator().HasIndex(&Watched{}, "usernctnidx") {
+		err = db.Migrator().DropIndex(&Watched{}, "usernctnidx")
+		if err != nil {
+			log.Fatal("Failed to drop old watched unique index:", err)
+		}
+	}
+	// Content unique index is now by provider, drop the old tmdb only one.
+	if db.Migrator().HasIndex(&Content{}, "contentidtotypeidx") {
+		err = db.Migrator().DropIndex(&Content{}, "contentidtotypeidx")
+		if err != nil {
+			log.Fatal("Failed to drop old content unique index:", err)
+		}
+	}
+	// User unique index now includes jellyfin server, drop the old one.
+	if db.Migrator().HasIndex(&User{}, "usr_name_to_type") {
+		err = db.Migrator().DropIndex(&User{}, "usr_name_to_type")
+		if err != nil {
+			log.Fatal("Failed to drop old user unique index:", err)
+		}
+	}
+	// Keyword names were stored twice, they're only kept in keyword_list now.
+	if db.Migrator().HasColumn(&Content{}, "keywords") {
+		db.Exec(`UPDATE contents SET keyword_list = (SELECT json_group_array(json_object('id', 0, 'name', value)) FROM json_each(contents.keywords))
+			WHERE keyword_list IS NULL AND json_array_length(keywords) > 0`)
+		err = db.Migrator().DropColumn(&Content{}, "keywords")
+		if err != nil {
+			log.Fatal("Failed to drop old content keywords column:", err)
+		}
+	}
+	migrateJellyfinHost(db)
+	ensureAdminExists(db)
+
+	go startImageDownloader()
+	go startContentRefreshJob(db)
+	go startDeletedUserPurgeJob(db)
+	go startAuthLogPruneJob(db)
+	go startNotificationPruneJob(db)
+	startJobWorkers(db)
 
 	if isProd {
-		go runUI()
+		if !isServingFrontend() {
+			go runUI()
+		}
 		gin.SetMode(gin.ReleaseMode)
 	}
-	gin.DefaultWriter = multiw
-	gine := gin.Default()
-	gine.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "accept", "origin", "Cache-Control", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-	if isProd {
+	gin.DefaultWriter = slogWriter{level: slog.LevelDebug}
+	gin.DefaultErrorWriter = slogWriter{level: slog.LevelError}
+	gine, err := newEngine(db)
+	if err != nil {
+		log.Fatal("Failed to set trusted proxies, check TRUSTED_PROXIES env var:", err)
+	}
+	checkAPIDocs(getAPIPrefix(), gine.Routes())
+	if isServingFrontend() {
+		// Serve the frontend build ourselves, no UI server needed
+		gine.NoRoute(serveFrontend(getFrontendDir()))
+	} else if isProd {
 		// Proxy NoRoute requests to UI server
 		gine.NoRoute(func(c *gin.Context) {
 			director := func(req *http.Request) {
@@ -86,15 +137,76 @@ func main() {
 			proxy.ServeHTTP(c.Writer, c.Request)
 		})
 	}
-	br := newBaseRouter(db, gine.Group("/api"))
-	br.addAuthRoutes()
-	br.addContentRoutes()
-	br.addWatchedRoutes()
-	br.addActivityRoutes()
-	br.addProfileRoutes()
-	br.rg.Static("/img", "./data/img")
 
-	gine.Run("0.0.0.0:3080")
+	listenAddr := getListenAddr()
+	if socket := os.Getenv("UNIX_SOCKET"); socket != "" {
+		slog.Info("Listening on unix socket", "socket", socket)
+		// Remove socket left behind from a previous run, or we can't listen.
+		if fi, err := os.Stat(socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(socket)
+		}
+		err = gine.RunUnix(socket)
+	} else if os.Getenv("TLS_CERT") != "" {
+		slog.Info("Listening with TLS", "address", listenAddr)
+		err = gine.RunTLS(listenAddr, os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY"))
+	} else {
+		slog.Info("Listening", "address", listenAddr)
+		err = gine.Run(listenAddr)
+	}
+	if err != nil {
+		log.Fatal("Failed to start server:", err)
+	}
+}
+
+// Every model we store, all are auto migrated on startup.
+var dbModels = []any{&User{}, &Content{}, &Watched{}, &Activity{}, &SubProfile{}, &WatchedEpisode{}, &Notification{}, &UserProfile{}, &ServerSettings{}, &JellyfinServer{}, &WatchGoal{}, &Job{}, &ReWatchEntry{}, &AuthLog{}, &Tag{}, &WatchedTag{}, &ContentCredit{}}
+
+// Open the sqlite database at dsn.
+func openDB(dsn string) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open(dsn), newGormConfig())
+}
+
+// Config our database is opened with, whatever the driver.
+func newGormConfig() *gorm.Config {
+	return &gorm.Config{
+		// So isDuplicateErr works without matching driver specific error messages.
+		TranslateError: true,
+		// Store all timestamps in UTC, they are converted to a users timezone when needed.
+		// Older rows were stored in server local time, but always with their offset,
+		// so they are still read back as the correct instant and don't need converting.
+		NowFunc: func() time.Time { return timeNow().UTC() },
+	}
+}
+
+// Create the gin engine with our middleware and all api routes registered.
+// Only errors when the configured trusted proxies are invalid.
+func newEngine(db *gorm.DB) (*gin.Engine, error) {
+	gine := gin.New()
+	gine.Use(requestLogger(), gin.Recovery(), limitRequestBody(routeBodyLimits(), getMaxBodySize()))
+	// Only trust X-Forwarded-For from our configured proxies, so c.ClientIP() can't be spoofed.
+	if err := gine.SetTrustedProxies(getTrustedProxies()); err != nil {
+		return nil, err
+	}
+	gine.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "accept", "origin", "Cache-Control", "X-Requested-With", "X-Profile-Id"},
+		ExposeHeaders:    []string{"Content-Length"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+	if level := getCompressionLevel(); level != gzip.NoCompression {
+		gine.Use(compressResponses(level))
+	}
+	br := newBaseRouter(db, gine.Group(getAPIPrefix()))
+	br.addAPIRoutes()
+	if getAPIPrefix() != legacyAPIPrefix {
+		// Keep the unversioned routes working for a release, so older clients don't break.
+		// TODO: Remove in the release after next.
+		lbr := newBaseRouter(db, gine.Group(legacyAPIPrefix, deprecatedAPI(getAPIPrefix())))
+		lbr.addAPIRoutes()
+	}
+	return gine, nil
 }
 
 // Ensure all required environment variables are set.
@@ -103,29 +215,175 @@ func ensureEnv() {
 		log.Fatal("JWT_SECRET env var missing!")
 	}
 
-	if os.Getenv("JELLYFIN_HOST") != "" {
-		AvailableAuthProviders = append(AvailableAuthProviders, "jellyfin")
+	if lf := os.Getenv("LOG_FORMAT"); lf != "" && strings.ToLower(lf) != getLogFormat() {
+		slog.Warn("LOG_FORMAT env var is invalid, falling back to text", "log_format", lf)
+	}
+
+	if ll := os.Getenv("LOG_LEVEL"); ll != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(ll)); err != nil {
+			slog.Warn("LOG_LEVEL env var is invalid, must be one of debug, info, warn or error", "log_level", ll)
+		}
+	}
+
+	if av := os.Getenv("ARGON_VARIANT"); av != "" && av != getArgonVariant() {
+		slog.Warn("ARGON_VARIANT env var is invalid, falling back to argon2id", "argon_variant", av)
+	}
+
+	if ps := os.Getenv("POSTER_SIZE"); ps != "" && ps != getPosterSize() {
+		slog.Warn("POSTER_SIZE env var is invalid, falling back to w500", "poster_size", ps, "valid_sizes", validPosterSizes)
+	}
+
+	for _, name := range []string{"MAX_BODY_SIZE_MB", "MAX_IMPORT_SIZE_MB"} {
+		if v := os.Getenv(name); v != "" {
+			if mb, err := strconv.ParseInt(v, 10, 64); err != nil || mb <= 0 {
+				slog.Warn(name+" env var is invalid, must be a whole number of megabytes above 0, falling back to default", "value", v)
+			}
+		}
+	}
+
+	if cl := os.Getenv("COMPRESSION_LEVEL"); cl != "" && cl != strconv.Itoa(getCompressionLevel()) {
+		slog.Warn("COMPRESSION_LEVEL env var is invalid, must be between 0 and 9, falling back to default", "compression_level", cl)
+	}
+
+	if base := os.Getenv("TMDB_IMAGE_BASE"); base != "" {
+		if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatal("TMDB_IMAGE_BASE env var must be a http(s) url (eg. https://image.tmdb.org/t/p/): ", base)
+		}
+	}
+
+	if port := os.Getenv("PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			log.Fatal("PORT env var must be a number between 1 and 65535, got: ", port)
+		}
+	}
+	if host := os.Getenv("HOST"); host != "" && strings.ContainsAny(host, " /:") && net.ParseIP(host) == nil {
+		log.Fatal("HOST env var is not a valid ip or hostname (eg. 127.0.0.1): ", host)
+	}
+	if _, _, err := net.SplitHostPort(getListenAddr()); err != nil {
+		log.Fatal("LISTEN_ADDR env var is not a valid address (eg. 0.0.0.0:3080): ", err)
+	}
+	if os.Getenv("UNIX_SOCKET") != "" && os.Getenv("TLS_CERT") != "" {
+		log.Fatal("UNIX_SOCKET can't be used with TLS_CERT, terminate TLS in your reverse proxy instead.")
+	}
+
+	if p := os.Getenv("API_PREFIX"); p != "" && (!strings.HasPrefix(p, "/") || strings.ContainsAny(p, " :*")) {
+		log.Fatal("API_PREFIX env var must be a path starting with / (eg. /api/v1): ", p)
+	}
+
+	if isAuthCookieEnabled() {
+		if strings.ContainsAny(getAuthCookieName(), " ;,=\t") {
+			log.Fatal("AUTH_COOKIE_NAME env var must not contain spaces, ;, , or =: ", getAuthCookieName())
+		}
+		if !strings.HasPrefix(getAuthCookiePath(), "/") {
+			log.Fatal("AUTH_COOKIE_PATH env var must be a path starting with / (eg. /): ", getAuthCookiePath())
+		}
+	}
+
+	if isServingFrontend() {
+		if _, err := os.Stat(path.Join(getFrontendDir(), "index.html")); err != nil {
+			log.Fatal("SERVE_FRONTEND is enabled, but FRONTEND_DIR doesn't contain an index.html (is it a static build?): ", err)
+		}
+	}
+
+	// Fail now if TLS is misconfigured, instead of when we start listening.
+	tlsCert := os.Getenv("TLS_CERT")
+	tlsKey := os.Getenv("TLS_KEY")
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatal("TLS_CERT and TLS_KEY env vars must both be set to enable TLS!")
+	}
+	if tlsCert != "" {
+		if _, err := tls.LoadX509KeyPair(tlsCert, tlsKey); err != nil {
+			log.Fatal("Failed to load TLS certificate from TLS_CERT and TLS_KEY: ", err)
+		}
 	}
 }
 
-// Setup slog defaults
-func setupLogging() io.Writer {
-	level := slog.LevelInfo
-	if os.Getenv("DEBUG") == "true" {
-		level = slog.LevelDebug
+// Address the server should listen on. LISTEN_ADDR takes
+// priority, otherwise it is built from HOST and PORT.
+func getListenAddr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	host := os.Getenv("HOST")
+	if host == "" {
+		host = "0.0.0.0"
 	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3080"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Prefix our api routes were served under before they were versioned.
+const legacyAPIPrefix = "/api"
+
+// Get prefix api routes are served under from API_PREFIX, defaulting to /api/v1.
+func getAPIPrefix() string {
+	if p := os.Getenv("API_PREFIX"); p != "" {
+		return strings.TrimSuffix(p, "/")
+	}
+	return "/api/v1"
+}
+
+// Get list of proxies (IPs or CIDRs) from TRUSTED_PROXIES.
+// Returns nil (trust no proxies) when unset.
+func getTrustedProxies() []string {
+	tp := os.Getenv("TRUSTED_PROXIES")
+	if tp == "" {
+		return nil
+	}
+	proxies := []string{}
+	for _, p := range strings.Split(tp, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// Setup slog defaults
+func setupLogging() {
 	multiw := io.MultiWriter(&lumberjack.Logger{
-		Filename:   "./data/watcharr.log",
+		Filename:   dataPath("watcharr.log"),
 		MaxSize:    1, // megabytes
 		MaxBackups: 3,
 		MaxAge:     28, // days
 		Compress:   false,
 	}, os.Stdout)
-	slog.SetDefault(slog.New(
-		slog.NewTextHandler(multiw, &slog.HandlerOptions{Level: level}),
-	))
-	slog.Info("Logging level set", "logging_level", level)
-	return multiw
+	logLevel.Set(getLogLevel())
+	slog.SetDefault(slog.New(newLogHandler(multiw, getLogFormat(), logLevel)))
+	slog.Info("Logging level set", "logging_level", logLevel.Level(), "logging_format", getLogFormat())
+}
+
+// Get log format from LOG_FORMAT (text or json), defaulting to text.
+func getLogFormat() string {
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "json" {
+		return "json"
+	}
+	return "text"
+}
+
+// Get log level from LOG_LEVEL, defaulting to info.
+// DEBUG=true is still supported for older configs.
+func getLogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err == nil {
+		return level
+	}
+	if os.Getenv("DEBUG") == "true" {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+func newLogHandler(w io.Writer, format string, level slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
 }
 
 // Run UI server
@@ -148,11 +406,3 @@ func runUI() {
 		log.Fatal("UI ERR ", err)
 	}
 }
-
-func ensureDirExists(dir string) error {
-	err := os.MkdirAll(dir, 0764)
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestLogHandlerMatchesLogFormat(t *testing.T) {
+	tests := []struct {
+		env  string
+		json bool
+	}{
+		{"", false},
+		{"text", false},
+		{"json", true},
+		{"JSON", true},
+		{"xml", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOG_FORMAT", tt.env)
+		h := newLogHandler(io.Discard, getLogFormat(), slog.LevelInfo)
+		if _, ok := h.(*slog.JSONHandler); ok != tt.json {
+			t.Errorf("LOG_FORMAT=%q: got %T, want json handler %v", tt.env, h, tt.json)
+		}
+	}
+}
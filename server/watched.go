@@ -9,11 +9,14 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type WatchedStatus string
@@ -26,29 +29,149 @@ const (
 	DROPPED  WatchedStatus = "DROPPED"
 )
 
+// Where a watched list item was added from.
+type WatchedSource string
+
+const (
+	SOURCE_MANUAL         WatchedSource = "manual"
+	SOURCE_JSON_IMPORT    WatchedSource = "json_import"
+	SOURCE_ACCOUNT_IMPORT WatchedSource = "account_import"
+	SOURCE_CSV_IMPORT     WatchedSource = "csv_import"
+)
+
 type Watched struct {
 	GormModel
-	Status    WatchedStatus `json:"status"`
-	Rating    int8          `json:"rating"`
-	Thoughts  string        `json:"thoughts"`
-	UserID    uint          `json:"-" gorm:"uniqueIndex:usernctnidx"`
-	ContentID int           `json:"-" gorm:"uniqueIndex:usernctnidx"`
-	Content   Content       `json:"content"`
-	Activity  []Activity    `json:"activity"`
+	Status       WatchedStatus `json:"status"`
+	Rating       int8          `json:"rating"`
+	Thoughts     string        `json:"thoughts"`
+	Source       WatchedSource `json:"source" gorm:"not null;default:manual"` // How this item was added, so imports can be audited/undone.
+	UserID       uint          `json:"-" gorm:"uniqueIndex:userprflctntidx"`
+	SubProfileID uint          `json:"-" gorm:"uniqueIndex:userprflctntidx;not null;default:0"` // Sub profile this item belongs to, 0 if the users main profile.
+	DisplayOrder int           `json:"displayOrder" gorm:"not null;default:0"`                  // Position in the users custom sort order (?sort=custom).
+	ContentID    int           `json:"-" gorm:"uniqueIndex:userprflctntidx"`
+	Content      Content       `json:"content"`
+	Activity     []Activity    `json:"activity"`
+
+	// Why the item was added (eg. recommended by a friend), separate from post-watch thoughts.
+	PlannedNote string `json:"plannedNote"`
+	// Set once the item has been FINISHED, so the planned note can be shown differently.
+	PlannedNoteFinished bool `json:"plannedNoteFinished" gorm:"not null;default:false"`
+	// Service or place the item was watched on (eg. Netflix, Cinema, Blu-ray).
+	WatchedOn string `json:"watchedOn"`
+	// Where playback of a movie is at, so it can be resumed (both in seconds).
+	// Runtime is what the client sent, it can differ from the contents runtime.
+	ProgressSeconds uint32 `json:"progressSeconds" gorm:"not null;default:0"`
+	ProgressRuntime uint32 `json:"progressRuntime" gorm:"not null;default:0"`
 }
 
 type WatchedAddRequest struct {
-	Status      WatchedStatus `json:"status"`
+	Status      WatchedStatus `json:"status" binding:"omitempty,oneof=FINISHED WATCHING PLANNED ONHOLD DROPPED"`
 	Rating      int8          `json:"rating" binding:"max=10"`
-	ContentID   int           `json:"contentId" binding:"required"`
-	ContentType ContentType   `json:"contentType" binding:"required,oneof=movie tv"`
+	ContentID   int           `json:"contentId" binding:"required_without=ImdbID"`
+	ContentType ContentType   `json:"contentType" binding:"required_without=ImdbID,omitempty,oneof=movie tv"`
+	WatchedOn   string        `json:"watchedOn" binding:"max=50"`
+	// Can be provided instead of ContentID, it will be resolved to its TMDB id.
+	ImdbID string `json:"imdbId"`
+	// Where ContentID is from, TMDB by default.
+	Provider ContentProvider `json:"provider" binding:"omitempty,oneof=tmdb anilist"`
+	// When the item was watched, for backfilling history. The entry and its
+	// activity are dated with it. Can only be in the future when status is PLANNED.
+	WatchedDate *time.Time `json:"watchedDate"`
+}
+
+var ErrWatchedDateInFuture = errors.New("watchedDate can't be in the future, unless status is PLANNED")
+
+// Ratings are always stored out of 10, whatever scale the user shows them in.
+// 0 means unrated.
+const maxRating = 10
+
+var ErrInvalidRating = fmt.Errorf("rating must be between 0 and %d", maxRating)
+
+// Check a rating is in range before it is saved.
+func validateRating(rating int8) error {
+	if rating < 0 || rating > maxRating {
+		return ErrInvalidRating
+	}
+	return nil
+}
+
+// Longest WatchedOn and PlannedNote, the same limits requests are bound with.
+const (
+	watchedOnMaxLength   = 50
+	plannedNoteMaxLength = 500
+)
+
+var watchedStatuses = []WatchedStatus{FINISHED, WATCHING, PLANNED, HOLD, DROPPED}
+
+var ErrInvalidStatus = errors.New("status must be one of FINISHED, WATCHING, PLANNED, ONHOLD or DROPPED")
+
+// Check a watched entry is valid before it is saved, for entries that
+// aren't from a request (eg. imports), so weren't checked when bound.
+func validateWatched(w Watched) error {
+	if !slices.Contains(watchedStatuses, w.Status) {
+		return ErrInvalidStatus
+	}
+	if err := validateRating(w.Rating); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(w.WatchedOn) > watchedOnMaxLength {
+		return fmt.Errorf("watchedOn can be at most %d characters", watchedOnMaxLength)
+	}
+	if utf8.RuneCountInString(w.PlannedNote) > plannedNoteMaxLength {
+		return fmt.Errorf("plannedNote can be at most %d characters", plannedNoteMaxLength)
+	}
+	return nil
+}
+
+// Returned (with 300 status) when an external id
+// matches more than one item, so the client can choose.
+type WatchedAddAmbiguousResponse struct {
+	Error      string                   `json:"error"`
+	Candidates []TMDBSearchMultiResults `json:"candidates"`
 }
 
 type WatchedUpdateRequest struct {
-	Status         WatchedStatus `json:"status" binding:"required_without_all=Rating Thoughts RemoveThoughts"`
-	Rating         int8          `json:"rating" binding:"max=10,required_without_all=Status Thoughts RemoveThoughts"`
-	Thoughts       string        `json:"thoughts" binding:"required_without_all=Status Rating RemoveThoughts"`
-	RemoveThoughts bool          `json:"removeThoughts"`
+	Status            WatchedStatus `json:"status" binding:"required_without_all=Rating Thoughts RemoveThoughts PlannedNote RemovePlannedNote WatchedOn RemoveWatchedOn,omitempty,oneof=FINISHED WATCHING PLANNED ONHOLD DROPPED"`
+	Rating            int8          `json:"rating" binding:"max=10,required_without_all=Status Thoughts RemoveThoughts PlannedNote RemovePlannedNote WatchedOn RemoveWatchedOn"`
+	Thoughts          string        `json:"thoughts" binding:"required_without_all=Status Rating RemoveThoughts PlannedNote RemovePlannedNote WatchedOn RemoveWatchedOn"`
+	RemoveThoughts    bool          `json:"removeThoughts"`
+	PlannedNote       string        `json:"plannedNote" binding:"max=500"`
+	RemovePlannedNote bool          `json:"removePlannedNote"`
+	WatchedOn         string        `json:"watchedOn" binding:"max=50"`
+	RemoveWatchedOn   bool          `json:"removeWatchedOn"`
+}
+
+// Query params that can be used to filter the watched list.
+type WatchedFilters struct {
+	Certification string        `form:"certification"`
+	Source        WatchedSource `form:"source" binding:"omitempty,oneof=manual json_import account_import csv_import"`
+	Type          ContentType   `form:"type"`
+	Status        WatchedStatus `form:"status" binding:"omitempty,oneof=FINISHED WATCHING PLANNED ONHOLD DROPPED"`
+	// Only shows with their next episode airing before this date (YYYY-MM-DD).
+	AiringBefore *time.Time `form:"airing_before" time_format:"2006-01-02"`
+	// ISO 639-1 codes, eg. original language French (fr) with English (en) audio.
+	SpokenLanguage   string `form:"spokenLanguage"`
+	OriginalLanguage string `form:"originalLanguage"`
+	// ISO 3166-1 code of a country content was produced in.
+	Country string `form:"country"`
+	// Year content was released.
+	Year int `form:"year" binding:"omitempty,min=1800,max=9999"`
+	// Matched against titles (including original and alternative ones) and keywords, like search.
+	Query string `form:"q" binding:"max=200"`
+	// Id of a tag entries must have (or match, for smart tags).
+	Tag uint `form:"tag"`
+	// Set to `custom` to order by the users custom order.
+	Sort string `form:"sort" binding:"omitempty,oneof=custom"`
+}
+
+type WatchedSearchQuery struct {
+	// Matched against titles (including original and alternative ones) and keywords (eg. heist).
+	Query string `form:"q" binding:"required"`
+}
+
+type WatchedReorderRequest struct {
+	// Every watched id in the order they should be displayed.
+	Order []uint `json:"order" binding:"required,min=1"`
 }
 
 type WatchedUpdateResponse struct {
@@ -59,151 +182,241 @@ type WatchedRemoveResponse struct {
 	NewActivity Activity `json:"newActivity"`
 }
 
-func getWatched(db *gorm.DB, userId uint) []Watched {
+func getWatched(db *gorm.DB, userId uint, profileId uint, f WatchedFilters) []Watched {
 	watched := new([]Watched)
-	res := db.Model(&Watched{}).Preload("Content").Preload("Activity").Where("user_id = ?", userId).Find(&watched)
+	q := filterWatched(db, userId, profileId, f).Preload("Content").Preload("Activity")
+	if f.Sort == "custom" {
+		q = q.Order("display_order, created_at")
+	}
+	res := q.Find(&watched)
 	if res.Error != nil {
 		panic(res.Error)
 	}
 	return *watched
 }
 
-func addWatched(db *gorm.DB, userId uint, ar WatchedAddRequest) (Watched, error) {
-	slog.Debug("Adding watched item", "userId", userId, "contentType", ar.ContentType, "contentId", ar.ContentID)
+// Query for a users watched list on profileId, with filters applied.
+func filterWatched(db *gorm.DB, userId uint, profileId uint, f WatchedFilters) *gorm.DB {
+	q := db.Model(&Watched{}).Where("user_id = ? AND sub_profile_id = ?", userId, profileId)
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.Certification != "" {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("certification = ?", f.Certification))
+	}
+	if f.Source != "" {
+		q = q.Where("source = ?", f.Source)
+	}
+	if f.Type != "" {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("type = ?", f.Type))
+	}
+	if f.AiringBefore != nil {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("next_episode_air_date < ?", *f.AiringBefore))
+	}
+	if f.SpokenLanguage != "" {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("EXISTS (SELECT 1 FROM json_each(contents.spoken_languages) WHERE json_extract(value, '$.iso_639_1') = ?)", f.SpokenLanguage))
+	}
+	if f.OriginalLanguage != "" {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("original_language = ?", f.OriginalLanguage))
+	}
+	if f.Country != "" {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("EXISTS (SELECT 1 FROM json_each(contents.production_countries) WHERE json_extract(value, '$.iso_3166_1') = ?)", strings.ToUpper(f.Country)))
+	}
+	if f.Year != 0 {
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where("CAST(strftime('%Y', release_date) AS INTEGER) = ?", f.Year))
+	}
+	if f.Query != "" {
+		like := "%" + escapeLike(f.Query) + "%"
+		q = q.Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where(
+			watchedSearchWhere, like, like, like, like,
+		))
+	}
+	if f.Tag != 0 {
+		q = whereTagged(db, q, userId, profileId, f.Tag)
+	}
+	return q
+}
+
+// Most entries that can be picked at random at once.
+const randomWatchedMaxCount = 20
+
+var ErrNoWatchedMatch = errors.New("no watched entries match the filters")
+
+type RandomWatchedQuery struct {
+	WatchedFilters
+	// How many to pick, 5 by default and capped at randomWatchedMaxCount.
+	Count int `form:"count" binding:"min=1"`
+}
+
+// Pick up to count random entries from a users (filtered) watched list, for when they can't decide.
+func getRandomWatched(db *gorm.DB, userId uint, profileId uint, f WatchedFilters, count int) ([]Watched, error) {
+	watched := []Watched{}
+	res := filterWatched(db, userId, profileId, f).
+		Preload("Content").
+		Preload("Activity").
+		Order("RANDOM()").
+		Limit(min(count, randomWatchedMaxCount)).
+		Find(&watched)
+	if res.Error != nil {
+		slog.Error("getRandomWatched: Failed to get watched entries", "error", res.Error)
+		return []Watched{}, errors.New("failed to get random watched entries")
+	}
+	if len(watched) == 0 {
+		return []Watched{}, ErrNoWatchedMatch
+	}
+	return watched, nil
+}
+
+// Content matching a watched search, by any title it's known by or a keyword.
+// Alternative titles are only matched once they have been fetched, see contentAlternativeTitles.
+const watchedSearchWhere = `title LIKE ? ESCAPE '\' OR original_title LIKE ? ESCAPE '\' OR ` +
+	`EXISTS (SELECT 1 FROM json_each(contents.alternative_titles) WHERE value LIKE ? ESCAPE '\') OR ` +
+	`EXISTS (SELECT 1 FROM json_each(contents.keyword_list) WHERE json_extract(value, '$.name') LIKE ? ESCAPE '\')`
+
+// Search a users watched list by title or keyword.
+func searchWatched(db *gorm.DB, userId uint, profileId uint, query string) ([]Watched, error) {
+	watched := []Watched{}
+	like := "%" + escapeLike(query) + "%"
+	res := db.Model(&Watched{}).Preload("Content").Preload("Activity").
+		Where("user_id = ? AND sub_profile_id = ?", userId, profileId).
+		Where("content_id IN (?)", db.Model(&Content{}).Select("id").Where(
+			watchedSearchWhere, like, like, like, like,
+		)).
+		Find(&watched)
+	if res.Error != nil {
+		slog.Error("Failed to search watched list", "error", res.Error)
+		return []Watched{}, errors.New("failed to search watched list")
+	}
+	return watched, nil
+}
+
+func getWatchedItem(db *gorm.DB, userId uint, profileId uint, id uint) (Watched, error) {
+	var w Watched
+	res := db.Model(&Watched{}).Preload("Content").Preload("Activity").Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Take(&w)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return Watched{}, errors.New("no watched entry found")
+		}
+		slog.Error("Failed to get watched entry", "id", id, "error", res.Error.Error())
+		return Watched{}, errors.New("failed to get watched entry")
+	}
+	return w, nil
+}
+
+// Resolve an add requests ImdbID into a TMDB ContentID/ContentType.
+// If the id is ambiguous, all candidates are returned with no error.
+func resolveWatchedAddImdbID(ar *WatchedAddRequest) ([]TMDBSearchMultiResults, error) {
+	results, err := findByExternalID(ar.ImdbID, "imdb")
+	if err != nil {
+		return nil, err
+	}
+	candidates := []TMDBSearchMultiResults{}
+	for _, r := range results {
+		// Content type can be passed to disambiguate
+		if ar.ContentType == "" || string(ar.ContentType) == r.MediaType {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no content found for imdb id")
+	}
+	if len(candidates) > 1 {
+		return candidates, nil
+	}
+	ar.ContentID = candidates[0].ID
+	ar.ContentType = ContentType(candidates[0].MediaType)
+	ar.Provider = PROVIDER_TMDB
+	return nil, nil
+}
 
+// Get content from our db, fetching it from TMDB (and
+// downloading its poster) if we don't have it cached yet.
+func getOrCacheContent(db *gorm.DB, contentType ContentType, tmdbId int) (Content, error) {
+	return getOrCacheProviderContent(db, PROVIDER_TMDB, contentType, tmdbId)
+}
+
+// Get content from our db by its id at provider, fetching and caching it if we don't have it.
+func getOrCacheProviderContent(db *gorm.DB, provider ContentProvider, contentType ContentType, id int) (Content, error) {
+	source, err := getContentSource(provider)
+	if err != nil {
+		return Content{}, err
+	}
+	if provider == "" {
+		provider = PROVIDER_TMDB
+	}
 	var content Content
-	db.Where("tmdb_id = ?", ar.ContentID).Find(&content)
+	db.Where("provider = ? AND provider_id = ? AND type = ?", provider, id, contentType).Find(&content)
 
 	// Create content if not found from our db
-	if content == (Content{}) {
+	if content.ID == 0 {
 		slog.Debug("Content not in db, fetching...")
 
-		resp, err := tmdbAPIRequest("/"+string(ar.ContentType)+"/"+strconv.Itoa(ar.ContentID), map[string]string{})
+		content, err = source.fetch(contentType, id)
 		if err != nil {
-			slog.Error("addWatched content tmdb api request failed", "error", err)
-			return Watched{}, errors.New("failed to find requested media")
+			return Content{}, err
 		}
-
-		var (
-			id               int
-			title            string
-			overview         string
-			posterPath       string
-			releaseDate      time.Time
-			popularity       float32
-			voteAverage      float32
-			voteCount        uint32
-			imdbID           string
-			status           string
-			budget           uint32
-			revenue          uint32
-			runtime          uint32
-			numberOfEpisodes uint32
-			numberOfSeasons  uint32
-		)
-		var dateFormat = "2006-01-02"
-		// Get details from movie/show response and fill out needed vars
-		if ar.ContentType == "movie" {
-			content := new(TMDBMovieDetails)
-			err = json.Unmarshal([]byte(resp), &content)
-			if err != nil {
-				slog.Error("Failed to unmarshal movie details", "error", err)
-				return Watched{}, errors.New("failed to process movie details response")
-			}
-			id = content.ID
-			overview = content.Overview
-			posterPath = content.PosterPath
-			title = content.Title
-			releaseDate, err = time.Parse(dateFormat, content.ReleaseDate)
-			if err != nil {
-				slog.Error("Failed to parse movie release date", "error", err)
-			}
-			popularity = content.Popularity
-			voteAverage = content.VoteAverage
-			voteCount = content.VoteCount
-			imdbID = content.ImdbID
-			status = content.Status
-			budget = content.Budget
-			revenue = content.Revenue
-			runtime = content.Runtime
-		} else {
-			content := new(TMDBShowDetails)
-			err = json.Unmarshal(resp, &content)
-			if err != nil {
-				slog.Error("Failed to unmarshal show details", "error", err)
-				return Watched{}, errors.New("failed to process show details response")
-			}
-			id = content.ID
-			overview = content.Overview
-			posterPath = content.PosterPath
-			title = content.Name
-			releaseDate, err = time.Parse(dateFormat, content.FirstAirDate)
-			if err != nil {
-				slog.Error("Failed to parse tv release date", "error", err)
-			}
-			popularity = content.Popularity
-			voteAverage = content.VoteAverage
-			voteCount = content.VoteCount
-			status = content.Status
-			if len(content.EpisodeRunTime) > 0 {
-				runtime = uint32(content.EpisodeRunTime[0])
-			}
-			numberOfEpisodes = content.NumberOfEpisodes
-			numberOfSeasons = content.NumberOfSeasons
-		}
-		// Save the content in our db
-		slog.Info("Saving content to db", "id", id, "title", title)
-		if id == 0 || title == "" {
-			slog.Error("addWatched, returned content missing id or title!", "id", id, "title", title)
-			return Watched{}, errors.New("content response missing id or title")
-		}
-		content = Content{
-			TmdbID:           id,
-			Title:            title,
-			Overview:         overview,
-			PosterPath:       posterPath,
-			Type:             ar.ContentType,
-			ReleaseDate:      releaseDate,
-			Popularity:       popularity,
-			VoteAverage:      voteAverage,
-			VoteCount:        voteCount,
-			ImdbID:           imdbID,
-			Status:           status,
-			Budget:           budget,
-			Revenue:          revenue,
-			Runtime:          runtime,
-			NumberOfEpisodes: numberOfEpisodes,
-			NumberOfSeasons:  numberOfSeasons,
-		}
-		res := db.Create(&content)
+		slog.Info("Saving content to db", "provider", content.Provider, "id", content.ProviderID, "title", content.Title)
+		now := timeNow()
+		content.LastRefreshedAt = &now
+		// Content may have been created by another request since we checked, in
+		// that case nothing is inserted and we take the existing row instead.
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&content)
 		if res.Error != nil {
-			// Error if anything but unique contraint error
-			if !strings.Contains(res.Error.Error(), "UNIQUE") {
-				slog.Error("Error creating content in database", "error", res.Error.Error())
-				return Watched{}, errors.New("failed to cache content in database")
+			slog.Error("Error creating content in database", "error", res.Error.Error())
+			return Content{}, errors.New("failed to cache content in database")
+		}
+		if res.RowsAffected == 0 {
+			if err := db.Where("provider = ? AND provider_id = ? AND type = ?", content.Provider, content.ProviderID, content.Type).Take(&content).Error; err != nil {
+				slog.Error("Error getting existing content from database", "error", err.Error())
+				return Content{}, errors.New("failed to cache content in database")
 			}
 		}
-		// If row created, download the image
-		if res.RowsAffected > 0 {
-			err := download("https://image.tmdb.org/t/p/w500"+posterPath, path.Join("./data/img", posterPath))
+		// If row created, download the image (if content has one, otherwise
+		// we would be requesting the base image url which isn't valid).
+		if res.RowsAffected > 0 && content.PosterPath != "" {
+			posterSize := getPosterSize()
+			err := download(source.posterURL(posterSize, content.PosterPath), posterFilePath(content.PosterPath))
 			if err != nil {
 				slog.Error("Failed to download content image!", "error", err.Error())
+			} else {
+				db.Model(&content).Update("poster_size", posterSize)
 			}
 		}
 	}
 	// Error if content has no id
 	if content.ID == 0 {
-		return Watched{}, errors.New("failed to find content id")
+		return Content{}, errors.New("failed to find content id")
+	}
+	return content, nil
+}
+
+func addWatched(db *gorm.DB, userId uint, profileId uint, ar WatchedAddRequest, source WatchedSource) (Watched, error) {
+	slog.Debug("Adding watched item", "userId", userId, "profileId", profileId, "contentType", ar.ContentType, "contentId", ar.ContentID, "source", source)
+	if err := validateRating(ar.Rating); err != nil {
+		return Watched{}, err
+	}
+
+	content, err := getOrCacheProviderContent(db, ar.Provider, ar.ContentType, ar.ContentID)
+	if err != nil {
+		return Watched{}, err
 	}
 	// Create watched entry in db
 	if ar.Status == "" {
-		ar.Status = WATCHING
+		ar.Status = getDefaultStatusOnAdd(db, userId)
+	}
+	if ar.WatchedDate != nil && ar.WatchedDate.After(timeNow()) && ar.Status != PLANNED {
+		return Watched{}, ErrWatchedDateInFuture
 	}
-	watched := Watched{Status: ar.Status, Rating: ar.Rating, UserID: userId, ContentID: content.ID}
+	watched := Watched{Status: ar.Status, Rating: ar.Rating, WatchedOn: sanitizeString(ar.WatchedOn), Source: source, UserID: userId, SubProfileID: profileId, ContentID: content.ID}
+	if ar.WatchedDate != nil {
+		watched.CreatedAt = ar.WatchedDate.UTC()
+	}
+	// New items go to the end of the users custom order.
+	db.Model(&Watched{}).Select("COALESCE(MAX(display_order), 0) + 1").Where("user_id = ? AND sub_profile_id = ?", userId, profileId).Scan(&watched.DisplayOrder)
 	res := db.Create(&watched)
 	if res.Error != nil {
-		if strings.Contains(res.Error.Error(), "UNIQUE") {
-			res = db.Model(&Watched{}).Unscoped().Preload("Activity").Where("user_id = ? AND content_id = ?", userId, watched.ContentID).Take(&watched)
+		if isDuplicateErr(res.Error) {
+			res = db.Model(&Watched{}).Unscoped().Preload("Activity").Where("user_id = ? AND sub_profile_id = ? AND content_id = ?", userId, profileId, watched.ContentID).Take(&watched)
 			if res.Error != nil {
 				return Watched{}, errors.New("content already on watched list. errored checking for soft deleted record")
 			}
@@ -211,9 +424,10 @@ func addWatched(db *gorm.DB, userId uint, ar WatchedAddRequest) (Watched, error)
 				return Watched{}, errors.New("content already on watched list")
 			} else {
 				slog.Info("addWatched: Watched list item for this content exists as soft deleted record.. attempting to restore")
-				res = db.Model(&Watched{}).Unscoped().Where("user_id = ? AND content_id = ?", userId, watched.ContentID).Updates(map[string]interface{}{"status": ar.Status, "rating": ar.Rating, "deleted_at": nil})
+				res = db.Model(&Watched{}).Unscoped().Where("user_id = ? AND sub_profile_id = ? AND content_id = ?", userId, profileId, watched.ContentID).Updates(map[string]interface{}{"status": ar.Status, "rating": ar.Rating, "watched_on": watched.WatchedOn, "source": source, "deleted_at": nil})
 				watched.Status = ar.Status
 				watched.Rating = ar.Rating
+				watched.Source = source
 				if res.Error != nil {
 					slog.Error("addWatched: Failed to restore soft deleted watch list item", "error", res.Error)
 					return Watched{}, errors.New("content already on watched list. errored removing soft delete timestamp")
@@ -230,9 +444,9 @@ func addWatched(db *gorm.DB, userId uint, ar WatchedAddRequest) (Watched, error)
 	activityJson, err := json.Marshal(map[string]interface{}{"status": ar.Status, "rating": ar.Rating})
 	if err != nil {
 		slog.Error("Failed to marshal json for data in ADD_WATCHED activity request, adding without data", "error", err.Error())
-		activity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: watched.ID, Type: ADDED_WATCHED})
+		activity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: watched.ID, Type: ADDED_WATCHED, CustomDate: ar.WatchedDate})
 	} else {
-		activity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: watched.ID, Type: ADDED_WATCHED, Data: string(activityJson)})
+		activity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: watched.ID, Type: ADDED_WATCHED, Data: string(activityJson), CustomDate: ar.WatchedDate})
 	}
 	watched.Activity = append(watched.Activity, activity)
 	watched.Content = content
@@ -240,27 +454,48 @@ func addWatched(db *gorm.DB, userId uint, ar WatchedAddRequest) (Watched, error)
 }
 
 // this method is too ugly to look at please make him look better, future irhm
-func updateWatched(db *gorm.DB, userId uint, id uint, ar WatchedUpdateRequest) (WatchedUpdateResponse, error) {
+func updateWatched(db *gorm.DB, userId uint, profileId uint, id uint, ar WatchedUpdateRequest) (WatchedUpdateResponse, error) {
 	slog.Debug("UpdateWatched", "request_data", ar)
+	if err := validateRating(ar.Rating); err != nil {
+		return WatchedUpdateResponse{}, err
+	}
 	upwat := Watched{}
-	res := db.Model(&Watched{}).Where("id = ? AND user_id = ?", id, userId).Take(&upwat)
+	res := db.Model(&Watched{}).Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Take(&upwat)
 	if res.Error != nil {
 		slog.Error("Watched entry update failed:", "id", id, "error", res.Error.Error())
 		return WatchedUpdateResponse{}, errors.New("failed to update watched entry")
 	}
 	originalThoughts := upwat.Thoughts
+	originalPlannedNote := upwat.PlannedNote
 	if ar.Rating != 0 {
 		upwat.Rating = ar.Rating
 	}
 	if ar.Status != "" {
 		upwat.Status = ar.Status
+		if ar.Status == FINISHED {
+			upwat.PlannedNoteFinished = true
+			// So watching it again starts from the beginning.
+			upwat.ProgressSeconds = 0
+		}
 	}
 	if ar.Thoughts != "" {
-		upwat.Thoughts = ar.Thoughts
+		upwat.Thoughts = sanitizeString(ar.Thoughts)
 	}
 	if ar.RemoveThoughts {
 		upwat.Thoughts = ""
 	}
+	if ar.PlannedNote != "" {
+		upwat.PlannedNote = sanitizeString(ar.PlannedNote)
+	}
+	if ar.RemovePlannedNote {
+		upwat.PlannedNote = ""
+	}
+	if ar.WatchedOn != "" {
+		upwat.WatchedOn = sanitizeString(ar.WatchedOn)
+	}
+	if ar.RemoveWatchedOn {
+		upwat.WatchedOn = ""
+	}
 	res = db.Save(upwat)
 	if res.RowsAffected <= 0 {
 		return WatchedUpdateResponse{}, errors.New("no watched entry found")
@@ -278,16 +513,53 @@ func updateWatched(db *gorm.DB, userId uint, id uint, ar WatchedUpdateRequest) (
 	if ar.RemoveThoughts {
 		addedActivity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: id, Type: THOUGHTS_REMOVED, Data: originalThoughts})
 	}
+	if ar.PlannedNote != "" {
+		addedActivity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: id, Type: PLANNED_NOTE_CHANGED, Data: upwat.PlannedNote})
+	}
+	if ar.RemovePlannedNote {
+		addedActivity, _ = addActivity(db, userId, ActivityAddRequest{WatchedID: id, Type: PLANNED_NOTE_REMOVED, Data: originalPlannedNote})
+	}
 	return WatchedUpdateResponse{NewActivity: addedActivity}, nil
 }
 
-func removeWatched(db *gorm.DB, userId uint, id uint) (WatchedRemoveResponse, error) {
+// Set the custom order of a users watched list, in one update.
+func reorderWatched(db *gorm.DB, userId uint, profileId uint, rr WatchedReorderRequest) error {
+	seen := map[uint]bool{}
+	for _, id := range rr.Order {
+		if seen[id] {
+			return errors.New("order contains duplicate ids")
+		}
+		seen[id] = true
+	}
+	var count int64
+	db.Model(&Watched{}).Where("id IN ? AND user_id = ? AND sub_profile_id = ?", rr.Order, userId, profileId).Count(&count)
+	if count != int64(len(rr.Order)) {
+		return errors.New("not all watched entries were found")
+	}
+	caseSql := "CASE id"
+	args := []interface{}{}
+	for i, id := range rr.Order {
+		caseSql += " WHEN ? THEN ?"
+		args = append(args, id, i+1)
+	}
+	caseSql += " END"
+	res := db.Model(&Watched{}).
+		Where("id IN ? AND user_id = ? AND sub_profile_id = ?", rr.Order, userId, profileId).
+		Update("display_order", gorm.Expr(caseSql, args...))
+	if res.Error != nil {
+		slog.Error("Failed to reorder watched list", "error", res.Error)
+		return errors.New("failed to reorder watched list")
+	}
+	return nil
+}
+
+func removeWatched(db *gorm.DB, userId uint, profileId uint, id uint) (WatchedRemoveResponse, error) {
 	slog.Debug("Removing watched item:", "id", id, "user_id", userId)
 	// Our model has a deleted_at field, which will make gorm do a soft delete.
 	// Since other tables (eg activities) will link their rows to a watched_id, it's best to soft
 	// delete, so if user restores watched item they still have activity for example (also so
 	// someone else wont get other users activity if auto increment gives them the same watched id).
-	res := db.Model(&Watched{}).Where("id = ? AND user_id = ?", id, userId).Delete(&Watched{})
+	res := db.Model(&Watched{}).Where("id = ? AND user_id = ? AND sub_profile_id = ?", id, userId, profileId).Delete(&Watched{})
 	if res.Error != nil {
 		slog.Error("Removing watched entry failed", "id", id, "error", res.Error.Error())
 		return WatchedRemoveResponse{}, errors.New("failed to remove watched entry")
@@ -296,9 +568,26 @@ func removeWatched(db *gorm.DB, userId uint, id uint) (WatchedRemoveResponse, er
 		return WatchedRemoveResponse{}, errors.New("no watched entry found")
 	}
 	addedActivity, _ := addActivity(db, userId, ActivityAddRequest{WatchedID: id, Type: REMOVED_WATCHED})
+	var w Watched
+	if db.Unscoped().Select("content_id").Where("id = ?", id).Take(&w).Error == nil {
+		go removeEpisodeStills(db, w.ContentID)
+	}
 	return WatchedRemoveResponse{NewActivity: addedActivity}, nil
 }
 
+// Poster sizes TMDB allows us to request.
+var validPosterSizes = []string{"w92", "w154", "w185", "w342", "w500", "w780", "original"}
+
+// Get poster size to download from POSTER_SIZE,
+// falling back to w500 if unset or invalid.
+func getPosterSize() string {
+	size := os.Getenv("POSTER_SIZE")
+	if !slices.Contains(validPosterSizes, size) {
+		return "w500"
+	}
+	return size
+}
+
 func download(url string, outf string) (err error) {
 	slog.Debug("Attempting to download file", "url", url, "outf", outf)
 
@@ -320,6 +609,13 @@ func download(url string, outf string) (err error) {
 		}
 	}
 	defer out.Close()
+	// Don't leave a partial (or empty) file behind, it would be served as a blank image.
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(outf)
+		}
+	}()
 
 	// Get the data
 	resp, err := http.Get(url)
@@ -341,3 +637,189 @@ func download(url string, outf string) (err error) {
 
 	return nil
 }
+
+// Another user on this instance that has watched some content.
+// Rating and Review are only included if they share them.
+type OthersWatched struct {
+	Username string        `json:"username"`
+	Status   WatchedStatus `json:"status"`
+	Rating   *int8         `json:"rating,omitempty"`
+	Review   string        `json:"review,omitempty"`
+}
+
+// Max number of other users returned for a piece of content.
+const othersWatchedLimit = 10
+
+// Get other users who have content on their watched list, most recent first.
+// Only users who have opted in to sharing with the instance are included.
+func getOthersWatched(db *gorm.DB, userId uint, contentType ContentType, tmdbId string) []OthersWatched {
+	var rows []struct {
+		OthersWatched
+		Rating              int8
+		Thoughts            string
+		SharePrivateRatings bool
+		ShareReviews        bool
+	}
+	res := db.Model(&Watched{}).
+		Select("users.username, watcheds.status, watcheds.rating, watcheds.thoughts, users.setting_share_private_ratings AS share_private_ratings, users.setting_share_reviews AS share_reviews").
+		Joins("JOIN users ON users.id = watcheds.user_id AND users.deleted_at IS NULL").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("contents.tmdb_id = ? AND contents.type = ?", tmdbId, contentType).
+		Where("watcheds.user_id != ? AND watcheds.sub_profile_id = 0 AND users.setting_share_with_instance = ?", userId, true).
+		Order("watcheds.updated_at DESC").
+		Limit(othersWatchedLimit).
+		Scan(&rows)
+	if res.Error != nil {
+		slog.Error("Failed to get others watched", "tmdb_id", tmdbId, "error", res.Error)
+		return []OthersWatched{}
+	}
+	others := []OthersWatched{}
+	for _, r := range rows {
+		o := r.OthersWatched
+		if r.SharePrivateRatings && r.Rating != 0 {
+			rating := r.Rating
+			o.Rating = &rating
+		}
+		if r.ShareReviews {
+			o.Review = r.Thoughts
+		}
+		others = append(others, o)
+	}
+	return others
+}
+
+// How many items on a watched list were watched on a service.
+type WatchedServiceStat struct {
+	Service  string `json:"service"`
+	Count    int64  `json:"count"`
+	Finished int64  `json:"finished"`
+	Movies   int64  `json:"movies"`
+	Tv       int64  `json:"tv"`
+}
+
+type WatchedCountResponse struct {
+	Total    int64 `json:"total"`
+	Movies   int64 `json:"movies"`
+	Tv       int64 `json:"tv"`
+	Finished int64 `json:"finished"`
+	Watching int64 `json:"watching"`
+	Planning int64 `json:"planning"`
+	OnHold   int64 `json:"onHold"`
+	Dropped  int64 `json:"dropped"`
+	// Extra viewings logged, on top of the items themselves.
+	Rewatches int64 `json:"rewatches"`
+}
+
+// Get every service (WatchedOn) a user has used, most used first.
+// Services only differing in case are counted together.
+func getWatchedServices(db *gorm.DB, userId uint, profileId uint) ([]WatchedServiceStat, error) {
+	stats := []WatchedServiceStat{}
+	res := db.Model(&Watched{}).
+		Select(`MAX(watcheds.watched_on) AS service, COUNT(*) AS count,
+			SUM(watcheds.status = ?) AS finished,
+			SUM(contents.type = ?) AS movies,
+			SUM(contents.type = ?) AS tv`, FINISHED, MOVIE, SHOW).
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ? AND watcheds.watched_on != ''", userId, profileId).
+		Group("watcheds.watched_on COLLATE NOCASE").
+		Order("count DESC, service").
+		Scan(&stats)
+	if res.Error != nil {
+		slog.Error("Failed to get watched services", "error", res.Error)
+		return []WatchedServiceStat{}, errors.New("failed to get watched services")
+	}
+	return stats, nil
+}
+
+// Count watched list items by type and status, without loading them.
+// Also returns an etag which changes whenever the counts could have.
+func getWatchedCount(db *gorm.DB, userId uint, profileId uint) (WatchedCountResponse, string, error) {
+	var rows []struct {
+		Type       ContentType
+		Status     WatchedStatus
+		Count      int64
+		MaxUpdated string
+	}
+	res := db.Model(&Watched{}).
+		Select("contents.type, watcheds.status, COUNT(*) AS count, MAX(watcheds.updated_at) AS max_updated").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId).
+		Group("contents.type, watcheds.status").
+		Scan(&rows)
+	if res.Error != nil {
+		slog.Error("Failed to count watched entries", "error", res.Error)
+		return WatchedCountResponse{}, "", errors.New("failed to count watched entries")
+	}
+	resp := WatchedCountResponse{}
+	maxUpdated := ""
+	for _, r := range rows {
+		resp.Total += r.Count
+		switch r.Type {
+		case MOVIE:
+			resp.Movies += r.Count
+		case SHOW:
+			resp.Tv += r.Count
+		}
+		switch r.Status {
+		case FINISHED:
+			resp.Finished += r.Count
+		case WATCHING:
+			resp.Watching += r.Count
+		case PLANNED:
+			resp.Planning += r.Count
+		case HOLD:
+			resp.OnHold += r.Count
+		case DROPPED:
+			resp.Dropped += r.Count
+		}
+		if r.MaxUpdated > maxUpdated {
+			maxUpdated = r.MaxUpdated
+		}
+	}
+	if res := reWatchesOf(db, userId, profileId).Count(&resp.Rewatches); res.Error != nil {
+		slog.Error("Failed to count rewatches", "error", res.Error)
+		return WatchedCountResponse{}, "", errors.New("failed to count watched entries")
+	}
+	// Removing an item doesn't touch updated_at, so total is included too.
+	etag := fmt.Sprintf("W/\"%d-%d-%s\"", resp.Total, resp.Rewatches, maxUpdated)
+	return resp, etag, nil
+}
+
+type WatchedStatsQuery struct {
+	// IANA timezone to group by, overrides users timezone setting.
+	Timezone string `form:"tz"`
+}
+
+type WatchedMonthlyStat struct {
+	// Month in YYYY-MM format.
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
+// Count watched list items added and rewatches logged per month, grouped in loc.
+func getWatchedMonthly(db *gorm.DB, userId uint, profileId uint, loc *time.Location) ([]WatchedMonthlyStat, error) {
+	var dates []time.Time
+	res := db.Model(&Watched{}).Where("user_id = ? AND sub_profile_id = ?", userId, profileId).Pluck("created_at", &dates)
+	if res.Error != nil {
+		slog.Error("Failed to get watched dates", "error", res.Error)
+		return []WatchedMonthlyStat{}, errors.New("failed to get monthly stats")
+	}
+	var rewatchDates []time.Time
+	if res := reWatchesOf(db, userId, profileId).Pluck("re_watch_entries.watched_at", &rewatchDates); res.Error != nil {
+		slog.Error("Failed to get rewatch dates", "error", res.Error)
+		return []WatchedMonthlyStat{}, errors.New("failed to get monthly stats")
+	}
+	dates = append(dates, rewatchDates...)
+	// Older rows may be stored with different offsets, so we
+	// can't rely on the database to order them for us.
+	counts := map[string]int{}
+	for _, d := range dates {
+		counts[d.In(loc).Format("2006-01")]++
+	}
+	stats := []WatchedMonthlyStat{}
+	for month, count := range counts {
+		stats = append(stats, WatchedMonthlyStat{Month: month, Count: count})
+	}
+	slices.SortFunc(stats, func(a, b WatchedMonthlyStat) int { return strings.Compare(a.Month, b.Month) })
+	return stats, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Serve posters from a fake image server, returning the paths requested from it.
+func useFakeImages(t *testing.T) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var requested []string
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte("poster"))
+	}))
+	t.Cleanup(images.Close)
+	t.Setenv("TMDB_IMAGE_BASE", images.URL)
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+func TestCacheContentPoster(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		posterPath    string
+		wantHasPoster bool
+		wantRequested []string
+	}{
+		{"with poster", "/fightclub.jpg", true, []string{"/w500/fightclub.jpg"}},
+		{"empty poster path", "", false, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newTestDB(t)
+			useFakeTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"id":550,"title":"Fight Club","release_date":"1999-10-15","poster_path":"`+tc.posterPath+`"}`)
+			})
+			requested := useFakeImages(t)
+
+			c, err := getOrCacheContent(db, MOVIE, 550)
+			if err != nil {
+				t.Fatalf("getOrCacheContent failed: %v", err)
+			}
+			if c.HasPoster != tc.wantHasPoster {
+				t.Errorf("got hasPoster %v, want %v", c.HasPoster, tc.wantHasPoster)
+			}
+			// Content read back from the db must agree.
+			var found Content
+			db.Take(&found, c.ID)
+			if found.HasPoster != tc.wantHasPoster {
+				t.Errorf("got hasPoster %v from db, want %v", found.HasPoster, tc.wantHasPoster)
+			}
+			got := requested()
+			if len(got) != len(tc.wantRequested) || (len(got) > 0 && got[0] != tc.wantRequested[0]) {
+				t.Errorf("got image requests %q, want %q", got, tc.wantRequested)
+			}
+			if tc.posterPath == "" {
+				// Nothing should be written where an empty path points (the img dir itself).
+				if fi, err := os.Stat(posterFilePath("")); err == nil && !fi.IsDir() {
+					t.Errorf("a file was written for the empty poster path")
+				}
+			}
+		})
+	}
+}
+
+func TestPosterSize(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want string
+	}{
+		{"", "w500"},
+		{"w185", "w185"},
+		{"w342", "w342"},
+		{"original", "original"},
+		{"w501", "w500"},
+		{"W185", "w500"},
+		{" w185", "w500"},
+		{"185", "w500"},
+		{"../w185", "w500"},
+	} {
+		t.Run(tc.env, func(t *testing.T) {
+			t.Setenv("POSTER_SIZE", tc.env)
+			if got := getPosterSize(); got != tc.want {
+				t.Fatalf("getPosterSize with %q = %q, want %q", tc.env, got, tc.want)
+			}
+			// The size used to download the poster is the one stored with the content.
+			db := newTestDB(t)
+			useFakeTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"id":550,"title":"Fight Club","release_date":"1999-10-15","poster_path":"/fightclub.jpg"}`)
+			})
+			requested := useFakeImages(t)
+			c, err := getOrCacheContent(db, MOVIE, 550)
+			if err != nil {
+				t.Fatalf("getOrCacheContent failed: %v", err)
+			}
+			if got := requested(); len(got) != 1 || got[0] != "/"+tc.want+"/fightclub.jpg" {
+				t.Errorf("got image requests %q, want one for size %s", got, tc.want)
+			}
+			var found Content
+			db.Take(&found, c.ID)
+			if found.PosterSize != tc.want {
+				t.Errorf("got stored poster size %q, want %q", found.PosterSize, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateRating(t *testing.T) {
+	for _, tc := range []struct {
+		rating  int8
+		wantErr bool
+	}{
+		{-128, true},
+		{-1, true},
+		{0, false},
+		{1, false},
+		{10, false},
+		{11, true},
+		{127, true},
+	} {
+		if err := validateRating(tc.rating); (err != nil) != tc.wantErr {
+			t.Errorf("validateRating(%d) = %v, want error: %v", tc.rating, err, tc.wantErr)
+		}
+	}
+}
+
+// Out of range ratings are rejected by both adding and updating, nothing is saved.
+func TestWatchedRatingBounds(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register("alice")
+	var w Watched
+	s.expect("POST", "/watched", token, `{"contentId":603,"contentType":"movie","status":"FINISHED","rating":7}`, http.StatusOK, &w)
+	id := strconv.Itoa(int(w.ID))
+
+	for _, tc := range []struct {
+		rating string
+		want   int
+	}{
+		{"-129", http.StatusBadRequest},
+		{"-128", http.StatusBadRequest},
+		{"-1", http.StatusBadRequest},
+		{"11", http.StatusBadRequest},
+		{"127", http.StatusBadRequest},
+		{"128", http.StatusBadRequest},
+		{"1.5", http.StatusBadRequest},
+		{`"5"`, http.StatusBadRequest},
+		{"1", http.StatusOK},
+		{"10", http.StatusOK},
+	} {
+		t.Run(tc.rating, func(t *testing.T) {
+			status, b := s.do("PUT", "/watched/"+id, token, `{"rating":`+tc.rating+`}`)
+			if status != tc.want {
+				t.Errorf("update: got status %d, want %d (body: %s)", status, tc.want, b)
+			}
+			var saved Watched
+			s.db.Take(&saved, w.ID)
+			if tc.want == http.StatusOK {
+				if r := strconv.Itoa(int(saved.Rating)); r != tc.rating {
+					t.Errorf("update: got saved rating %s, want %s", r, tc.rating)
+				}
+			} else if saved.Rating < 0 || saved.Rating > maxRating {
+				t.Errorf("update: out of range rating %d was saved", saved.Rating)
+			}
+
+			status, b = s.do("POST", "/watched", token, `{"contentId":550,"contentType":"movie","status":"FINISHED","rating":`+tc.rating+`}`)
+			if status != tc.want {
+				t.Errorf("add: got status %d, want %d (body: %s)", status, tc.want, b)
+			}
+			var added Watched
+			res := s.db.Joins("Content").Where("Content.tmdb_id = ?", 550).Find(&added)
+			if tc.want != http.StatusOK {
+				if res.RowsAffected != 0 {
+					t.Errorf("add: watched was saved with rating %d", added.Rating)
+				}
+				return
+			}
+			if r := strconv.Itoa(int(added.Rating)); r != tc.rating {
+				t.Errorf("add: got saved rating %s, want %s", r, tc.rating)
+			}
+			s.db.Unscoped().Delete(&added)
+		})
+	}
+}
+
+// Ratings and reviews of other users are only on public routes when they opted in to sharing them.
+func TestOthersWatchedSharing(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		settings    string
+		rating      int
+		wantOthers  bool
+		wantRating  bool
+		wantReview  bool
+		wantProfile bool
+	}{
+		{"defaults", `{}`, 8, false, false, false, false},
+		{"share nothing", `{"shareWithInstance":true}`, 8, true, false, false, true},
+		{"share ratings", `{"shareWithInstance":true,"sharePrivateRatings":true}`, 8, true, true, false, true},
+		{"share reviews", `{"shareWithInstance":true,"shareReviews":true}`, 8, true, false, true, true},
+		{"share both", `{"shareWithInstance":true,"sharePrivateRatings":true,"shareReviews":true}`, 8, true, true, true, true},
+		{"share both unrated", `{"shareWithInstance":true,"sharePrivateRatings":true,"shareReviews":true}`, 0, true, false, true, true},
+		{"ratings and reviews without instance", `{"sharePrivateRatings":true,"shareReviews":true}`, 8, false, false, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			useFakeTMDB(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/3/tv/1399" {
+					io.WriteString(w, `{"id":1399,"name":"Game of Thrones","first_air_date":"2011-04-17"}`)
+					return
+				}
+				fakeTMDB(w, r)
+			})
+			alice := s.register("alice")
+			bob := s.register("bob")
+			s.expect("PUT", "/profile/settings", bob, tc.settings, http.StatusOK, nil)
+			for _, add := range []string{`"contentId":550,"contentType":"movie"`, `"contentId":1399,"contentType":"tv"`} {
+				var w Watched
+				s.expect("POST", "/watched", bob, `{`+add+`,"status":"FINISHED","rating":`+strconv.Itoa(tc.rating)+`}`, http.StatusOK, &w)
+				s.expect("PUT", "/watched/"+strconv.Itoa(int(w.ID)), bob, `{"thoughts":"loved it"}`, http.StatusOK, nil)
+			}
+
+			for _, path := range []string{"/content/movie/550", "/content/tv/1399"} {
+				var content struct {
+					OthersWatched []map[string]any `json:"othersWatched"`
+				}
+				s.expect("GET", path, alice, "", http.StatusOK, &content)
+				if !tc.wantOthers {
+					if len(content.OthersWatched) != 0 {
+						t.Errorf("%s: got others watched %v, want none", path, content.OthersWatched)
+					}
+					continue
+				}
+				if len(content.OthersWatched) != 1 {
+					t.Fatalf("%s: got others watched %v, want bob", path, content.OthersWatched)
+				}
+				o := content.OthersWatched[0]
+				if o["username"] != "bob" || o["status"] != "FINISHED" {
+					t.Errorf("%s: got %v, want bob with status FINISHED", path, o)
+				}
+				if rating, ok := o["rating"]; ok != tc.wantRating || (ok && rating != float64(tc.rating)) {
+					t.Errorf("%s: got rating %v (included: %v), want included: %v", path, rating, ok, tc.wantRating)
+				}
+				if review, ok := o["review"]; ok != tc.wantReview || (ok && review != "loved it") {
+					t.Errorf("%s: got review %v (included: %v), want included: %v", path, review, ok, tc.wantReview)
+				}
+			}
+
+			// The public profile never has ratings or reviews.
+			want := http.StatusOK
+			if !tc.wantProfile {
+				want = http.StatusNotFound
+			}
+			status, b := s.do("GET", "/profile/user/bob", alice, "")
+			if status != want {
+				t.Fatalf("profile: got status %d, want %d (body: %s)", status, want, b)
+			}
+			if bytes.Contains(b, []byte("loved it")) || bytes.Contains(b, []byte(`"rating`)) {
+				t.Errorf("profile includes a rating or review: %s", b)
+			}
+		})
+	}
+}
+
+// 100 users adding the same, uncached, content at once. Only one content row
+// is created and everyone else gets it, without any failing or erroring.
+func BenchmarkConcurrentAdds(b *testing.B) {
+	db := newTestDB(b)
+	useFakeTMDB(b, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":`+path.Base(r.URL.Path)+`,"title":"Fight Club","release_date":"1999-10-15"}`)
+	})
+	const adds = 100
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		errs := make(chan error, adds)
+		for j := 0; j < adds; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := getOrCacheContent(db, MOVIE, i+1); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			b.Fatalf("add failed: %v", err)
+		}
+	}
+	b.StopTimer()
+	var n int64
+	db.Model(&Content{}).Count(&n)
+	if n != int64(b.N) {
+		b.Errorf("got %d content rows for %d contents", n, b.N)
+	}
+}
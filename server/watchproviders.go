@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long the watch providers available in a region are cached, they rarely change.
+const watchProvidersCacheTTL = 24 * time.Hour
+
+// How long provider discover results are cached.
+const providerDiscoverCacheTTL = 6 * time.Hour
+
+// Most providers that can be discovered at once.
+const providerDiscoverMaxProviders = 10
+
+var ErrUnknownWatchProvider = errors.New("unknown watch provider for region")
+
+type WatchProvidersQuery struct {
+	// Type of content the providers are for, defaults to movie.
+	Type ContentType `form:"type" binding:"omitempty,oneof=movie tv"`
+	// Two letter country code, defaults to the users region setting.
+	Region string `form:"region"`
+}
+
+type WatchProvidersResponse struct {
+	Region  string              `json:"region"`
+	Results []TMDBWatchProvider `json:"results"`
+}
+
+type ProviderDiscoverQuery struct {
+	// Type of content to discover, defaults to movie.
+	Type ContentType `form:"type" binding:"omitempty,oneof=movie tv"`
+	// Comma separated TMDB watch provider ids, content on any of them is returned.
+	Providers string `form:"providers" binding:"required"`
+	// Two letter country code, defaults to the users region setting.
+	Region string `form:"region"`
+	// TMDB only serves up to page 500.
+	Page int `form:"page" binding:"omitempty,min=1,max=500"`
+}
+
+type watchProvidersCacheEntry struct {
+	providers []TMDBWatchProvider
+	expires   time.Time
+}
+
+// Watch providers by type and region.
+var watchProvidersCache sync.Map
+
+type providerDiscoverCacheEntry struct {
+	resp    TMDBSearchMultiResponse
+	expires time.Time
+}
+
+// Provider discover results by type, region, providers and page.
+var providerDiscoverCache sync.Map
+
+// Region to use for watch providers, the requested one or the users, falling back to the servers DEFAULT_COUNTRY.
+func watchProviderRegion(s UserSettings, requested string) (string, error) {
+	region := requested
+	if region == "" {
+		region = s.Region
+	}
+	if region == "" {
+		return getDefaultCountry(), nil
+	}
+	if !isValidRegion(region) {
+		return "", errors.New("region must be a two letter country code")
+	}
+	return strings.ToUpper(region), nil
+}
+
+// Get streaming services (watch providers) available in region, most popular first.
+func getWatchProviders(contentType ContentType, region string) (WatchProvidersResponse, error) {
+	key := string(contentType) + "/" + region
+	if e, ok := watchProvidersCache.Load(key); ok && timeNow().Before(e.(watchProvidersCacheEntry).expires) {
+		return WatchProvidersResponse{Region: region, Results: e.(watchProvidersCacheEntry).providers}, nil
+	}
+	resp := new(struct {
+		Results []TMDBWatchProvider `json:"results"`
+	})
+	err := tmdbRequest("/watch/providers/"+string(contentType), map[string]string{"watch_region": region}, &resp)
+	if err != nil {
+		slog.Error("Failed to complete watch providers request!", "type", contentType, "region", region, "error", err.Error())
+		return WatchProvidersResponse{}, errors.New("failed to complete watch providers request")
+	}
+	providers := resp.Results
+	if providers == nil {
+		providers = []TMDBWatchProvider{}
+	}
+	slices.SortStableFunc(providers, func(a, b TMDBWatchProvider) int { return a.DisplayPriority - b.DisplayPriority })
+	watchProvidersCache.Store(key, watchProvidersCacheEntry{providers: providers, expires: timeNow().Add(watchProvidersCacheTTL)})
+	return WatchProvidersResponse{Region: region, Results: providers}, nil
+}
+
+// Parse comma separated provider ids, checking each is available in region.
+// Returned sorted, so the same providers in any order share a cache entry.
+func parseWatchProviders(contentType ContentType, region string, providers string) ([]int, error) {
+	ids := []int{}
+	for _, p := range strings.Split(providers, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || id <= 0 {
+			return nil, errors.New("providers must be comma separated provider ids")
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > providerDiscoverMaxProviders {
+		return nil, errors.New("too many providers, at most " + strconv.Itoa(providerDiscoverMaxProviders) + " can be used")
+	}
+	available, err := getWatchProviders(contentType, region)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if !slices.ContainsFunc(available.Results, func(p TMDBWatchProvider) bool { return p.ProviderID == id }) {
+			return nil, ErrUnknownWatchProvider
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Discover popular content streaming on any of providers in region.
+// Results are in the same form as search results, so they can be shown the same way.
+func discoverByProvider(contentType ContentType, region string, providers []int, page int) (TMDBSearchMultiResponse, error) {
+	ps := make([]string, len(providers))
+	for i, p := range providers {
+		ps[i] = strconv.Itoa(p)
+	}
+	// TMDB treats | as or, we want content on any of the providers.
+	withProviders := strings.Join(ps, "|")
+	key := string(contentType) + "/" + region + "/" + withProviders + "/" + strconv.Itoa(page)
+	if e, ok := providerDiscoverCache.Load(key); ok && timeNow().Before(e.(providerDiscoverCacheEntry).expires) {
+		return e.(providerDiscoverCacheEntry).resp, nil
+	}
+	resp := new(TMDBSearchMultiResponse)
+	err := tmdbRequest("/discover/"+string(contentType), map[string]string{
+		"with_watch_providers": withProviders,
+		"watch_region":         region,
+		"page":                 strconv.Itoa(page),
+		"sort_by":              "popularity.desc",
+	}, &resp)
+	if err != nil {
+		slog.Error("Failed to complete discover by provider request!", "error", err.Error())
+		return TMDBSearchMultiResponse{}, errors.New("failed to complete discover request")
+	}
+	if resp.Results == nil {
+		resp.Results = []TMDBSearchMultiResults{}
+	}
+	// Discover results don't say what they are, unlike search results.
+	for i := range resp.Results {
+		resp.Results[i].MediaType = string(contentType)
+	}
+	providerDiscoverCache.Store(key, providerDiscoverCacheEntry{resp: *resp, expires: timeNow().Add(providerDiscoverCacheTTL)})
+	return *resp, nil
+}
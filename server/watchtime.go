@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Runtimes (in minutes) used when we don't know one, so
+// totals aren't thrown off by content missing its runtime.
+const (
+	estimatedMovieRuntime   = 100
+	estimatedEpisodeRuntime = 40
+)
+
+type WatchTimeTotals struct {
+	Minutes int     `json:"minutes"`
+	Hours   float64 `json:"hours"`
+	// Finished movies (and movie rewatches) counted, and the minutes spent on them.
+	Movies       int `json:"movies"`
+	MovieMinutes int `json:"movieMinutes"`
+	// Episodes watched counted, and the minutes spent on them.
+	Episodes       int `json:"episodes"`
+	EpisodeMinutes int `json:"episodeMinutes"`
+	// Movies and episodes with no known runtime, an estimate was used for them.
+	EstimatedCount int  `json:"estimatedCount"`
+	Estimated      bool `json:"estimated"`
+}
+
+type WatchTimeYear struct {
+	Year int `json:"year"`
+	WatchTimeTotals
+}
+
+type WatchTimeStats struct {
+	WatchTimeTotals
+	// Oldest year first.
+	Years []WatchTimeYear `json:"years"`
+}
+
+// A years watch time for movies or episodes, as summed up by the database.
+type watchTimeRow struct {
+	Year    string
+	Minutes int
+	Count   int
+	// Rows with no runtime, they aren't included in Minutes.
+	Unknown int
+}
+
+// Get total time spent watching, overall and per year (in loc).
+// Movies use their runtime. Episodes use their own runtime (from when they
+// were marked watched), falling back to the shows average episode runtime.
+func getWatchTime(db *gorm.DB, userId uint, profileId uint, loc *time.Location) (WatchTimeStats, error) {
+	// Dates are stored with different offsets, sqlite moves them to
+	// utc, then this moves them into loc (as of the start of this year).
+	_, offset := time.Date(timeNow().In(loc).Year(), time.January, 1, 0, 0, 0, 0, loc).Zone()
+	shift := strconv.Itoa(offset/60) + " minutes"
+	if offset >= 0 {
+		shift = "+" + shift
+	}
+
+	var movies []watchTimeRow
+	res := db.Raw(`SELECT year, SUM(runtime) AS minutes, COUNT(*) AS count, SUM(runtime = 0) AS unknown FROM (
+			SELECT strftime('%Y', watcheds.created_at, ?) AS year, contents.runtime AS runtime
+			FROM watcheds JOIN contents ON contents.id = watcheds.content_id
+			WHERE watcheds.user_id = ? AND watcheds.sub_profile_id = ? AND watcheds.deleted_at IS NULL AND contents.type = ? AND watcheds.status = ?
+			UNION ALL
+			SELECT strftime('%Y', re_watch_entries.watched_at, ?) AS year, contents.runtime AS runtime
+			FROM re_watch_entries
+			JOIN watcheds ON watcheds.id = re_watch_entries.watched_id
+			JOIN contents ON contents.id = watcheds.content_id
+			WHERE watcheds.user_id = ? AND watcheds.sub_profile_id = ? AND watcheds.deleted_at IS NULL AND re_watch_entries.deleted_at IS NULL AND contents.type = ?
+		) GROUP BY year`,
+		shift, userId, profileId, MOVIE, FINISHED,
+		shift, userId, profileId, MOVIE).Scan(&movies)
+	if res.Error != nil {
+		slog.Error("getWatchTime: Failed to sum movie runtimes", "userId", userId, "error", res.Error)
+		return WatchTimeStats{}, errors.New("failed to get watch time")
+	}
+
+	var episodes []watchTimeRow
+	res = db.Model(&WatchedEpisode{}).
+		Select(`strftime('%Y', COALESCE(watched_episodes.watched_date, watched_episodes.created_at), ?) AS year,
+			SUM(CASE WHEN watched_episodes.runtime > 0 THEN watched_episodes.runtime ELSE contents.runtime END) AS minutes,
+			COUNT(*) AS count,
+			SUM(watched_episodes.runtime = 0 AND contents.runtime = 0) AS unknown`, shift).
+		Joins("JOIN watcheds ON watcheds.id = watched_episodes.watched_id AND watcheds.deleted_at IS NULL").
+		Joins("JOIN contents ON contents.id = watcheds.content_id").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId).
+		Group("year").
+		Scan(&episodes)
+	if res.Error != nil {
+		slog.Error("getWatchTime: Failed to sum episode runtimes", "userId", userId, "error", res.Error)
+		return WatchTimeStats{}, errors.New("failed to get watch time")
+	}
+
+	years := map[int]*WatchTimeYear{}
+	year := func(y string) *WatchTimeYear {
+		n, _ := strconv.Atoi(y)
+		if years[n] == nil {
+			years[n] = &WatchTimeYear{Year: n}
+		}
+		return years[n]
+	}
+	for _, r := range movies {
+		t := &year(r.Year).WatchTimeTotals
+		t.Movies += r.Count
+		t.MovieMinutes += r.Minutes + r.Unknown*estimatedMovieRuntime
+		t.EstimatedCount += r.Unknown
+	}
+	for _, r := range episodes {
+		t := &year(r.Year).WatchTimeTotals
+		t.Episodes += r.Count
+		t.EpisodeMinutes += r.Minutes + r.Unknown*estimatedEpisodeRuntime
+		t.EstimatedCount += r.Unknown
+	}
+
+	stats := WatchTimeStats{Years: []WatchTimeYear{}}
+	for _, y := range years {
+		y.finish()
+		stats.Years = append(stats.Years, *y)
+		stats.Movies += y.Movies
+		stats.MovieMinutes += y.MovieMinutes
+		stats.Episodes += y.Episodes
+		stats.EpisodeMinutes += y.EpisodeMinutes
+		stats.EstimatedCount += y.EstimatedCount
+	}
+	stats.finish()
+	slices.SortFunc(stats.Years, func(a, b WatchTimeYear) int { return a.Year - b.Year })
+	return stats, nil
+}
+
+// Fill in the totals worked out from the movie and episode ones.
+func (t *WatchTimeTotals) finish() {
+	t.Minutes = t.MovieMinutes + t.EpisodeMinutes
+	t.Hours = math.Round(float64(t.Minutes)/60*10) / 10
+	t.Estimated = t.EstimatedCount > 0
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrWrappedFutureYear = errors.New("year hasn't started yet")
+
+// A name (eg. genre or actor) and how many titles watched it came up in.
+type WrappedCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type WrappedMonth struct {
+	// 1 for January to 12 for December.
+	Month int `json:"month"`
+	// Movies and episodes watched in the month.
+	Count int `json:"count"`
+}
+
+// A watched list item picked out for the wrapped summary.
+type WrappedItem struct {
+	WatchedID uint    `json:"watchedId"`
+	Content   Content `json:"content"`
+	// Rating given, for the highest and lowest rated picks.
+	Rating int8 `json:"rating,omitempty"`
+	// Times rewatched in the year, for the most rewatched pick.
+	Count int `json:"count,omitempty"`
+	// When it was watched, for the first and last picks.
+	Date *time.Time `json:"date,omitempty"`
+}
+
+// A users year of watching, summed up. Picks are nil when
+// nothing qualified for them (eg. no movies were rated).
+type YearWrapped struct {
+	Year int `json:"year"`
+	// Movies finished (rewatches of the same movie only count once) and episodes watched.
+	Movies   int `json:"movies"`
+	Episodes int `json:"episodes"`
+	// Hours spent watching, Estimated when some runtimes weren't known.
+	Hours     float64 `json:"hours"`
+	Estimated bool    `json:"estimated"`
+	// Most common across the movies and shows watched, directors only come from movies.
+	TopGenre    *WrappedCount `json:"topGenre"`
+	TopDirector *WrappedCount `json:"topDirector"`
+	TopActor    *WrappedCount `json:"topActor"`
+	// Month the most movies and episodes were watched in.
+	MostWatchedMonth *WrappedMonth `json:"mostWatchedMonth"`
+	// Highest and lowest rated movies finished in the year.
+	HighestRated *WrappedItem `json:"highestRated"`
+	LowestRated  *WrappedItem `json:"lowestRated"`
+	// Title with the most rewatches logged in the year.
+	MostRewatched *WrappedItem `json:"mostRewatched"`
+	// First and last movie or episode watched in the year.
+	First       *WrappedItem `json:"first"`
+	Last        *WrappedItem `json:"last"`
+	GeneratedAt time.Time    `json:"generatedAt"`
+}
+
+// Wrapped summaries of past years, they don't change once a year
+// is over so are kept until restart. Keyed by `userId:profileId:year:timezone`.
+var wrappedCache sync.Map
+
+// A movie or episode being watched.
+type wrappedWatch struct {
+	watchedId uint
+	at        time.Time
+}
+
+// Get a users wrapped summary for year (in loc).
+func getYearlyWrapped(db *gorm.DB, userId uint, profileId uint, year int, loc *time.Location) (YearWrapped, error) {
+	now := timeNow().In(loc)
+	if year > now.Year() {
+		return YearWrapped{}, ErrWrappedFutureYear
+	}
+	key := strconv.Itoa(int(userId)) + ":" + strconv.Itoa(int(profileId)) + ":" + strconv.Itoa(year) + ":" + loc.String()
+	if w, ok := wrappedCache.Load(key); ok {
+		return w.(YearWrapped), nil
+	}
+	w, err := buildYearlyWrapped(db, userId, profileId, year, loc)
+	if err != nil {
+		return YearWrapped{}, err
+	}
+	// The current year is still changing, so it's always worked out again.
+	if year < now.Year() {
+		wrappedCache.Store(key, w)
+	}
+	return w, nil
+}
+
+func buildYearlyWrapped(db *gorm.DB, userId uint, profileId uint, year int, loc *time.Location) (YearWrapped, error) {
+	w := YearWrapped{Year: year, GeneratedAt: timeNow()}
+	inYear := func(t time.Time) bool { return t.In(loc).Year() == year }
+
+	movieEvents, err := finishEvents(db, userId, profileId, MOVIE)
+	if err != nil {
+		return YearWrapped{}, err
+	}
+	showEvents, err := finishEvents(db, userId, profileId, SHOW)
+	if err != nil {
+		return YearWrapped{}, err
+	}
+	var episodes []struct {
+		WatchedID   uint
+		WatchedDate *time.Time
+		CreatedAt   time.Time
+	}
+	res := db.Model(&WatchedEpisode{}).
+		Select("watched_episodes.watched_id, watched_episodes.watched_date, watched_episodes.created_at").
+		Joins("JOIN watcheds ON watcheds.id = watched_episodes.watched_id AND watcheds.deleted_at IS NULL").
+		Where("watcheds.user_id = ? AND watcheds.sub_profile_id = ?", userId, profileId).
+		Scan(&episodes)
+	if res.Error != nil {
+		slog.Error("buildYearlyWrapped: Failed to get episodes", "userId", userId, "error", res.Error)
+		return YearWrapped{}, errors.New("failed to get wrapped")
+	}
+	var rewatches []struct {
+		WatchedID uint
+		WatchedAt time.Time
+	}
+	res = reWatchesOf(db, userId, profileId).Select("re_watch_entries.watched_id, re_watch_entries.watched_at").Scan(&rewatches)
+	if res.Error != nil {
+		slog.Error("buildYearlyWrapped: Failed to get rewatches", "userId", userId, "error", res.Error)
+		return YearWrapped{}, errors.New("failed to get wrapped")
+	}
+
+	// Everything watched in the year, and the movies and shows it was from.
+	watches := []wrappedWatch{}
+	movies := map[uint]bool{}
+	shows := map[uint]bool{}
+	for _, e := range movieEvents {
+		if inYear(e.CreatedAt) {
+			watches = append(watches, wrappedWatch{e.WatchedID, e.CreatedAt})
+			movies[e.WatchedID] = true
+		}
+	}
+	for _, e := range showEvents {
+		if inYear(e.CreatedAt) {
+			shows[e.WatchedID] = true
+		}
+	}
+	for _, e := range episodes {
+		at := e.CreatedAt
+		if e.WatchedDate != nil {
+			at = *e.WatchedDate
+		}
+		if inYear(at) {
+			watches = append(watches, wrappedWatch{e.WatchedID, at})
+			shows[e.WatchedID] = true
+			w.Episodes++
+		}
+	}
+	rewatchCounts := map[uint]int{}
+	for _, r := range rewatches {
+		if inYear(r.WatchedAt) {
+			rewatchCounts[r.WatchedID]++
+		}
+	}
+	w.Movies = len(movies)
+
+	ids := []uint{}
+	for id := range movies {
+		ids = append(ids, id)
+	}
+	for id := range shows {
+		ids = append(ids, id)
+	}
+	for id := range rewatchCounts {
+		ids = append(ids, id)
+	}
+	var watched []Watched
+	if len(ids) > 0 {
+		if res := db.Model(&Watched{}).Preload("Content").Where("id IN ?", ids).Find(&watched); res.Error != nil {
+			slog.Error("buildYearlyWrapped: Failed to get watched list items", "userId", userId, "error", res.Error)
+			return YearWrapped{}, errors.New("failed to get wrapped")
+		}
+	}
+	byId := map[uint]Watched{}
+	for _, wl := range watched {
+		byId[wl.ID] = wl
+	}
+	item := func(id uint) *WrappedItem {
+		wl, ok := byId[id]
+		if !ok {
+			return nil
+		}
+		return &WrappedItem{WatchedID: id, Content: wl.Content}
+	}
+
+	stats, err := getWatchTime(db, userId, profileId, loc)
+	if err != nil {
+		return YearWrapped{}, err
+	}
+	for _, y := range stats.Years {
+		if y.Year == year {
+			w.Hours = y.Hours
+			w.Estimated = y.Estimated
+		}
+	}
+
+	genres := map[string]int{}
+	directors := map[string]int{}
+	actors := map[string]int{}
+	for _, wl := range watched {
+		if !movies[wl.ID] && !shows[wl.ID] {
+			continue
+		}
+		for _, g := range wl.Content.Genres {
+			genres[g]++
+		}
+		for _, a := range wl.Content.Cast {
+			actors[a]++
+		}
+		if wl.Content.Type == MOVIE {
+			for _, d := range wl.Content.Directors {
+				directors[d]++
+			}
+		}
+	}
+	w.TopGenre = topWrappedCount(genres)
+	w.TopDirector = topWrappedCount(directors)
+	w.TopActor = topWrappedCount(actors)
+
+	if len(watches) > 0 {
+		months := [12]int{}
+		for _, e := range watches {
+			months[e.at.In(loc).Month()-1]++
+		}
+		top := 0
+		for m := range months {
+			if months[m] > months[top] {
+				top = m
+			}
+		}
+		w.MostWatchedMonth = &WrappedMonth{Month: top + 1, Count: months[top]}
+
+		slices.SortStableFunc(watches, func(a, b wrappedWatch) int { return a.at.Compare(b.at) })
+		if w.First = item(watches[0].watchedId); w.First != nil {
+			w.First.Date = &watches[0].at
+		}
+		if w.Last = item(watches[len(watches)-1].watchedId); w.Last != nil {
+			w.Last.Date = &watches[len(watches)-1].at
+		}
+	}
+
+	rated := []Watched{}
+	for _, wl := range watched {
+		if movies[wl.ID] && wl.Rating > 0 {
+			rated = append(rated, wl)
+		}
+	}
+	if len(rated) > 0 {
+		slices.SortStableFunc(rated, func(a, b Watched) int {
+			if a.Rating != b.Rating {
+				return cmp.Compare(b.Rating, a.Rating)
+			}
+			return cmp.Compare(a.Content.Title, b.Content.Title)
+		})
+		w.HighestRated = item(rated[0].ID)
+		w.HighestRated.Rating = rated[0].Rating
+		// With one rated movie, it's only the highest.
+		if len(rated) > 1 {
+			w.LowestRated = item(rated[len(rated)-1].ID)
+			w.LowestRated.Rating = rated[len(rated)-1].Rating
+		}
+	}
+
+	var mostId uint
+	for id, n := range rewatchCounts {
+		if n > rewatchCounts[mostId] || (n == rewatchCounts[mostId] && id < mostId) {
+			mostId = id
+		}
+	}
+	if mostId != 0 {
+		if w.MostRewatched = item(mostId); w.MostRewatched != nil {
+			w.MostRewatched.Count = rewatchCounts[mostId]
+		}
+	}
+	return w, nil
+}
+
+// Most common name in counts, ties go to the first alphabetically. Nil when counts is empty.
+func topWrappedCount(counts map[string]int) *WrappedCount {
+	var top *WrappedCount
+	for name, n := range counts {
+		if top == nil || n > top.Count || (n == top.Count && name < top.Name) {
+			top = &WrappedCount{Name: name, Count: n}
+		}
+	}
+	return top
+}
@@ -1,5 +1,6 @@
 import { watchedList } from "@/store";
 import type {
+  ContentProvider,
   MediaType,
   Watched,
   WatchedAddRequest,
@@ -11,14 +12,16 @@ import { get } from "svelte/store";
 import { notify } from "./notify";
 const { MODE } = import.meta.env;
 
-export const baseURL = MODE === "development" ? "http://127.0.0.1:3080/api" : "/api";
+export const baseURL = MODE === "development" ? "http://127.0.0.1:3080/api/v1" : "/api/v1";
 
 /**
  *
- * @param contentId TMDB ID
+ * @param contentId ID at provider (TMDB ID by default)
  * @param contentType
  * @param status
  * @param rating
+ * @param thoughts
+ * @param provider Where contentId is from
  * @returns
  */
 export function updateWatched(
@@ -26,12 +29,16 @@ export function updateWatched(
   contentType: MediaType,
   status?: WatchedStatus,
   rating?: number,
-  thoughts?: string
+  thoughts?: string,
+  provider: ContentProvider = "tmdb"
 ) {
   // If item is already in watched store, run update request instead
   const wList = get(watchedList);
   const wEntry = wList.find(
-    (w) => w.content.tmdbId === contentId && w.content.type === contentType
+    (w) =>
+      w.content.providerId === contentId &&
+      w.content.provider === provider &&
+      w.content.type === contentType
   );
   if (wEntry?.id) {
     if (!status && !rating && typeof thoughts === "undefined") return;
@@ -67,6 +74,7 @@ export function updateWatched(
     .post("/watched", {
       contentId,
       contentType,
+      provider,
       rating,
       status
     } as WatchedAddRequest)
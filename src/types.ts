@@ -1,6 +1,7 @@
 export type WatchedStatus = "PLANNED" | "WATCHING" | "FINISHED" | "HOLD" | "DROPPED";
 export type ContentType = "tv" | "movie";
 export type MediaType = ContentType | "person";
+export type ContentProvider = "tmdb" | "anilist";
 
 // Wasn't able to figure out how to import this type from its component file in other places, so its here for now.
 export type Icon =
@@ -20,6 +21,21 @@ export type Icon =
 
 export type Theme = "light" | "dark";
 
+export interface AvailableAuthProviders {
+  providers: string[];
+  jellyfinServers: { id: number; name: string }[];
+}
+
+export interface PublicServerConfig {
+  apiVersion: string;
+  instanceName: string;
+  signupEnabled: boolean;
+  defaultLanguage: string;
+  authProviders: string[];
+  jellyfinServers: { id: number; name: string }[];
+  imageProxyEnabled: boolean;
+}
+
 interface dbModel {
   createdAt: string;
   updatedAt: string;
@@ -29,10 +45,29 @@ interface dbModel {
 export interface Content {
   // id: number; // Not used
   tmdbId: number;
+  provider: ContentProvider;
+  providerId: number;
   title: string;
+  originalTitle: string;
   poster_path: string;
   overview: string;
   type: ContentType;
+  status: string;
+  // Show is still airing (returning), false once it has ended.
+  airing: boolean;
+  inProduction: boolean;
+  nextEpisodeAirDate?: string;
+  genres: string[];
+  directors: string[];
+  cast: string[];
+  // Only filled in once fetched, see ContentAlternativeTitlesResponse.
+  alternativeTitles?: string[];
+}
+
+export interface ContentAlternativeTitlesResponse {
+  id: number;
+  originalTitle: string;
+  titles: string[];
 }
 
 export interface Activity extends dbModel {
@@ -49,13 +84,37 @@ export interface Watched extends dbModel {
   activity: Activity[];
   status: WatchedStatus;
   thoughts: string;
+  plannedNote: string;
+  plannedNoteFinished: boolean;
+  watchedOn: string;
+  progressSeconds: number;
+  progressRuntime: number;
+}
+
+export interface ReWatchEntry extends dbModel {
+  id: number;
+  watchedId: number;
+  rating?: number;
+  review: string;
+  watchedAt: string;
+}
+
+export interface Tag extends dbModel {
+  id: number;
+  name: string;
+  // Smart tags filter, as watched list query params (eg. status=FINISHED&type=movie).
+  filter?: string;
+  dynamic: boolean;
 }
 
 export interface WatchedAddRequest {
   contentId: number;
   contentType: ContentType;
+  provider?: ContentProvider;
   rating?: number;
   status: WatchedStatus;
+  watchedOn?: string;
+  watchedDate?: string;
 }
 
 export interface WatchedUpdateRequest {
@@ -63,12 +122,69 @@ export interface WatchedUpdateRequest {
   status?: WatchedStatus;
   thoughts?: string;
   removeThoughts?: boolean;
+  plannedNote?: string;
+  removePlannedNote?: boolean;
+  watchedOn?: string;
+  removeWatchedOn?: boolean;
 }
 
 export interface Profile {
+  username: string;
   joined: Date;
+  bio: string;
+  location: string;
+  website: string;
   showsWatched: number;
   moviesWatched: number;
+  watchedCounts: Record<ContentType, Partial<Record<WatchedStatus, number>>>;
+  // Admin is acting as this user, show a banner.
+  impersonating?: boolean;
+}
+
+export type JobStatus = "queued" | "running" | "done" | "failed";
+
+export interface Job extends dbModel {
+  id: number;
+  type: string;
+  status: JobStatus;
+  done: number;
+  total: number;
+  result?: unknown;
+  error?: string;
+  startedAt?: string;
+  finishedAt?: string;
+}
+
+export interface WrappedCount {
+  name: string;
+  count: number;
+}
+
+export interface WrappedItem {
+  watchedId: number;
+  content: Content;
+  rating?: number;
+  count?: number;
+  date?: string;
+}
+
+export interface YearWrapped {
+  year: number;
+  movies: number;
+  episodes: number;
+  hours: number;
+  estimated: boolean;
+  topGenre?: WrappedCount;
+  topDirector?: WrappedCount;
+  topActor?: WrappedCount;
+  // month is 1 (January) to 12.
+  mostWatchedMonth?: { month: number; count: number };
+  highestRated?: WrappedItem;
+  lowestRated?: WrappedItem;
+  mostRewatched?: WrappedItem;
+  first?: WrappedItem;
+  last?: WrappedItem;
+  generatedAt: string;
 }
 
 export interface TMDBContentDetails {
@@ -205,6 +321,25 @@ export interface TMDBShowSeason {
   season_number: number;
 }
 
+export interface ContentCredit {
+  id: number;
+  name: string;
+  profilePath: string;
+  character?: string;
+  department?: string;
+  job?: string;
+  order: number;
+}
+
+export interface EpisodeCreditsResponse {
+  id: number;
+  seasonNumber: number;
+  episodeNumber: number;
+  cast: ContentCredit[];
+  guestStars: ContentCredit[];
+  crew: ContentCredit[];
+}
+
 export interface TMDBSeasonDetails {
   _id: string;
   air_date: string;
@@ -308,6 +443,32 @@ export interface TMDBPersonDetails {
 export interface TMDBPersonCombinedCredits {
   id: number;
   cast: TMDBPersonCombinedCreditsCast[];
+  crew: TMDBPersonCombinedCreditsCrew[];
+}
+
+export interface TMDBPersonCombinedCreditsCrew
+  extends Omit<TMDBPersonCombinedCreditsCast, "character"> {
+  department: string;
+  job: string;
+}
+
+export interface PersonCredit {
+  id: number;
+  mediaType: ContentType;
+  title: string;
+  posterPath: string;
+  date: string;
+  popularity: number;
+  voteAverage: number;
+  episodeCount?: number;
+  character?: string;
+  departments: string[];
+  jobs?: string[];
+}
+
+export interface PersonCombinedCredits {
+  id: number;
+  credits: PersonCredit[];
 }
 
 export interface TMDBPersonCombinedCreditsCast {